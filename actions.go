@@ -0,0 +1,923 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/agent"
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/askrepo"
+	"github.com/markis/gh-copilot/internal/autosave"
+	"github.com/markis/gh-copilot/internal/chat"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/commit"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/edit"
+	"github.com/markis/gh-copilot/internal/github"
+	"github.com/markis/gh-copilot/internal/index"
+	"github.com/markis/gh-copilot/internal/issue"
+	"github.com/markis/gh-copilot/internal/logs"
+	"github.com/markis/gh-copilot/internal/postprocess"
+	"github.com/markis/gh-copilot/internal/pr"
+	"github.com/markis/gh-copilot/internal/prompts"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/scope"
+	"github.com/markis/gh-copilot/internal/serve"
+	"github.com/markis/gh-copilot/internal/session"
+	"github.com/markis/gh-copilot/internal/shell"
+	"github.com/markis/gh-copilot/internal/telemetry"
+	"github.com/markis/gh-copilot/internal/wrap"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// actionHandler runs a builtin action instead of sending a prompt to the model.
+type actionHandler func(ctx context.Context, cfg config.Config, args args.Arguments) error
+
+// actions maps builtin action names to their handlers.
+var actions = map[string]actionHandler{
+	args.ActionRecover:        runRecover,
+	args.ActionFeedback:       runFeedback,
+	args.ActionStatsQuality:   runStatsQuality,
+	args.ActionStatsUsage:     runStatsUsage,
+	args.ActionIndexBuild:     runIndexBuild,
+	args.ActionIndexStats:     runIndexStats,
+	args.ActionIndexVerify:    runIndexVerify,
+	args.ActionIndexMigrate:   runIndexMigrate,
+	args.ActionIndexQuery:     runIndexQuery,
+	args.ActionSearch:         runSearch,
+	args.ActionChat:           runChat,
+	args.ActionConfigInit:     runConfigInit,
+	args.ActionConfigGet:      runConfigGet,
+	args.ActionConfigSet:      runConfigSet,
+	args.ActionConfigValidate: runConfigValidate,
+	args.ActionServe:          runServe,
+	args.ActionServeInstall:   runServeInstall,
+	args.ActionServeUninstall: runServeUninstall,
+	args.ActionEdit:           runEdit,
+	args.ActionAgent:          runAgent,
+	args.ActionQuota:          runQuota,
+	args.ActionSessionList:    runSessionList,
+	args.ActionSessionExport:  runSessionExport,
+	args.ActionPromptsInstall: runPromptsInstall,
+	args.ActionPromptsUpdate:  runPromptsUpdate,
+	args.ActionPromptsList:    runPromptsList,
+	args.ActionPromptsShow:    runPromptsShow,
+	args.ActionPromptsAdd:     runPromptsAdd,
+	args.ActionPromptsRemove:  runPromptsRemove,
+	args.ActionPromptsImport:  runPromptsImport,
+	args.ActionPromptsExport:  runPromptsExport,
+	args.ActionAuthStatus:     runAuthStatus,
+	args.ActionAuthLogin:      runAuthLogin,
+	args.ActionLogs:           runLogs,
+	args.ActionPRReplies:      runPRReplies,
+	args.ActionPRReview:       runPRReview,
+	args.ActionCommitFixup:    runCommitFixup,
+	args.ActionCIExplain:      runCIExplain,
+	args.ActionIssue:          runIssue,
+	args.ActionAskRepo:        runAskRepo,
+	args.ActionWrap:           runWrap,
+	args.ActionInit:           runInit,
+	args.ActionShellSuggest:   runShellSuggest,
+	args.ActionShellExplain:   runShellExplain,
+}
+
+// interactiveActions run until the user quits, or as long as the command they wrap, so they aren't
+// bound by the context timeout.
+var interactiveActions = map[string]bool{
+	args.ActionChat:      true,
+	args.ActionServe:     true,
+	args.ActionAuthLogin: true,
+	args.ActionWrap:      true,
+	args.ActionAgent:     true,
+}
+
+// actionPriorities are the priorities of the requests of actions without --priority: interactive
+// ones go first, and indexing yields to them. Other actions and prompts are normal.
+var actionPriorities = map[string]client.Priority{
+	args.ActionChat:         client.PriorityHigh,
+	args.ActionShellSuggest: client.PriorityHigh,
+	args.ActionShellExplain: client.PriorityHigh,
+	args.ActionIndexBuild:   client.PriorityLow,
+	args.ActionIndexMigrate: client.PriorityLow,
+}
+
+// configActions still run when the config file can't be loaded, so it can be fixed.
+var configActions = map[string]bool{
+	args.ActionConfigInit:     true,
+	args.ActionConfigGet:      true,
+	args.ActionConfigSet:      true,
+	args.ActionConfigValidate: true,
+	args.ActionInit:           true,
+}
+
+// runAction dispatches the builtin action selected on the command line.
+func runAction(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	handler, ok := actions[args.Action]
+	if !ok {
+		return fmt.Errorf("unknown action: %s", args.Action)
+	}
+	return handler(ctx, cfg, args)
+}
+
+// runRecover prints the last autosaved answer.
+func runRecover(_ context.Context, _ config.Config, _ args.Arguments) error {
+	answer, err := autosave.Latest()
+	if err != nil {
+		return fmt.Errorf("recovering answer: %w", err)
+	}
+
+	fmt.Print(answer)
+	return nil
+}
+
+// runFeedback records the user's rating of the previous answer, unless acceptance tracking is disabled.
+func runFeedback(_ context.Context, cfg config.Config, args args.Arguments) error {
+	if !cfg.TrackAcceptance {
+		fmt.Fprintln(os.Stderr, "Feedback is not recorded, track_acceptance is disabled in the config file.")
+		return nil
+	}
+
+	last, err := telemetry.LastAnswer()
+	if err != nil {
+		return fmt.Errorf("recording feedback: %w", err)
+	}
+
+	return telemetry.Record(telemetry.Event{
+		Answer: last.Answer,
+		Kind:   telemetry.EventFeedback,
+		Value:  args.Feedback,
+	})
+}
+
+// runStatsQuality prints how often answers were accepted, per command and model.
+func runStatsQuality(_ context.Context, _ config.Config, _ args.Arguments) error {
+	events, err := telemetry.Load()
+	if err != nil {
+		return fmt.Errorf("loading events: %w", err)
+	}
+
+	stats := telemetry.Quality(events)
+	if len(stats) == 0 {
+		fmt.Fprintln(os.Stderr, "No answers recorded yet.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND\tMODEL\tANSWERS\tACCEPTED\tGOOD\tBAD")
+	for _, s := range stats {
+		command := s.Command
+		if command == "" {
+			command = "(prompt)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d%%\t%d\t%d\n",
+			command, s.Model, s.Answers, s.Accepted*100/s.Answers, s.Good, s.Bad)
+	}
+	return w.Flush()
+}
+
+// runStatsUsage prints the token usage of serve callers, per client and model.
+func runStatsUsage(_ context.Context, _ config.Config, _ args.Arguments) error {
+	records, err := serve.LoadUsage()
+	if err != nil {
+		return fmt.Errorf("loading usage: %w", err)
+	}
+
+	summaries := serve.SummarizeUsage(records)
+	if len(summaries) == 0 {
+		fmt.Fprintln(os.Stderr, "No served requests recorded yet.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tMODEL\tREQUESTS\tPROMPT\tCOMPLETION\tTOTAL")
+	for _, s := range summaries {
+		total := strconv.Itoa(s.PromptTokens + s.CompletionTokens)
+		if s.Estimated {
+			total = "~" + total // Upstream didn't report the usage of some requests
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n",
+			s.Client, s.Model, s.Requests, s.PromptTokens, s.CompletionTokens, total)
+	}
+	return w.Flush()
+}
+
+// runIndexBuild embeds the current repository into its index.
+func runIndexBuild(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	root, err := index.FindRoot(ctx, args.Scope)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.Build(ctx, cfg, root, func(done, total int) {
+		fmt.Fprintf(os.Stderr, "Embedded %d/%d chunks\n", done, total)
+	})
+	if err != nil {
+		return fmt.Errorf("building index: %w", err)
+	}
+	if err := idx.Save(); err != nil {
+		return fmt.Errorf("saving index: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Indexed %d chunks in %s\n", len(idx.Chunks), root)
+	return nil
+}
+
+// runIndexStats prints the statistics of the current repository's index.
+func runIndexStats(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	idx, err := loadIndex(ctx, args.Scope)
+	if err != nil {
+		return err
+	}
+
+	stats, err := idx.Stats(ctx)
+	if err != nil {
+		return fmt.Errorf("computing index stats: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Root:\t%s\n", stats.Root)
+	fmt.Fprintf(w, "Model:\t%s\n", stats.Model)
+	fmt.Fprintf(w, "Built:\t%s\n", stats.Created.Format(time.RFC1123))
+	fmt.Fprintf(w, "Files:\t%d\n", stats.Files)
+	fmt.Fprintf(w, "Chunks:\t%d\n", stats.Chunks)
+	fmt.Fprintf(w, "Dimensions:\t%d\n", stats.Dimensions)
+	fmt.Fprintf(w, "Disk size:\t%.1f MiB\n", float64(stats.DiskSize)/(1<<20))
+	if stats.Graph > 0 {
+		fmt.Fprintf(w, "Search:\tapproximate, graph of %d chunks\n", stats.Graph)
+	} else {
+		fmt.Fprintf(w, "Search:\texact\n")
+	}
+	fmt.Fprintf(w, "Coverage:\t%.1f%%\n", stats.Coverage)
+	fmt.Fprintf(w, "Stale files:\t%d\n", len(stats.Stale))
+	fmt.Fprintf(w, "Unindexed files:\t%d\n", len(stats.Unindexed))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, file := range stats.Stale {
+		fmt.Printf("  stale: %s\n", render.FileLink(cfg.Render.EditorURI, filepath.Join(idx.Root, file), 1, file, args.UsePlainText))
+	}
+	for _, file := range stats.Unindexed {
+		fmt.Printf("  unindexed: %s\n", render.FileLink(cfg.Render.EditorURI, filepath.Join(idx.Root, file), 1, file, args.UsePlainText))
+	}
+	return nil
+}
+
+// runIndexVerify checks the current repository's index for corruption.
+func runIndexVerify(ctx context.Context, _ config.Config, args args.Arguments) error {
+	idx, err := loadIndex(ctx, args.Scope)
+	if err != nil {
+		return err
+	}
+
+	problems := idx.Verify()
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("index is corrupt: %d problems found, run `gh copilot index build` to rebuild it", len(problems))
+	}
+
+	fmt.Printf("Index OK: %d chunks verified\n", len(idx.Chunks))
+	return nil
+}
+
+// runIndexMigrate re-embeds the chunks of the current repository's index with another model.
+func runIndexMigrate(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	idx, err := loadIndex(ctx, args.Scope)
+	if err != nil {
+		return err
+	}
+
+	migrated, err := index.Migrate(ctx, cfg, idx, args.Index.Model, func(done, total int) {
+		fmt.Fprintf(os.Stderr, "Re-embedded %d/%d chunks\n", done, total)
+	})
+	if err != nil {
+		return fmt.Errorf("migrating index (rerun to resume): %w", err)
+	}
+
+	if migrated == 0 {
+		fmt.Fprintf(os.Stderr, "The index is already embedded with %s\n", args.Index.Model)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Migrated %d chunks to %s\n", migrated, args.Index.Model)
+	return nil
+}
+
+// runIndexQuery prints the files of the current repository's index most similar to the query, with the
+// scores of their matched chunks, to debug what retrieval attaches.
+func runIndexQuery(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	idx, err := loadIndex(ctx, args.Scope)
+	if err != nil {
+		return err
+	}
+
+	warnMismatched(idx)
+	files, err := index.SearchFiles(ctx, cfg, idx, strings.Join(args.ActionArgs, " "), args.Index.Top)
+	if err != nil {
+		return fmt.Errorf("searching index: %w", err)
+	}
+
+	const shownChunks = 3
+	for _, file := range files {
+		fmt.Printf("%.3f  %s\n", file.Score,
+			render.FileLink(cfg.Render.EditorURI, filepath.Join(idx.Root, file.Path), 1, file.Path, args.UsePlainText))
+		for _, result := range file.Chunks[:min(len(file.Chunks), shownChunks)] {
+			lines := fmt.Sprintf("%d-%d", result.Chunk.StartLine, result.Chunk.EndLine)
+			link := render.FileLink(cfg.Render.EditorURI, filepath.Join(idx.Root, file.Path),
+				result.Chunk.StartLine, lines, args.UsePlainText)
+			fmt.Printf("       %.3f  %s%s  %s\n", result.Score, link,
+				strings.Repeat(" ", max(0, 11-len(lines))), strings.Join(result.Chunk.Symbols, ", "))
+		}
+		if more := len(file.Chunks) - shownChunks; more > 0 {
+			fmt.Printf("       and %d more chunks\n", more)
+		}
+	}
+	return nil
+}
+
+// warnMismatched notes the chunks a search skips, as another model than the index's embedded them.
+func warnMismatched(idx *index.Index) {
+	for model, count := range idx.Mismatched() {
+		fmt.Fprintf(os.Stderr, "Skipping %d chunks embedded with %s, run `gh copilot index migrate --model %s` to finish migrating them\n",
+			count, model, model)
+	}
+}
+
+// loadIndex loads the index of the current repository, or of the scope's directory.
+func loadIndex(ctx context.Context, s scope.Scope) (*index.Index, error) {
+	root, err := index.FindRoot(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := index.Load(root)
+	if err != nil {
+		return nil, fmt.Errorf("loading index: %w", err)
+	}
+	return idx, nil
+}
+
+// runSearch searches the current repository's index and prints the matching chunks.
+func runSearch(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	idx, err := loadIndex(ctx, args.Scope)
+	if err != nil {
+		return err
+	}
+
+	query := strings.Join(args.ActionArgs, " ")
+	filter := index.Filter{Symbol: args.Search.Symbol, Lang: args.Search.Lang}
+	if query != "" {
+		warnMismatched(idx)
+	}
+	results, err := index.Search(ctx, cfg, idx, query, filter, args.Search.Top)
+	if err != nil {
+		return fmt.Errorf("searching index: %w", err)
+	}
+
+	// Aligned by hand, as tabwriter would count the hyperlink escape sequences
+	locations := make([]string, len(results))
+	width := 0
+	for i, result := range results {
+		locations[i] = fmt.Sprintf("%s:%d", result.Chunk.Path, result.Chunk.StartLine)
+		width = max(width, len(locations[i]))
+	}
+	for i, result := range results {
+		link := render.FileLink(cfg.Render.EditorURI, filepath.Join(idx.Root, result.Chunk.Path),
+			result.Chunk.StartLine, locations[i], args.UsePlainText)
+		fmt.Printf("%.3f  %s%s  %s\n", result.Score, link,
+			strings.Repeat(" ", width-len(locations[i])), strings.Join(result.Chunk.Symbols, ", "))
+	}
+	return nil
+}
+
+// runChat starts an interactive chat session, optionally grounded by a pull request.
+func runChat(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	var loader chat.ContextLoader
+	if number := args.Chat.PullRequest; number > 0 {
+		loader = func(ctx context.Context) (string, error) {
+			pr, err := github.FetchPullRequest(ctx, number)
+			if err != nil {
+				return "", err
+			}
+			pr.Confine(args.Scope)
+			fmt.Fprintf(os.Stderr, "Loaded pull request #%d at %.7s\n", pr.Number, pr.HeadSHA)
+			return pr.Markdown(), nil
+		}
+	}
+
+	return chat.NewSession(cfg, args, loader).Run(ctx)
+}
+
+// runServe serves the Copilot API on the local address, or JSON-RPC with --rpc, until interrupted.
+func runServe(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	if args.Serve.RPC {
+		return serve.New(ctx, cfg).ServeRPC(ctx, args.Serve.Addr)
+	}
+	return serve.New(ctx, cfg).ListenAndServe(ctx, args.Serve.Addr)
+}
+
+// runServeInstall installs the server as a user-level service.
+func runServeInstall(ctx context.Context, _ config.Config, args args.Arguments) error {
+	path, err := serve.Install(ctx, args.ActionArgs)
+	if err != nil {
+		return fmt.Errorf("installing service: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Installed and started %s\n", path)
+	return nil
+}
+
+// runServeUninstall removes the server's user-level service.
+func runServeUninstall(ctx context.Context, _ config.Config, _ args.Arguments) error {
+	path, err := serve.Uninstall(ctx)
+	if err != nil {
+		return fmt.Errorf("uninstalling service: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Stopped and removed %s\n", path)
+	return nil
+}
+
+// runAgent lets the model accomplish a task in steps, asking before writes and test runs.
+func runAgent(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return agent.Run(ctx, cfg, args)
+}
+
+// runEdit asks for a change to a file as a diff, and applies it with --apply.
+func runEdit(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return edit.Run(ctx, cfg, args)
+}
+
+// runLogs asks for the root cause of the failure in the piped log.
+func runLogs(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return logs.Run(ctx, cfg, args)
+}
+
+// runCIExplain diagnoses the failed jobs of a workflow run.
+func runCIExplain(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return logs.Explain(ctx, cfg, args)
+}
+
+// runWrap runs a command and explains its output when it fails.
+func runWrap(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return wrap.Run(ctx, cfg, args)
+}
+
+// runInit prints the widgets of the shell, to be evaluated by its startup file.
+func runInit(_ context.Context, _ config.Config, args args.Arguments) error {
+	script, err := shell.Init(args.ActionArgs[0])
+	if err != nil {
+		return err
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// runShellSuggest prints a command line for the task or command line.
+func runShellSuggest(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return shell.Suggest(ctx, cfg, args)
+}
+
+// runShellExplain explains the command line.
+func runShellExplain(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return shell.Explain(ctx, cfg, args)
+}
+
+// runAskRepo answers a question about the repository from its most relevant files.
+func runAskRepo(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return askrepo.Run(ctx, cfg, args)
+}
+
+// runIssue summarizes an issue, suggests its labels, or drafts a reply.
+func runIssue(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return issue.Run(ctx, cfg, args)
+}
+
+// runPRReplies drafts replies to the unresolved review threads of a pull request.
+func runPRReplies(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return pr.Replies(ctx, cfg, args)
+}
+
+// runCommitFixup proposes a better message for a commit and applies it after confirmation.
+func runCommitFixup(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return commit.Fixup(ctx, cfg, args)
+}
+
+// runPRReview reviews the changes of a pull request.
+func runPRReview(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	return pr.Review(ctx, cfg, args)
+}
+
+// runQuota prints the rate limit last reported by the Copilot API.
+func runQuota(_ context.Context, _ config.Config, _ args.Arguments) error {
+	limit, err := client.CurrentRateLimit()
+	if err != nil {
+		return err
+	}
+	if limit.Observed.IsZero() {
+		fmt.Fprintln(os.Stderr, "No rate limit reported yet, it is recorded from the API's responses.")
+		return nil
+	}
+
+	fmt.Printf("%s (reported %s ago)\n", limit, time.Since(limit.Observed).Round(time.Second))
+	return nil
+}
+
+// runAuthLogin logs in with the device flow, waiting until the user entered the code in the browser.
+func runAuthLogin(ctx context.Context, cfg config.Config, _ args.Arguments) error {
+	code, err := client.RequestDeviceCode(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "First copy your one-time code: %s\n", code.UserCode)
+	fmt.Fprintf(os.Stderr, "Then open %s in your browser and enter it.\n", code.VerificationURI)
+	fmt.Fprintln(os.Stderr, "Waiting for authorization...")
+
+	user, path, err := client.WaitForLogin(ctx, cfg, code)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s as %s, the token is stored in %s\n", cfg.AuthHost, user, path)
+	return nil
+}
+
+// runAuthStatus reports the source of the GitHub token and whether it is exchanged for a Copilot token,
+// with a hint when it fails.
+func runAuthStatus(ctx context.Context, cfg config.Config, _ args.Arguments) error {
+	status := client.CheckAuth(ctx, cfg)
+
+	fmt.Println(status.Host)
+	if status.Source == "" {
+		fmt.Println("  ✗ No GitHub token found, searched:")
+		for _, source := range status.Searched {
+			fmt.Printf("      %s\n", source)
+		}
+	} else {
+		fmt.Printf("  ✓ GitHub token %s from %s\n", status.Token, status.Source)
+	}
+
+	if status.Err != nil {
+		if status.Source != "" {
+			fmt.Printf("  ✗ Copilot token exchange failed: %v\n", status.Err)
+		}
+		if status.Hint != "" {
+			fmt.Printf("  Hint: %s\n", status.Hint)
+		}
+		return fmt.Errorf("not authenticated with GitHub Copilot on %s", status.Host)
+	}
+
+	fmt.Printf("  ✓ Copilot token valid until %s (in %s)\n",
+		status.ExpiresAt.Local().Format("2006-01-02 15:04"), time.Until(status.ExpiresAt).Round(time.Minute))
+	if status.SKU != "" {
+		chat := "chat enabled"
+		if !status.ChatEnabled {
+			chat = "chat disabled"
+		}
+		fmt.Printf("  Plan: %s, %s\n", status.SKU, chat)
+	}
+	if len(status.Scopes) > 0 {
+		fmt.Printf("  Token scopes: %s\n", strings.Join(status.Scopes, ", "))
+	}
+	return nil
+}
+
+// runSessionList lists the stored chat sessions.
+func runSessionList(_ context.Context, _ config.Config, _ args.Arguments) error {
+	sessions, err := session.List()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Fprintln(os.Stderr, "No sessions stored yet, chats are stored as they go.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMESSAGES\tUPDATED\tMODELS")
+	for _, s := range sessions {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n",
+			s.Name, len(s.Turns), s.Updated.Format("2006-01-02 15:04"), strings.Join(s.Models(), ", "))
+	}
+	return w.Flush()
+}
+
+// runSessionExport renders a stored chat session as a document on stdout.
+func runSessionExport(_ context.Context, _ config.Config, args args.Arguments) error {
+	s, err := session.Load(args.ActionArgs[0])
+	if err != nil {
+		return err
+	}
+	return session.Export(os.Stdout, s, args.Session.Format)
+}
+
+// runPromptsInstall installs a prompt pack into the prompts directory.
+func runPromptsInstall(ctx context.Context, _ config.Config, args args.Arguments) error {
+	pack, err := prompts.Install(ctx, args.ActionArgs[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Installed %s %s (%.7s): %s\n", pack.Source, pack.Version, pack.Commit, strings.Join(pack.Files, ", "))
+	return nil
+}
+
+// runPromptsUpdate updates the installed prompt packs, or the named one.
+func runPromptsUpdate(ctx context.Context, _ config.Config, args args.Arguments) error {
+	name := ""
+	if len(args.ActionArgs) > 0 {
+		name = args.ActionArgs[0]
+	}
+
+	before, after, err := prompts.Update(ctx, name)
+	for i := range after {
+		if before[i].Checksum == after[i].Checksum {
+			fmt.Fprintf(os.Stderr, "%s %s is up to date\n", after[i].Source, after[i].Version)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Updated %s %s (%.7s) to %s (%.7s)\n",
+			after[i].Source, before[i].Version, before[i].Commit, after[i].Version, after[i].Commit)
+	}
+	if err != nil {
+		return err
+	}
+	if len(after) == 0 {
+		fmt.Fprintln(os.Stderr, "No prompt packs installed, see `gh copilot prompts install`.")
+	}
+	return nil
+}
+
+// runPromptsList prints the names of the prompts with the start of their text.
+func runPromptsList(_ context.Context, cfg config.Config, _ args.Arguments) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODEL\tPROMPT")
+	for _, name := range slices.Sorted(maps.Keys(cfg.Prompts)) {
+		prompt := cfg.Prompts[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, cmp.Or(prompt.Model, "-"), summarizeLine(prompt.Prompt))
+	}
+	return w.Flush()
+}
+
+// runPromptsShow prints a prompt and its settings as YAML.
+func runPromptsShow(_ context.Context, cfg config.Config, args args.Arguments) error {
+	prompt, ok := cfg.Prompts[args.ActionArgs[0]]
+	if !ok {
+		return fmt.Errorf("no prompt named %s, see `gh copilot prompts list`", args.ActionArgs[0])
+	}
+	return printYAML(prompt)
+}
+
+// runPromptsAdd adds a prompt, given after its name or piped, to the config file.
+func runPromptsAdd(_ context.Context, cfg config.Config, args args.Arguments) error {
+	name := args.ActionArgs[0]
+	if !prompts.ValidName(name) {
+		return fmt.Errorf("invalid prompt name %q: use letters, digits, dashes, and underscores", name)
+	}
+	if _, ok := cfg.Prompts[name]; ok && !args.PromptLibrary.Force {
+		return fmt.Errorf("a prompt named %s exists, use --force to replace it", name)
+	}
+	text := strings.Join(args.ActionArgs[1:], " ")
+	if text == "" {
+		text = args.Stdin
+	}
+	if strings.TrimSpace(text) == "" {
+		return errors.New("no prompt provided, give it after the name or pipe it")
+	}
+
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+	prompt := config.ConfigPrompt{Prompt: strings.TrimSpace(text), Model: args.PromptLibrary.Model, System: args.PromptLibrary.System}
+	if err := config.SetPrompts(path, config.Prompts{name: prompt}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Added %s to %s, run it with `gh copilot %s`\n", name, path, name)
+	return nil
+}
+
+// runPromptsRemove removes a prompt from the config file.
+func runPromptsRemove(_ context.Context, _ config.Config, args args.Arguments) error {
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+	if err := config.RemovePrompt(path, args.ActionArgs[0]); err != nil {
+		if errors.Is(err, config.ErrPromptNotFound) {
+			return fmt.Errorf("%w; only prompts of the config file can be removed, those of prompt files and packs go with their files", err)
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Removed %s from %s\n", args.ActionArgs[0], path)
+	return nil
+}
+
+// runPromptsImport fetches a shared prompt file and adds its prompts to the config file, once the
+// user confirmed the prompts it adds and replaces.
+func runPromptsImport(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	url := args.ActionArgs[0]
+	imported, err := prompts.Fetch(ctx, cfg, url)
+	if err != nil {
+		return err
+	}
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s has %d prompts:\n", url, len(imported))
+	for _, name := range slices.Sorted(maps.Keys(imported)) {
+		action := "add"
+		if _, ok := cfg.Prompts[name]; ok {
+			action = "replace"
+		}
+		fmt.Fprintf(os.Stderr, "  %-8s %s: %s\n", action, name, summarizeLine(imported[name].Prompt))
+	}
+	if !args.PromptLibrary.Yes {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.New("stdin is not a terminal to confirm the import, use --yes to import anyway")
+		}
+		if !confirm(fmt.Sprintf("Import them into %s? [y/N]: ", path)) {
+			return errors.New("import canceled")
+		}
+	}
+
+	if err := config.SetPrompts(path, imported); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d prompts into %s\n", len(imported), path)
+	return nil
+}
+
+// runPromptsExport prints the prompts, or the named ones, as YAML like that of prompts.d, which
+// `prompts import` reads.
+func runPromptsExport(_ context.Context, cfg config.Config, args args.Arguments) error {
+	exported := cfg.Prompts
+	if len(args.ActionArgs) > 0 {
+		exported = config.Prompts{}
+		for _, name := range args.ActionArgs {
+			prompt, ok := cfg.Prompts[name]
+			if !ok {
+				return fmt.Errorf("no prompt named %s, see `gh copilot prompts list`", name)
+			}
+			exported[name] = prompt
+		}
+	}
+	return printYAML(exported)
+}
+
+// printYAML prints the value as YAML on stdout.
+func printYAML(value any) error {
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(value); err != nil {
+		return fmt.Errorf("failed to encode YAML: %w", err)
+	}
+	return enc.Close()
+}
+
+// summarizeLine returns the start of the text on a single line.
+func summarizeLine(text string) string {
+	summary := strings.Join(strings.Fields(text), " ")
+	if len(summary) > 60 {
+		summary = summary[:57] + "..."
+	}
+	return summary
+}
+
+// confirm asks the question on stderr and reports whether it was answered with yes.
+func confirm(question string) bool {
+	fmt.Fprint(os.Stderr, question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// runConfigInit writes a commented config file.
+func runConfigInit(_ context.Context, _ config.Config, args args.Arguments) error {
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+
+	if err := config.Init(path, args.Config.Force); err != nil {
+		if errors.Is(err, config.ErrConfigExists) {
+			return fmt.Errorf("%w, use --force to overwrite it", err)
+		}
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	return nil
+}
+
+// runConfigGet prints the effective value of a config key.
+func runConfigGet(_ context.Context, cfg config.Config, args args.Arguments) error {
+	value, err := config.Get(cfg, args.ActionArgs[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(value)
+	return nil
+}
+
+// runConfigSet sets a config key in the config file.
+func runConfigSet(_ context.Context, _ config.Config, args args.Arguments) error {
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+
+	return config.Set(path, args.ActionArgs[0], args.ActionArgs[1])
+}
+
+// runConfigValidate checks the user and project config files and prints their problems by line.
+func runConfigValidate(_ context.Context, _ config.Config, args args.Arguments) error {
+	paths := args.ActionArgs
+	if len(paths) == 0 {
+		userPath, err := config.Path()
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(userPath); err == nil {
+			paths = append(paths, userPath)
+		}
+
+		projectPath, err := config.ProjectPath()
+		if err != nil {
+			return err
+		}
+		if projectPath != "" {
+			paths = append(paths, projectPath)
+		}
+	}
+	if len(paths) == 0 {
+		return errors.New("no config file found, create one with `gh copilot config init`")
+	}
+
+	invalid := 0
+	for _, path := range paths {
+		problems, err := validateConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, problem := range problems {
+			if problem.Line > 0 {
+				fmt.Printf("%s:%d: %s\n", path, problem.Line, problem.Message)
+			} else {
+				fmt.Printf("%s: %s\n", path, problem.Message)
+			}
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s is valid\n", path)
+		}
+		invalid += len(problems)
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("config is invalid: %d problems found", invalid)
+	}
+	return nil
+}
+
+// validateConfigFile checks a config file against the schema and the known post-processors.
+func validateConfigFile(path string) ([]config.Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	problems := config.Validate(data)
+
+	// Post-processor names are validated here, as the config package doesn't know them.
+	// Unmarshal decodes what it can even when other values are invalid.
+	var cfg config.Config
+	_ = yaml.Unmarshal(data, &cfg)
+	if err := postprocess.Validate(cfg.PostProcess); err != nil {
+		problems = append(problems, config.Problem{Message: "post_process: " + err.Error()})
+	}
+	for name, prompt := range cfg.Prompts {
+		if err := postprocess.Validate(prompt.PostProcess); err != nil {
+			problems = append(problems, config.Problem{Message: fmt.Sprintf("prompts.%s.post_process: %v", name, err)})
+		}
+	}
+	return problems, nil
+}
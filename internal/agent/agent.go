@@ -0,0 +1,151 @@
+// Package agent lets the model accomplish a task in steps, calling tools that read, search, and
+// test the code of the working directory, and asking the user before it writes a file or runs a command.
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/render"
+	"golang.org/x/term"
+)
+
+// systemPrompt explains the tools and how to call them.
+const systemPrompt = `You accomplish the user's task in steps, in the working directory of a software project.
+In each step, reply only with a JSON object in a ` + "```json" + ` code block that calls one tool:
+
+{"summary": "<one sentence on what you do next and why>", "tool": "<tool>", "args": {...}}
+
+Tools:
+- read_file {"path": "<file>", "start_line": <n>, "end_line": <n>}: read a file with line numbers, the lines are optional
+- list_dir {"path": "<directory>"}: list a directory, "." for the working directory
+- grep {"pattern": "<regular expression>", "path": "<file or directory>"}: find the lines of files matching the pattern
+- run_tests {}: run the tests of the project
+- write_file {"path": "<file>", "content": "<full new content>"}: create or replace a file
+- finish {"answer": "<markdown>"}: end the task with your answer to the user
+
+Paths are relative to the working directory. The result of each tool is sent back to you. The user
+may decline a write or a test run. Finish as soon as the task is done, or when you can't make progress.`
+
+// call is a tool call of the model.
+type call struct {
+	Summary string          `json:"summary"`
+	Tool    string          `json:"tool"`
+	Args    json.RawMessage `json:"args"`
+}
+
+// Run asks the model to accomplish the task, running the tool it calls in each step and sending
+// back the result, until it finishes or runs out of steps. The summary of each step is printed as
+// it is taken, and the answer of the model rendered at the end.
+func Run(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	task := strings.TrimSpace(strings.Join(append(args.ActionArgs, args.Prompts...), "\n\n"))
+	if task == "" {
+		return errors.New("agent needs a task, as arguments or on stdin")
+	}
+
+	t := &tools{
+		cfg:         cfg,
+		args:        args,
+		testCommand: cfg.Agent.TestCommand,
+		input:       bufio.NewReader(os.Stdin),
+		interactive: term.IsTerminal(int(os.Stdin.Fd())),
+	}
+	messages := []client.Message{
+		{Role: client.SystemRole, Content: systemPrompt},
+		{Role: client.UserRole, Content: "Task: " + task},
+	}
+
+	maxSteps := args.Agent.MaxSteps
+	for step := 1; step <= maxSteps; step++ {
+		answer, err := client.Complete(ctx, cfg, args.Model, messages)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, client.Message{Role: client.AssistantRole, Content: answer})
+
+		c, err := parseCall(answer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Step %d/%d: the answer is not a tool call, asking again\n", step, maxSteps)
+			messages = append(messages, client.Message{Role: client.UserRole, Content: fmt.Sprintf(
+				"Your reply is not a tool call: %v. Reply only with a JSON object in a ```json code block.", err)})
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Step %d/%d: %s\n", step, maxSteps, c.Summary)
+
+		if c.Tool == "finish" {
+			var finish struct {
+				Answer string `json:"answer"`
+			}
+			_ = json.Unmarshal(c.Args, &finish)
+			return render.RenderMarkdown(ctx, cfg, args, finish.Answer)
+		}
+		fmt.Fprintf(os.Stderr, "  %s\n", describe(c))
+		result := t.call(ctx, c)
+		messages = append(messages, client.Message{Role: client.UserRole, Content: fmt.Sprintf(
+			"Result of %s:\n\n%s", c.Tool, fence(result))})
+	}
+	return fmt.Errorf("agent stopped after %d steps without finishing, raise --max-steps to let it go further", maxSteps)
+}
+
+// parseCall extracts the tool call from the answer, a JSON object in a code block or on its own.
+func parseCall(answer string) (call, error) {
+	text := strings.TrimSpace(answer)
+	for _, block := range codeblock.Parse(answer) {
+		if block.Lang == "json" || block.Lang == "" {
+			text = block.Code
+			break
+		}
+	}
+
+	var c call
+	if err := json.Unmarshal([]byte(text), &c); err != nil {
+		return call{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if c.Tool == "" {
+		return call{}, errors.New("it names no tool")
+	}
+	if len(c.Args) == 0 {
+		c.Args = json.RawMessage("{}")
+	}
+	return c, nil
+}
+
+// describe returns the tool call as a line for the user, e.g. read_file main.go.
+func describe(c call) string {
+	var a map[string]any
+	_ = json.Unmarshal(c.Args, &a)
+	parts := []string{c.Tool}
+	for _, key := range []string{"pattern", "path"} {
+		if value, ok := a[key].(string); ok && value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// fence wraps the text in a code fence longer than any backtick run in it.
+func fence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return marker + "\n" + text + marker
+}
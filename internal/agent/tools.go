@@ -0,0 +1,371 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/patch"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/sandbox"
+)
+
+const (
+	maxResult   = 16 << 10 // Bytes of a result sent back to the model, the rest is cut
+	maxMatches  = 100      // Lines of a grep result
+	maxFileSize = 1 << 20  // Files larger than this aren't searched
+)
+
+// testCommands are the commands that run the tests of a project, by a file at its root.
+var testCommands = []struct{ file, command string }{
+	{"go.mod", "go test ./..."},
+	{"Cargo.toml", "cargo test"},
+	{"package.json", "npm test"},
+	{"pyproject.toml", "pytest"},
+	{"Makefile", "make test"},
+}
+
+// tools runs the tool calls of the model, confined to the working directory and the scope.
+type tools struct {
+	cfg         config.Config
+	args        args.Arguments
+	testCommand string
+	input       *bufio.Reader // Answers to the confirmations
+	interactive bool          // Confirmations can be answered, otherwise writes and runs are declined
+}
+
+// call runs the tool call and returns its result, or the error that the model should know about.
+func (t *tools) call(ctx context.Context, c call) string {
+	var result string
+	var err error
+	switch c.Tool {
+	case "read_file":
+		result, err = t.readFile(c.Args)
+	case "list_dir":
+		result, err = t.listDir(c.Args)
+	case "grep":
+		result, err = t.grep(c.Args)
+	case "run_tests":
+		result, err = t.runTests(ctx)
+	case "write_file":
+		result, err = t.writeFile(ctx, c.Args)
+	default:
+		err = fmt.Errorf("unknown tool %s", c.Tool)
+	}
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if len(result) > maxResult {
+		result = result[:maxResult] + "\n... (cut)"
+	}
+	return result
+}
+
+// resolve checks that the path is below the working directory and inside the scope, also once
+// its symlinks are followed, and cleans it.
+func (t *tools) resolve(path string) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	path = filepath.Clean(path)
+	if path != "." && !filepath.IsLocal(path) {
+		return "", fmt.Errorf("%s is outside of the working directory", path)
+	}
+	if !t.args.Scope.Contains(path) {
+		return "", fmt.Errorf("%s is outside of --scope", path)
+	}
+
+	// A new file is checked by its deepest existing parent, where it would be created
+	existing := path
+	for existing != "." {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	target, err := realPath(existing)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	wd, err := realPath(".")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the working directory: %w", err)
+	}
+	if !within(wd, target) {
+		return "", fmt.Errorf("%s links outside of the working directory", path)
+	}
+	if t.args.Scope.IsSet() {
+		scopeDir, err := realPath(t.args.Scope.Dir)
+		if err != nil || !within(scopeDir, target) {
+			return "", fmt.Errorf("%s links outside of --scope", path)
+		}
+	}
+	return path, nil
+}
+
+// realPath returns the absolute path with its symlinks followed.
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// within reports whether the path is the directory or below it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+// readFile returns the lines of a file, numbered.
+func (t *tools) readFile(raw json.RawMessage) (string, error) {
+	var a struct {
+		Path      string `json:"path"`
+		StartLine int    `json:"start_line"`
+		EndLine   int    `json:"end_line"`
+	}
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return "", fmt.Errorf("invalid args: %w", err)
+	}
+	path, err := t.resolve(a.Path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	first, last := max(a.StartLine, 1), len(lines)
+	if a.EndLine > 0 {
+		last = min(a.EndLine, last)
+	}
+	var b strings.Builder
+	for i := first; i <= last; i++ {
+		fmt.Fprintf(&b, "%5d  %s\n", i, lines[i-1])
+	}
+	return b.String(), nil
+}
+
+// listDir returns the entries of a directory, one per line, with a slash after directories.
+func (t *tools) listDir(raw json.RawMessage) (string, error) {
+	var a struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return "", fmt.Errorf("invalid args: %w", err)
+	}
+	path, err := t.resolve(a.Path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		b.WriteString(entry.Name())
+		if entry.IsDir() {
+			b.WriteString("/")
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// grep returns the lines of the files below a path matching a pattern, as path:line: text,
+// skipping hidden directories and binary or large files.
+func (t *tools) grep(raw json.RawMessage) (string, error) {
+	var a struct {
+		Pattern string `json:"pattern"`
+		Path    string `json:"path"`
+	}
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return "", fmt.Errorf("invalid args: %w", err)
+	}
+	pattern, err := regexp.Compile(a.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	root, err := t.resolve(a.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	matches := 0
+	errDone := errors.New("enough matches")
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Unreadable entries are skipped
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path != root && d.Type()&fs.ModeSymlink != 0 {
+			return nil // Symlinks may lead outside of the working directory
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxFileSize || !t.args.Scope.Contains(path) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+			return nil
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if !pattern.MatchString(line) {
+				continue
+			}
+			if matches == maxMatches {
+				b.WriteString("... (more matches, narrow the pattern or path)\n")
+				return errDone
+			}
+			fmt.Fprintf(&b, "%s:%d: %s\n", path, i+1, line)
+			matches++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDone) {
+		return "", err
+	}
+	if matches == 0 {
+		return "No matches.", nil
+	}
+	return b.String(), nil
+}
+
+// runTests runs the test command of the project in the sandbox, once the user confirms it, and
+// returns its output.
+func (t *tools) runTests(ctx context.Context) (string, error) {
+	command := t.testCommand
+	if command == "" {
+		command = detectTestCommand(t.args.Scope.Dir)
+	}
+	if strings.TrimSpace(command) == "" {
+		return "", errors.New("no test command is configured or detected, the user can set agent.test_command")
+	}
+	policy, err := sandbox.NewPolicy(t.cfg, t.args.Scope.Dir)
+	if err != nil {
+		return "", err
+	}
+	if err := policy.Check(command); err != nil {
+		return "", err
+	}
+	if !t.confirm(fmt.Sprintf("Run %s? [y/N]: ", command)) {
+		return "The user declined to run the tests.", nil
+	}
+
+	var out bytes.Buffer
+	code, err := policy.Run(ctx, "agent", command, &out, &out)
+	if err != nil {
+		return "", err
+	}
+	// The end of the output has the failures and the summary, and room is left for the status
+	output := out.Bytes()
+	if len(output) > maxResult/2 {
+		output = append([]byte("... (cut)\n"), output[len(output)-maxResult/2:]...)
+	}
+	if code != 0 {
+		return fmt.Sprintf("%s\n%s failed with exit status %d", output, command, code), nil
+	}
+	return fmt.Sprintf("%s\n%s passed", output, command), nil
+}
+
+// writeFile shows the change to a file, and writes it once the user confirms it.
+func (t *tools) writeFile(ctx context.Context, raw json.RawMessage) (string, error) {
+	var a struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return "", fmt.Errorf("invalid args: %w", err)
+	}
+	path, err := t.resolve(a.Path)
+	if err != nil {
+		return "", err
+	}
+	if path == "." {
+		return "", errors.New("write_file needs the path of a file")
+	}
+	// The target of the symlink may change before it is written
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return "", fmt.Errorf("%s is a symlink, write the file it links to instead", path)
+	}
+
+	perm := os.FileMode(0o644)
+	original := ""
+	if info, err := os.Stat(path); err == nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		original, perm = string(data), info.Mode().Perm()
+	}
+	if a.Content == original {
+		return "The file already has this content.", nil
+	}
+
+	diff := patch.Diff(path, original, a.Content)
+	if err := render.RenderMarkdown(ctx, t.cfg, t.args, "```diff\n"+diff.String()+"```\n"); err != nil {
+		return "", err
+	}
+	added, removed := diff.Stats()
+	if !t.confirm(fmt.Sprintf("Write the changes to %s (+%d -%d)? [y/N]: ", path, added, removed)) {
+		return fmt.Sprintf("The user declined to write %s.", path), nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create the directory of %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, []byte(a.Content), perm); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return fmt.Sprintf("Wrote %s.", path), nil
+}
+
+// confirm asks the question on stderr and reports whether it was answered with yes. Without a
+// terminal to answer on, it declines.
+func (t *tools) confirm(question string) bool {
+	if !t.interactive {
+		fmt.Fprintf(os.Stderr, "%sno, stdin is not a terminal\n", question)
+		return false
+	}
+	fmt.Fprint(os.Stderr, question)
+	line, err := t.input.ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// detectTestCommand returns the test command of the project in the directory, by its build files.
+func detectTestCommand(dir string) string {
+	for _, candidate := range testCommands {
+		if _, err := os.Stat(filepath.Join(dir, candidate.file)); err == nil {
+			return candidate.command
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestResolveSymlinks checks that the tools don't follow symlinks out of the working directory,
+// and don't write through them.
+func TestResolveSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wd := t.TempDir()
+	t.Chdir(wd)
+	for link, target := range map[string]string{
+		"out":        outside,
+		"secret":     filepath.Join(outside, "secret"),
+		"inside":     "file",
+		"new-inside": "missing",
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("can't create symlinks: %v", err)
+		}
+	}
+	if err := os.WriteFile("file", []byte("file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tl := &tools{}
+	tests := []struct {
+		path   string
+		denied bool
+	}{
+		{"out", true},
+		{"out/secret", true},
+		{"out/new/file", true},
+		{"secret", true},
+		{"file", false},
+		{"inside", false},
+		{"new/file", false},
+		{"new-inside", true},
+	}
+	for _, tt := range tests {
+		if _, err := tl.resolve(tt.path); (err != nil) != tt.denied {
+			t.Errorf("resolve(%s) = %v, want denied: %t", tt.path, err, tt.denied)
+		}
+	}
+
+	// A symlink to a missing file can't be resolved, and so isn't written either
+	args, _ := json.Marshal(map[string]string{"path": "inside", "content": "changed\n"})
+	if _, err := tl.writeFile(t.Context(), args); err == nil || !strings.Contains(err.Error(), "symlink") {
+		t.Errorf("writing through a symlink: got %v, want it refused", err)
+	}
+}
@@ -6,33 +6,275 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/filetype"
+	"github.com/markis/gh-copilot/internal/postprocess"
+	"github.com/markis/gh-copilot/internal/scope"
+	"github.com/markis/gh-copilot/internal/telemetry"
+	"github.com/markis/gh-copilot/internal/watch"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // Arguments represents the command-line arguments structure.
 type Arguments struct {
-	Prompts      []string
-	Model        string
-	Command      string
-	UsePlainText bool
+	Prompts       []string
+	Model         string
+	Models        []string // Models whose answers to the prompt are compared
+	Command       string
+	UsePlainText  bool
+	Theme         string // Overrides the configured render theme
+	PostProcess   []string
+	CopyBlock     int    // The 1-based code block to copy to the clipboard, 0 to disable
+	Code          string // Only print the code blocks of this language ("*" for all), "" to render the answer
+	Feedback      string // Rating for the previous answer, "good" or "bad"
+	OutputPath    string // File that receives the raw, un-rendered answer
+	OutputFormat  string // Format of the output file: "md", "txt", or "json"
+	DryRun        bool   // Print the request payload instead of sending it
+	Debug         bool   // Enable debug logging
+	LogFile       string // Write debug logs to this file instead of stderr
+	Stop          []string
+	Prefill       string   // Start of the assistant's answer, which the model continues
+	Files         []string // Files attached to the prompt as context
+	PerFile       bool     // Send the prompt once per attached file, in concurrent requests
+	Watch         []string // Files, or globs of files, that re-run the prompt when they change
+	WatchDiff     bool     // Attach the diff of the changes to watched files instead of the files, after the first run
+	Deterministic bool     // Render reproducible output for golden-file tests
+	NoCache       bool     // Request a new answer even when the cache has one
+	NoStream      bool     // Request the whole answer in a single response instead of streaming it
+	JSONSchema    string   // JSON schema file the answer must match, as JSON
+	Stats         bool     // Print the duration, size, and remaining rate limit after the answer
+	TranslateTo   string   // Language the answer is translated to after it was received
+	SideBySide    bool     // Render the translation next to the answer instead of below it
+	StdinAs       string   // How piped input is sent: as the prompt, or fenced as context
+	Lang          string   // Language of the piped input, detected when empty
+	Profile       string   // Config profile, applied when the config was loaded
+	PromptFile    string   // One-off prompt file, whose frontmatter configures the request
+	System        string   // System message sent before the prompts
+	Params        Params   // Sampling parameters of the answer
+	Length        string   // Answer length preset: short, normal, or detailed
+	Output        string   // Output mode: the rendered answer ("text"), or its chunks as JSON Lines ("jsonl")
+	Stdin         string   // Piped input sent as a message of its own, the first prompt, before it was fenced
+	NoSummarize   bool     // Send long piped input as it is instead of summarizing it first
+	Priority      string   // Priority of the requests: high, normal, or low, "" for the command's default
+	Choices       int      // Number of answers requested at once, rendered one after the other
+	Pick          bool     // Ask which of the --choices answers to keep, for --out and --copy
+
+	// Scope confines retrieval, attached files, and git operations to a subdirectory.
+	Scope scope.Scope
+
+	// WatchDebounce is how long changes to watched files must settle before a run.
+	WatchDebounce time.Duration
+
+	// Action is the builtin action to run instead of sending a prompt (e.g. "recover").
+	Action     string
+	ActionArgs []string
+
+	Search  SearchArguments
+	Chat    ChatArguments
+	Config  ConfigArguments
+	Serve   ServeArguments
+	Edit    EditArguments
+	Session SessionArguments
+	Compare CompareArguments
+	Index   IndexArguments
+	PR      PRArguments
+	Commit  CommitArguments
+	Issue   IssueArguments
+	AskRepo AskRepoArguments
+	Shell   ShellArguments
+	Agent   AgentArguments
+
+	// PromptLibrary holds the flags of the `prompts` commands, as Prompts are those of the request.
+	PromptLibrary PromptLibraryArguments
+}
+
+// PromptLibraryArguments holds the flags of the `prompts` commands.
+type PromptLibraryArguments struct {
+	Model  string // Model of the added prompt
+	System string // System message of the added prompt
+	Force  bool   // Replace an existing prompt of the same name on add
+	Yes    bool   // Import without asking for confirmation
+}
+
+// AgentArguments holds the flags of the `agent` command.
+type AgentArguments struct {
+	MaxSteps int // Tool calls before the agent gives up
+}
+
+// ShellArguments holds the flags of the `shell` commands.
+type ShellArguments struct {
+	Name string // Shell the command line is for, $SHELL's by default
+	Run  bool   // Run the suggested command line in the exec sandbox, once the user confirms it
+}
+
+// AskRepoArguments holds the flags of the `askrepo` command.
+type AskRepoArguments struct {
+	Source string // Where the relevant files are found: auto, index, or search
+	Top    int    // Number of files to retrieve
+}
+
+// IssueArguments holds the flags of the `issue` command.
+type IssueArguments struct {
+	Labels      bool // Suggest labels instead of summarizing
+	Reply       bool // Draft a reply instead of summarizing
+	PostComment bool // Draft a reply and post it after confirmation
+}
+
+// CommitArguments holds the flags of the `commit` command.
+type CommitArguments struct {
+	Fixup string // Commit whose message is improved
+}
+
+// PRArguments holds the flags of the `pr` commands.
+type PRArguments struct {
+	Number int  // Pull request to review or whose review threads are replied to
+	Post   bool // Post the review or the drafted replies after confirmation instead of only printing them
+}
+
+// IndexArguments holds the flags of the `index` commands.
+type IndexArguments struct {
+	Model string // Embedding model `index migrate` switches to
+	Top   int    // Maximum number of files `index query` prints
+}
+
+// CompareArguments holds the flags of the `compare` command.
+type CompareArguments struct {
+	Diff bool // Render the word-level changes between the two answers
 }
 
+// Params holds the sampling parameters of a request, unset ones are left to the model.
+type Params struct {
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int
+}
+
+// EditArguments holds the flags of the `edit` command.
+type EditArguments struct {
+	Files    []string // Files to edit
+	Apply    bool     // Write the proposed changes without asking
+	Attempts int      // Answers asked for until one applies
+}
+
+// ServeArguments holds the flags of the `serve` command.
+type ServeArguments struct {
+	Addr string // Address to listen on, empty to serve JSON-RPC on stdin and stdout
+	RPC  bool   // Serve JSON-RPC for editor plugins instead of HTTP
+}
+
+// ConfigArguments holds the flags of the `config` commands.
+type ConfigArguments struct {
+	Force bool // Overwrite an existing config file on init
+}
+
+// ChatArguments holds the flags of the `chat` command.
+type ChatArguments struct {
+	PullRequest int    // Pull request to preload as context, 0 for none
+	Session     string // Stored session to resume, or the name to store the conversation under
+}
+
+// SessionArguments holds the flags of the `session` commands.
+type SessionArguments struct {
+	Format string // Export format: md, html, or json
+}
+
+// SearchArguments holds the flags of the `search` command.
+type SearchArguments struct {
+	Symbol string
+	Lang   string
+	Top    int
+}
+
+// Builtin actions that are handled by the application instead of being sent to the model.
+const (
+	ActionRecover        = "recover"
+	ActionFeedback       = "feedback"
+	ActionStatsQuality   = "stats quality"
+	ActionStatsUsage     = "stats usage"
+	ActionIndexBuild     = "index build"
+	ActionIndexStats     = "index stats"
+	ActionIndexVerify    = "index verify"
+	ActionIndexMigrate   = "index migrate"
+	ActionIndexQuery     = "index query"
+	ActionSearch         = "search"
+	ActionChat           = "chat"
+	ActionConfigInit     = "config init"
+	ActionConfigGet      = "config get"
+	ActionConfigSet      = "config set"
+	ActionConfigValidate = "config validate"
+	ActionServe          = "serve"
+	ActionServeInstall   = "serve install"
+	ActionServeUninstall = "serve uninstall"
+	ActionEdit           = "edit"
+	ActionQuota          = "quota"
+	ActionSessionList    = "session list"
+	ActionSessionExport  = "session export"
+	ActionPromptsInstall = "prompts install"
+	ActionPromptsUpdate  = "prompts update"
+	ActionPromptsList    = "prompts list"
+	ActionPromptsShow    = "prompts show"
+	ActionPromptsAdd     = "prompts add"
+	ActionPromptsRemove  = "prompts remove"
+	ActionPromptsImport  = "prompts import"
+	ActionPromptsExport  = "prompts export"
+	ActionAuthStatus     = "auth status"
+	ActionAuthLogin      = "auth login"
+	ActionLogs           = "logs"
+	ActionPRReplies      = "pr replies"
+	ActionPRReview       = "pr review"
+	ActionCommitFixup    = "commit fixup"
+	ActionCIExplain      = "ci explain"
+	ActionIssue          = "issue"
+	ActionAskRepo        = "askrepo"
+	ActionWrap           = "wrap"
+	ActionInit           = "init"
+	ActionShellSuggest   = "shell suggest"
+	ActionShellExplain   = "shell explain"
+	ActionAgent          = "agent"
+)
+
+// Modes of --stdin-as.
+const (
+	StdinAsPrompt  = "prompt"
+	StdinAsContext = "context"
+)
+
+// maxChoices is the most answers --choices requests at once.
+const maxChoices = 10
+
+// stdinPlaceholder is substituted with the piped input in prompt templates.
+const stdinPlaceholder = "{stdin}"
+
 // ParseArgs parses command-line arguments and stdin input, returning an Arguments struct.
 // It uses Cobra to handle commands and flags, allowing for both predefined commands and direct prompts.
 // It reads from stdin if available, and handles errors gracefully.
 func ParseArgs(ctx context.Context, cfg config.Config) (Arguments, error) {
-	args := Arguments{}
+	args := Arguments{
+		PostProcess: cfg.PostProcess,
+	}
+	stop := cfg.Stop
+	prefill := cfg.Prefill
+	formatCode := false
+	scopeDir := ""
+	httpAddr := ""
+	// Piped input is read once needed, as serve --rpc keeps stdin for its messages
+	readPiped := sync.OnceValues(readStdin)
+	stdinUsed := false // Substituted into a prompt template instead of being sent on its own
 
 	rootCmd := &cobra.Command{
-		Use:   "gh-copilot [command] [flags] [prompt]",
+		Use:   "gh-copilot [command] [flags] [prompt...]",
 		Short: "A GitHub Copilot CLI tool for AI-assisted development",
+		Args:  cobra.ArbitraryArgs, // Unquoted prompts are passed as multiple arguments
 		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
 			// Handle direct prompts (when no command is specified)
-			if len(cmdArgs) > 0 {
-				args.Prompts = append(args.Prompts, cmdArgs[0])
+			if prompt := joinPrompt(cmdArgs, cmd.ArgsLenAtDash()); prompt != "" {
+				args.Prompts = append(args.Prompts, prompt)
 			}
 			return nil
 		},
@@ -42,58 +284,964 @@ func ParseArgs(ctx context.Context, cfg config.Config) (Arguments, error) {
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&args.Model, "model", cfg.Model, "The AI model to use")
+	rootCmd.PersistentFlags().StringSliceVar(&args.Models, "models", nil, "Compare the answers of several models, e.g. gpt-4o,claude-3.7-sonnet")
 	rootCmd.PersistentFlags().BoolVar(&args.UsePlainText, "plain", shouldUsePlainText(cfg), "Disable markdown rendering")
+	rootCmd.PersistentFlags().StringVar(&args.Theme, "theme", "", "Markdown theme: a glamour style name, a JSON style file, or auto")
+	rootCmd.PersistentFlags().BoolVar(&formatCode, "format", false, "Format code blocks with the configured formatters")
+	rootCmd.PersistentFlags().IntVar(&args.CopyBlock, "copy", 0, "Copy the first (or --copy=n th) code block to the clipboard")
+	rootCmd.PersistentFlags().Lookup("copy").NoOptDefVal = "1"
+	rootCmd.PersistentFlags().StringVar(&args.Code, "code", "", "Only print the code blocks of the answer (or --code=lang for one language)")
+	rootCmd.PersistentFlags().Lookup("code").NoOptDefVal = "*"
+	rootCmd.PersistentFlags().StringVar(&args.Feedback, "feedback", "", "Rate the previous answer as good or bad")
+	rootCmd.PersistentFlags().StringArrayVarP(&args.Files, "file", "f", nil, "Attach a file as context, cited by line (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&args.PerFile, "per-file", false, "Ask about each --file in a request of its own, concurrently, and render the answers per file")
+	rootCmd.PersistentFlags().StringArrayVar(&args.Watch, "watch", nil, "Attach a file, or the files of a glob like 'src/**/*.go', and re-run the prompt whenever they change (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&args.WatchDiff, "watch-diff", false, "With --watch, attach only the diff of the changes since the previous run after the first one")
+	rootCmd.PersistentFlags().DurationVar(&args.WatchDebounce, "watch-debounce", watch.DefaultDebounce, "With --watch, how long changes must settle before the prompt re-runs")
+	rootCmd.PersistentFlags().StringArrayVar(&args.Stop, "stop", nil, "Stop generating at this sequence (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&args.Prefill, "prefill", "", "Start of the answer for the model to continue (not echoed)")
+	rootCmd.PersistentFlags().BoolVar(&args.Debug, "debug", false, "Log request, stream, and render details (also GH_COPILOT_DEBUG)")
+	rootCmd.PersistentFlags().StringVar(&args.LogFile, "log-file", "", "Write debug logs to a file instead of stderr")
+	rootCmd.PersistentFlags().BoolVar(&args.DryRun, "dry-run", false, "Print the request payload without contacting the API")
+	rootCmd.PersistentFlags().StringVar(&args.OutputPath, "out", "", "Write the raw answer to a file while streaming")
+	rootCmd.PersistentFlags().BoolVar(&args.Deterministic, "deterministic-output", false, "Render reproducible output: no color, links, or timestamps, and a fixed width")
+	rootCmd.PersistentFlags().BoolVar(&args.Stats, "stats", false, "Print the duration, answer size, and remaining rate limit to stderr")
+	rootCmd.PersistentFlags().BoolVar(&args.NoCache, "no-cache", false, "Request a new answer instead of using the cached one, and cache it")
+	rootCmd.PersistentFlags().StringVar(&args.JSONSchema, "json-schema", "", "Make the answer JSON matching the schema of this file, validated and corrected once")
+	rootCmd.PersistentFlags().BoolVar(&args.NoStream, "no-stream", false, "Wait for the whole answer in a single response instead of streaming it")
+	rootCmd.PersistentFlags().BoolVar(&args.NoSummarize, "no-summarize", false, "Send long piped input as it is, instead of summarizing it before asking")
+	rootCmd.PersistentFlags().StringVar(&args.TranslateTo, "translate-to", "", "Also translate the answer to this language, e.g. fr")
+	rootCmd.PersistentFlags().BoolVar(&args.SideBySide, "side-by-side", false, "Render the --translate-to translation next to the answer")
+	rootCmd.PersistentFlags().StringVar(&args.StdinAs, "stdin-as", StdinAsPrompt, "Send piped input as the prompt, or as context fenced before the prompt")
+	rootCmd.PersistentFlags().StringVar(&args.Profile, "profile", cfg.Profile, "Config profile to use (also GH_COPILOT_PROFILE)")
+	rootCmd.PersistentFlags().StringVar(&args.PromptFile, "prompt-file", "", "Send the prompt of a markdown file, configured by its YAML frontmatter")
+	rootCmd.PersistentFlags().StringVar(&args.Length, "length", cfg.Length, "Answer length: short, normal, or detailed")
+	rootCmd.PersistentFlags().StringVar(&scopeDir, "scope", "", "Confine the index, search, attached files, and git operations to a subdirectory, e.g. a project of a monorepo")
+	rootCmd.PersistentFlags().StringVar(&args.Lang, "lang", "", "Language of the piped input, e.g. go or py (default: detected)")
+	rootCmd.PersistentFlags().StringVar(&args.Output, "output", "text", "Output mode: the rendered answer (text), or each streamed chunk as a line of JSON (jsonl)")
+	rootCmd.PersistentFlags().StringVar(&args.OutputFormat, "out-format", "", "Format of the --out file: md, txt, or json (default: from extension)")
+	rootCmd.PersistentFlags().IntVarP(&args.Choices, "choices", "n", 1, "Request this many answers at once, and render each under a separator")
+	rootCmd.PersistentFlags().BoolVar(&args.Pick, "pick", false, "With --choices, pick the answer to keep for --out and --copy after they were rendered")
+	rootCmd.PersistentFlags().StringVar(&args.Priority, "priority", "", "Request priority: high, normal, or low; low yields to high (default: high for chat and shell, low for index build and migrate)")
+
+	// Add builtin commands
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   ActionRecover,
+		Short: "Print the last autosaved answer",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionRecover
+			return nil
+		},
+	})
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show local usage statistics",
+	}
+	statsCmd.AddCommand(&cobra.Command{
+		Use:   "quality",
+		Short: "Report how often answers were accepted, per command and model",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionStatsQuality
+			return nil
+		},
+	})
+	statsCmd.AddCommand(&cobra.Command{
+		Use:   "usage",
+		Short: "Report the token usage of serve callers, per client and model",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionStatsUsage
+			return nil
+		},
+	})
+	rootCmd.AddCommand(statsCmd)
+
+	indexCmd := &cobra.Command{
+		Use:   "index",
+		Short: "Manage the embeddings index of the current repository",
+	}
+	indexCmd.AddCommand(&cobra.Command{
+		Use:   "build",
+		Short: "Embed the repository's source files into the index",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionIndexBuild
+			return nil
+		},
+	})
+	indexCmd.AddCommand(&cobra.Command{
+		Use:   "stats",
+		Short: "Report the size, freshness, and coverage of the index",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionIndexStats
+			return nil
+		},
+	})
+	indexCmd.AddCommand(&cobra.Command{
+		Use:   "verify",
+		Short: "Check the index for corruption",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionIndexVerify
+			return nil
+		},
+	})
+	migrateCmd := &cobra.Command{
+		Use:   "migrate --model <model>",
+		Short: "Re-embed the chunks of the index that another embedding model embedded",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionIndexMigrate
+			return nil
+		},
+	}
+	migrateCmd.Flags().StringVar(&args.Index.Model, "model", "", "Embedding model to switch the index to, e.g. local:nomic-embed-text")
+	_ = migrateCmd.MarkFlagRequired("model")
+	indexCmd.AddCommand(migrateCmd)
+	queryCmd := &cobra.Command{
+		Use:   "query <text>",
+		Short: "Print the files of the index most similar to the text, with their scores, to debug retrieval",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionIndexQuery
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	queryCmd.Flags().IntVar(&args.Index.Top, "top", cfg.Rag.TopK, "Maximum number of files")
+	indexCmd.AddCommand(queryCmd)
+	rootCmd.AddCommand(indexCmd)
+
+	searchCmd := &cobra.Command{
+		Use:   "search [query]",
+		Short: "Search the repository index by similarity and symbol metadata",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			if len(cmdArgs) == 0 && args.Search.Symbol == "" && args.Search.Lang == "" {
+				return errors.New("search needs a query, --symbol, or --lang")
+			}
+			args.Action = ActionSearch
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	searchCmd.Flags().StringVar(&args.Search.Symbol, "symbol", "", "Only match chunks defining this symbol (glob patterns allowed)")
+	searchCmd.Flags().StringVar(&args.Search.Lang, "lang", "", "Only match chunks of this filetype, e.g. go")
+	searchCmd.Flags().IntVar(&args.Search.Top, "top", 10, "Maximum number of results")
+	rootCmd.AddCommand(searchCmd)
+
+	compareCmd := &cobra.Command{
+		Use:   "compare --models <a,b> [--diff] [prompt...]",
+		Short: "Compare the answers of models, or with --diff a cached and a fresh answer",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			if prompt := joinPrompt(cmdArgs, cmd.ArgsLenAtDash()); prompt != "" {
+				args.Prompts = append(args.Prompts, prompt)
+			}
+			return nil
+		},
+	}
+	compareCmd.Flags().BoolVar(&args.Compare.Diff, "diff", false, "Render a word-level diff of two models' answers, or of one model's cached and fresh answer")
+	rootCmd.AddCommand(compareCmd)
+
+	chatCmd := &cobra.Command{
+		Use:   "chat",
+		Short: "Start an interactive chat session",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionChat
+			return nil
+		},
+	}
+	chatCmd.Flags().IntVar(&args.Chat.PullRequest, "pr", 0, "Preload a pull request's diff, description, and comments as context")
+	chatCmd.Flags().StringVar(&args.Chat.Session, "session", "", "Resume the stored session of this name, or store the conversation under it")
+	rootCmd.AddCommand(chatCmd)
+
+	sessionCmd := &cobra.Command{
+		Use:   "session",
+		Short: "List and export stored chat sessions",
+	}
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the stored chat sessions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionSessionList
+			return nil
+		},
+	})
+	sessionExportCmd := &cobra.Command{
+		Use:   "export <name>",
+		Short: "Render a stored chat session as a shareable document",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionSessionExport
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	sessionExportCmd.Flags().StringVar(&args.Session.Format, "format", "md", "Document format: md, html, or json")
+	sessionCmd.AddCommand(sessionExportCmd)
+	rootCmd.AddCommand(sessionCmd)
+
+	promptsCmd := &cobra.Command{
+		Use:   "prompts",
+		Short: "Manage prompt packs",
+	}
+	promptsCmd.AddCommand(&cobra.Command{
+		Use:   "install <github.com/owner/repo[@version]>",
+		Short: "Install a prompt pack from GitHub, pinning its checksum",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPromptsInstall
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	promptsCmd.AddCommand(&cobra.Command{
+		Use:   "update [pack]",
+		Short: "Update the installed prompt packs, or one of them, to their latest version",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPromptsUpdate
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	promptsCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the prompts of the config, prompt files, and packs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPromptsList
+			return nil
+		},
+	})
+	promptsCmd.AddCommand(&cobra.Command{
+		Use:   "show <name>",
+		Short: "Print a prompt and its settings as YAML",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPromptsShow
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	builtins := map[string]bool{} // Names of the builtin commands, filled before the prompts are added
+	promptsAddCmd := &cobra.Command{
+		Use:   "add <name> [prompt...]",
+		Short: "Add a prompt to the config file, read from stdin if not given",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			name := cmdArgs[0]
+			if builtins[name] {
+				return fmt.Errorf("%s is a builtin command, a prompt can't take its name", name)
+			}
+			if cmd.Flags().Changed("model") {
+				args.PromptLibrary.Model = args.Model
+			}
+			args.Action = ActionPromptsAdd
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	promptsAddCmd.Flags().StringVar(&args.PromptLibrary.System, "system", "", "System message sent before the prompt")
+	promptsAddCmd.Flags().BoolVar(&args.PromptLibrary.Force, "force", false, "Replace an existing prompt of the same name")
+	promptsCmd.AddCommand(promptsAddCmd)
+	promptsCmd.AddCommand(&cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a prompt from the config file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPromptsRemove
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	promptsImportCmd := &cobra.Command{
+		Use:   "import <https-url>",
+		Short: "Add the prompts of a shared YAML file to the config file, after confirmation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPromptsImport
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	promptsImportCmd.Flags().BoolVarP(&args.PromptLibrary.Yes, "yes", "y", false, "Import without asking for confirmation")
+	promptsCmd.AddCommand(promptsImportCmd)
+	promptsCmd.AddCommand(&cobra.Command{
+		Use:   "export [name...]",
+		Short: "Print the prompts, or the named ones, as YAML to share or import",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPromptsExport
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	rootCmd.AddCommand(promptsCmd)
+
+	authCmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Log in to GitHub Copilot and diagnose authentication",
+	}
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "login",
+		Short: "Log in with the device flow and store the token like the Copilot editor plugins",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionAuthLogin
+			return nil
+		},
+	})
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show where the GitHub token comes from and whether Copilot accepts it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionAuthStatus
+			return nil
+		},
+	})
+	rootCmd.AddCommand(authCmd)
+
+	serveCmd := &cobra.Command{
+		Use:   ActionServe,
+		Short: "Serve the Copilot API to local tools as an OpenAI-compatible endpoint",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionServe
+			return resolveServeAddr(cmd, &args.Serve, httpAddr)
+		},
+	}
+	serveCmd.PersistentFlags().StringVar(&args.Serve.Addr, "addr", cfg.Serve.Addr, "Address to listen on: host:port, or unix:[path] for a Unix domain socket")
+	serveCmd.PersistentFlags().StringVar(&httpAddr, "http", "", "Serve the OpenAI-compatible HTTP API on [host]:port, e.g. :8080 for localhost:8080")
+	serveCmd.PersistentFlags().BoolVar(&args.Serve.RPC, "rpc", false, "Serve JSON-RPC for editor plugins, on stdin and stdout unless --addr is given")
+	serveCmd.AddCommand(&cobra.Command{
+		Use:   "install",
+		Short: "Run the server as a user-level systemd or launchd service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionServeInstall
+			if err := resolveServeAddr(cmd, &args.Serve, httpAddr); err != nil {
+				return err
+			}
+			if args.Serve.RPC && args.Serve.Addr == "" {
+				return errors.New("--rpc needs an --addr for the service, which has no stdin")
+			}
+			// The service follows the config, unless flags were given
+			cmd.Flags().Visit(func(flag *pflag.Flag) {
+				args.ActionArgs = append(args.ActionArgs, "--"+flag.Name+"="+flag.Value.String())
+			})
+			return nil
+		},
+	})
+	serveCmd.AddCommand(&cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop and remove the server's service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionServeUninstall
+			return nil
+		},
+	})
+	rootCmd.AddCommand(serveCmd)
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   ActionQuota,
+		Short: "Show the remaining rate limit last reported by the Copilot API",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionQuota
+			return nil
+		},
+	})
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "logs [question...]",
+		Short: "Find the root cause of a failure in a piped CI or build log",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionLogs
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+
+	ciCmd := &cobra.Command{
+		Use:   "ci",
+		Short: "Work with GitHub Actions workflow runs",
+	}
+	ciCmd.AddCommand(&cobra.Command{
+		Use:   "explain <run-id|url> [question...]",
+		Short: "Diagnose the failed jobs of a workflow run from their logs",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionCIExplain
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	rootCmd.AddCommand(ciCmd)
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "wrap -- <command> [args...]",
+		Short: "Run a command, and explain its output and suggest a fix when it fails",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionWrap
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:       "init <zsh|bash|fish>",
+		Short:     "Print the shell widgets that suggest (Ctrl-G) or explain (Alt-G) the command line",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"bash", "fish", "zsh"},
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionInit
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+
+	shellCmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Suggest or explain command lines, for the widgets of `init`",
+	}
+	shellCmd.PersistentFlags().StringVar(&args.Shell.Name, "shell", "", "Shell the command line is for (default: $SHELL)")
+	suggestCmd := &cobra.Command{
+		Use:   "suggest <task|command line...>",
+		Short: "Print only a command line for a task, or a fixed or completed command line",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionShellSuggest
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	suggestCmd.Flags().BoolVar(&args.Shell.Run, "run", false, "Run the command line in the exec sandbox, once confirmed")
+	shellCmd.AddCommand(suggestCmd)
+	shellCmd.AddCommand(&cobra.Command{
+		Use:   "explain <command line...>",
+		Short: "Explain what a command line does",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionShellExplain
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	rootCmd.AddCommand(shellCmd)
+
+	askRepoCmd := &cobra.Command{
+		Use:   "askrepo [question...]",
+		Short: "Answer a question about the repository from its most relevant files",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionAskRepo
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	askRepoCmd.Flags().StringVar(&args.AskRepo.Source, "source", "auto", "Where to find the relevant files: index, search (GitHub code search), or auto")
+	askRepoCmd.Flags().IntVar(&args.AskRepo.Top, "top", cfg.Rag.TopK, "Number of files to retrieve")
+	rootCmd.AddCommand(askRepoCmd)
+
+	issueCmd := &cobra.Command{
+		Use:   "issue <number|url> [instructions...]",
+		Short: "Summarize an issue, suggest labels, or draft a reply",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionIssue
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	issueCmd.Flags().BoolVar(&args.Issue.Labels, "labels", false, "Suggest labels of the repository that fit the issue")
+	issueCmd.Flags().BoolVar(&args.Issue.Reply, "reply", false, "Draft a reply to the issue")
+	issueCmd.Flags().BoolVar(&args.Issue.PostComment, "post-comment", false, "Draft a reply and post it after confirmation")
+	issueCmd.MarkFlagsMutuallyExclusive("labels", "reply")
+	issueCmd.MarkFlagsMutuallyExclusive("labels", "post-comment")
+	rootCmd.AddCommand(issueCmd)
+
+	prCmd := &cobra.Command{
+		Use:   "pr",
+		Short: "Work on the review of a pull request",
+	}
+	prRepliesCmd := &cobra.Command{
+		Use:   "replies --pr <number>",
+		Short: "Draft replies to the unresolved review threads you take part in",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPRReplies
+			return nil
+		},
+	}
+	prRepliesCmd.Flags().IntVar(&args.PR.Number, "pr", 0, "Pull request whose review threads to reply to")
+	prRepliesCmd.Flags().BoolVar(&args.PR.Post, "post", false, "Ask to post each drafted reply to its thread")
+	_ = prRepliesCmd.MarkFlagRequired("pr")
+	prCmd.AddCommand(prRepliesCmd)
+	prReviewCmd := &cobra.Command{
+		Use:   "review --pr <number> [instructions...]",
+		Short: "Review the changes of a pull request, and post the findings as a draft review",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionPRReview
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	prReviewCmd.Flags().IntVar(&args.PR.Number, "pr", 0, "Pull request to review")
+	prReviewCmd.Flags().BoolVar(&args.PR.Post, "post", false, "Ask to post the findings as a draft review with inline comments")
+	_ = prReviewCmd.MarkFlagRequired("pr")
+	prCmd.AddCommand(prReviewCmd)
+	rootCmd.AddCommand(prCmd)
+
+	commitCmd := &cobra.Command{
+		Use:   "commit --fixup <commit> [instructions...]",
+		Short: "Propose a better message for a commit, and amend it or create an amend! commit",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionCommitFixup
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	commitCmd.Flags().StringVar(&args.Commit.Fixup, "fixup", "", "Commit whose message to improve, e.g. HEAD")
+	_ = commitCmd.MarkFlagRequired("fixup")
+	rootCmd.AddCommand(commitCmd)
+
+	editCmd := &cobra.Command{
+		Use:   "edit [<file> | --file <file>...] [instructions...]",
+		Short: "Ask for changes to files as a unified diff, and review or apply them",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			// Several files are given with --file, a single one can be the first argument
+			if len(args.Files) > 0 {
+				args.Edit.Files, args.Files = args.Files, nil
+			} else if len(cmdArgs) > 0 {
+				args.Edit.Files, cmdArgs = cmdArgs[:1], cmdArgs[1:]
+			} else {
+				return errors.New("edit needs a file to edit")
+			}
+			args.Action = ActionEdit
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	editCmd.Flags().BoolVar(&args.Edit.Apply, "apply", false, "Write the changes without asking, keeping .orig backups")
+	editCmd.Flags().IntVar(&args.Edit.Attempts, "attempts", cfg.Edit.Attempts, "Answers asked for until one applies, showing the model the lines its diff got wrong")
+	rootCmd.AddCommand(editCmd)
+
+	agentCmd := &cobra.Command{
+		Use:   "agent [task...]",
+		Short: "Let the model read, search, and test the code to accomplish a task, asking before writes and runs",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionAgent
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	}
+	agentCmd.Flags().IntVar(&args.Agent.MaxSteps, "max-steps", cfg.Agent.MaxSteps, "Tool calls before the agent gives up")
+	rootCmd.AddCommand(agentCmd)
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the configuration file",
+	}
+	configInitCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a commented config file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionConfigInit
+			return nil
+		},
+	}
+	configInitCmd.Flags().BoolVar(&args.Config.Force, "force", false, "Overwrite an existing config file")
+	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "get <key>",
+		Short: "Print the effective value of a config key, e.g. render.theme",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionConfigGet
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a config key in the config file",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionConfigSet
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "validate [path...]",
+		Short: "Check the user and project config files against the schema",
+		RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+			args.Action = ActionConfigValidate
+			args.ActionArgs = cmdArgs
+			return nil
+		},
+	})
+	rootCmd.AddCommand(configCmd)
 
 	// Add predefined commands
+	for _, cmd := range rootCmd.Commands() {
+		builtins[cmd.Name()] = true
+	}
 	for name, prompt := range cfg.Prompts {
+		if hasCommand(rootCmd, name) {
+			continue // Builtin commands take precedence
+		}
 		cmdPrompt := prompt // Create a local copy for the closure
+		params := make(map[string]*string, len(cmdPrompt.Args))
 		cmd := &cobra.Command{
-			Use:   name + " [input]",
+			Use:   name + " [input...]",
 			Short: summarizePrompt(cmdPrompt.Prompt),
 			RunE: func(cmd *cobra.Command, cmdArgs []string) error {
 				args.Command = name
-				if len(cmdArgs) > 0 {
-					args.Prompts = append(args.Prompts, cmdArgs[0])
+				if input := joinPrompt(cmdArgs, cmd.ArgsLenAtDash()); input != "" {
+					args.Prompts = append(args.Prompts, input)
+				}
+				var stdin *string
+				if strings.Contains(cmdPrompt.Prompt, stdinPlaceholder) {
+					piped, err := readPiped()
+					if err != nil {
+						return err
+					}
+					stdin, stdinUsed = &piped, true
 				}
-				args.Prompts = append(args.Prompts, cmdPrompt.Prompt)
+				args.Prompts = append(args.Prompts, expandPrompt(cmdPrompt.Prompt, params, stdin))
 				if cmdPrompt.Model != "" {
 					args.Model = cmdPrompt.Model
 				}
+				if len(cmdPrompt.PostProcess) > 0 {
+					args.PostProcess = cmdPrompt.PostProcess
+				}
+				if len(cmdPrompt.Stop) > 0 {
+					stop = cmdPrompt.Stop
+				}
+				if cmdPrompt.Prefill != "" {
+					prefill = cmdPrompt.Prefill
+				}
+				if cmdPrompt.System != "" {
+					args.System = cmdPrompt.System
+				}
+				args.Params = Params{Temperature: cmdPrompt.Temperature, TopP: cmdPrompt.TopP, MaxTokens: cmdPrompt.MaxTokens}
+				// Output that isn't a terminal stays plain, as with render.format
+				if cmdPrompt.Format != "" && !rootCmd.PersistentFlags().Changed("plain") {
+					promptCfg := cfg
+					promptCfg.Render.Format = cmdPrompt.Format
+					args.UsePlainText = shouldUsePlainText(promptCfg)
+				}
 				return nil
 			},
 		}
+		for _, param := range cmdPrompt.Args {
+			params[param] = cmd.Flags().String(param, "", fmt.Sprintf("Value for {%s} in the prompt", param))
+			if err := cmd.MarkFlagRequired(param); err != nil {
+				return Arguments{}, fmt.Errorf("failed to register argument %s for %s: %w", param, name, err)
+			}
+		}
 		rootCmd.AddCommand(cmd)
 	}
 
-	// Read from stdin if available
-	if stat, err := os.Stdin.Stat(); err == nil && (stat.Mode()&os.ModeCharDevice) == 0 {
-		scanner := bufio.NewScanner(os.Stdin)
-		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // 1MB max buffer
-		var buf strings.Builder
-		for scanner.Scan() {
-			buf.WriteString(scanner.Text())
-			buf.WriteByte('\n')
+	// Execute the command
+	if err := rootCmd.Execute(); err != nil {
+		return Arguments{}, err
+	}
+	var stdin string
+	var err error
+	if !args.Serve.RPC || args.Serve.Addr != "" {
+		if stdin, err = readPiped(); err != nil {
+			return Arguments{}, err
+		}
+	}
+
+	if args.PromptFile != "" {
+		file, err := config.LoadPromptFile(args.PromptFile)
+		if err != nil {
+			return Arguments{}, err
 		}
-		if err := scanner.Err(); err != nil {
-			return Arguments{}, fmt.Errorf("failed to read stdin: %w", err)
+		if strings.Contains(file.Prompt, stdinPlaceholder) {
+			file.Prompt = strings.ReplaceAll(file.Prompt, stdinPlaceholder, stdin)
+			stdinUsed = true
+		}
+		args.Prompts = append(args.Prompts, file.Prompt)
+		args.System = file.System
+		args.Params = Params{Temperature: file.Temperature, TopP: file.TopP, MaxTokens: file.MaxTokens}
+
+		// Flags take precedence over the file
+		flags := rootCmd.PersistentFlags()
+		if file.Model != "" && !flags.Changed("model") {
+			args.Model = file.Model
+		}
+		if len(file.Stop) > 0 {
+			stop = file.Stop
+		}
+		if file.Prefill != "" {
+			prefill = file.Prefill
+		}
+		if len(file.PostProcess) > 0 {
+			args.PostProcess = file.PostProcess
 		}
-		prompt := strings.TrimSpace(buf.String())
-		args.Prompts = append(args.Prompts, prompt)
 	}
 
-	// Execute the command
-	if err := rootCmd.Execute(); err != nil {
+	switch args.Length {
+	case "short", "normal", "detailed":
+	default:
+		return Arguments{}, fmt.Errorf("invalid --length %q: must be short, normal, or detailed", args.Length)
+	}
+	switch args.Priority {
+	case "", "high", "normal", "low":
+	default:
+		return Arguments{}, fmt.Errorf("invalid --priority %q: must be high, normal, or low", args.Priority)
+	}
+	if args.Action == ActionAgent && args.Agent.MaxSteps < 1 {
+		return Arguments{}, fmt.Errorf("invalid --max-steps %d: must be at least 1", args.Agent.MaxSteps)
+	}
+
+	if args.Scope, err = scope.Resolve(ctx, scopeDir); err != nil {
 		return Arguments{}, err
 	}
+	for _, path := range slices.Concat(args.Files, args.Watch, args.Edit.Files) {
+		if !args.Scope.Contains(path) {
+			return Arguments{}, fmt.Errorf("%s is outside of --scope %s", path, scopeDir)
+		}
+	}
+
+	// Piped input comes first, followed by the prompt it is about
+	switch args.StdinAs {
+	case StdinAsPrompt, StdinAsContext:
+	default:
+		return Arguments{}, fmt.Errorf("invalid --stdin-as %q: must be prompt or context", args.StdinAs)
+	}
+	if stdin != "" && !stdinUsed {
+		args.Stdin = stdin
+
+		// Code is fenced with its language, so the model knows what it is looking at
+		lang := filetype.Normalize(args.Lang)
+		if lang == "" {
+			lang = filetype.FromContent(stdin)
+		}
+		switch {
+		case args.StdinAs == StdinAsContext:
+			stdin = "Context (piped input):\n" + fence(stdin, lang)
+		case lang != "":
+			stdin = fence(stdin, lang)
+		}
+		args.Prompts = append([]string{stdin}, args.Prompts...)
+	}
+
+	// Golden files capture the rendered markdown, which plain text mode would skip when redirected
+	if args.Deterministic && !rootCmd.PersistentFlags().Changed("plain") {
+		args.UsePlainText = false
+	}
+
+	if args.Action == ActionEdit && args.Edit.Attempts < 1 {
+		return Arguments{}, fmt.Errorf("invalid --attempts %d: must be at least 1", args.Edit.Attempts)
+	}
+
+	args.Model = cfg.ResolveModel(args.Model)
+	for i, model := range args.Models {
+		args.Models[i] = cfg.ResolveModel(model)
+	}
+	if len(args.Models) == 1 {
+		args.Model, args.Models = args.Models[0], nil
+	}
+
+	// Flags take precedence over the config
+	if len(args.Stop) == 0 {
+		args.Stop = stop
+	}
+	if args.Prefill == "" {
+		args.Prefill = prefill
+	}
+
+	// Builtin actions don't need a prompt
+	if args.Action != "" {
+		return args, nil
+	}
+
+	if args.Feedback != "" {
+		if args.Feedback != telemetry.FeedbackGood && args.Feedback != telemetry.FeedbackBad {
+			return Arguments{}, fmt.Errorf("invalid feedback %q: must be good or bad", args.Feedback)
+		}
+		if len(args.Prompts) > 0 {
+			return Arguments{}, errors.New("--feedback rates the previous answer and can't be combined with a prompt")
+		}
+		args.Action = ActionFeedback
+		return args, nil
+	}
 
 	// Check if we have any prompts
 	if len(args.Prompts) == 0 {
 		return Arguments{}, errors.New("no prompt provided")
 	}
 
+	if (args.WatchDiff || rootCmd.PersistentFlags().Changed("watch-debounce")) && len(args.Watch) == 0 {
+		return Arguments{}, errors.New("--watch-diff and --watch-debounce require --watch")
+	}
+
+	if len(args.Models) > 1 && (args.OutputPath != "" || args.CopyBlock > 0) {
+		return Arguments{}, errors.New("--out and --copy take a single answer and can't be combined with --models")
+	}
+
+	if args.Compare.Diff && len(args.Models) > 2 {
+		return Arguments{}, errors.New("--diff compares two answers: give two --models, or one to compare its cached and fresh answer")
+	}
+	if args.Compare.Diff && (args.OutputPath != "" || args.CopyBlock > 0 || args.Code != "" || args.TranslateTo != "") {
+		return Arguments{}, errors.New("--diff renders the changes between two answers and can't be combined with --out, --copy, --code, or --translate-to")
+	}
+
+	if args.PerFile && (len(args.Models) > 1 || args.Compare.Diff || args.OutputPath != "" || args.CopyBlock > 0 ||
+		args.TranslateTo != "" || args.Output != "text" || len(args.Watch) > 0) {
+		return Arguments{}, errors.New("--per-file renders an answer per file and can't be combined with --models, --diff, --out, --copy, --translate-to, --output, or --watch")
+	}
+
+	if args.Choices < 1 || args.Choices > maxChoices {
+		return Arguments{}, fmt.Errorf("invalid --choices %d: must be between 1 and %d", args.Choices, maxChoices)
+	}
+	if args.Choices > 1 && (len(args.Models) > 1 || args.Compare.Diff || args.PerFile || args.TranslateTo != "") {
+		return Arguments{}, errors.New("--choices renders several answers to one request and can't be combined with --models, --diff, --per-file, or --translate-to")
+	}
+	if args.JSONSchema != "" && (len(args.Models) > 1 || args.Compare.Diff || args.PerFile || args.Choices > 1 ||
+		args.TranslateTo != "" || args.Code != "") {
+		return Arguments{}, errors.New("--json-schema validates a single JSON answer and can't be combined with --models, --diff, --per-file, --choices, --translate-to, or --code")
+	}
+	if args.Pick && args.Choices < 2 {
+		return Arguments{}, errors.New("--pick requires --choices of 2 or more")
+	}
+
+	if args.SideBySide && args.TranslateTo == "" {
+		return Arguments{}, errors.New("--side-by-side requires --translate-to")
+	}
+	if args.TranslateTo != "" && (len(args.Models) > 1 || args.Code != "") {
+		return Arguments{}, errors.New("--translate-to translates a rendered answer and can't be combined with --models or --code")
+	}
+	if args.SideBySide && args.OutputPath != "" {
+		return Arguments{}, errors.New("--side-by-side can't be combined with --out")
+	}
+
+	if formatCode && !slices.Contains(args.PostProcess, postprocess.Format) {
+		args.PostProcess = append(slices.Clone(args.PostProcess), postprocess.Format)
+	}
+
+	if err := postprocess.Validate(args.PostProcess); err != nil {
+		return Arguments{}, err
+	}
+
+	switch args.Output {
+	case "text":
+	case "jsonl":
+		if len(args.Models) > 1 || args.TranslateTo != "" || args.Code != "" || args.Compare.Diff {
+			return Arguments{}, errors.New("--output jsonl streams a single answer and can't be combined with --models, --translate-to, --code, or --diff")
+		}
+	default:
+		return Arguments{}, fmt.Errorf("invalid --output %q: must be text or jsonl", args.Output)
+	}
+
+	switch args.OutputFormat {
+	case "", "md", "txt", "json":
+	default:
+		return Arguments{}, fmt.Errorf("invalid --out-format %q: must be md, txt, or json", args.OutputFormat)
+	}
+
+	if args.CopyBlock < 0 {
+		return Arguments{}, errors.New("--copy must be a positive code block number")
+	}
+
 	return args, nil
 }
 
+// Profile finds the config profile selected by the --profile flag or the GH_COPILOT_PROFILE
+// environment variable. It is needed to load the config, before the arguments are parsed.
+func Profile(argv []string) string {
+	for i, arg := range argv {
+		if arg == "--" {
+			break
+		}
+		if value, ok := strings.CutPrefix(arg, "--profile="); ok {
+			return value
+		}
+		if arg == "--profile" && i+1 < len(argv) {
+			return argv[i+1]
+		}
+	}
+	return os.Getenv("GH_COPILOT_PROFILE")
+}
+
+// resolveServeAddr sets the address `serve` listens on from its flags: that of --http, where a
+// missing host is localhost, as the server hands out the user's Copilot access, none for --rpc
+// on stdin and stdout, or --addr.
+func resolveServeAddr(cmd *cobra.Command, serve *ServeArguments, httpAddr string) error {
+	switch {
+	case httpAddr != "":
+		if serve.RPC || cmd.Flags().Changed("addr") {
+			return errors.New("--http gives the address of the HTTP server and can't be combined with --addr or --rpc")
+		}
+		if strings.HasPrefix(httpAddr, ":") {
+			httpAddr = "localhost" + httpAddr
+		}
+		serve.Addr = httpAddr
+	case serve.RPC && !cmd.Flags().Changed("addr"):
+		serve.Addr = ""
+	}
+	return nil
+}
+
+// readStdin reads the piped input, if any.
+func readStdin() (string, error) {
+	if stat, err := os.Stdin.Stat(); err != nil || (stat.Mode()&os.ModeCharDevice) != 0 {
+		return "", nil
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // 1MB max buffer
+	var buf strings.Builder
+	for scanner.Scan() {
+		buf.WriteString(scanner.Text())
+		buf.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// fence wraps the input in a code fence tagged with its language. The fence is longer than
+// any backtick run in the input, which may itself be markdown.
+func fence(input, lang string) string {
+	longest, run := 0, 0
+	for _, r := range input {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	return fence + lang + "\n" + input + "\n" + fence
+}
+
+// hasCommand checks if the root command already has a subcommand with the given name.
+func hasCommand(root *cobra.Command, name string) bool {
+	for _, cmd := range root.Commands() {
+		if cmd.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
 // shouldUsePlainText determines if plain text output should be used based on environment and terminal settings.
 func shouldUsePlainText(cfg config.Config) bool {
 	// Check if the rendering format is set to plain
@@ -121,6 +1269,69 @@ func shouldUsePlainText(cfg config.Config) bool {
 	return false
 }
 
+// joinPrompt joins the positional arguments into a single prompt, so prompts don't need quoting.
+// Arguments that contained whitespace were quoted in the shell, so they are re-quoted to keep
+// their grouping, and multi-line arguments are put on their own lines. Arguments after `--`
+// (at index dash) are joined literally.
+func joinPrompt(cmdArgs []string, dash int) string {
+	if len(cmdArgs) == 1 {
+		return cmdArgs[0]
+	}
+
+	words := cmdArgs
+	var literal []string
+	if dash >= 0 {
+		words, literal = cmdArgs[:dash], cmdArgs[dash:]
+	}
+
+	var b strings.Builder
+	for _, word := range words {
+		switch {
+		case strings.Contains(word, "\n"):
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(word)
+			b.WriteByte('\n')
+			continue
+		case strings.ContainsAny(word, " \t") && !strings.ContainsRune(word, '"'):
+			word = `"` + word + `"`
+		}
+
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+	}
+
+	if len(literal) > 0 {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strings.Join(literal, " "))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// expandPrompt substitutes the named prompt arguments into their {name} placeholders, and the
+// piped input, unless nil, into {stdin}. Both are substituted in a single pass, so placeholders in
+// the piped input or the arguments' values are left as they are.
+func expandPrompt(prompt string, params map[string]*string, stdin *string) string {
+	if len(params) == 0 && stdin == nil {
+		return prompt
+	}
+
+	replacements := make([]string, 0, len(params)*2+2)
+	if stdin != nil {
+		replacements = append(replacements, stdinPlaceholder, *stdin)
+	}
+	for name, value := range params {
+		replacements = append(replacements, "{"+name+"}", *value)
+	}
+	return strings.NewReplacer(replacements...).Replace(prompt)
+}
+
+// summarizePrompt creates a short description of a prompt for the command help.
 func summarizePrompt(prompt string) string {
 	// Trim and limit the length of the prompt summary
 	summary := strings.TrimSpace(prompt)
@@ -0,0 +1,201 @@
+// Package askrepo answers questions about the current repository, grounded by the files most
+// relevant to the question.
+package askrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/github"
+	"github.com/markis/gh-copilot/internal/index"
+	"github.com/markis/gh-copilot/internal/scope"
+	"github.com/markis/gh-copilot/internal/tokens"
+)
+
+// Sources of the files, chosen with --source.
+const (
+	SourceAuto   = "auto"   // The index when the repository has one, code search otherwise
+	SourceIndex  = "index"  // The local embeddings index
+	SourceSearch = "search" // GitHub code search
+)
+
+const (
+	maxKeywords   = 5     // Keywords of the question that code search looks for
+	maxFileTokens = 8000  // Larger files are left out, they would crowd out the others
+	maxTokens     = 24000 // Tokens of all files attached to the question
+)
+
+// systemPrompt grounds the answer in the attached files.
+const systemPrompt = `You answer questions about the current repository. The attached files were retrieved as the most
+relevant to the question; base the answer on them, cite the code it relies on, and say so when they don't answer it.`
+
+// word matches the words of a question that can be searched for, including identifiers like config.Load.
+var word = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_.]*[A-Za-z0-9_]`)
+
+// stopWords are the words of a question that don't help finding code.
+var stopWords = map[string]bool{
+	"about": true, "and": true, "are": true, "can": true, "code": true, "does": true, "file": true, "files": true,
+	"for": true, "from": true, "function": true, "how": true, "into": true, "not": true, "repo": true,
+	"repository": true, "should": true, "that": true, "the": true, "there": true, "this": true, "use": true,
+	"used": true, "what": true, "when": true, "where": true, "which": true, "who": true, "why": true,
+	"with": true, "work": true, "works": true,
+}
+
+// Run retrieves the files most relevant to the question from the index or with code search,
+// attaches them, and asks the question, citing the files in the answer.
+func Run(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	question := strings.TrimSpace(strings.Join(append(args.ActionArgs, args.Prompts...), "\n\n"))
+	if question == "" {
+		return errors.New("askrepo needs a question, as arguments or on stdin")
+	}
+
+	var files []string
+	var err error
+	switch args.AskRepo.Source {
+	case SourceIndex:
+		files, err = fromIndex(ctx, cfg, args.Scope, question, args.AskRepo.Top)
+	case SourceSearch:
+		files, err = fromSearch(ctx, args.Scope, question, args.AskRepo.Top)
+	case SourceAuto:
+		files, err = fromIndex(ctx, cfg, args.Scope, question, args.AskRepo.Top)
+		if errors.Is(err, index.ErrNoIndex) {
+			fmt.Fprintln(os.Stderr, "The repository has no index, using GitHub code search (`gh copilot index build` indexes it)")
+			files, err = fromSearch(ctx, args.Scope, question, args.AskRepo.Top)
+		}
+	default:
+		return fmt.Errorf("invalid --source %q: must be %s, %s, or %s", args.AskRepo.Source, SourceAuto, SourceIndex, SourceSearch)
+	}
+	if err != nil {
+		return err
+	}
+
+	files = fitFiles(args.Model, files)
+	if len(files) == 0 {
+		return errors.New("found no files relevant to the question")
+	}
+	fmt.Fprintf(os.Stderr, "Attached %s\n", strings.Join(files, ", "))
+
+	args.Files = append(args.Files, files...)
+	args.Prompts = []string{question}
+	args.System = strings.TrimSpace(args.System + "\n\n" + systemPrompt)
+	args.Command = args.Action
+	args.Action = ""
+	return client.Ask(ctx, cfg, args)
+}
+
+// fromIndex returns the files of the index most similar to the question, relative to the working directory.
+func fromIndex(ctx context.Context, cfg config.Config, s scope.Scope, question string, top int) ([]string, error) {
+	root, err := index.FindRoot(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.Load(root)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := index.SearchFiles(ctx, cfg, idx, question, top)
+	if err != nil {
+		return nil, fmt.Errorf("searching index: %w", err)
+	}
+	var files []string
+	for _, result := range results {
+		files = append(files, relative(filepath.Join(idx.Root, filepath.FromSlash(result.Path))))
+	}
+	return files, nil
+}
+
+// fromSearch returns the files that GitHub code search finds for the keywords of the question,
+// relative to the working directory. Files that aren't checked out are left out.
+func fromSearch(ctx context.Context, s scope.Scope, question string, top int) ([]string, error) {
+	keywords := Keywords(question)
+	if len(keywords) == 0 {
+		return nil, errors.New("the question has no keywords to search for")
+	}
+	query := strings.Join(keywords, " OR ")
+	if s.Prefix != "" {
+		query += " path:" + strings.TrimSuffix(s.Prefix, "/")
+	}
+
+	paths, err := github.SearchCode(ctx, query, top)
+	if err != nil {
+		return nil, err
+	}
+	root, err := index.FindRoot(ctx, scope.Scope{})
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, path := range paths {
+		file := filepath.Join(root, filepath.FromSlash(path))
+		if _, err := os.Stat(file); err == nil && s.ContainsRepoPath(path) {
+			files = append(files, relative(file))
+		}
+	}
+	return files, nil
+}
+
+// Keywords returns the words of the question to search code for: identifiers first, then the longest words.
+func Keywords(question string) []string {
+	var identifiers, words []string
+	for _, w := range word.FindAllString(question, -1) {
+		lower := strings.ToLower(w)
+		if len(w) < 3 || stopWords[lower] || slices.Contains(identifiers, w) || slices.Contains(words, lower) {
+			continue
+		}
+		if isIdentifier(w) {
+			identifiers = append(identifiers, w)
+		} else {
+			words = append(words, lower)
+		}
+	}
+	slices.SortStableFunc(words, func(a, b string) int { return len(b) - len(a) })
+	keywords := append(identifiers, words...)
+	return keywords[:min(len(keywords), maxKeywords)]
+}
+
+// isIdentifier reports whether the word looks like code, e.g. camelCase, snake_case, or a qualified name.
+func isIdentifier(w string) bool {
+	return strings.ContainsAny(w, "_.") || strings.ContainsFunc(w[1:], unicode.IsUpper)
+}
+
+// fitFiles keeps the files, in order, that fit the token budget of the attached files.
+func fitFiles(model string, files []string) []string {
+	var kept []string
+	total := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil || slices.Contains(kept, file) {
+			continue
+		}
+		n := tokens.Count(model, string(data))
+		if n > maxFileTokens || total+n > maxTokens {
+			continue
+		}
+		kept = append(kept, file)
+		total += n
+	}
+	return kept
+}
+
+// relative returns the path relative to the working directory when it is below it, so citations are short.
+func relative(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,55 @@
+package attach
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/filetype"
+)
+
+// CitationInstruction asks the model to cite the attached files so the citations can be linked.
+const CitationInstruction = "The attached files are shown with a line number before each line. " +
+	"When a statement refers to the attached code, cite the location as `path:line` or `path:start-end`, " +
+	"using the path exactly as given."
+
+// File is a file attached to the prompt as context.
+type File struct {
+	Path    string // Path as given by the user, used in citations
+	Lang    string
+	Content string
+}
+
+// Load reads the files to attach.
+func Load(paths []string) ([]File, error) {
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attached file: %w", err)
+		}
+
+		files = append(files, File{
+			Path:    filepath.ToSlash(filepath.Clean(path)),
+			Lang:    filetype.FromPath(path),
+			Content: string(data),
+		})
+	}
+	return files, nil
+}
+
+// Message formats the file as a fenced code block with line numbers, for use as a prompt message.
+func (f File) Message() string {
+	lines := strings.Split(strings.TrimSuffix(f.Content, "\n"), "\n")
+	width := len(strconv.Itoa(len(lines)))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: `%s`\n```%s\n", f.Path, f.Lang)
+	for i, line := range lines {
+		fmt.Fprintf(&b, "%*d: %s\n", width, i+1, line)
+	}
+	b.WriteString("```")
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package autosave
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+const (
+	autosaveDir  = "autosave"
+	filePattern  = "answer-*.md"
+	keepAutosave = 10
+)
+
+// ErrNoAutosave is returned when there is no autosaved answer to recover.
+var ErrNoAutosave = errors.New("no autosaved answer found")
+
+// Writer continuously persists the raw streamed answer to disk, so a crash,
+// network drop, or closed terminal never loses a long generation.
+type Writer struct {
+	file *os.File
+}
+
+// NewWriter creates a new autosave file in the state directory, pruning old autosaves.
+func NewWriter() (*Writer, error) {
+	dir, err := getAutosavePath()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create autosave directory: %w", err)
+	}
+
+	if err := prune(dir, keepAutosave-1); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(dir, filePattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create autosave file: %w", err)
+	}
+
+	return &Writer{file: file}, nil
+}
+
+// Write appends streamed content to the autosave file.
+// Writes go straight to the file descriptor, so content survives a process crash.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+// Close closes the autosave file, keeping it on disk for `recover`.
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
+
+// Latest returns the contents of the most recent autosaved answer.
+func Latest() (string, error) {
+	dir, err := getAutosavePath()
+	if err != nil {
+		return "", err
+	}
+
+	files, err := listAutosaves(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", ErrNoAutosave
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to read autosave: %w", err)
+	}
+	return string(data), nil
+}
+
+// getAutosavePath retrieves the directory where autosaved answers are stored.
+func getAutosavePath() (string, error) {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	return filepath.Join(stateDir, autosaveDir), nil
+}
+
+// listAutosaves returns the autosave files in the directory, newest first.
+func listAutosaves(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, filePattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list autosaves: %w", err)
+	}
+
+	modTimes := make(map[string]int64, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime().UnixNano()
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return modTimes[files[i]] > modTimes[files[j]]
+	})
+	return files, nil
+}
+
+// prune removes all but the newest keep autosave files.
+func prune(dir string, keep int) error {
+	files, err := listAutosaves(dir)
+	if err != nil {
+		return err
+	}
+
+	for i := keep; i < len(files); i++ {
+		if err := os.Remove(files[i]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old autosave: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// responsesDir is the directory of cached answers, in the application cache directory.
+const responsesDir = "responses"
+
+// Key hashes a request payload into a cache key, so identical requests share their answer.
+func Key(payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Get returns the cached answer for the key, unless it is missing or older than the TTL.
+func Get(key string, ttl time.Duration) (string, bool) {
+	path, err := entryPath(key)
+	if err != nil {
+		return "", false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || (ttl > 0 && time.Since(info.ModTime()) > ttl) {
+		return "", false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// Put caches the answer for the key.
+func Put(key, answer string) error {
+	path, err := entryPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Write atomically, so concurrent invocations never read a partial answer
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(answer); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// entryPath returns the path of the cache entry for the key.
+func entryPath(key string) (string, error) {
+	cacheDir, err := config.CachePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache path: %w", err)
+	}
+	return filepath.Join(cacheDir, responsesDir, key), nil
+}
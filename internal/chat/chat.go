@@ -0,0 +1,324 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/session"
+)
+
+// contextPrompt introduces the grounding context in the system message.
+const contextPrompt = "Answer the user's questions using the following context.\n\n"
+
+// ContextLoader loads the grounding context of a session, e.g. a pull request. It is
+// called when the session starts and again on `/reload`.
+type ContextLoader func(ctx context.Context) (string, error)
+
+// errInterrupted is returned when the user stops an answer with Ctrl-C.
+var errInterrupted = errors.New("answer interrupted")
+
+// Session is an interactive conversation with the model.
+type Session struct {
+	cfg        config.Config
+	args       args.Arguments
+	loader     ContextLoader
+	context    string           // The loaded grounding context
+	history    []client.Message // The user and assistant messages so far
+	stored     *session.Session // The conversation as stored for `session export`
+	last       string           // The last message sent, for /regenerate
+	answered   bool             // Whether the history ends with the answer to the last message
+	input      *bufio.Scanner
+	inputErr   error // Why reading the input stopped, set before the lines channel is closed
+	interrupts chan os.Signal
+}
+
+// NewSession creates a chat session, optionally grounded by the context loader.
+func NewSession(cfg config.Config, args args.Arguments, loader ContextLoader) *Session {
+	input := bufio.NewScanner(os.Stdin)
+	input.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return &Session{
+		cfg:    cfg,
+		args:   args,
+		loader: loader,
+		input:  input,
+	}
+}
+
+// Run loads the context and reads messages from the terminal until `/exit`, Ctrl-C, or EOF.
+// Ctrl-C while an answer streams in stops the answer but keeps the session.
+func (s *Session) Run(ctx context.Context) error {
+	// Take over Ctrl-C from the process-wide handler, which would end the session
+	signal.Reset(os.Interrupt)
+	s.interrupts = make(chan os.Signal, 1)
+	signal.Notify(s.interrupts, os.Interrupt)
+	defer signal.Stop(s.interrupts)
+
+	// SIGHUP reloads the config, like /reload, keeping the conversation
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	if err := s.resume(); err != nil {
+		return err
+	}
+	defer func() {
+		if len(s.stored.Turns) > 0 {
+			fmt.Fprintf(os.Stderr, "Saved the conversation as session %s.\n", s.stored.Name)
+		}
+	}()
+
+	if err := s.reload(ctx); err != nil {
+		return err
+	}
+
+	lines := s.readLines()
+	fmt.Fprintln(os.Stderr, "Type a message, /help for commands, or /exit to quit.")
+	for {
+		fmt.Fprint(os.Stderr, "> ")
+		var line string
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stderr)
+			return ctx.Err()
+		case <-s.interrupts:
+			fmt.Fprintln(os.Stderr)
+			return nil
+		case <-hangups:
+			fmt.Fprintln(os.Stderr)
+			if err := s.reloadConfig(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			continue
+		case next, ok := <-lines:
+			if !ok {
+				fmt.Fprintln(os.Stderr)
+				if s.inputErr != nil && !errors.Is(s.inputErr, io.EOF) {
+					return fmt.Errorf("failed to read input: %w", s.inputErr)
+				}
+				return nil
+			}
+			line = strings.TrimSpace(next)
+		}
+
+		if line == "" {
+			continue
+		}
+
+		var err error
+		if strings.HasPrefix(line, "/") {
+			var quit bool
+			if quit, err = s.command(ctx, line); quit {
+				return nil
+			}
+		} else {
+			err = s.send(ctx, line)
+		}
+
+		switch {
+		case errors.Is(err, errInterrupted):
+			fmt.Fprintln(os.Stderr, "Answer stopped, /regenerate to try again.")
+		case err != nil && ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+}
+
+// readLines reads lines from the terminal in the background, so waiting for input can be interrupted.
+func (s *Session) readLines() <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for s.input.Scan() {
+			lines <- s.input.Text()
+		}
+		s.inputErr = s.input.Err()
+	}()
+	return lines
+}
+
+// command handles a slash command, reporting whether the session should end.
+func (s *Session) command(ctx context.Context, line string) (bool, error) {
+	switch name, _, _ := strings.Cut(line, " "); name {
+	case "/exit", "/quit":
+		return true, nil
+	case "/reload":
+		if err := s.reloadConfig(ctx); err != nil {
+			return false, err
+		}
+		return false, s.reload(ctx)
+	case "/regenerate", "/r":
+		return false, s.regenerate(ctx)
+	case "/clear":
+		s.history, s.last, s.answered = nil, "", false
+		s.stored.Turns = nil
+		s.save()
+		fmt.Fprintln(os.Stderr, "Conversation cleared.")
+		return false, nil
+	case "/help":
+		fmt.Fprintln(os.Stderr, "/reload      reload the config and refresh the context (e.g. after the pull request was updated)")
+		fmt.Fprintln(os.Stderr, "/regenerate  send the last message again for a new answer (also /r)")
+		fmt.Fprintln(os.Stderr, "/clear       forget the conversation so far")
+		fmt.Fprintln(os.Stderr, "/exit        quit the chat")
+		fmt.Fprintln(os.Stderr, "Ctrl-C stops an answer while it streams in, and quits at the prompt.")
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown command %s, type /help for commands", name)
+	}
+}
+
+// resume loads the stored session named by --session to continue its conversation, or starts a new one.
+func (s *Session) resume() error {
+	name := s.args.Chat.Session
+	if name != "" {
+		stored, err := session.Load(name)
+		switch {
+		case err == nil:
+			s.stored = stored
+			for _, turn := range stored.Turns {
+				s.history = append(s.history, client.Message{Role: client.Role(turn.Role), Content: turn.Content})
+			}
+			fmt.Fprintf(os.Stderr, "Resumed session %s with %d messages.\n", name, len(stored.Turns))
+			return nil
+		case !errors.Is(err, session.ErrNotFound):
+			return err
+		}
+	}
+
+	stored, err := session.New(name)
+	if err != nil {
+		return err
+	}
+	s.stored = stored
+	return nil
+}
+
+// save stores the conversation. Failures only produce a warning, the chat goes on.
+func (s *Session) save() {
+	if len(s.stored.Turns) == 0 && s.stored.Updated.Equal(s.stored.Created) {
+		return // Nothing was said yet, don't store an empty session
+	}
+	if err := s.stored.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save session: %v\n", err)
+	}
+}
+
+// reloadConfig reloads the config file, e.g. after its render options were changed.
+// The model keeps the value resolved when the session started.
+func (s *Session) reloadConfig(ctx context.Context) error {
+	cfg, err := config.LoadConfig(ctx, s.cfg.Profile)
+	if err != nil {
+		return fmt.Errorf("keeping the current config, reloading failed: %w", err)
+	}
+	s.cfg = cfg
+	fmt.Fprintln(os.Stderr, "Reloaded the config.")
+	return nil
+}
+
+// reload (re)loads the grounding context, keeping the conversation.
+func (s *Session) reload(ctx context.Context) error {
+	parts := make([]string, 0, len(s.args.Prompts)+1)
+	for _, prompt := range s.args.Prompts {
+		if strings.TrimSpace(prompt) != "" {
+			parts = append(parts, prompt)
+		}
+	}
+
+	if s.loader != nil {
+		loaded, err := s.loader(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load context: %w", err)
+		}
+		parts = append(parts, loaded)
+	}
+
+	s.context = strings.Join(parts, "\n\n")
+	if s.context != "" {
+		fmt.Fprintf(os.Stderr, "Loaded %d bytes of context.\n", len(s.context))
+	}
+	return nil
+}
+
+// send sends the user's message with the conversation so far and renders the answer.
+func (s *Session) send(ctx context.Context, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ContextTimeout)
+	defer cancel()
+	interrupted := s.cancelOnInterrupt(cancel)
+
+	s.last, s.answered = message, false
+	sent := time.Now()
+	messages := make([]client.Message, 0, len(s.history)+2)
+	if s.context != "" {
+		messages = append(messages, client.Message{Role: client.SystemRole, Content: contextPrompt + s.context})
+	}
+	messages = append(messages, s.history...)
+	messages = append(messages, client.Message{Role: client.UserRole, Content: message})
+
+	answer, err := client.Converse(ctx, s.cfg, s.args, messages)
+	if interrupted() {
+		return errInterrupted
+	}
+	if err != nil {
+		return err
+	}
+
+	s.history = append(s.history,
+		client.Message{Role: client.UserRole, Content: message},
+		client.Message{Role: client.AssistantRole, Content: answer},
+	)
+	s.answered = true
+
+	now := time.Now()
+	s.stored.Turns = append(s.stored.Turns,
+		session.Turn{Role: string(client.UserRole), Content: message, Time: sent},
+		session.Turn{Role: string(client.AssistantRole), Content: answer, Model: s.args.Model, Time: now},
+	)
+	s.save()
+	return nil
+}
+
+// regenerate sends the last message again, replacing its answer.
+func (s *Session) regenerate(ctx context.Context) error {
+	if s.last == "" {
+		return errors.New("no message to regenerate")
+	}
+	if s.answered {
+		s.history = s.history[:len(s.history)-2]
+		s.stored.Turns = s.stored.Turns[:len(s.stored.Turns)-2]
+	}
+	return s.send(ctx, s.last)
+}
+
+// cancelOnInterrupt cancels the answer when Ctrl-C is pressed. The returned function stops
+// watching for Ctrl-C and reports whether the answer was interrupted.
+func (s *Session) cancelOnInterrupt(cancel context.CancelFunc) func() bool {
+	done := make(chan struct{})
+	result := make(chan bool, 1)
+	go func() {
+		select {
+		case <-s.interrupts:
+			cancel()
+			result <- true
+		case <-done:
+			result <- false
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		return <-result
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// AuthStatus describes how requests to the Copilot API are authenticated.
+type AuthStatus struct {
+	Host        string   // GitHub host the token is for
+	Searched    []string // Token sources searched, in order
+	Source      string   // Source the GitHub token was found in, empty when none has one
+	Token       string   // GitHub token, masked
+	Scopes      []string // OAuth scopes granted to the GitHub token
+	SKU         string   // Copilot plan
+	ChatEnabled bool
+	ExpiresAt   time.Time // Expiry of the Copilot token
+	Err         error     // Why the token lookup or exchange failed
+	Hint        string    // What to do about the failure
+}
+
+// CheckAuth looks up the GitHub token and exchanges it for a Copilot token, to diagnose authentication.
+func CheckAuth(ctx context.Context, cfg config.Config) AuthStatus {
+	status := AuthStatus{Host: cfg.AuthHost}
+	if cfg.AuthHost == "github.com" {
+		status.Searched = append(status.Searched, "GITHUB_TOKEN environment variable (in Codespaces)")
+	}
+	files, err := tokenFiles()
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Searched = append(status.Searched, files...)
+	status.Searched = append(status.Searched, "gh CLI: GH_TOKEN and the other token variables, its config, and the system keyring")
+
+	token, source, err := lookupGitHubToken(cfg.AuthHost)
+	if err != nil {
+		status.Err, status.Hint = err, authHint(err, cfg.AuthHost)
+		return status
+	}
+	status.Source, status.Token = source, maskToken(token)
+
+	// A fresh exchange, so the status isn't that of a cached token
+	auth, err := exchangeToken(ctx, cfg, token)
+	if err != nil {
+		status.Err, status.Hint = err, authHint(err, cfg.AuthHost)
+		return status
+	}
+	status.Scopes, status.SKU, status.ChatEnabled = auth.Scopes, auth.SKU, auth.ChatEnabled
+	status.ExpiresAt = time.Unix(auth.ExpiresAt, 0)
+	return status
+}
+
+// authHint suggests how to fix a failed token lookup or exchange.
+func authHint(err error, host string) string {
+	var exchangeErr *TokenExchangeError
+	switch {
+	case errors.Is(err, ErrNoGitHubToken):
+		return fmt.Sprintf("Run `gh auth login --hostname %s`, or sign in to GitHub Copilot in VS Code or Neovim.", host)
+	case errors.As(err, &exchangeErr) && exchangeErr.StatusCode == http.StatusUnauthorized:
+		return "The GitHub token was revoked or expired: sign in to GitHub Copilot in your editor again."
+	case errors.As(err, &exchangeErr) && (exchangeErr.StatusCode == http.StatusForbidden || exchangeErr.StatusCode == http.StatusNotFound):
+		return fmt.Sprintf("The account has no access to Copilot on %s: check its subscription, or your organization's Copilot policy.", host)
+	case errors.As(err, &exchangeErr):
+		return "The GitHub API failed: try again later, or check https://www.githubstatus.com."
+	default:
+		return "Check your network and proxy settings, and the endpoints.github_api of your config."
+	}
+}
+
+// maskToken hides all but the prefix of a token, which tells its kind, e.g. gho_ for OAuth tokens.
+func maskToken(token string) string {
+	const visible = 4
+	if len(token) <= visible*2 {
+		return "****"
+	}
+	return token[:visible] + "****"
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched by the error returned while requests are held back during an outage.
+var ErrCircuitOpen = errors.New("the Copilot API is unavailable")
+
+// CircuitOpenError is returned without contacting the API after it failed repeatedly, until
+// the cooldown has passed and a probe request succeeds.
+type CircuitOpenError struct {
+	Failures int           // Consecutive failures that opened the circuit
+	RetryIn  time.Duration // Time until the next probe request is let through
+}
+
+// Error describes the outage and when requests resume.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v after %d consecutive failures, retrying in %s",
+		ErrCircuitOpen, e.Failures, e.RetryIn.Round(time.Second))
+}
+
+// Is matches ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// breakerTransport fails fast while the Copilot API is down, instead of stacking up requests that hang
+// until they time out. After threshold consecutive failures (transport errors or 5xx responses)
+// the circuit opens; once the cooldown has passed, a single probe request is let through, whose
+// outcome closes or reopens it.
+type breakerTransport struct {
+	base      http.RoundTripper
+	host      string // Host of the Copilot API, the only one whose requests are guarded
+	threshold int    // Consecutive failures that open the circuit, 0 to disable
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// RoundTrip sends the request unless the circuit is open.
+func (b *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b.threshold <= 0 || req.URL.Host != b.host {
+		return b.base.RoundTrip(req)
+	}
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := b.base.RoundTrip(req)
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		b.release() // Canceled by the caller, which says nothing about the API, unlike timeouts
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		b.record(false)
+	default:
+		b.record(true)
+	}
+	return resp, err
+}
+
+// allow checks whether a request may be sent, letting one probe through after the cooldown.
+func (b *breakerTransport) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if wait := b.cooldown - time.Since(b.openedAt); wait > 0 || b.probing {
+		return &CircuitOpenError{Failures: b.failures, RetryIn: max(wait, 0)}
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a request, opening the circuit after too many failures in a row.
+func (b *breakerTransport) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends a request without counting it.
+func (b *breakerTransport) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/autosave"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/stream"
+	"golang.org/x/term"
+)
+
+// askChoices streams the answers of a request for several choices, rendering the first as it
+// arrives and the others under their own separator once the stream ends, and returns the answer
+// picked with --pick, or the first. It is written to the autosave and the --out file.
+func askChoices(ctx context.Context, cfg config.Config, args args.Arguments, payload ApiPayload) (string, error) {
+	if err := fitContextWindow(ctx, cfg, &payload); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first choice streams to its renderer, the others are kept until it is done, which is
+	// when the whole response is
+	first := make(chan stream.Chunk)
+	rest := make([][]stream.Chunk, args.Choices)
+	go func() {
+		defer close(first)
+		for chunk := range Stream(ctx, cfg, payload) {
+			if chunk.Error == nil && chunk.Index > 0 && chunk.Index < len(rest) {
+				rest[chunk.Index] = append(rest[chunk.Index], chunk)
+				continue
+			}
+			if chunk.Error == nil && chunk.Index != 0 {
+				continue
+			}
+			select {
+			case first <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	answers := make([]string, args.Choices)
+	for i := range answers {
+		fmt.Printf("\n=== Choice %d of %d ===\n\n", i+1, args.Choices)
+		renderer, err := render.NewTerminalRenderer(ctx, cfg, args)
+		if err != nil {
+			return "", fmt.Errorf("failed to create renderer: %w", err)
+		}
+
+		chunks := first
+		if i > 0 {
+			buffered := make(chan stream.Chunk, len(rest[i]))
+			for _, chunk := range rest[i] {
+				buffered <- chunk
+			}
+			close(buffered)
+			chunks = buffered
+		}
+		if err := renderer.Render(chunks); err != nil {
+			return "", err
+		}
+		answers[i] = renderer.Answer()
+	}
+
+	answer := answers[0]
+	if args.Pick {
+		i, err := pickChoice(len(answers))
+		if err != nil {
+			return "", err
+		}
+		answer = answers[i]
+	}
+	return answer, saveAnswer(cfg, args, answer)
+}
+
+// pickChoice asks on stderr which of the n choices to keep, and returns its index.
+func pickChoice(n int) (int, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return 0, errors.New("--pick needs a terminal to pick the answer on")
+	}
+	input := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "Keep which answer? [1-%d, Enter for 1]: ", n)
+		line, err := input.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return 0, nil
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return 0, nil
+		}
+		if i, err := strconv.Atoi(line); err == nil && i >= 1 && i <= n {
+			return i - 1, nil
+		}
+	}
+}
+
+// saveAnswer writes the kept answer to the autosave and the --out file, as renderAnswer does with
+// a single answer while it streams.
+func saveAnswer(cfg config.Config, args args.Arguments, answer string) error {
+	var sinks []io.WriteCloser
+	if cfg.Autosave {
+		saver, err := autosave.NewWriter()
+		if err != nil {
+			return fmt.Errorf("failed to create autosave: %w", err)
+		}
+		sinks = append(sinks, saver)
+	}
+	if args.OutputPath != "" {
+		out, err := render.NewOutputFile(args.OutputPath, args.OutputFormat, args.Model, args.Prompts, args.Deterministic)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, out)
+	}
+
+	var errs []error
+	for _, sink := range sinks {
+		_, err := io.WriteString(sink, answer)
+		errs = append(errs, err, sink.Close())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to save answer: %w", err)
+	}
+	return nil
+}
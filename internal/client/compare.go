@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/cache"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/patch"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/stream"
+	"github.com/markis/gh-copilot/internal/telemetry"
+)
+
+// compareBuffer is the number of chunks buffered per model while an earlier model's answer is
+// rendered. Streams longer than that wait for their turn, which only delays them.
+const compareBuffer = 1 << 14
+
+// Compare sends the same prompt to several models at once and renders their answers one after
+// the other, each under a header, while the later ones keep streaming in the background.
+func Compare(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	// Streams of answers that are no longer rendered, e.g. after an error, are stopped on return
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	streams := make([]chan stream.Chunk, len(args.Models))
+	for i, model := range args.Models {
+		modelArgs := args
+		modelArgs.Model = model
+		payload, err := prepareInput(modelArgs)
+		if err != nil {
+			return err
+		}
+
+		if args.DryRun {
+			if err := printPayload(cfg, payload); err != nil {
+				return err
+			}
+			continue
+		}
+
+		streams[i] = make(chan stream.Chunk, compareBuffer)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streamChunks(ctx, cfg, payload, streams[i])
+		}()
+	}
+	if args.DryRun {
+		return nil
+	}
+
+	failed := 0
+	for i, model := range args.Models {
+		fmt.Printf("\n=== %s ===\n\n", model)
+
+		modelArgs := args
+		modelArgs.Model = model
+		renderer, err := render.NewTerminalRenderer(ctx, cfg, modelArgs)
+		if err != nil {
+			return fmt.Errorf("failed to create renderer: %w", err)
+		}
+		if err := renderer.Render(streams[i]); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "%s failed: %v\n", model, err)
+			failed++
+			continue
+		}
+
+		RecordEvent(cfg, telemetry.Event{
+			Answer:  telemetry.NewAnswerID(),
+			Kind:    telemetry.EventAnswer,
+			Command: args.Command,
+			Model:   model,
+		})
+	}
+
+	if failed == len(args.Models) {
+		return errors.New("all models failed")
+	}
+	return nil
+}
+
+// Stream sends the payload and returns the chunks of the streamed answer, ending with an error chunk
+// when the request or stream fails. Cancel the context to stop the stream early.
+func Stream(ctx context.Context, cfg config.Config, payload ApiPayload) <-chan stream.Chunk {
+	chunks := make(chan stream.Chunk)
+	go streamChunks(ctx, cfg, payload, chunks)
+	return chunks
+}
+
+// streamChunks streams the answer to the payload into the channel, closing it when the answer is complete.
+func streamChunks(ctx context.Context, cfg config.Config, payload ApiPayload, chunks chan<- stream.Chunk) {
+	defer close(chunks)
+
+	resp, err := postJSON(ctx, cfg, "/chat/completions", payload, acceptFor(payload))
+	if err != nil {
+		chunks <- stream.Chunk{Error: err}
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", err)
+		}
+	}()
+
+	parser := stream.NewParser(ctx)
+	go parser.ProcessResponse(resp.Header.Get("Content-Type"), resp.Body)
+	for chunk := range parser.Chunks() {
+		select {
+		case chunks <- chunk:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CompareDiff receives two answers to the prompt and renders the word-level changes between them:
+// those of two models, or the cached answer of the model and a fresh one, which replaces it in the cache.
+func CompareDiff(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	if len(args.Models) == 2 {
+		return diffModels(ctx, cfg, args)
+	}
+
+	payload, err := prepareInput(args)
+	if err != nil {
+		return err
+	}
+	if args.DryRun {
+		return printPayload(cfg, payload)
+	}
+	if !cfg.Cache.Enabled {
+		return errors.New("comparing with the cached answer requires the response cache, or give two --models")
+	}
+
+	key, err := cache.Key(payload)
+	if err != nil {
+		return err
+	}
+	cached, ok := cache.Get(key, 0) // Even an expired answer is worth comparing with
+	if !ok {
+		return errors.New("no cached answer to compare with: ask once without --diff, or give two --models")
+	}
+
+	fresh, err := collectAnswer(ctx, cfg, payload)
+	if err != nil {
+		return err
+	}
+	if err := cache.Put(key, fresh); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to cache answer: %v\n", err)
+	}
+	return render.WordDiff(os.Stdout, args.Model+" (cached)", args.Model+" (fresh)", patch.DiffWords(cached, fresh), args.UsePlainText)
+}
+
+// diffModels receives the answers of both models at once and renders the changes from the first to the second.
+func diffModels(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var answers [2]string
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, model := range args.Models {
+		modelArgs := args
+		modelArgs.Model = model
+		payload, err := prepareInput(modelArgs)
+		if err != nil {
+			return err
+		}
+		if args.DryRun {
+			if err := printPayload(cfg, payload); err != nil {
+				return err
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answers[i], errs[i] = collectAnswer(ctx, cfg, payload)
+		}()
+	}
+	wg.Wait()
+	if args.DryRun {
+		return nil
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", args.Models[i], err)
+		}
+	}
+	return render.WordDiff(os.Stdout, args.Models[0], args.Models[1], patch.DiffWords(answers[0], answers[1]), args.UsePlainText)
+}
+
+// collectAnswer receives the whole streamed answer to the payload without rendering it.
+func collectAnswer(ctx context.Context, cfg config.Config, payload ApiPayload) (string, error) {
+	if err := fitContextWindow(ctx, cfg, &payload); err != nil {
+		return "", err
+	}
+
+	// Streams no longer read after an error are stopped on return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		chunks := make(chan stream.Chunk)
+		go streamChunks(ctx, cfg, payload, chunks)
+
+		var answer strings.Builder
+		for chunk := range chunks {
+			if chunk.Error != nil {
+				return "", fmt.Errorf("stream error: %w", chunk.Error)
+			}
+			answer.WriteString(chunk.Content)
+		}
+		if strings.TrimSpace(answer.String()) != "" {
+			return answer.String(), nil
+		}
+		if attempt > 0 {
+			return "", ErrEmptyResponse
+		}
+		payload.Messages = withNudge(payload.Messages)
+	}
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// Complete sends a non-streaming chat request and returns the content of the first choice.
+// It is used for auxiliary requests whose answer is processed rather than rendered.
+func Complete(ctx context.Context, cfg config.Config, model string, messages []Message) (string, error) {
+	payload := ApiPayload{
+		Model:          model,
+		Messages:       messages,
+		NumOfResponses: 1,
+	}
+
+	resp, err := postJSON(ctx, cfg, "/chat/completions", payload, "application/json")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", err)
+		}
+	}()
+
+	var result ApiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(result.Choices) == 0 {
+		return "", errors.New("received no choices in response")
+	}
+	return result.Choices[0].Message.Content, nil
+}
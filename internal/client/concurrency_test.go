@@ -0,0 +1,287 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// fakeAPI is a Copilot API for tests. Its Copilot tokens expire within tokenRefreshMargin, so
+// every request exchanges the token again, and it streams the same answer to every question,
+// with rate limit headers. The first request for a question in limited gets a 429 instead.
+type fakeAPI struct {
+	*httptest.Server
+	answer       string
+	finishReason string        // Why the answer ended, stop if empty
+	delay        time.Duration // Wait before the answer starts streaming
+	limited      map[string]bool
+	exchanges    atomic.Int32
+	requests     atomic.Int32
+	refused      sync.Map // Questions whose request was already rate limited
+}
+
+// newFakeAPI starts a fake API answering every question with the answer.
+func newFakeAPI(t *testing.T, answer string, limited ...string) *fakeAPI {
+	api := &fakeAPI{answer: answer, limited: map[string]bool{}}
+	for _, question := range limited {
+		api.limited[question] = true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /copilot_internal/v2/token", func(w http.ResponseWriter, r *http.Request) {
+		n := api.exchanges.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"token": "copilot-%d", "expires_at": %d}`, n, time.Now().Add(30*time.Second).Unix())
+	})
+	mux.HandleFunc("POST /chat/completions", api.chat)
+	api.Server = httptest.NewServer(mux)
+	t.Cleanup(api.Close)
+	return api
+}
+
+// chat streams the answer, or rate limits the first request for a limited question.
+func (api *fakeAPI) chat(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer copilot-") {
+		http.Error(w, "missing Copilot token", http.StatusUnauthorized)
+		return
+	}
+	var payload ApiPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload.Messages) == 0 {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	n := api.requests.Add(1)
+
+	question := payload.Messages[len(payload.Messages)-1].Content
+	if _, refused := api.refused.LoadOrStore(question, true); api.limited[question] && !refused {
+		w.Header().Set("X-Ratelimit-Remaining", "0")
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	time.Sleep(api.delay)
+	w.Header().Set("X-Ratelimit-Remaining", strconv.Itoa(1000-int(n)))
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, word := range strings.SplitAfter(api.answer, " ") {
+		data, _ := json.Marshal(map[string]any{
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": word}}},
+		})
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+		w.(http.Flusher).Flush()
+	}
+	_, _ = fmt.Fprintf(w, "data: {\"choices\": [{\"index\": 0, \"delta\": {}, \"finish_reason\": %q}]}\n\ndata: [DONE]\n\n",
+		cmp.Or(api.finishReason, "stop"))
+}
+
+// testConfig returns the default config pointed at the fake API, with a GitHub token in a
+// Copilot plugin config and the state in temporary directories. The shared state of the
+// package is reset, so tests don't see each other's tokens and rate limits.
+func testConfig(t *testing.T, api *fakeAPI) config.Config {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("CODESPACES", "")
+
+	hosts := filepath.Join(dir, "github-copilot", "hosts.json")
+	if err := os.MkdirAll(filepath.Dir(hosts), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hosts, []byte(`{"github.com": {"oauth_token": "gho_test"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	forgetToken()
+	rateLimitMu.Lock()
+	rateLimit, rateLimitLoaded = RateLimit{}, false
+	rateLimitMu.Unlock()
+
+	cfg, err := config.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AuthHost = "github.com"
+	cfg.Endpoints.API, cfg.Endpoints.GitHubAPI = api.URL, api.URL
+	cfg.Latency = config.ConfigLatency{}
+	return cfg
+}
+
+// captureOutput redirects stdout and stderr to pipes while f runs, and returns what was written
+// to them.
+func captureOutput(t *testing.T, f func()) (stdout, stderr string) {
+	read := func(file **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := *file
+		*file = w
+		out := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			out <- string(data)
+		}()
+		return func() string {
+			*file = original
+			_ = w.Close()
+			return <-out
+		}
+	}
+
+	restoreStdout := read(&os.Stdout)
+	restoreStderr := read(&os.Stderr)
+	defer func() {
+		stdout, stderr = restoreStdout(), restoreStderr()
+	}()
+	f()
+	return
+}
+
+// TestConcurrentAsk asks and streams from many goroutines at once, with the Copilot token
+// exchanged again for every request and some requests rate limited, which shares the token,
+// the HTTP client, and the rate limit between them. Run with -race.
+func TestConcurrentAsk(t *testing.T) {
+	const answer = "The answer is 42."
+	const goroutines = 16
+	var limited []string
+	for i := 0; i < goroutines; i += 4 {
+		limited = append(limited, fmt.Sprintf("question %d", i))
+	}
+	api := newFakeAPI(t, answer, limited...)
+	cfg := testConfig(t, api)
+
+	errs := make(chan error, goroutines)
+	answers := make(chan string, goroutines)
+	stdout, _ := captureOutput(t, func() {
+		var wg sync.WaitGroup
+		for i := range goroutines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				question := fmt.Sprintf("question %d", i)
+				if i%2 == 0 {
+					errs <- Ask(context.Background(), cfg, args.Arguments{Model: "gpt-4o", Prompts: []string{question}, UsePlainText: true})
+					return
+				}
+
+				payload := newPayload(args.Arguments{Model: "gpt-4o"}, []Message{{Role: UserRole, Content: question}})
+				var b strings.Builder
+				for chunk := range Stream(context.Background(), cfg, payload) {
+					if chunk.Error != nil {
+						errs <- chunk.Error
+						return
+					}
+					b.WriteString(chunk.Content)
+				}
+				answers <- b.String()
+				errs <- nil
+			}()
+		}
+		wg.Wait()
+	})
+	close(errs)
+	close(answers)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent request failed: %v", err)
+		}
+	}
+	for streamed := range answers {
+		if streamed != answer {
+			t.Errorf("streamed answer = %q, want %q", streamed, answer)
+		}
+	}
+	if got := strings.Count(stdout, answer); got != goroutines/2 {
+		t.Errorf("stdout has %d answers, want %d:\n%s", got, goroutines/2, stdout)
+	}
+	if want := int32(goroutines + len(limited)); api.requests.Load() != want {
+		t.Errorf("API got %d requests, want %d with the retries of the rate limited ones", api.requests.Load(), want)
+	}
+}
+
+// TestConcurrentTokenRefresh gets the headers from many goroutines while the token expires and
+// is forgotten, as after a 401, checking that every caller gets a whole token.
+func TestConcurrentTokenRefresh(t *testing.T) {
+	api := newFakeAPI(t, "")
+	cfg := testConfig(t, api)
+
+	var wg sync.WaitGroup
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%8 == 0 {
+				forgetToken()
+			}
+			headers, err := getHeaders(context.Background(), cfg)
+			if err != nil {
+				t.Errorf("getHeaders: %v", err)
+				return
+			}
+			if auth := headers["Authorization"]; !strings.HasPrefix(auth, "Bearer copilot-") {
+				t.Errorf("Authorization = %q, want a Copilot token", auth)
+			}
+			headers["Authorization"] = "changed" // Each caller gets headers of its own
+		}()
+	}
+	wg.Wait()
+
+	if api.exchanges.Load() == 0 {
+		t.Error("the token was never exchanged")
+	}
+}
+
+// TestConcurrentRateLimit observes and reads the rate limit from many goroutines, as concurrent
+// requests do, checking that the last observed limit is one of those reported.
+func TestConcurrentRateLimit(t *testing.T) {
+	cfg := testConfig(t, newFakeAPI(t, ""))
+
+	_, stderr := captureOutput(t, func() {
+		var wg sync.WaitGroup
+		for i := range 32 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				header := http.Header{}
+				header.Set("X-Ratelimit-Remaining", strconv.Itoa(i+1))
+				header.Set("X-Ratelimit-Limit", "100")
+				observeRateLimit(&http.Response{StatusCode: http.StatusOK, Header: header})
+			}()
+			go func() {
+				defer wg.Done()
+				if err := waitForRateLimit(context.Background(), cfg); err != nil {
+					t.Errorf("waitForRateLimit: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+	if stderr != "" {
+		t.Errorf("unexpected output on stderr: %s", stderr)
+	}
+
+	limit, err := CurrentRateLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit.Remaining < 1 || limit.Remaining > 32 || limit.Limit != 100 {
+		t.Errorf("rate limit = %+v, want one of those observed", limit)
+	}
+}
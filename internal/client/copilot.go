@@ -1,3 +1,5 @@
+// Package client talks to the Copilot API. Its functions are safe for concurrent use: the HTTP client,
+// Copilot token, and rate limit are shared across goroutines, everything else is per request.
 package client
 
 import (
@@ -9,28 +11,47 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/attach"
+	"github.com/markis/gh-copilot/internal/autosave"
+	"github.com/markis/gh-copilot/internal/cache"
+	"github.com/markis/gh-copilot/internal/clipboard"
+	"github.com/markis/gh-copilot/internal/codeblock"
 	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logging"
 	"github.com/markis/gh-copilot/internal/render"
 	"github.com/markis/gh-copilot/internal/stream"
+	"github.com/markis/gh-copilot/internal/telemetry"
+	"github.com/markis/gh-copilot/internal/tokens"
+	"github.com/markis/gh-copilot/internal/trace"
 )
 
 // For more examples of using go-gh, see:
 // https://github.com/cli/go-gh/blob/trunk/example_gh_test.go
 
-// Constants
-const (
-	APIBase   = "https://api.githubcopilot.com"
-	GitHubAPI = "https://api.github.com"
-)
-
 // AuthorizationResponse represents the structure of the response from the GitHub API for authorization.
 type AuthorizationResponse struct {
-	Token string `json:"token"`
+	Token       string   `json:"token"`
+	ExpiresAt   int64    `json:"expires_at"`   // Unix time
+	SKU         string   `json:"sku"`          // Copilot plan, e.g. "copilot_for_business_seat"
+	ChatEnabled bool     `json:"chat_enabled"` // Whether the plan includes chat
+	Scopes      []string `json:"-"`            // OAuth scopes of the GitHub token, from the response headers
+}
+
+// TokenExchangeError is returned when the API refuses to exchange the GitHub token for a Copilot token.
+type TokenExchangeError struct {
+	StatusCode int
+}
+
+// Error names the status code of the refused request.
+func (e *TokenExchangeError) Error() string {
+	return fmt.Sprintf("token request failed with status code: %d", e.StatusCode)
 }
 
 // ApiResponse represents the structure of the response from the chat API.
@@ -57,6 +78,8 @@ const (
 type Message struct {
 	Role    Role   `json:"role"`    // "user", "assistant", or "system"
 	Content string `json:"content"` // The message content
+
+	attachment string // Attached file or piped input the message holds, named when it is cut to fit
 }
 
 type ApiPayload struct {
@@ -65,6 +88,12 @@ type ApiPayload struct {
 	NumOfResponses int       `json:"n,omitempty"`      // Number of responses to generate
 	TopP           float64   `json:"top_p,omitempty"`  // Top-p sampling
 	Stream         bool      `json:"stream,omitempty"` // Whether to stream the response
+	Stop           []string  `json:"stop,omitempty"`   // Sequences where the model stops generating
+	Temperature    *float64  `json:"temperature,omitempty"`
+	MaxTokens      int       `json:"max_tokens,omitempty"`
+
+	// ResponseFormat constrains the answer, e.g. to JSON matching the schema of --json-schema.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
 // defaultHeaders returns the default headers for the API requests.
@@ -75,96 +104,207 @@ func defaultHeaders() map[string]string {
 	}
 }
 
-// getHeaders retrieves the authorization headers required for the API requests.
+// tokenRefreshMargin is how long before its expiry a Copilot token is exchanged again.
+const tokenRefreshMargin = time.Minute
+
+var (
+	copilotTokenMu sync.Mutex
+	copilotToken   AuthorizationResponse
+	copilotFor     string // GitHub token the Copilot token was exchanged for
+)
+
+// getHeaders retrieves the authorization headers required for the API requests. The Copilot token is
+// reused until shortly before it expires; concurrent callers share a single exchange.
 func getHeaders(ctx context.Context, cfg config.Config) (map[string]string, error) {
-	token, err := getGitHubToken()
+	token, err := getGitHubToken(cfg.AuthHost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get GitHub token: %w", err)
 	}
 
+	copilotTokenMu.Lock()
+	defer copilotTokenMu.Unlock()
+
+	if copilotFor != token || time.Until(time.Unix(copilotToken.ExpiresAt, 0)) < tokenRefreshMargin {
+		auth, err := exchangeToken(ctx, cfg, token)
+		if err != nil {
+			return nil, err
+		}
+		copilotToken, copilotFor = auth, token
+	}
+
+	headers := defaultHeaders()
+	headers["Authorization"] = "Bearer " + copilotToken.Token
+	if cfg.Http.PriorityHeader != "" {
+		headers[cfg.Http.PriorityHeader] = priorityFrom(ctx).String()
+	}
+	return headers, nil
+}
+
+// forgetToken discards the cached Copilot token.
+func forgetToken() {
+	copilotTokenMu.Lock()
+	copilotToken, copilotFor = AuthorizationResponse{}, ""
+	copilotTokenMu.Unlock()
+}
+
+// exchangeToken exchanges the GitHub token for a short-lived Copilot API token.
+func exchangeToken(ctx context.Context, cfg config.Config, token string) (AuthorizationResponse, error) {
 	client := getHTTPClient(ctx, cfg)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, GitHubAPI+"/copilot_internal/v2/token", nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Endpoints.GitHubAPI+"/copilot_internal/v2/token", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return AuthorizationResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	headers := defaultHeaders()
-	for k, v := range headers {
+	for k, v := range defaultHeaders() {
 		req.Header.Set(k, v)
 	}
 	req.Header.Set("Authorization", "Token "+token)
 
+	logger := logging.FromContext(ctx)
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		logger.Debug("token exchange failed", "error", err, "duration", time.Since(start))
+		return AuthorizationResponse{}, fmt.Errorf("failed to execute request: %w", err)
 	}
+	logger.Debug("token exchange", "status", resp.StatusCode, "duration", time.Since(start))
 	defer func() {
 		err = resp.Body.Close()
 		if err != nil {
-			fmt.Printf("failed to close response body: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", err)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("token request failed with status code: %d", resp.StatusCode)
+		return AuthorizationResponse{}, &TokenExchangeError{StatusCode: resp.StatusCode}
 	}
 
 	auth := AuthorizationResponse{}
 	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return AuthorizationResponse{}, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	if auth.Token == "" {
-		return nil, errors.New("received empty token in response")
+		return AuthorizationResponse{}, errors.New("received empty token in response")
+	}
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			auth.Scopes = append(auth.Scopes, scope)
+		}
 	}
 
-	headers["Authorization"] = "Bearer " + auth.Token
-	return headers, nil
+	return auth, nil
 }
 
 // prepareInput constructs the API payload from user arguments.
 // It converts user prompts into the message format expected by the API,
 // sets the appropriate model, and configures model-specific parameters.
-func prepareInput(args args.Arguments) ApiPayload {
-	// Get model configuration
-	isOpenAIModel := strings.HasPrefix(args.Model, "o1")
+func prepareInput(args args.Arguments) (ApiPayload, error) {
+	files, err := attach.Load(args.Files)
+	if err != nil {
+		return ApiPayload{}, err
+	}
 
-	messages := make([]Message, 0, len(args.Prompts))
-	for _, prompt := range args.Prompts {
+	messages := make([]Message, 0, len(args.Prompts)+len(files)+2)
+	if args.System != "" {
+		messages = append(messages, Message{Role: SystemRole, Content: args.System})
+	}
+	if len(files) > 0 {
+		messages = append(messages, Message{Role: SystemRole, Content: attach.CitationInstruction})
+	}
+	for _, file := range files {
+		messages = append(messages, Message{Role: UserRole, Content: file.Message(), attachment: "`" + file.Path + "`"})
+	}
+
+	for i, prompt := range args.Prompts {
 		if strings.TrimSpace(prompt) == "" {
 			continue // Skip empty prompts
 		}
 
-		messages = append(messages, Message{
+		message := Message{
 			Role:    UserRole,
 			Content: prompt,
-		})
+		}
+		if i == 0 && args.Stdin != "" {
+			message.attachment = pipedInput // The piped input is the first prompt
+		}
+		messages = append(messages, message)
+	}
+
+	if args.JSONSchema == "" {
+		return newPayload(args, messages), nil
+	}
+	format, err := loadResponseFormat(args.JSONSchema)
+	if err != nil {
+		return ApiPayload{}, err
+	}
+	messages = append(messages, Message{Role: SystemRole, Content: fmt.Sprintf(schemaInstruction, format.JSONSchema.Schema)})
+	payload := newPayload(args, messages)
+	payload.ResponseFormat = format
+	return payload, nil
+}
+
+// newPayload builds the request payload for the messages, configuring model-specific parameters
+// and the output constraints from the arguments.
+func newPayload(args args.Arguments, messages []Message) ApiPayload {
+	// Get model configuration
+	isOpenAIModel := strings.HasPrefix(args.Model, "o1")
+
+	// The length instruction comes first, so the other system messages can refine it
+	length := lengthFor(args.Model, args.Length)
+	if length.instruction != "" {
+		messages = append([]Message{{Role: SystemRole, Content: length.instruction}}, messages...)
+	}
+	maxTokens := args.Params.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = length.maxTokens
+	}
+
+	// The model continues from a trailing assistant message instead of starting a new one
+	if args.Prefill != "" {
+		messages = append(messages, Message{Role: AssistantRole, Content: args.Prefill})
 	}
 
 	// Build base request payload with initial capacity
 	payload := ApiPayload{
-		Model:    args.Model,
-		Messages: messages,
+		Model:       args.Model,
+		Messages:    messages,
+		Stop:        args.Stop,
+		Temperature: args.Params.Temperature,
+		MaxTokens:   maxTokens,
 	}
 
 	// Add non-OpenAI specific parameters
 	if !isOpenAIModel {
 		payload.NumOfResponses = 1
 		payload.TopP = 1.0
-		payload.Stream = true
+		payload.Stream = !args.NoStream
+	}
+	if args.Choices > 1 {
+		payload.NumOfResponses = args.Choices
+	}
+	if args.Params.TopP != nil {
+		payload.TopP = *args.Params.TopP
 	}
 
 	return payload
 }
 
-// getHTTPClient returns a singleton HTTP client
 var (
-	httpClient     *http.Client
-	httpClientOnce sync.Once
+	httpClientMu  sync.Mutex
+	httpClient    *http.Client
+	httpClientCfg config.ConfigHttp
+	httpClientAPI string
 )
 
+// getHTTPClient returns a copy of the shared HTTP client, which is rebuilt when the HTTP settings or
+// the API endpoint change, e.g. after the config is reloaded.
 func getHTTPClient(ctx context.Context, cfg config.Config) *http.Client {
-	httpClientOnce.Do(func() {
+	httpClientMu.Lock()
+	if httpClient == nil || httpClientCfg != cfg.Http || httpClientAPI != cfg.Endpoints.API {
+		if httpClient != nil {
+			httpClient.CloseIdleConnections() // Requests in flight keep their connections
+		}
 		transport := &http.Transport{
 			MaxIdleConns:       cfg.Http.MaxIdleConns,
 			IdleConnTimeout:    cfg.Http.IdleConnTimeout,
@@ -179,71 +319,460 @@ func getHTTPClient(ctx context.Context, cfg config.Config) *http.Client {
 			KeepAlive: cfg.Http.DialContextKeepAlive,
 		}).DialContext
 
+		apiHost := ""
+		if api, err := url.Parse(cfg.Endpoints.API); err == nil {
+			apiHost = api.Host
+		}
 		httpClient = &http.Client{
-			Transport: transport,
+			Transport: trace.Transport(&breakerTransport{
+				base:      transport,
+				host:      apiHost,
+				threshold: cfg.Http.BreakerThreshold,
+				cooldown:  cfg.Http.BreakerCooldown,
+			}),
 		}
-	})
+		httpClientCfg, httpClientAPI = cfg.Http, cfg.Endpoints.API
+	}
+	clientCopy := *httpClient
+	httpClientMu.Unlock()
 
 	// Check if there's a timeout in the context
 	if deadline, ok := ctx.Deadline(); ok {
-		// Create a clone of the default client with the context timeout
-		clientCopy := *httpClient
 		clientCopy.Timeout = time.Until(deadline)
 		return &clientCopy
 	}
 
-	// Return default client with default timeout
-	clientCopy := *httpClient
+	// Default timeout
 	clientCopy.Timeout = cfg.Http.HttpClientTimeout
 	return &clientCopy
 }
 
-// Ask sends a chat request to the Copilot API and processes the response.
-func Ask(ctx context.Context, cfg config.Config, args args.Arguments) error {
-	headers, err := getHeaders(ctx, cfg)
+// postJSON sends an authenticated JSON request to the Copilot API and returns the response
+// when it succeeds. The caller is responsible for closing the response body.
+func postJSON(ctx context.Context, cfg config.Config, path string, payload any, accept string) (*http.Response, error) {
+	data, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to get headers: %w", err)
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	payload := prepareInput(args)
-	data, err := json.Marshal(payload)
+	resp, err := Post(ctx, cfg, path, data, accept)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, err
+	}
+
+	// Rate limited requests are retried once the limit resets, if that is soon enough
+	if limit, ok := parseRateLimit(resp.Header, resp.StatusCode, time.Now()); ok && limit.Remaining <= 0 &&
+		(resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden) {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		logging.FromContext(ctx).Debug("rate limited", "status", resp.StatusCode, "body", string(body))
+
+		waited := time.Now()
+		if err := sleepUntilReset(ctx, cfg, limit.Reset); err != nil {
+			return nil, err
+		}
+		timingsFrom(ctx).record(stageRateLimit, waited)
+		if resp, err = Post(ctx, cfg, path, data, accept); err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			_ = resp.Body.Close()
+			limit, _ := parseRateLimit(resp.Header, resp.StatusCode, time.Now())
+			return nil, &RateLimitError{Reset: limit.Reset}
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", err)
+		}
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
+
+// Post sends an authenticated JSON body to the Copilot API and returns the response, whatever its status.
+// The caller is responsible for closing the response body.
+func Post(ctx context.Context, cfg config.Config, path string, data []byte, accept string) (*http.Response, error) {
+	timings := timingsFrom(ctx)
+	waited := time.Now()
+	if err := prioritize(ctx); err != nil {
+		return nil, err
 	}
+	if err := waitForRateLimit(ctx, cfg); err != nil {
+		return nil, err
+	}
+	timings.record(stageRateLimit, waited)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, APIBase+"/chat/completions", bytes.NewReader(data))
+	authStart := time.Now()
+	headers, err := getHeaders(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to get headers: %w", err)
+	}
+	timings.record(stageAuth, authStart)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Endpoints.API+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept", accept)
+
+	logger := logging.FromContext(ctx)
+	logger.Debug("sending request", "method", req.Method, "url", req.URL.String(),
+		logging.Headers(req.Header), "bytes", len(data))
 
 	client := getHTTPClient(ctx, cfg)
+	start := time.Now()
 	resp, err := client.Do(req)
+	if err != nil {
+		logger.Debug("request failed", "url", req.URL.String(), "error", err, "duration", time.Since(start))
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	logger.Debug("received response", "url", req.URL.String(), "status", resp.StatusCode,
+		logging.Headers(resp.Header), "duration", time.Since(start))
+	timings.record(stageConnect, start)
+	observeRateLimit(resp)
+	if resp.StatusCode == http.StatusUnauthorized {
+		forgetToken() // E.g. revoked, the next request exchanges a new one
+	}
+
+	return resp, nil
+}
+
+// Ping checks that the token exchange succeeds and the Copilot API is reachable.
+func Ping(ctx context.Context, cfg config.Config) error {
+	headers, err := getHeaders(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to get headers: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Endpoints.API+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := getHTTPClient(ctx, cfg).Do(req)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("failed to close response body: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", err)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Model is a model offered by the Copilot API.
+type Model struct {
+	ID           string `json:"id"`
+	Name         string `json:"name,omitempty"`
+	Vendor       string `json:"vendor,omitempty"`
+	Capabilities struct {
+		Type   string `json:"type,omitempty"` // "chat" or "embeddings"
+		Family string `json:"family,omitempty"`
+	} `json:"capabilities"`
+}
+
+// ListModels returns the models offered to the user by the Copilot API.
+func ListModels(ctx context.Context, cfg config.Config) ([]Model, error) {
+	headers, err := getHeaders(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get headers: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Endpoints.API+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := getHTTPClient(ctx, cfg).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	}
+	var models struct {
+		Data []Model `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+	return models.Data, nil
+}
+
+// Ask sends a chat request to the Copilot API and processes the response.
+func Ask(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	if err := summarizeStdin(ctx, cfg, &args); err != nil {
+		return err
+	}
+	if args.Compare.Diff {
+		return CompareDiff(ctx, cfg, args)
+	}
+	if len(args.Models) > 1 {
+		return Compare(ctx, cfg, args)
+	}
+	if args.PerFile && len(args.Files) > 1 {
+		return PerFile(ctx, cfg, args)
+	}
+	start := time.Now()
+
+	payload, err := prepareInput(args)
+	if err != nil {
+		return err
+	}
+	if args.DryRun {
+		return printPayload(cfg, payload)
+	}
+
+	var answer string
+	if args.SideBySide {
+		answer, err = askSideBySide(ctx, cfg, args, payload)
+	} else if args.JSONSchema != "" {
+		answer, err = askStructured(ctx, cfg, args, payload)
+	} else if args.Choices > 1 && args.Output != render.OutputModeJSONL {
+		answer, err = askChoices(ctx, cfg, args, payload)
+	} else {
+		answer, err = cachedAnswer(ctx, cfg, args, payload)
+	}
+	if err != nil {
+		return err
+	}
+	if args.TranslateTo != "" && !args.SideBySide {
+		if err := renderTranslation(ctx, cfg, args, answer); err != nil {
+			return err
+		}
+	}
+
+	answerID := telemetry.NewAnswerID()
+	RecordEvent(cfg, telemetry.Event{
+		Answer:  answerID,
+		Kind:    telemetry.EventAnswer,
+		Command: args.Command,
+		Model:   args.Model,
+	})
+
+	if args.Stats {
+		printStats(args.Model, time.Since(start), answer)
+	}
+
+	if args.Code != "" {
+		RecordEvent(cfg, telemetry.Event{Answer: answerID, Kind: telemetry.EventExtract})
+	}
+
+	if args.CopyBlock > 0 {
+		if err := copyCodeBlock(answer, args.CopyBlock); err != nil {
+			return err
+		}
+		RecordEvent(cfg, telemetry.Event{Answer: answerID, Kind: telemetry.EventCopy})
+	}
+	return nil
+}
+
+// Converse sends a whole conversation to the Copilot API, renders the streamed answer, and returns it.
+func Converse(ctx context.Context, cfg config.Config, args args.Arguments, messages []Message) (string, error) {
+	payload := newPayload(args, messages)
+	if args.DryRun {
+		return "", printPayload(cfg, payload)
+	}
+	return streamAnswer(ctx, cfg, args, payload)
+}
+
+// streamAnswer sends the payload and renders the streamed answer to the terminal,
+// returning the final answer once the stream has finished.
+func streamAnswer(ctx context.Context, cfg config.Config, args args.Arguments, payload ApiPayload) (string, error) {
+	if err := fitContextWindow(ctx, cfg, &payload); err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	ctx, timings := withTimings(ctx)
+	for attempt := 0; ; attempt++ {
+		status := render.StartStatus(cfg, args)
+		resp, err := postJSON(ctx, cfg, "/chat/completions", payload, acceptFor(payload))
+		if err != nil {
+			status.Stop()
+			return "", err
+		}
+
+		parser := stream.NewParser(ctx)
+		go parser.ProcessResponse(resp.Header.Get("Content-Type"), resp.Body)
+		chunks := awaitContent(watchFirstToken(timings, time.Now(), parser.Chunks()))
+		status.Stop()
+		var answer string
+		if chunks != nil {
+			answer, err = renderAnswer(ctx, cfg, args, chunks)
+		}
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", closeErr)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// An empty answer isn't rendered, the model is asked once more instead
+		if chunks == nil {
+			if attempt > 0 {
+				return "", ErrEmptyResponse
+			}
+			fmt.Fprintln(os.Stderr, "The model returned an empty response, asking again")
+			payload.Messages = withNudge(payload.Messages)
+			continue
+		}
+		warnSlow(cfg, timings, time.Since(start))
+		return answer, nil
+	}
+}
+
+// acceptFor returns the content type of the response to the payload: events, or a whole JSON
+// response for models that don't stream and --no-stream. The response is parsed by the content
+// type it has, which isn't always the one asked for.
+func acceptFor(payload ApiPayload) string {
+	if payload.Stream {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+// cachedAnswer renders the cached answer to an identical request when the cache is enabled,
+// and otherwise streams the answer and caches it. --no-cache replaces the cached answer. Requests
+// for several choices aren't cached, the cache holds a single answer.
+func cachedAnswer(ctx context.Context, cfg config.Config, args args.Arguments, payload ApiPayload) (string, error) {
+	if !cfg.Cache.Enabled || args.Choices > 1 {
+		return streamAnswer(ctx, cfg, args, payload)
+	}
+
+	key, err := cache.Key(payload)
+	if err != nil {
+		return "", err
+	}
+	if answer, ok := cache.Get(key, cfg.Cache.TTL); ok && !args.NoCache {
+		logging.FromContext(ctx).Debug("answer served from cache", "key", key)
+		chunks := make(chan stream.Chunk, 1)
+		chunks <- stream.Chunk{Content: answer}
+		close(chunks)
+		return renderAnswer(ctx, cfg, args, chunks)
+	}
+
+	answer, err := streamAnswer(ctx, cfg, args, payload)
+	if err != nil {
+		return "", err
 	}
+	if err := cache.Put(key, answer); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to cache answer: %v\n", err)
+	}
+	return answer, nil
+}
+
+// renderAnswer renders the answer's chunks to the terminal, the autosave, and the --out file,
+// returning the final answer once all chunks were received.
+func renderAnswer(ctx context.Context, cfg config.Config, args args.Arguments, chunks <-chan stream.Chunk) (answer string, err error) {
+	var renderer render.StreamRenderer
+	if args.Output == render.OutputModeJSONL {
+		renderer = render.NewJSONLRenderer(ctx, os.Stdout)
+	} else if renderer, err = render.NewTerminalRenderer(ctx, cfg, args); err != nil {
+		return "", fmt.Errorf("failed to create renderer: %w", err)
+	}
+
+	if cfg.Autosave {
+		saver, err := autosave.NewWriter()
+		if err != nil {
+			return "", fmt.Errorf("failed to create autosave: %w", err)
+		}
+		defer func() {
+			if err := saver.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to close autosave: %v\n", err)
+			}
+		}()
+		renderer.Tee(saver)
+	}
+
+	if args.OutputPath != "" {
+		out, err := render.NewOutputFile(args.OutputPath, args.OutputFormat, args.Model, args.Prompts, args.Deterministic)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		renderer.Tee(out)
+	}
+
+	if err := renderer.Render(chunks); err != nil {
+		return "", err
+	}
+	return renderer.Answer(), nil
+}
 
-	parser := stream.NewParser(ctx)
-	renderer, err := render.NewTerminalRenderer(ctx, cfg, args)
+// printPayload prints the request payload as pretty JSON, with a token estimate on stderr.
+func printPayload(cfg config.Config, payload ApiPayload) error {
+	data, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to create renderer: %w", err)
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	fmt.Println(string(data))
+
+	budget, window := promptBudget(cfg, payload)
+	fmt.Fprintf(os.Stderr, "%d messages, ~%d prompt tokens of the %d that fit in the %d token context window of %s\n",
+		len(payload.Messages), countMessages(payload.Model, payload.Messages), budget, window, payload.Model)
+	return nil
+}
+
+// printStats prints the model, duration, answer size, and remaining rate limit of a request to stderr.
+func printStats(model string, elapsed time.Duration, answer string) {
+	stats := fmt.Sprintf("%s, %s, ~%d tokens", model, elapsed.Round(100*time.Millisecond), tokens.Estimate(answer))
+	if limit, err := CurrentRateLimit(); err == nil && !limit.Observed.IsZero() {
+		stats += ", " + limit.String()
+	}
+	fmt.Fprintln(os.Stderr, stats)
+}
+
+// RecordEvent logs an acceptance event locally, if enabled. Failures only produce a warning.
+func RecordEvent(cfg config.Config, event telemetry.Event) {
+	if !cfg.TrackAcceptance {
+		return
 	}
+	if err := telemetry.Record(event); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to record %s event: %v\n", event.Kind, err)
+	}
+}
 
-	go parser.Process(resp.Body)
-	return renderer.Render(parser.Chunks())
+// copyCodeBlock places the nth (1-based) fenced code block of the answer on the clipboard.
+func copyCodeBlock(answer string, n int) error {
+	blocks := codeblock.Parse(answer)
+	if n > len(blocks) {
+		return fmt.Errorf("cannot copy code block %d: answer has %d code blocks", n, len(blocks))
+	}
+
+	if err := clipboard.Write(blocks[n-1].Code + "\n"); err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Copied code block %d to the clipboard\n", n)
+	return nil
 }
@@ -4,15 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logging"
 )
 
+// LocalModelPrefix marks embedding models served by the local endpoint of the config, e.g. local:nomic-embed-text.
+const LocalModelPrefix = "local:"
+
 // EmbeddingInput represents an input for embedding generation
 type EmbeddingInput struct {
 	Filename  string
@@ -44,8 +54,12 @@ func prepareEmbeddingRequest(inputs []EmbeddingInput, threshold int) []string {
 		if input.Filetype == "raw" {
 			results = append(results, content)
 		} else {
-			formatted := fmt.Sprintf("File: `%s`\n```%s\n%s\n```",
-				input.Filename,
+			header := fmt.Sprintf("File: `%s`", input.Filename)
+			if input.StartLine > 1 {
+				header += fmt.Sprintf(" from line %d", input.StartLine) // A chunk of a larger file
+			}
+			formatted := fmt.Sprintf("%s\n```%s\n%s\n```",
+				header,
 				input.Filetype,
 				content)
 			results = append(results, formatted)
@@ -55,7 +69,8 @@ func prepareEmbeddingRequest(inputs []EmbeddingInput, threshold int) []string {
 	return results
 }
 
-// GenerateEmbeddings generates embeddings for the provided inputs
+// GenerateEmbeddings generates embeddings for the provided inputs, in concurrent batches of the
+// configured size, each retried when it fails for a transient reason.
 //
 // Here's how you would use it in practice:
 //
@@ -95,7 +110,7 @@ func prepareEmbeddingRequest(inputs []EmbeddingInput, threshold int) []string {
 //	}
 //
 // // Find similar documents
-// matches := FindSimilarDocuments(queryEmbedding[0], documents, documentEmbeddings, 0.8)
+// matches := FindSimilarDocuments(queryEmbedding[0], documents, documentEmbeddings, RankOptions{TopK: 5, MinScore: 0.8})
 //
 // // Use the most relevant matches in your chat context
 // relevantDocs := make([]EmbeddingInput, 0)
@@ -107,11 +122,204 @@ func prepareEmbeddingRequest(inputs []EmbeddingInput, threshold int) []string {
 // // Use in chat with relevant context
 // err = Ask(ctx, "Explain this code", "copilot-codex", false, relevantDocs)
 func GenerateEmbeddings(ctx context.Context, cfg config.Config, inputs []EmbeddingInput, model string) ([]EmbeddingOutput, error) {
+	return embedBatches(ctx, cfg, inputs, model, nil)
+}
+
+// EmbedWithFallback generates embeddings like GenerateEmbeddings, falling back to the local embedding
+// endpoint of the config when the Copilot API is unreachable. It returns the model that was used,
+// which is prefixed with LocalModelPrefix for the local endpoint. Progress, if not nil, is called
+// after every batch with the number of inputs embedded so far.
+func EmbedWithFallback(
+	ctx context.Context,
+	cfg config.Config,
+	inputs []EmbeddingInput,
+	model string,
+	progress func(done, total int),
+) ([]EmbeddingOutput, string, error) {
+	embeddings, err := embedBatches(ctx, cfg, inputs, model, progress)
+	if err == nil || !Unreachable(err) || cfg.Rag.LocalEndpoint == "" || strings.HasPrefix(model, LocalModelPrefix) {
+		return embeddings, model, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Copilot embeddings are unreachable (%v), using %s of %s instead\n",
+		err, cfg.Rag.LocalModel, cfg.Rag.LocalEndpoint)
+	model = LocalModelPrefix + cfg.Rag.LocalModel
+	embeddings, err = embedBatches(ctx, cfg, inputs, model, progress)
+	return embeddings, model, err
+}
+
+// embedBatches embeds the inputs in batches of the configured size, sent by a pool of workers, and
+// returns the embeddings in the order of the inputs. The first batch that fails stops the others.
+func embedBatches(
+	ctx context.Context,
+	cfg config.Config,
+	inputs []EmbeddingInput,
+	model string,
+	progress func(done, total int),
+) ([]EmbeddingOutput, error) {
+	size := max(cfg.Rag.BatchSize, 1)
+	batches := (len(inputs) + size - 1) / size
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type batch struct {
+		start  int // Position of the first input of the batch
+		inputs []EmbeddingInput
+	}
+	queue := make(chan batch)
+	var (
+		mu       sync.Mutex
+		outputs  = make([]EmbeddingOutput, 0, len(inputs))
+		done     int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for range min(max(cfg.Rag.Concurrency, 1), batches) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range queue {
+				embeddings, err := embedBatch(ctx, cfg, b.inputs, model)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				for _, embedding := range embeddings {
+					embedding.Index += b.start // Indexes of the response are within the batch
+					outputs = append(outputs, embedding)
+				}
+				done += len(b.inputs)
+				if progress != nil {
+					progress(done, len(inputs))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for start := 0; start < len(inputs); start += size {
+		select {
+		case queue <- batch{start: start, inputs: inputs[start:min(start+size, len(inputs))]}:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Index < outputs[j].Index })
+	return outputs, nil
+}
+
+// embedBatch embeds a batch of inputs, retrying the configured number of times when the request
+// fails for a reason that may pass: a network error, an error status of the server, or the rate limit.
+func embedBatch(ctx context.Context, cfg config.Config, inputs []EmbeddingInput, model string) ([]EmbeddingOutput, error) {
+	for attempt := 0; ; attempt++ {
+		embeddings, err := embedOnce(ctx, cfg, inputs, model)
+		if err == nil || attempt >= cfg.Rag.Retries || !retryableEmbedding(err) || ctx.Err() != nil {
+			return embeddings, err
+		}
+
+		var status *embeddingStatusError
+		if errors.As(err, &status) && status.StatusCode == http.StatusTooManyRequests && !status.Reset.IsZero() {
+			if err := sleepUntilReset(ctx, cfg, status.Reset); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		wait := retryBackoff(ctx, attempt)
+		fmt.Fprintf(os.Stderr, "Embedding a batch of %d inputs failed (%v), retrying in %s\n", len(inputs), err, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// embedOnce sends a single request for the embeddings of the inputs, to the local endpoint for its models.
+func embedOnce(ctx context.Context, cfg config.Config, inputs []EmbeddingInput, model string) ([]EmbeddingOutput, error) {
+	if local, ok := strings.CutPrefix(model, LocalModelPrefix); ok {
+		return localEmbeddings(ctx, cfg, inputs, local)
+	}
+
 	headers, err := getHeaders(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get headers: %w", err)
 	}
+	if err := prioritize(ctx); err != nil {
+		return nil, err
+	}
+	if err := waitForRateLimit(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return requestEmbeddings(ctx, cfg, cfg.Endpoints.API+"/embeddings", headers, inputs, model)
+}
+
+// embeddingStatusError is returned when an embeddings endpoint answers with an error status.
+type embeddingStatusError struct {
+	StatusCode int
+	Body       string
+	Reset      time.Time // When the rate limit resets, if the response tells
+}
+
+// Error tells the status and the body of the response.
+func (e *embeddingStatusError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// retryableEmbedding reports whether a failed embeddings request may succeed when sent again.
+func retryableEmbedding(err error) bool {
+	var status *embeddingStatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, ErrCircuitOpen) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// Unreachable reports whether the error means the Copilot API could not be reached, e.g. offline,
+// as opposed to the API refusing the request.
+func Unreachable(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNoGitHubToken)
+}
+
+// localEmbeddings generates embeddings with the OpenAI-compatible local endpoint of the config.
+func localEmbeddings(ctx context.Context, cfg config.Config, inputs []EmbeddingInput, model string) ([]EmbeddingOutput, error) {
+	if cfg.Rag.LocalEndpoint == "" {
+		return nil, fmt.Errorf("the embedding model %s%s needs rag.local_endpoint in the config", LocalModelPrefix, model)
+	}
+	endpoint := strings.TrimSuffix(cfg.Rag.LocalEndpoint, "/") + "/embeddings"
+	return requestEmbeddings(ctx, cfg, endpoint, nil, inputs, model)
+}
 
+// requestEmbeddings posts the inputs to an OpenAI-compatible embeddings endpoint.
+func requestEmbeddings(
+	ctx context.Context,
+	cfg config.Config,
+	endpoint string,
+	headers map[string]string,
+	inputs []EmbeddingInput,
+	model string,
+) ([]EmbeddingOutput, error) {
 	threshold := 20000 // Similar to BIG_EMBED_THRESHOLD from Lua
 	prepared := prepareEmbeddingRequest(inputs, threshold)
 
@@ -124,9 +332,8 @@ func GenerateEmbeddings(ctx context.Context, cfg config.Config, inputs []Embeddi
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
-	fmt.Printf("Request payload: %s\n", string(data))
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, APIBase+"/embeddings", bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -136,21 +343,33 @@ func GenerateEmbeddings(ctx context.Context, cfg config.Config, inputs []Embeddi
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	logger := logging.FromContext(ctx)
+	logger.Debug("sending request", "method", req.Method, "url", req.URL.String(),
+		logging.Headers(req.Header), "inputs", len(inputs), "bytes", len(data))
+
 	client := getHTTPClient(ctx, cfg)
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		logger.Debug("request failed", "url", req.URL.String(), "error", err, "duration", time.Since(start))
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
+	logger.Debug("received response", "url", req.URL.String(), "status", resp.StatusCode,
+		logging.Headers(resp.Header), "duration", time.Since(start))
 	defer func() {
 		err := resp.Body.Close()
 		if err != nil {
-			fmt.Printf("failed to close response body: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to close response body: %v\n", err)
 		}
 	}()
 
+	if headers != nil {
+		observeRateLimit(resp) // Only the Copilot API counts
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		limit, _ := parseRateLimit(resp.Header, resp.StatusCode, time.Now())
+		return nil, &embeddingStatusError{StatusCode: resp.StatusCode, Body: string(body), Reset: limit.Reset}
 	}
 
 	var result struct {
@@ -166,6 +385,7 @@ func GenerateEmbeddings(ctx context.Context, cfg config.Config, inputs []Embeddi
 // EmbeddingMatch represents a matched document with its similarity score
 type EmbeddingMatch struct {
 	Input EmbeddingInput
+	Index int // Position of the document in the searched collection
 	Score float32
 }
 
@@ -192,15 +412,18 @@ func CosineSimilarity(a, b []float32) float32 {
 	return dotProduct / similarity
 }
 
-// FindSimilarDocuments finds the most similar documents to a query embedding
-func FindSimilarDocuments(queryEmbedding EmbeddingOutput, documents []EmbeddingInput, documentEmbeddings []EmbeddingOutput, threshold float32) []EmbeddingMatch {
+// FindSimilarDocuments finds the documents most similar to a query embedding, at least as similar as
+// the minimum score of the options, and returns the top k of them, re-ranked for diversity when the
+// options' lambda is between 0 and 1.
+func FindSimilarDocuments(queryEmbedding EmbeddingOutput, documents []EmbeddingInput, documentEmbeddings []EmbeddingOutput, opts RankOptions) []EmbeddingMatch {
 	matches := make([]EmbeddingMatch, 0)
 
 	for i, docEmbedding := range documentEmbeddings {
 		score := CosineSimilarity(queryEmbedding.Embedding, docEmbedding.Embedding)
-		if score >= threshold {
+		if score >= opts.MinScore {
 			matches = append(matches, EmbeddingMatch{
 				Input: documents[i],
+				Index: i,
 				Score: score,
 			})
 		}
@@ -211,5 +434,37 @@ func FindSimilarDocuments(queryEmbedding EmbeddingOutput, documents []EmbeddingI
 		return matches[i].Score > matches[j].Score
 	})
 
+	matches = limitMatches(matches, documentEmbeddings, opts)
+	if opts.Normalize {
+		scores := make([]float32, len(matches))
+		for i, match := range matches {
+			scores[i] = match.Score
+		}
+		NormalizeScores(scores)
+		for i := range matches {
+			matches[i].Score = scores[i]
+		}
+	}
+	return matches
+}
+
+// limitMatches returns the top k of the matches, sorted by score, picked by maximal marginal
+// relevance when the options diversify.
+func limitMatches(matches []EmbeddingMatch, documentEmbeddings []EmbeddingOutput, opts RankOptions) []EmbeddingMatch {
+	if opts.Diversify() {
+		scores := make([]float32, len(matches))
+		vectors := make([][]float32, len(matches))
+		for i, match := range matches {
+			scores[i], vectors[i] = match.Score, documentEmbeddings[match.Index].Embedding
+		}
+		picked := make([]EmbeddingMatch, 0, len(matches))
+		for _, i := range SelectMMR(scores, vectors, opts.MMRLambda, opts.TopK) {
+			picked = append(picked, matches[i])
+		}
+		return picked
+	}
+	if opts.TopK > 0 && len(matches) > opts.TopK {
+		return matches[:opts.TopK]
+	}
 	return matches
 }
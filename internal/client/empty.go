@@ -0,0 +1,48 @@
+package client
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+// ErrEmptyResponse is returned when the model's answer is empty or only whitespace, even when asked again.
+var ErrEmptyResponse = errors.New("the model returned an empty response, possibly because it was filtered: rephrase the prompt or try another model")
+
+// emptyNudge asks the model again after an empty answer.
+const emptyNudge = "Your previous reply was empty. Please reply to the request above."
+
+// awaitContent reads the chunks until the first one with visible content or an error, and returns
+// a channel that replays the chunks read, followed by the rest. It returns nil when the stream
+// ended without either.
+func awaitContent(chunks <-chan stream.Chunk) <-chan stream.Chunk {
+	var read []stream.Chunk
+	for chunk := range chunks {
+		read = append(read, chunk)
+		if chunk.Error != nil || strings.TrimSpace(chunk.Content) != "" {
+			out := make(chan stream.Chunk)
+			go func() {
+				defer close(out)
+				for _, chunk := range read {
+					out <- chunk
+				}
+				for chunk := range chunks {
+					out <- chunk
+				}
+			}()
+			return out
+		}
+	}
+	return nil
+}
+
+// withNudge returns the messages with a request to reply after an empty answer, before the prefill if any.
+func withNudge(messages []Message) []Message {
+	nudge := Message{Role: UserRole, Content: emptyNudge}
+	messages = append([]Message(nil), messages...)
+	if n := len(messages); n > 0 && messages[n-1].Role == AssistantRole {
+		return append(messages[:n-1], nudge, messages[n-1])
+	}
+	return append(messages, nudge)
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+// stage is a part of a request whose duration is measured for the slow request warnings.
+type stage int
+
+const (
+	stageRateLimit  stage = iota // Waiting for an exhausted rate limit to reset
+	stageAuth                    // Exchanging the GitHub token for a Copilot token
+	stageConnect                 // Connecting and waiting for the response headers
+	stageFirstToken              // Waiting for the first content after the headers
+	stageCount
+)
+
+// stageHints names the stages and tells what helps when they are slow.
+var stageHints = [stageCount]struct{ name, hint string }{
+	stageRateLimit:  {"waiting for the rate limit", "Spread out the requests, `gh copilot quota` shows the remaining ones."},
+	stageAuth:       {"the token exchange", "Check your network and proxy settings for the endpoints.github_api of your config. The Copilot token is reused within a run, so `chat` and `serve` only wait for it once."},
+	stageConnect:    {"connecting to the API", "Check your network and proxy settings (HTTPS_PROXY), and the endpoints.api of your config."},
+	stageFirstToken: {"waiting for the first token", "The model was slow to start answering, a faster model or a shorter prompt may help."},
+}
+
+// timingsKey is the context key of the timings of a request.
+type timingsKey struct{}
+
+// timings holds the durations of the stages of a request.
+type timings [stageCount]time.Duration
+
+// withTimings returns a context whose requests record the durations of their stages in the timings.
+func withTimings(ctx context.Context) (context.Context, *timings) {
+	t := &timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// timingsFrom returns the timings of the context's request, nil if they aren't measured.
+func timingsFrom(ctx context.Context) *timings {
+	t, _ := ctx.Value(timingsKey{}).(*timings)
+	return t
+}
+
+// record adds the time since the start of the stage, if the timings are measured.
+func (t *timings) record(s stage, start time.Time) {
+	if t != nil {
+		t[s] += time.Since(start)
+	}
+}
+
+// slowest returns the stage that took the longest.
+func (t *timings) slowest() stage {
+	slowest := stageRateLimit
+	for s := range stageCount {
+		if t[s] > t[slowest] {
+			slowest = s
+		}
+	}
+	return slowest
+}
+
+// watchFirstToken forwards the chunks, recording the time from the start until the first content.
+// The timings may only be read once the returned channel is closed.
+func watchFirstToken(t *timings, start time.Time, chunks <-chan stream.Chunk) <-chan stream.Chunk {
+	out := make(chan stream.Chunk)
+	go func() {
+		defer close(out)
+		first := true
+		for chunk := range chunks {
+			if first && chunk.Content != "" {
+				t.record(stageFirstToken, start)
+				first = false
+			}
+			out <- chunk
+		}
+	}()
+	return out
+}
+
+// warnSlow prints a warning to stderr when the request exceeded a latency threshold of the config,
+// naming the stage that took the longest and what helps against it.
+func warnSlow(cfg config.Config, t *timings, total time.Duration) {
+	firstToken := time.Duration(0)
+	for s := range stageCount {
+		firstToken += t[s]
+	}
+
+	var slow string
+	switch {
+	case cfg.Latency.FirstToken > 0 && firstToken > cfg.Latency.FirstToken:
+		slow = fmt.Sprintf("the first token took %s, over the %s of latency.first_token", round(firstToken), cfg.Latency.FirstToken)
+	case cfg.Latency.Total > 0 && total > cfg.Latency.Total:
+		slow = fmt.Sprintf("the answer took %s, over the %s of latency.total", round(total), cfg.Latency.Total)
+		// With a quick start, the time was spent streaming the answer, which only a shorter answer helps
+		if firstToken < total/2 {
+			fmt.Fprintf(os.Stderr, "Warning: %s, most of it streaming. --length short asks for a shorter answer.\n", slow)
+			return
+		}
+	default:
+		return
+	}
+
+	s := t.slowest()
+	fmt.Fprintf(os.Stderr, "Warning: %s; %s took %s. %s\n", slow, stageHints[s].name, round(t[s]), stageHints[s].hint)
+}
+
+// round rounds a duration for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(100 * time.Millisecond)
+}
@@ -0,0 +1,44 @@
+package client
+
+import "strings"
+
+// lengthPreset is the instruction and token limit of an answer length.
+type lengthPreset struct {
+	instruction string
+	maxTokens   int // 0 leaves the limit to the model
+}
+
+// lengthPresets are the presets of --length, "normal" leaves the answer to the model.
+var lengthPresets = map[string]lengthPreset{
+	"short": {
+		instruction: "Answer as briefly as possible, in a few sentences or a single code block. " +
+			"Skip introductions, summaries, and alternatives unless asked.",
+		maxTokens: 600,
+	},
+	"detailed": {
+		instruction: "Answer thoroughly: explain the reasoning, cover edge cases and alternatives, " +
+			"and give complete examples.",
+	},
+}
+
+// reasoningTokenFactor scales the token limit of reasoning models, whose hidden reasoning counts
+// against it, so a short answer isn't cut off before it starts.
+const reasoningTokenFactor = 8
+
+// lengthFor returns the preset of the answer length for the model.
+func lengthFor(model, length string) lengthPreset {
+	preset := lengthPresets[length]
+	if preset.maxTokens > 0 && isReasoningModel(model) {
+		preset.maxTokens *= reasoningTokenFactor
+	}
+	return preset
+}
+
+// isReasoningModel reports whether the model reasons before answering, like o1 and o3-mini.
+func isReasoningModel(model string) bool {
+	if strings.Contains(model, "-thinking") {
+		return true
+	}
+	return len(model) > 1 && model[0] == 'o' && model[1] >= '0' && model[1] <= '9'
+
+}
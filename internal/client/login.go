@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// copilotClientID is the OAuth app of the Copilot editor plugins, whose tokens the token exchange accepts.
+const copilotClientID = "Iv1.b507a08c87ecfe98"
+
+// slowDownInterval is added to the polling interval when the server asks to slow down.
+const slowDownInterval = 5 * time.Second
+
+// DeviceCode is the code the user enters in the browser to authorize the login.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"` // Seconds
+	Interval        int    `json:"interval"`   // Seconds between polls
+}
+
+// deviceTokenResponse is the answer to a poll for the access token, with an error until it is authorized.
+type deviceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// RequestDeviceCode starts the device authorization flow on the config's auth host.
+func RequestDeviceCode(ctx context.Context, cfg config.Config) (DeviceCode, error) {
+	var code DeviceCode
+	err := postForm(ctx, cfg, "/login/device/code", url.Values{
+		"client_id": {copilotClientID},
+		"scope":     {"read:user"},
+	}, &code)
+	if err != nil {
+		return DeviceCode{}, fmt.Errorf("failed to request a device code: %w", err)
+	}
+	if code.DeviceCode == "" || code.UserCode == "" {
+		return DeviceCode{}, errors.New("failed to request a device code: empty response")
+	}
+	return code, nil
+}
+
+// WaitForLogin polls until the user authorized the device code, and stores the OAuth token in the
+// hosts.json of the Copilot editor plugins. It returns the login of the user and the file's path.
+func WaitForLogin(ctx context.Context, cfg config.Config, code DeviceCode) (user, path string, err error) {
+	interval := time.Duration(max(code.Interval, 1)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", "", errors.New("the device code expired, run the login again")
+			}
+			return "", "", ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var resp deviceTokenResponse
+		err := postForm(ctx, cfg, "/login/oauth/access_token", url.Values{
+			"client_id":   {copilotClientID},
+			"device_code": {code.DeviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &resp)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to poll for the access token: %w", err)
+		}
+
+		switch resp.Error {
+		case "":
+			if resp.AccessToken == "" {
+				return "", "", errors.New("received empty token in response")
+			}
+			return storeLogin(ctx, cfg, resp.AccessToken)
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownInterval
+		case "expired_token":
+			return "", "", errors.New("the device code expired, run the login again")
+		case "access_denied":
+			return "", "", errors.New("the login was canceled in the browser")
+		default:
+			return "", "", fmt.Errorf("login failed: %s: %s", resp.Error, resp.Description)
+		}
+	}
+}
+
+// storeLogin looks up the user of the token and adds it to hosts.json, replacing an earlier token of the host.
+func storeLogin(ctx context.Context, cfg config.Config, token string) (user, path string, err error) {
+	user, err = fetchLogin(ctx, cfg, token)
+	if err != nil {
+		return "", "", err
+	}
+
+	dir, err := configPath()
+	if err != nil {
+		home, homeErr := os.UserHomeDir()
+		if homeErr != nil {
+			return "", "", fmt.Errorf("failed to get config path: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	path = filepath.Join(dir, "github-copilot", "hosts.json")
+
+	hosts := map[string]any{}
+	if err := readJSONFile(path, &hosts); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	hosts[cfg.AuthHost] = map[string]string{"user": user, "oauth_token": token}
+
+	data, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal hosts: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", "", fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	forgetToken() // A Copilot token of an earlier login must not be reused
+	return user, path, nil
+}
+
+// fetchLogin retrieves the login of the token's user.
+func fetchLogin(ctx context.Context, cfg config.Config, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Endpoints.GitHubAPI+"/user", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := getHTTPClient(ctx, cfg).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the user: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch the user: status code %d", resp.StatusCode)
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", fmt.Errorf("failed to decode user: %w", err)
+	}
+	return user.Login, nil
+}
+
+// postForm posts the form to a path of the auth host's web endpoint and decodes the JSON answer.
+func postForm(ctx context.Context, cfg config.Config, path string, form url.Values, v any) error {
+	endpoint := "https://" + cfg.AuthHost + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := getHTTPClient(ctx, cfg).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
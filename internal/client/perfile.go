@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/stream"
+	"github.com/markis/gh-copilot/internal/telemetry"
+)
+
+// perFileConcurrency is the number of files asked about at once.
+const perFileConcurrency = 4
+
+// PerFile sends the prompt once for each attached file, with only that file attached, and renders
+// the answers one after the other under the file's header, while the later ones keep streaming in
+// the background. Each answer is more focused than one about all files, and they arrive sooner.
+func PerFile(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	// Streams of answers that are no longer rendered, e.g. after an error, are stopped on return
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	streams := make([]chan stream.Chunk, len(args.Files))
+	done := make([]chan struct{}, len(args.Files)) // Closed when the file's answer is received
+	for i, file := range args.Files {
+		fileArgs := args
+		fileArgs.Files = []string{file}
+		payload, err := prepareInput(fileArgs)
+		if err != nil {
+			return err
+		}
+
+		if args.DryRun {
+			if err := printPayload(cfg, payload); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fitContextWindow(ctx, cfg, &payload); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		// A file waits for the one perFileConcurrency before it, so the file being rendered is always streaming
+		streams[i] = make(chan stream.Chunk, compareBuffer)
+		done[i] = make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+			if i >= perFileConcurrency {
+				select {
+				case <-done[i-perFileConcurrency]:
+				case <-ctx.Done():
+					close(streams[i])
+					return
+				}
+			}
+			streamChunks(ctx, cfg, payload, streams[i])
+		}()
+	}
+	if args.DryRun {
+		return nil
+	}
+
+	failed := 0
+	for i, file := range args.Files {
+		fmt.Printf("\n=== %s ===\n\n", file)
+
+		renderer, err := render.NewTerminalRenderer(ctx, cfg, args)
+		if err != nil {
+			return fmt.Errorf("failed to create renderer: %w", err)
+		}
+		if err := renderer.Render(streams[i]); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "%s failed: %v\n", file, err)
+			failed++
+			continue
+		}
+
+		RecordEvent(cfg, telemetry.Event{
+			Answer:  telemetry.NewAnswerID(),
+			Kind:    telemetry.EventAnswer,
+			Command: args.Command,
+			Model:   args.Model,
+		})
+	}
+
+	if failed == len(args.Files) {
+		return errors.New("all files failed")
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logging"
+)
+
+// Priority ranks the requests of concurrent invocations against each other, so interactive use
+// isn't slowed down by background work such as indexing.
+type Priority int
+
+const (
+	PriorityLow    Priority = iota - 1 // Background work, which yields to interactive requests
+	PriorityNormal                     // One-off questions
+	PriorityHigh                       // Interactive use, which background work yields to
+)
+
+// interactiveFile marks, by its modification time, when the last high priority request was sent,
+// in the state directory, so background work in other invocations yields to it.
+const interactiveFile = "interactive"
+
+// yieldWindow is how long low priority requests are held back after a high priority request was sent.
+const yieldWindow = 10 * time.Second
+
+// ParsePriority parses a priority from its name: high, normal, or low.
+func ParsePriority(name string) (Priority, error) {
+	switch name {
+	case "high":
+		return PriorityHigh, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	}
+	return PriorityNormal, fmt.Errorf("invalid priority %q: must be high, normal, or low", name)
+}
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	}
+	return "normal"
+}
+
+// priorityKey is the context key of the priority of requests.
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests are sent with the priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// priorityFrom returns the priority of the requests of the context, normal unless set.
+func priorityFrom(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority)
+	return priority
+}
+
+// prioritize runs before a request is sent: a high priority request marks that interactive use
+// is going on, and a low priority one waits until none was sent for a while.
+func prioritize(ctx context.Context) error {
+	path, err := interactivePath()
+	if err != nil {
+		return nil // Priorities are a hint, they must not block requests
+	}
+
+	switch priorityFrom(ctx) {
+	case PriorityHigh:
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err == nil {
+			err = os.WriteFile(path, nil, 0o600)
+		}
+		if err != nil {
+			logging.FromContext(ctx).Debug("failed to mark interactive request", "error", err)
+		}
+	case PriorityLow:
+		noted := false
+		for {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil
+			}
+			wait := yieldWindow - time.Since(info.ModTime())
+			if wait <= 0 {
+				return nil
+			}
+			if !noted {
+				fmt.Fprintln(os.Stderr, "Pausing for interactive requests")
+				noted = true
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return nil
+}
+
+// retryBackoff returns how long to wait before the retry after the attempt: interactive requests
+// retry sooner, background ones leave the API more room.
+func retryBackoff(ctx context.Context, attempt int) time.Duration {
+	base := time.Second
+	switch priorityFrom(ctx) {
+	case PriorityHigh:
+		base = 250 * time.Millisecond
+	case PriorityLow:
+		base = 4 * time.Second
+	}
+	return base << attempt
+}
+
+// interactivePath returns the path of the file marking interactive requests.
+func interactivePath() (string, error) {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	return filepath.Join(stateDir, interactiveFile), nil
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"math"
+	"slices"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// RankOptions limits and diversifies the documents ranked by similarity to a query.
+type RankOptions struct {
+	TopK      int     // Documents returned, 0 for all
+	MinScore  float32 // Minimum similarity to the query for a document to be ranked
+	MMRLambda float32 // Weight of relevance against diversity in the re-ranking, 1 or 0 to rank by relevance alone
+	Normalize bool    // Rescale the scores of the ranked documents, from 0 for the least to 1 for the most similar
+}
+
+// NormalizeScores rescales the scores in place, from 0 for the lowest to 1 for the highest, so
+// scores of models whose similarities cluster differently can be compared. Equal scores become 1.
+func NormalizeScores(scores []float32) {
+	if len(scores) == 0 {
+		return
+	}
+	lo, hi := slices.Min(scores), slices.Max(scores)
+	for i, score := range scores {
+		if hi > lo {
+			scores[i] = (score - lo) / (hi - lo)
+		} else {
+			scores[i] = 1
+		}
+	}
+}
+
+// SelectMMR picks k of the candidates, whose relevance to the query and vectors are given, by
+// maximal marginal relevance: each pick maximizes lambda times its relevance minus 1-lambda times
+// its similarity to the most similar candidate picked before it, so near duplicates of a better
+// candidate give way to other relevant ones. It returns the positions of the picked candidates
+// in the order they were picked. Relevance is normalized, so lambda weighs comparable ranges.
+func SelectMMR(relevance []float32, vectors [][]float32, lambda float32, k int) []int {
+	k = min(k, len(relevance))
+	if k <= 0 {
+		k = len(relevance)
+	}
+	normalized := slices.Clone(relevance)
+	NormalizeScores(normalized)
+
+	picked := make([]int, 0, k)
+	used := make([]bool, len(relevance))
+	redundancy := make([]float32, len(relevance)) // Similarity to the most similar picked candidate
+	for i := range redundancy {
+		redundancy[i] = float32(math.Inf(-1))
+	}
+	for len(picked) < k {
+		best, bestScore := -1, float32(math.Inf(-1))
+		for i := range relevance {
+			if used[i] {
+				continue
+			}
+			score := lambda * normalized[i]
+			if len(picked) > 0 {
+				score -= (1 - lambda) * redundancy[i]
+			}
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		picked = append(picked, best)
+		used[best] = true
+		for i := range relevance {
+			if !used[i] {
+				redundancy[i] = max(redundancy[i], CosineSimilarity(vectors[i], vectors[best]))
+			}
+		}
+	}
+	return picked
+}
+
+// NewRankOptions creates rank options from the RAG configuration.
+func NewRankOptions(cfg config.Config) RankOptions {
+	return RankOptions{
+		TopK:      cfg.Rag.TopK,
+		MinScore:  cfg.Rag.MinScore,
+		MMRLambda: cfg.Rag.MMRLambda,
+	}
+}
+
+// Diversify reports whether the options re-rank by maximal marginal relevance.
+func (o RankOptions) Diversify() bool {
+	return o.MMRLambda > 0 && o.MMRLambda < 1
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// rateLimitFile holds the last rate limit reported by the API, in the state directory, so
+// later invocations and `quota` know it.
+const rateLimitFile = "ratelimit.json"
+
+// ErrRateLimited is matched by the error returned when the rate limit is exhausted.
+var ErrRateLimited = errors.New("rate limit of the Copilot API exhausted")
+
+// RateLimit is the rate limit state reported by the API in its x-ratelimit-* headers.
+type RateLimit struct {
+	Limit     int       `json:"limit,omitempty"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset,omitzero"` // When the remaining requests are replenished
+	Observed  time.Time `json:"observed"`
+}
+
+// Exhausted reports whether no requests remain until the reset.
+func (r RateLimit) Exhausted(now time.Time) bool {
+	return r.Remaining <= 0 && r.Reset.After(now)
+}
+
+// RateLimitError is returned when the rate limit is exhausted for longer than the configured wait.
+type RateLimitError struct {
+	Reset time.Time
+}
+
+// Error tells when the rate limit resets.
+func (e *RateLimitError) Error() string {
+	if e.Reset.IsZero() {
+		return ErrRateLimited.Error()
+	}
+	return fmt.Sprintf("%v, resets in %s (at %s)", ErrRateLimited,
+		time.Until(e.Reset).Round(time.Second), e.Reset.Local().Format(time.TimeOnly))
+}
+
+// Is matches ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+var (
+	rateLimitMu     sync.Mutex
+	rateLimit       RateLimit
+	rateLimitLoaded bool
+)
+
+// CurrentRateLimit returns the last rate limit reported by the API, by this or an earlier invocation.
+func CurrentRateLimit() (RateLimit, error) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+
+	if rateLimitLoaded {
+		return rateLimit, nil
+	}
+
+	path, err := rateLimitPath()
+	if err != nil {
+		return RateLimit{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return RateLimit{}, fmt.Errorf("failed to read rate limit: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &rateLimit); err != nil {
+			rateLimit = RateLimit{} // Corrupt, e.g. from an interrupted write
+		}
+	}
+	rateLimitLoaded = true
+	return rateLimit, nil
+}
+
+// waitForRateLimit pauses until the rate limit resets when it is exhausted, failing when that
+// takes longer than the configured wait.
+func waitForRateLimit(ctx context.Context, cfg config.Config) error {
+	limit, err := CurrentRateLimit()
+	if err != nil || !limit.Exhausted(time.Now()) {
+		return nil // An unreadable state must not block requests
+	}
+	return sleepUntilReset(ctx, cfg, limit.Reset)
+}
+
+// sleepUntilReset waits for the rate limit to reset, if that is within the configured wait.
+func sleepUntilReset(ctx context.Context, cfg config.Config, reset time.Time) error {
+	wait := time.Until(reset)
+	if reset.IsZero() || wait > cfg.Http.RateLimitWait {
+		return &RateLimitError{Reset: reset}
+	}
+
+	fmt.Fprintf(os.Stderr, "Rate limit exhausted, waiting %s for it to reset\n", wait.Round(time.Second))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// observeRateLimit records the rate limit reported by a response, if any.
+func observeRateLimit(resp *http.Response) {
+	limit, ok := parseRateLimit(resp.Header, resp.StatusCode, time.Now())
+	if !ok {
+		return
+	}
+
+	rateLimitMu.Lock()
+	rateLimit, rateLimitLoaded = limit, true
+	rateLimitMu.Unlock()
+
+	if err := saveRateLimit(limit); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save rate limit: %v\n", err)
+	}
+}
+
+// String summarizes the remaining requests and when they reset.
+func (r RateLimit) String() string {
+	text := fmt.Sprintf("%d requests remaining", r.Remaining)
+	if r.Limit > 0 {
+		text = fmt.Sprintf("%d of %d requests remaining", r.Remaining, r.Limit)
+	}
+	if until := time.Until(r.Reset); until > 0 {
+		text += fmt.Sprintf(", resets in %s", until.Round(time.Second))
+	}
+	return text
+}
+
+// parseRateLimit reads the rate limit from the x-ratelimit-* headers, in either the GitHub
+// form (reset as a Unix time) or the OpenAI form (per requests, reset as a duration).
+// A 429 without them is taken as exhausted until its Retry-After.
+func parseRateLimit(header http.Header, status int, now time.Time) (RateLimit, bool) {
+	value := func(name string) string {
+		if v := header.Get(name); v != "" {
+			return v
+		}
+		return header.Get(name + "-Requests")
+	}
+
+	limit := RateLimit{Observed: now}
+	remaining, err := strconv.Atoi(value("X-Ratelimit-Remaining"))
+	found := err == nil
+	limit.Remaining = remaining
+	limit.Limit, _ = strconv.Atoi(value("X-Ratelimit-Limit"))
+
+	if reset := value("X-Ratelimit-Reset"); reset != "" {
+		if seconds, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			if seconds > 1e9 {
+				limit.Reset = time.Unix(seconds, 0)
+			} else {
+				limit.Reset = now.Add(time.Duration(seconds) * time.Second)
+			}
+		} else if d, err := time.ParseDuration(reset); err == nil {
+			limit.Reset = now.Add(d)
+		}
+	}
+
+	if status == http.StatusTooManyRequests {
+		found = true
+		limit.Remaining = 0
+		if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+			limit.Reset = now.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	return limit, found
+}
+
+// saveRateLimit persists the rate limit for later invocations.
+func saveRateLimit(limit RateLimit) error {
+	path, err := rateLimitPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.Marshal(limit)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rate limit: %w", err)
+	}
+
+	// Concurrent requests each save, the rename keeps their writes from interleaving
+	tmp, err := os.CreateTemp(filepath.Dir(path), rateLimitFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create rate limit file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write rate limit: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write rate limit: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// rateLimitPath retrieves the path of the persisted rate limit.
+func rateLimitPath() (string, error) {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	return filepath.Join(stateDir, rateLimitFile), nil
+}
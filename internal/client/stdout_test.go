@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/args"
+)
+
+// TestAskStdoutOnlyAnswer asks with stdout redirected to a pipe, as `gh copilot ... > out.md`
+// does, with every notice of an answer triggered: a rate limited request, a slow first token,
+// an answer cut off at the token limit, and --stats. Only the answer may reach stdout, the
+// notices go to stderr. The spinner is on, and the answer slow enough to start for it to show.
+func TestAskStdoutOnlyAnswer(t *testing.T) {
+	const answer = "Only the answer."
+	notices := []string{
+		"Rate limit exhausted",            // Waiting for the rate limit to reset
+		"Warning: the first token took",   // latency.first_token exceeded
+		"Warning: the answer was cut off", // Finish reason length
+		"gpt-4o, ",                        // --stats
+		"waiting for model",               // The spinner, if it were shown
+		"\x1b[",                           // Any escape sequence of the spinner or the theme
+	}
+
+	for _, tt := range []struct {
+		name string
+		args args.Arguments
+	}{
+		{"plain", args.Arguments{UsePlainText: true}},
+		{"markdown", args.Arguments{Deterministic: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, answer, "question")
+			api.finishReason, api.delay = "length", 300*time.Millisecond
+			cfg := testConfig(t, api)
+			cfg.Latency.FirstToken = time.Nanosecond
+			cfg.Render.Spinner = true
+
+			a := tt.args
+			a.Model, a.Prompts, a.Stats = "gpt-4o", []string{"question"}, true
+			var err error
+			stdout, stderr := captureOutput(t, func() {
+				err = Ask(context.Background(), cfg, a)
+			})
+			if err != nil {
+				t.Fatalf("Ask: %v", err)
+			}
+
+			if !strings.Contains(stdout, answer) {
+				t.Errorf("stdout lacks the answer %q:\n%s", answer, stdout)
+			}
+			if tt.args.UsePlainText && strings.TrimSpace(stdout) != answer {
+				t.Errorf("stdout = %q, want only the answer %q", stdout, answer)
+			}
+			for _, notice := range notices {
+				if strings.Contains(stdout, notice) {
+					t.Errorf("stdout contains %q:\n%s", notice, stdout)
+				}
+			}
+			for _, notice := range notices[:4] {
+				if !strings.Contains(stderr, notice) {
+					t.Errorf("stderr lacks the notice %q, so the test doesn't cover it:\n%s", notice, stderr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/schema"
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+// schemaInstruction asks for JSON matching the schema, for models that ignore the response format.
+const schemaInstruction = "Reply only with JSON that matches this JSON schema, without a code block or any other text:\n\n```json\n%s\n```"
+
+// ResponseFormat constrains the format of the answer, see --json-schema.
+type ResponseFormat struct {
+	Type       string      `json:"type"` // "json_schema"
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema is the schema that the answer of a json_schema response format matches.
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// invalidNameChars are the characters that the name of a response format may not have.
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// loadResponseFormat reads the JSON schema file of --json-schema into a response format, named
+// after the file.
+func loadResponseFormat(path string) (*ResponseFormat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON schema: %w", err)
+	}
+	if _, err := schema.Parse(data); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema %s: %w", path, err)
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, data); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema %s: %w", path, err)
+	}
+	name := invalidNameChars.ReplaceAllString(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), "_")
+	if name == "" {
+		name = "answer"
+	}
+	return &ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &JSONSchema{Name: name, Schema: compacted.Bytes()},
+	}, nil
+}
+
+// askStructured receives the whole answer to a payload with a JSON schema and validates it. An
+// answer that doesn't match is sent back with the problems to be corrected, once. The JSON is
+// rendered only when it matches, without markdown, so it can be piped.
+func askStructured(ctx context.Context, cfg config.Config, args args.Arguments, payload ApiPayload) (string, error) {
+	s, err := schema.Parse(payload.ResponseFormat.JSONSchema.Schema)
+	if err != nil {
+		return "", fmt.Errorf("invalid JSON schema: %w", err)
+	}
+
+	var problems []string
+	for attempt := 0; attempt < 2; attempt++ {
+		answer, err := collectAnswer(ctx, cfg, payload)
+		if err != nil {
+			return "", err
+		}
+		answer = unfence(answer)
+		problems = s.ValidateJSON([]byte(answer))
+		if len(problems) == 0 {
+			args.UsePlainText = true
+			chunks := make(chan stream.Chunk, 1)
+			chunks <- stream.Chunk{Content: strings.TrimSpace(answer)}
+			close(chunks)
+			return renderAnswer(ctx, cfg, args, chunks)
+		}
+
+		if attempt == 0 {
+			fmt.Fprintf(os.Stderr, "The answer doesn't match %s, asking again:\n%s\n", args.JSONSchema, bullets(problems))
+			payload.Messages = append(payload.Messages,
+				Message{Role: AssistantRole, Content: answer},
+				Message{Role: UserRole, Content: "Your answer doesn't match the JSON schema:\n\n" + bullets(problems) +
+					"\n\nReply again with only the corrected JSON."})
+		}
+	}
+	return "", fmt.Errorf("the answer doesn't match %s:\n%s", args.JSONSchema, bullets(problems))
+}
+
+// unfence returns the code of an answer that is a single code block, as some models fence JSON
+// despite the instructions, and the answer itself otherwise.
+func unfence(answer string) string {
+	trimmed := strings.TrimSpace(answer)
+	if blocks := codeblock.Parse(trimmed); len(blocks) == 1 && strings.HasPrefix(trimmed, "```") && strings.HasSuffix(trimmed, "```") {
+		return blocks[0].Code
+	}
+	return answer
+}
+
+// bullets returns the problems as a markdown list.
+func bullets(problems []string) string {
+	return "- " + strings.Join(problems, "\n- ")
+}
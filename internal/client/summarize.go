@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/tokens"
+)
+
+// summarizeConcurrency is the number of pieces summarized at once.
+const summarizeConcurrency = 4
+
+// mapPrompt asks for the summary of a piece of the piped input, keeping what the question needs.
+const mapPrompt = `You condense part %d of %d of a long input, so a later question about the whole input can be answered
+from the summaries. Keep everything relevant to the question, and quote error messages, file names, line
+numbers, identifiers, and numbers verbatim. Leave out repetition and noise. Only reply with the summary.
+
+Question: %s`
+
+// combinePrompt asks to merge the summaries of the pieces into one.
+const combinePrompt = `You merge the summaries of consecutive parts of a long input into a single summary, in the
+input's order, so a question about the input can be answered from it. Keep everything relevant to the question,
+and quote error messages, file names, line numbers, and identifiers verbatim. Only reply with the summary.
+
+Question: %s`
+
+// summarizeStdin replaces long piped input with a map-reduce summary focused on the question, unless
+// it is short enough or --no-summarize is given: the input is split into pieces that are summarized
+// separately, whose summaries are then combined.
+func summarizeStdin(ctx context.Context, cfg config.Config, args *args.Arguments) error {
+	threshold := cfg.Summarize.Threshold
+	if args.Stdin == "" || args.NoSummarize || args.DryRun || threshold <= 0 {
+		return nil
+	}
+	model := cfg.ResolveModel(cfg.Summarize.Model)
+	size := tokens.Count(model, args.Stdin)
+	if size <= threshold {
+		return nil
+	}
+
+	question := strings.Join(args.Prompts[1:], "\n")
+	if question == "" {
+		question = "(none given, keep what matters most)"
+	}
+
+	pieces := splitTokens(model, args.Stdin, cfg.Summarize.ChunkSize)
+	fmt.Fprintf(os.Stderr, "Summarizing the piped input (~%d tokens) in %d pieces, --no-summarize sends it as it is\n", size, len(pieces))
+	summary, err := reduceSummaries(ctx, cfg, model, question, pieces)
+	if err != nil {
+		return fmt.Errorf("failed to summarize the piped input: %w", err)
+	}
+
+	args.Prompts[0] = fmt.Sprintf("Summary of the piped input, which was too long to send (%d lines):\n\n%s",
+		strings.Count(strings.TrimSuffix(args.Stdin, "\n"), "\n")+1, summary)
+	NoteTruncated(pipedInput, tokens.Count(model, summary), size, "tokens", "summarized, --no-summarize sends it as it is")
+	return nil
+}
+
+// reduceSummaries summarizes each piece, and combines the summaries, recursively while they don't fit a piece.
+func reduceSummaries(ctx context.Context, cfg config.Config, model, question string, pieces []string) (string, error) {
+	summaries := make([]string, len(pieces))
+	errs := make([]error, len(pieces))
+	slots := make(chan struct{}, summarizeConcurrency)
+	var wg sync.WaitGroup
+	for i, piece := range pieces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			summaries[i], errs[i] = Complete(ctx, cfg, model, []Message{
+				{Role: SystemRole, Content: fmt.Sprintf(mapPrompt, i+1, len(pieces), question)},
+				{Role: UserRole, Content: piece},
+			})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(summaries) == 1 {
+		return strings.TrimSpace(summaries[0]), nil
+	}
+
+	// Summaries that still don't fit a piece are summarized again, as long as that shrinks them
+	combined := strings.Join(summaries, "\n\n")
+	if next := splitTokens(model, combined, cfg.Summarize.ChunkSize); len(next) > 1 && len(next) < len(pieces) {
+		return reduceSummaries(ctx, cfg, model, question, next)
+	}
+	summary, err := Complete(ctx, cfg, model, []Message{
+		{Role: SystemRole, Content: fmt.Sprintf(combinePrompt, question)},
+		{Role: UserRole, Content: combined},
+	})
+	return strings.TrimSpace(summary), err
+}
+
+// splitTokens splits the text at line ends into pieces of at most size tokens. Longer lines are cut.
+func splitTokens(model, text string, size int) []string {
+	var pieces []string
+	var piece strings.Builder
+	count := 0
+	for line := range strings.Lines(text) {
+		n := tokens.Count(model, line)
+		for n > size { // About four characters per token
+			cut := min(len(line), size*4)
+			for cut > 0 && cut < len(line) && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			pieces = append(pieces, line[:cut])
+			line = line[cut:]
+			n = tokens.Count(model, line)
+		}
+		if count+n > size && piece.Len() > 0 {
+			pieces = append(pieces, piece.String())
+			piece.Reset()
+			count = 0
+		}
+		piece.WriteString(line)
+		count += n
+	}
+	if piece.Len() > 0 {
+		pieces = append(pieces, piece.String())
+	}
+	return pieces
+}
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+
+	"github.com/cli/go-gh/v2/pkg/auth"
 )
 
 // configPath determines the configuration directory for GitHub Copilot.
@@ -73,21 +75,25 @@ func readJSONFile(path string, v any) error {
 	return json.Unmarshal(data, v)
 }
 
-// getGitHubToken retrieves the GitHub token from environment variables or config files
-func getGitHubToken() (string, error) {
+// getGitHubToken retrieves the GitHub token of the host from environment variables, config files, or the gh CLI
+func getGitHubToken(host string) (string, error) {
+	token, _, err := lookupGitHubToken(host)
+	return token, err
+}
+
+// ErrNoGitHubToken is returned when none of the token sources has a token for the host.
+var ErrNoGitHubToken = errors.New("GitHub token not found")
+
+// lookupGitHubToken retrieves the GitHub token of the host and describes the source it was found in.
+func lookupGitHubToken(host string) (token, source string, err error) {
 	// Check environment variables first - fast path
-	if token := os.Getenv("GITHUB_TOKEN"); token != "" && os.Getenv("CODESPACES") != "" {
-		return token, nil
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && os.Getenv("CODESPACES") != "" && host == "github.com" {
+		return token, "GITHUB_TOKEN environment variable (Codespaces)", nil
 	}
 
-	configDir, err := configPath()
+	configFiles, err := tokenFiles()
 	if err != nil {
-		return "", fmt.Errorf("failed to get config path: %w", err)
-	}
-
-	configFiles := []string{
-		filepath.Join(configDir, "github-copilot", "hosts.json"),
-		filepath.Join(configDir, "github-copilot", "apps.json"),
+		return "", "", err
 	}
 
 	for _, path := range configFiles {
@@ -96,18 +102,49 @@ func getGitHubToken() (string, error) {
 			continue
 		}
 
-		if token := extractGitHubToken(config); token != "" {
-			return token, nil
+		if token := extractGitHubToken(config, host); token != "" {
+			return token, path, nil
 		}
 	}
 
-	return "", errors.New("GitHub token not found in environment or config files")
+	// Users who only ran `gh auth login` have a token of the gh CLI, possibly in the system keyring
+	if token, source := auth.TokenForHost(host); token != "" {
+		return token, ghTokenSource(source), nil
+	}
+
+	return "", "", fmt.Errorf("%w for %s in environment, config files, or the gh CLI", ErrNoGitHubToken, host)
+}
+
+// ghTokenSource describes the source of a gh CLI token as reported by go-gh.
+func ghTokenSource(source string) string {
+	switch source {
+	case "oauth_token":
+		return "gh CLI config (hosts.yml)"
+	case "gh":
+		return "gh CLI keyring (gh auth token)"
+	default:
+		return source + " environment variable"
+	}
+}
+
+// tokenFiles returns the config files of the Copilot editor plugins that are searched for a token, in order.
+func tokenFiles() ([]string, error) {
+	configDir, err := configPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config path: %w", err)
+	}
+
+	return []string{
+		filepath.Join(configDir, "github-copilot", "hosts.json"),
+		filepath.Join(configDir, "github-copilot", "apps.json"),
+	}, nil
 }
 
-// extractGitHubToken helps extract the token from config data
-func extractGitHubToken(config map[string]any) string {
-	for host, data := range config {
-		if !strings.Contains(host, "github.com") {
+// extractGitHubToken helps extract the token of the host from config data, whose keys are
+// the host optionally followed by the app ID, e.g. "github.com:Iv1.b507a08c87ecfe98"
+func extractGitHubToken(config map[string]any, host string) string {
+	for key, data := range config {
+		if name, _, _ := strings.Cut(key, ":"); name != host {
 			continue
 		}
 
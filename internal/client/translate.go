@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+const translatePrompt = `Translate the following Markdown into the language %q.
+Keep the Markdown structure, code blocks, inline code, commands, identifiers, and URLs unchanged.
+Reply only with the translation.`
+
+// translationMessages builds the request translating the text to the language.
+func translationMessages(lang, text string) []Message {
+	return []Message{
+		{Role: SystemRole, Content: fmt.Sprintf(translatePrompt, lang)},
+		{Role: UserRole, Content: text},
+	}
+}
+
+// Translate translates the answer with the configured translation model.
+func Translate(ctx context.Context, cfg config.Config, lang, answer string) (string, error) {
+	translation, err := Complete(ctx, cfg, cfg.TranslateModel, translationMessages(lang, answer))
+	if err != nil {
+		return "", fmt.Errorf("failed to translate the answer: %w", err)
+	}
+	return translation, nil
+}
+
+// renderTranslation streams the translation of the answer below it, under a header naming the language.
+func renderTranslation(ctx context.Context, cfg config.Config, args args.Arguments, answer string) error {
+	fmt.Printf("\n=== %s ===\n\n", args.TranslateTo)
+
+	// The stream is stopped on return when rendering fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	translateArgs := args
+	translateArgs.Model, translateArgs.Prefill, translateArgs.Stop = cfg.TranslateModel, "", nil
+	translateArgs.Params.Temperature, translateArgs.Params.TopP, translateArgs.Params.MaxTokens = nil, nil, 0
+	translateArgs.Length = ""
+	payload := newPayload(translateArgs, translationMessages(args.TranslateTo, answer))
+
+	chunks := make(chan stream.Chunk)
+	go streamChunks(ctx, cfg, payload, chunks)
+
+	renderer, err := render.NewTerminalRenderer(ctx, cfg, translateArgs)
+	if err != nil {
+		return fmt.Errorf("failed to create renderer: %w", err)
+	}
+	if err := renderer.Render(chunks); err != nil {
+		return fmt.Errorf("failed to translate the answer: %w", err)
+	}
+	return nil
+}
+
+// askSideBySide receives the whole answer and its translation before rendering them next to each other.
+func askSideBySide(ctx context.Context, cfg config.Config, args args.Arguments, payload ApiPayload) (string, error) {
+	answer, err := collectAnswer(ctx, cfg, payload)
+	if err != nil {
+		return "", err
+	}
+
+	translation, err := Translate(ctx, cfg, args.TranslateTo, answer)
+	if err != nil {
+		return "", err
+	}
+	return answer, render.RenderColumns(cfg, args, answer, translation)
+}
@@ -0,0 +1,19 @@
+package client
+
+import (
+	"fmt"
+	"os"
+)
+
+// pipedInput names the piped input in the notices of truncated attachments.
+const pipedInput = "the piped input"
+
+// NoteTruncated tells on stderr that an attachment was cut to fit, and by how much, so an answer
+// based on part of it isn't taken for one based on all of it. Sizes in tokens are estimates.
+func NoteTruncated(name string, sent, total int, unit, how string) {
+	approx := ""
+	if unit == "tokens" {
+		approx = "~"
+	}
+	fmt.Fprintf(os.Stderr, "Truncated %s: sent %s%d of its %s%d %s (%s)\n", name, approx, sent, approx, total, unit, how)
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/tokens"
+)
+
+// Strategies of `context_window.strategy`.
+const (
+	StrategyDropOldest = "drop-oldest"
+	StrategySummarize  = "summarize"
+	StrategyFail       = "fail"
+)
+
+// summaryTokens is the room left for the summary of the messages that don't fit.
+const summaryTokens = 1000
+
+// summaryPrompt asks the summary model to condense the messages that don't fit the context window.
+const summaryPrompt = `Summarize the following earlier part of a conversation with an AI assistant, including any
+files it was given. Keep the facts, decisions, file names, identifiers, and open questions needed to continue it.
+Only reply with the summary, in at most 500 words.`
+
+// countMessages estimates the prompt tokens of the messages with the tokenizer of the model.
+func countMessages(model string, messages []Message) int {
+	total := 0
+	for _, message := range messages {
+		total += tokens.CountMessage(model, message.Content)
+	}
+	return total
+}
+
+// promptBudget returns the number of prompt tokens that fit in the model's context window, leaving
+// room for the answer.
+func promptBudget(cfg config.Config, payload ApiPayload) (budget, window int) {
+	window = tokens.ContextWindow(payload.Model, cfg.ContextWindow.Sizes)
+	reserve := cfg.ContextWindow.Reserve
+	if payload.MaxTokens > 0 {
+		reserve = payload.MaxTokens
+	}
+	return window - reserve, window
+}
+
+// fitContextWindow warns when the messages of the payload exceed the model's context window, and drops
+// or summarizes the oldest of them as the config's strategy asks. System messages and the last user
+// message, with the prefill after it, are always kept.
+func fitContextWindow(ctx context.Context, cfg config.Config, payload *ApiPayload) error {
+	budget, window := promptBudget(cfg, *payload)
+	total := countMessages(payload.Model, payload.Messages)
+	if total <= budget {
+		return nil
+	}
+
+	strategy := cfg.ContextWindow.Strategy
+	if strategy == StrategyFail {
+		return fmt.Errorf("the prompt has ~%d tokens, more than the %d that fit in the %d token context window of %s: "+
+			"shorten it, or set context_window.strategy to %s or %s", total, budget, window, payload.Model, StrategyDropOldest, StrategySummarize)
+	}
+	warning := fmt.Sprintf("Warning: the prompt has ~%d tokens, more than the %d that fit in the %d token context window of %s",
+		total, budget, window, payload.Model)
+
+	// The oldest messages before the last user message are dropped until the rest fits, with room for the summary
+	target := budget
+	if strategy == StrategySummarize {
+		target -= summaryTokens
+	}
+	last := len(payload.Messages) - 1
+	for last > 0 && payload.Messages[last].Role != UserRole {
+		last--
+	}
+	var kept, dropped []Message
+	for i, message := range payload.Messages {
+		if total > target && i < last && message.Role != SystemRole {
+			total -= tokens.CountMessage(payload.Model, message.Content)
+			dropped = append(dropped, message)
+			continue
+		}
+		kept = append(kept, message)
+	}
+	if total > budget {
+		return fmt.Errorf("the prompt has ~%d tokens without its earlier messages, more than the %d that fit in the context window of %s",
+			total, budget, payload.Model)
+	}
+	fmt.Fprintln(os.Stderr, warning)
+
+	if strategy == StrategySummarize {
+		summary, err := summarizeMessages(ctx, cfg, dropped)
+		if err != nil {
+			return err
+		}
+		message := Message{Role: UserRole, Content: "Summary of the earlier conversation:\n\n" + summary}
+		if total+tokens.CountMessage(payload.Model, message.Content) <= budget {
+			kept = insertAfterSystem(kept, message)
+			fmt.Fprintf(os.Stderr, "Summarized the oldest %d message(s) to fit\n", len(dropped))
+			noteDropped(payload.Model, dropped, "summarized with the earlier conversation to fit the context window")
+			payload.Messages = kept
+			return nil
+		}
+		fmt.Fprintln(os.Stderr, "The summary doesn't fit either, dropping the messages instead")
+	}
+
+	fmt.Fprintf(os.Stderr, "Dropped the oldest %d message(s) to fit\n", len(dropped))
+	noteDropped(payload.Model, dropped, "left out to fit the context window")
+	payload.Messages = kept
+	return nil
+}
+
+// noteDropped names the attachments among the messages that didn't fit, none of which was sent.
+func noteDropped(model string, dropped []Message, how string) {
+	for _, message := range dropped {
+		if message.attachment != "" {
+			NoteTruncated(message.attachment, 0, tokens.Count(model, message.Content), "tokens", how)
+		}
+	}
+}
+
+// summarizeMessages condenses the messages with the summary model of the config.
+func summarizeMessages(ctx context.Context, cfg config.Config, messages []Message) (string, error) {
+	var text strings.Builder
+	for _, message := range messages {
+		fmt.Fprintf(&text, "%s:\n%s\n\n", message.Role, message.Content)
+	}
+
+	// What doesn't fit the summary model's own window is cut off, its start is usually the least relevant
+	model := cfg.ResolveModel(cfg.ContextWindow.SummaryModel)
+	conversation := text.String()
+	budget, _ := promptBudget(cfg, ApiPayload{Model: model})
+	if excess := tokens.Count(model, conversation) - budget; excess > 0 {
+		cut := min(len(conversation), excess*4) // About four characters per token
+		if i := strings.IndexByte(conversation[cut:], '\n'); i >= 0 {
+			cut += i + 1
+		}
+		conversation = conversation[cut:]
+	}
+
+	summary, err := Complete(ctx, cfg, model, []Message{
+		{Role: SystemRole, Content: summaryPrompt},
+		{Role: UserRole, Content: conversation},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize the earlier messages: %w", err)
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// insertAfterSystem inserts the message after the leading system messages.
+func insertAfterSystem(messages []Message, message Message) []Message {
+	i := 0
+	for i < len(messages) && messages[i].Role == SystemRole {
+		i++
+	}
+	return append(messages[:i], append([]Message{message}, messages[i:]...)...)
+}
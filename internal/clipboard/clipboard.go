@@ -0,0 +1,81 @@
+package clipboard
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+)
+
+// command is a clipboard helper program that reads the text to copy from stdin.
+type command struct {
+	name string
+	args []string
+}
+
+// Write places the text on the system clipboard. Over SSH, or when no clipboard
+// helper is installed, it falls back to the OSC52 terminal escape sequence.
+func Write(text string) error {
+	if isSSH() {
+		return writeOSC52(text)
+	}
+
+	for _, cmd := range clipboardCommands() {
+		path, err := exec.LookPath(cmd.name)
+		if err != nil {
+			continue
+		}
+
+		c := exec.Command(path, cmd.args...)
+		c.Stdin = strings.NewReader(text)
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("%s failed: %w", cmd.name, err)
+		}
+		return nil
+	}
+
+	return writeOSC52(text)
+}
+
+// clipboardCommands returns the clipboard helpers to try for the current platform, in order of preference.
+func clipboardCommands() []command {
+	switch runtime.GOOS {
+	case "darwin":
+		return []command{{name: "pbcopy"}}
+	case "windows":
+		return []command{{name: "clip.exe"}}
+	}
+
+	commands := make([]command, 0, 3)
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append(commands, command{name: "wl-copy"})
+	}
+	return append(commands,
+		command{name: "xclip", args: []string{"-selection", "clipboard"}},
+		command{name: "xsel", args: []string{"--clipboard", "--input"}},
+	)
+}
+
+// isSSH checks if the process is running in an SSH session.
+func isSSH() bool {
+	return os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != ""
+}
+
+// writeOSC52 asks the terminal to set the clipboard, wrapping the sequence for tmux and screen.
+func writeOSC52(text string) error {
+	seq := osc52.New(text)
+	switch {
+	case os.Getenv("TMUX") != "":
+		seq = seq.Tmux()
+	case os.Getenv("STY") != "":
+		seq = seq.Screen()
+	}
+
+	if _, err := seq.WriteTo(os.Stderr); err != nil {
+		return fmt.Errorf("failed to write OSC52 sequence: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package codeblock
+
+import (
+	"cmp"
+	"strings"
+)
+
+// Block represents a fenced code block found in markdown content.
+type Block struct {
+	Lang  string // The language tag of the fence, e.g. "go"
+	Fence string // The opening fence, e.g. ``` or ~~~~, which the closing one matches
+	Info  string // The full info string following the opening fence
+	Code  string // The code between the fences, without a trailing newline
+	Start int    // Byte offset of the opening fence in the source
+	End   int    // Byte offset just past the closing fence line in the source
+}
+
+// Parse finds all fenced code blocks (``` or ~~~) in the markdown content.
+// An unterminated block at the end of the content is included.
+func Parse(content string) []Block {
+	var (
+		blocks   []Block
+		current  *Block
+		fence    string
+		code     strings.Builder
+		position int
+	)
+
+	for line := range strings.SplitAfterSeq(content, "\n") {
+		lineEnd := position + len(line)
+		trimmed := strings.TrimSpace(line)
+
+		if current == nil {
+			if marker := fenceMarker(trimmed); marker != "" {
+				info := strings.TrimSpace(trimmed[len(marker):])
+				current = &Block{Lang: langFromInfo(info), Info: info, Fence: marker, Start: position}
+				fence = marker
+				code.Reset()
+			}
+		} else if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			current.Code = strings.TrimSuffix(code.String(), "\n")
+			current.End = lineEnd
+			blocks = append(blocks, *current)
+			current = nil
+		} else {
+			code.WriteString(line)
+		}
+
+		position = lineEnd
+	}
+
+	if current != nil {
+		current.Code = strings.TrimSuffix(code.String(), "\n")
+		current.End = len(content)
+		blocks = append(blocks, *current)
+	}
+
+	return blocks
+}
+
+// Replace rewrites every fenced code block in the content with the result of fn.
+func Replace(content string, fn func(Block) string) string {
+	blocks := Parse(content)
+	if len(blocks) == 0 {
+		return content
+	}
+
+	var result strings.Builder
+	last := 0
+	for _, block := range blocks {
+		result.WriteString(content[last:block.Start])
+		result.WriteString(fn(block))
+		last = block.End
+	}
+	result.WriteString(content[last:])
+	return result.String()
+}
+
+// Format renders a block back into fenced markdown with the given code, keeping its fence, so
+// blocks fenced with ~~~ or more backticks, e.g. to contain ``` themselves, stay intact.
+func Format(block Block, code string) string {
+	fence := cmp.Or(block.Fence, "```")
+	return fence + block.Info + "\n" + strings.TrimSuffix(code, "\n") + "\n" + fence + "\n"
+}
+
+// fenceMarker returns the opening fence (``` or ~~~ of any length) at the start of the line.
+func fenceMarker(line string) string {
+	for _, char := range []string{"`", "~"} {
+		if !strings.HasPrefix(line, char+char+char) {
+			continue
+		}
+		marker := line[:len(line)-len(strings.TrimLeft(line, char))]
+		// Backtick fences can't contain backticks in the info string
+		if char == "`" && strings.Contains(line[len(marker):], "`") {
+			return ""
+		}
+		return marker
+	}
+	return ""
+}
+
+// langFromInfo extracts the language tag from a fence info string.
+func langFromInfo(info string) string {
+	lang, _, _ := strings.Cut(info, " ")
+	return strings.ToLower(strings.TrimSpace(lang))
+}
@@ -0,0 +1,167 @@
+// Package commit proposes better messages for existing commits and applies them with git.
+package commit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/render"
+	"golang.org/x/term"
+)
+
+// maxDiff is the size of the diffs sent to the model, the rest is cut off.
+const maxDiff = 60000
+
+// recentCommits is the number of subjects of recent commits sent to show the repository's conventions.
+const recentCommits = 10
+
+// systemPrompt asks for a commit message that describes the change.
+const systemPrompt = `You write git commit messages. You are given a commit's message and diff, changes staged to
+be folded into it if any, and the subjects of recent commits of the repository. Write an improved message for the
+resulting commit: a subject of at most 72 characters in the imperative mood that follows the conventions of the
+recent subjects, a blank line, and a body wrapped at 72 characters that explains what changed and why, when the
+subject alone doesn't. Keep the facts of the original message that the diff can't show, like issue references.
+Reply only with the message, without a code block.`
+
+// Fixup proposes an improved message for the commit, taking staged changes into account, and applies
+// it after confirmation: the last commit is amended, older commits get an amend! commit that
+// `git rebase -i --autosquash` folds into them. With --scope, only the changes and commits of the
+// scope are read.
+func Fixup(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	sha, err := git(ctx, "", "rev-parse", "--verify", "--quiet", args.Commit.Fixup+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("%s is not a commit", args.Commit.Fixup)
+	}
+	head, err := git(ctx, "", "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	original, err := git(ctx, "", "log", "-1", "--format=%B", sha)
+	if err != nil {
+		return err
+	}
+	diff, err := git(ctx, "", append([]string{"show", "--format=", "--patch", sha}, args.Scope.Pathspec()...)...)
+	if err != nil {
+		return err
+	}
+	staged, err := git(ctx, "", append([]string{"diff", "--cached"}, args.Scope.Pathspec()...)...)
+	if err != nil {
+		return err
+	}
+	recent, err := git(ctx, "", append([]string{"log", fmt.Sprintf("-%d", recentCommits), "--format=%s"}, args.Scope.Pathspec()...)...)
+	if err != nil {
+		return err
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Message:\n\n````\n%s\n````\n\nDiff:\n\n````diff\n%s\n````\n\n", original, truncate(diff))
+	if staged != "" {
+		fmt.Fprintf(&prompt, "Staged changes to fold into the commit:\n\n````diff\n%s\n````\n\n", truncate(staged))
+	}
+	fmt.Fprintf(&prompt, "Recent subjects:\n\n%s\n", recent)
+	if instructions := strings.TrimSpace(strings.Join(append(args.ActionArgs, args.Prompts...), "\n\n")); instructions != "" {
+		fmt.Fprintf(&prompt, "\n%s\n", instructions)
+	}
+
+	answer, err := client.Complete(ctx, cfg, args.Model, []client.Message{
+		{Role: client.SystemRole, Content: systemPrompt},
+		{Role: client.UserRole, Content: prompt.String()},
+	})
+	if err != nil {
+		return err
+	}
+	message := answer
+	if blocks := codeblock.Parse(answer); len(blocks) > 0 {
+		message = blocks[0].Code // Some models fence it anyway
+	}
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return errors.New("the model proposed an empty message")
+	}
+
+	if err := render.RenderMarkdown(ctx, cfg, args, "````text\n"+message+"\n````\n"); err != nil {
+		return err
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+
+	if sha == head {
+		if !confirm(fmt.Sprintf("Amend %.7s with this message%s? [y/N]: ", sha, stagedNote(staged))) {
+			return nil
+		}
+		if _, err := git(ctx, message+"\n", "commit", "--amend", "--quiet", "--file=-"); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Amended %.7s\n", sha)
+		return nil
+	}
+
+	if !confirm(fmt.Sprintf("Create an amend! commit for %.7s with this message%s? [y/N]: ", sha, stagedNote(staged))) {
+		return nil
+	}
+	subject, _, _ := strings.Cut(original, "\n")
+	if _, err := git(ctx, "amend! "+subject+"\n\n"+message+"\n", "commit", "--allow-empty", "--quiet", "--file=-"); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Committed, run `git rebase -i --autosquash %.7s~` to fold it into %.7s\n", sha, sha)
+	return nil
+}
+
+// git runs a git command with the input on stdin and returns its output without the trailing newline.
+func git(ctx context.Context, input string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = strings.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// truncate cuts the diff off at maxDiff bytes, at a line end.
+func truncate(diff string) string {
+	if len(diff) <= maxDiff {
+		return diff
+	}
+	cut := strings.LastIndexByte(diff[:maxDiff], '\n')
+	if cut < 0 {
+		cut = maxDiff
+	}
+	return diff[:cut] + "\n... (diff truncated)"
+}
+
+// stagedNote mentions the staged changes in the confirmation, as they are committed too.
+func stagedNote(staged string) string {
+	if staged == "" {
+		return ""
+	}
+	return " and the staged changes"
+}
+
+// confirm asks the question on stderr and reports whether it was answered with yes.
+func confirm(question string) bool {
+	fmt.Fprint(os.Stderr, question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
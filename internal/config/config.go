@@ -3,8 +3,14 @@ package config
 import (
 	"context"
 	"fmt"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/creasty/defaults"
@@ -15,6 +21,9 @@ const (
 	configLoadTimeout = 10 * time.Second
 	configDirName     = "gh-copilot"
 	defaultConfig     = ".config"
+	defaultState      = ".local/state"
+	defaultCache      = ".cache"
+	projectConfigFile = ".gh-copilot.yaml" // overlays the user's config, usually from the repository root
 )
 
 var configFiles = []string{
@@ -26,17 +35,98 @@ var configFiles = []string{
 type Config struct {
 	ContextTimeout time.Duration `yaml:"context_timeout,omitempty" default:"10m"`
 	Model          string        `yaml:"model" default:"claude-3.7-sonnet"`
+	Aliases        Aliases       `yaml:"aliases,omitempty"`                 // short names for models, usable wherever a model is given
+	Autosave       bool          `yaml:"autosave,omitempty" default:"true"` // persist streamed answers for `recover`
+	PostProcess    []string      `yaml:"post_process,omitempty"`            // processors applied to the final answer
+	Formatters     Formatters    `yaml:"formatters,omitempty"`              // code formatter commands by language
 
+	TrackAcceptance bool `yaml:"track_acceptance,omitempty" default:"true"` // log copies/feedback locally for `stats quality`
+
+	TranslateModel string `yaml:"translate_model,omitempty" default:"gpt-4o-mini"` // model of the --translate-to requests
+
+	Length string `yaml:"length,omitempty" default:"normal"` // answer length preset: short, normal, or detailed
+
+	Stop    []string `yaml:"stop,omitempty"`    // sequences where the model stops generating
+	Prefill string   `yaml:"prefill,omitempty"` // start of the assistant's answer, which the model continues
+
+	AuthHost  string          `yaml:"auth_host,omitempty" default:"github.com"` // GitHub host whose Copilot sign-in is used
+	Endpoints ConfigEndpoints `yaml:"endpoints"`
+
+	Cache   ConfigCache  `yaml:"cache"`
 	Http    ConfigHttp   `yaml:"http"`
 	Render  ConfigRender `yaml:"render"`
+	Rag     ConfigRag    `yaml:"rag"`
+	Edit    ConfigEdit   `yaml:"edit"` // retries of `edit`
+	Serve   ConfigServe  `yaml:"serve"`
 	Prompts Prompts      `yaml:"prompts"`
+
+	Latency ConfigLatency `yaml:"latency"` // thresholds of the slow answer warnings
+
+	ContextWindow ConfigContextWindow `yaml:"context_window"` // what to do with prompts that don't fit the model
+
+	Summarize ConfigSummarize `yaml:"summarize"` // map-reduce summary of long piped input
+
+	Agent ConfigAgent `yaml:"agent"` // bounds and tools of `agent`
+
+	Exec ConfigExec `yaml:"exec"` // sandbox of the commands run by `shell suggest --run` and `agent`
+
+	Profiles Profiles `yaml:"profiles,omitempty"` // named sets of settings applied over the others, e.g. work
+	Profile  string   `yaml:"-"`                  // the profile applied, if any
+}
+
+// Profiles maps a profile name to the settings it overrides, which take the form of the config file.
+type Profiles map[string]yaml.Node
+
+// ConfigEndpoints defines the APIs requests are sent to, e.g. for GitHub Enterprise.
+type ConfigEndpoints struct {
+	API       string `yaml:"api,omitempty" default:"https://api.githubcopilot.com"` // Copilot API
+	GitHubAPI string `yaml:"github_api,omitempty" default:"https://api.github.com"` // GitHub API, which issues the Copilot token
 }
 
 type Prompts map[string]ConfigPrompt
 
+// Aliases maps a short name to the model it stands for, e.g. fast: gpt-4o-mini.
+type Aliases map[string]string
+
+// ModelMap maps the models callers of `serve` request, by name or glob pattern, to Copilot models,
+// e.g. gpt-3.5-turbo: gpt-4o-mini for tools that only know OpenAI's models.
+type ModelMap map[string]string
+
+// Map returns the model the requested one is mapped to, by its exact name or else by the first
+// matching pattern in lexical order, or the requested model itself if it isn't mapped.
+func (m ModelMap) Map(model string) string {
+	if mapped, ok := m[model]; ok {
+		return mapped
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(m)) {
+		if ok, _ := path.Match(pattern, model); ok {
+			return m[pattern]
+		}
+	}
+	return model
+}
+
+// Formatters maps a code language to a formatter command that reads stdin and writes stdout.
+// An empty command disables formatting for that language.
+type Formatters map[string]string
+
+// ConfigEdit defines how often `edit` asks for a change that applies.
+type ConfigEdit struct {
+	Attempts int `yaml:"attempts,omitempty" default:"3"` // answers asked for until one applies
+}
+
 type ConfigPrompt struct {
-	Model  string `yaml:"model,omitempty"`
-	Prompt string `yaml:"prompt"`
+	Model       string   `yaml:"model,omitempty"`
+	Prompt      string   `yaml:"prompt"`
+	Args        []string `yaml:"args,omitempty"`         // named parameters substituted into {name} placeholders
+	PostProcess []string `yaml:"post_process,omitempty"` // overrides the global post_process
+	Stop        []string `yaml:"stop,omitempty"`         // overrides the global stop sequences
+	Prefill     string   `yaml:"prefill,omitempty"`      // overrides the global prefill
+	System      string   `yaml:"system,omitempty"`       // system message sent before the prompt
+	Temperature *float64 `yaml:"temperature,omitempty"`
+	TopP        *float64 `yaml:"top_p,omitempty"`
+	MaxTokens   int      `yaml:"max_tokens,omitempty"`
+	Format      string   `yaml:"format,omitempty"` // overrides render.format: "markdown" or "plain"
 }
 
 type ConfigHttp struct {
@@ -48,14 +138,105 @@ type ConfigHttp struct {
 	DisableCompression   bool          `yaml:"disable_compression,omitempty" default:"false"`
 	DisableKeepAlives    bool          `yaml:"disable_keep_alives,omitempty" default:"false"`
 	ForceAttemptHTTP2    bool          `yaml:"force_attempt_http2,omitempty" default:"true"`
+	BreakerThreshold     int           `yaml:"breaker_threshold,omitempty" default:"5"`  // consecutive failures after which requests fail fast, 0 to disable
+	BreakerCooldown      time.Duration `yaml:"breaker_cooldown,omitempty" default:"30s"` // time before a request probes whether the API is back
+	RateLimitWait        time.Duration `yaml:"rate_limit_wait,omitempty" default:"1m"`   // longest pause for an exhausted rate limit to reset, instead of failing
+	PriorityHeader       string        `yaml:"priority_header,omitempty"`                // header sent with the priority of each request, for proxies that schedule by it
 }
 
 // ConfigRender defines how the output should be formatted and displayed.
 type ConfigRender struct {
-	Format    string `yaml:"format,omitempty" default:"markdown"` // "markdown" or "plain"
-	Theme     string `yaml:"theme,omitempty" default:"auto"`      // glamour theme name, "auto" for auto-detect
-	WrapLines bool   `yaml:"wrap_lines,omitempty" default:"true"`
-	WrapWidth int    `yaml:"wrap_width,omitempty" default:"120"`
+	Format     string `yaml:"format,omitempty" default:"markdown"`   // "markdown" or "plain"
+	Theme      string `yaml:"theme,omitempty" default:"auto"`        // glamour theme name or JSON style file, "auto" to detect the background
+	LightTheme string `yaml:"light_theme,omitempty" default:"light"` // theme used by "auto" on light backgrounds
+	DarkTheme  string `yaml:"dark_theme,omitempty" default:"dark"`   // theme used by "auto" on dark backgrounds
+	WrapLines  bool   `yaml:"wrap_lines,omitempty" default:"true"`
+	WrapWidth  int    `yaml:"wrap_width,omitempty"` // columns to wrap at, 0 for the terminal width
+	Live       bool   `yaml:"live,omitempty"`       // repaint the block that is streaming in, instead of waiting for it to complete
+	EditorURI  string `yaml:"editor_uri,omitempty"` // editor preset or link template for file references, e.g. "vscode://file/{path}:{line}"
+
+	SanitizeLinks string `yaml:"sanitize_links,omitempty" default:"off"` // "off", "clean" to drop tracking from links and link remote images, or "strip" to only keep their text
+
+	Sources    bool   `yaml:"sources,omitempty" default:"true"`    // list the cited files and linked URLs after answers about attached files
+	Hyperlinks string `yaml:"hyperlinks,omitempty" default:"auto"` // "auto" to detect whether links can be clickable, "always", or "never"
+	Spinner    bool   `yaml:"spinner,omitempty" default:"true"`    // show a spinner and the time waited until the answer starts streaming
+}
+
+// ConfigRag defines how relevant context is retrieved with embeddings.
+type ConfigRag struct {
+	EmbeddingModel string  `yaml:"embedding_model,omitempty" default:"copilot-text-embedding-ada-002"`
+	Queries        int     `yaml:"queries,omitempty" default:"3"`         // query reformulations generated per question
+	QuestionWeight float32 `yaml:"question_weight,omitempty" default:"2"` // fusion weight of the original question
+	TopK           int     `yaml:"top_k,omitempty" default:"5"`           // files retrieved for a question
+	MinScore       float32 `yaml:"min_score,omitempty"`                   // minimum similarity of a retrieved chunk to the question
+	MMRLambda      float32 `yaml:"mmr_lambda,omitempty" default:"1"`      // relevance against diversity of retrieved chunks, 1 for relevance alone
+
+	LocalEndpoint string `yaml:"local_endpoint,omitempty"` // OpenAI-compatible API of a local embedding model, used when Copilot's is unreachable
+	LocalModel    string `yaml:"local_model,omitempty"`    // model of the local endpoint, e.g. nomic-embed-text
+
+	BatchSize   int `yaml:"batch_size,omitempty" default:"64"` // inputs per embeddings request
+	Concurrency int `yaml:"concurrency,omitempty" default:"4"` // embeddings requests sent at once
+	Retries     int `yaml:"retries,omitempty" default:"2"`     // retries of a batch that failed for a transient reason
+}
+
+// ConfigCache defines the cache of answers to identical requests, for scripted invocations.
+type ConfigCache struct {
+	Enabled bool          `yaml:"enabled,omitempty"`           // answer identical requests from the cache
+	TTL     time.Duration `yaml:"ttl,omitempty" default:"24h"` // age after which cached answers are requested again, 0 to keep them
+}
+
+// ConfigServe defines how `serve` exposes the API to local tools.
+type ConfigServe struct {
+	Addr          string        `yaml:"addr,omitempty" default:"127.0.0.1:8686"` // host:port, or unix:path for a socket only the user can access
+	MaxConcurrent int           `yaml:"max_concurrent,omitempty" default:"4"`    // concurrent upstream requests
+	QueueSize     int           `yaml:"queue_size,omitempty" default:"32"`       // requests waiting for a slot before callers get a 429
+	RetryAfter    time.Duration `yaml:"retry_after,omitempty" default:"5s"`      // Retry-After sent with a 429
+	AllowedModels []string      `yaml:"allowed_models,omitempty"`                // models callers may request (glob patterns allowed), all if empty
+	ModelMap      ModelMap      `yaml:"model_map,omitempty"`                     // models requested by callers mapped to Copilot models
+}
+
+// ConfigContextWindow defines how prompts that exceed the model's context window are handled.
+type ConfigContextWindow struct {
+	Strategy     string         `yaml:"strategy,omitempty" default:"drop-oldest"`      // "drop-oldest" messages, "summarize" them, or "fail"
+	Reserve      int            `yaml:"reserve,omitempty" default:"4096"`              // tokens kept free for the answer, unless max_tokens is set
+	SummaryModel string         `yaml:"summary_model,omitempty" default:"gpt-4o-mini"` // model that summarizes the older messages
+	Sizes        map[string]int `yaml:"sizes,omitempty"`                               // context window in tokens by model, overriding the built-in ones
+}
+
+// ConfigSummarize defines when and how long piped input is summarized before the question is asked.
+type ConfigSummarize struct {
+	Threshold int    `yaml:"threshold,omitempty" default:"20000"` // tokens of piped input above which it is summarized, 0 to never
+	ChunkSize int    `yaml:"chunk_size,omitempty" default:"8000"` // tokens of the pieces summarized separately
+	Model     string `yaml:"model,omitempty" default:"gpt-4o-mini"`
+}
+
+// ConfigAgent defines how far `agent` goes on its own.
+type ConfigAgent struct {
+	MaxSteps    int    `yaml:"max_steps,omitempty" default:"10"` // tool calls before the agent gives up
+	TestCommand string `yaml:"test_command,omitempty"`           // command of the run_tests tool, detected from the project if empty
+}
+
+// ConfigExec defines what the commands run by `shell suggest --run` and `agent` may do. Unset
+// lists take the defaults of the sandbox, an empty list is empty.
+type ConfigExec struct {
+	Allow   []string      `yaml:"allow,omitempty"`                // programs that may run (glob patterns allowed), all that aren't denied if unset
+	Deny    []string      `yaml:"deny,omitempty"`                 // programs that may never run, privileged and destructive ones if unset
+	Env     []string      `yaml:"env,omitempty"`                  // environment variables passed on (glob patterns allowed), ones without credentials if unset
+	Timeout time.Duration `yaml:"timeout,omitempty" default:"5m"` // time until a command is killed
+}
+
+// ConfigLatency defines when a warning names the stage that made an answer slow, 0 to not warn.
+type ConfigLatency struct {
+	FirstToken time.Duration `yaml:"first_token,omitempty" default:"15s"` // time until the answer starts streaming
+	Total      time.Duration `yaml:"total,omitempty" default:"2m"`        // time until the answer is complete
+}
+
+// ResolveModel returns the model an alias stands for, or the name itself if it isn't an alias.
+func (c Config) ResolveModel(name string) string {
+	if model, ok := c.Aliases[name]; ok {
+		return model
+	}
+	return name
 }
 
 // configResult is a struct used to return the configuration and any error that occurs during loading.
@@ -68,14 +249,28 @@ var defaultPrompts = Prompts{
 	"ask": {Prompt: "Answer the following question."},
 }
 
-// newDefaultConfig creates a new default configuration with an empty prompts map.
-func newDefaultConfig() *Config {
-	return &Config{
-		Prompts: defaultPrompts,
+// newDefaultConfig creates a new configuration with the default prompts and values.
+func newDefaultConfig() (*Config, error) {
+	cfg := &Config{
+		Prompts: maps.Clone(defaultPrompts),
 	}
+	if err := defaults.Set(cfg); err != nil {
+		return nil, fmt.Errorf("setting defaults: %w", err)
+	}
+	return cfg, nil
+}
+
+// Default returns the configuration of the default values, without reading any config file.
+func Default() (Config, error) {
+	cfg, err := newDefaultConfig()
+	if err != nil {
+		return Config{}, err
+	}
+	return *cfg, nil
 }
 
-// getConfigPath retrieves the path to the configuration directory based on the XDG_CONFIG_HOME environment variable.
+// getConfigPath retrieves the path to the configuration directory based on the XDG_CONFIG_HOME environment
+// variable, or %APPDATA% on Windows.
 func getConfigPath() (string, error) {
 	configHome := os.Getenv("XDG_CONFIG_HOME")
 	if configHome == "" {
@@ -84,11 +279,55 @@ func getConfigPath() (string, error) {
 			return "", fmt.Errorf("failed to get user home directory: %w", err)
 		}
 		configHome = filepath.Join(home, defaultConfig)
+
+		// Windows keeps settings in %APPDATA%, unless ~/.config/gh-copilot was set up already
+		if appData := os.Getenv("APPDATA"); runtime.GOOS == "windows" && appData != "" {
+			if _, err := os.Stat(filepath.Join(configHome, configDirName)); err != nil {
+				configHome = appData
+			}
+		}
 	}
 
 	return filepath.Join(configHome, configDirName), nil
 }
 
+// StatePath retrieves the path to the application state directory based on the XDG_STATE_HOME environment variable.
+func StatePath() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		stateHome = filepath.Join(home, defaultState)
+	}
+
+	return filepath.Join(stateHome, configDirName), nil
+}
+
+// CachePath retrieves the path to the application cache directory based on the XDG_CACHE_HOME environment variable.
+func CachePath() (string, error) {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		cacheHome = filepath.Join(home, defaultCache)
+	}
+
+	return filepath.Join(cacheHome, configDirName), nil
+}
+
+// RuntimePath retrieves the path to the application runtime directory, for sockets, based on the
+// XDG_RUNTIME_DIR environment variable. Without it, a per-user directory in the temp directory is used.
+func RuntimePath() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, configDirName)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d", configDirName, os.Getuid()))
+}
+
 // tryLoadConfig attempts to load a configuration file from the specified path.
 func tryLoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -96,26 +335,27 @@ func tryLoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
-	cfg := newDefaultConfig()
-	if err := defaults.Set(cfg); err != nil {
-		return nil, fmt.Errorf("setting defaults: %w", err)
+	cfg, err := newDefaultConfig()
+	if err != nil {
+		return nil, err
 	}
 	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+		return nil, fmt.Errorf("failed to parse config file (run `gh copilot config validate` for details): %w", err)
 	}
 
 	return cfg, nil
 }
 
-// LoadConfig loads the configuration from the user's home directory, with a timeout.
-func LoadConfig(ctx context.Context) (Config, error) {
+// LoadConfig loads the configuration from the user's home directory, with a timeout, applying the
+// named profile unless it is empty.
+func LoadConfig(ctx context.Context, profile string) (Config, error) {
 	ctx, cancel := context.WithTimeout(ctx, configLoadTimeout)
 	defer cancel()
 
 	result := make(chan configResult, 1)
 
 	go func() {
-		cfg, err := loadConfigFiles(ctx)
+		cfg, err := loadConfigFiles(ctx, profile)
 		result <- configResult{config: cfg, err: err}
 	}()
 
@@ -131,8 +371,56 @@ func LoadConfig(ctx context.Context) (Config, error) {
 	}
 }
 
-// loadConfigFiles loads configuration files from the user's home directory.
-func loadConfigFiles(ctx context.Context) (*Config, error) {
+// loadConfigFiles loads the user's configuration file and prompt files, overlaid with the project's configuration
+// file and the profile.
+func loadConfigFiles(ctx context.Context, profile string) (*Config, error) {
+	cfg, err := loadUserConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Prompts == nil {
+		cfg.Prompts = Prompts{}
+	}
+	if err := addPromptFiles(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := overlayProjectConfig(cfg); err != nil {
+		return nil, err
+	}
+	if err := applyProfile(cfg, profile); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyProfile applies the settings of the named profile over the configuration.
+func applyProfile(cfg *Config, profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	node, ok := cfg.Profiles[profile]
+	if !ok {
+		names := slices.Sorted(maps.Keys(cfg.Profiles))
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %q: the config defines no profiles", profile)
+		}
+		return fmt.Errorf("unknown profile %q: the config defines %s", profile, strings.Join(names, ", "))
+	}
+
+	// The profile's settings are decoded over the loaded ones, like the project config
+	profiles := cfg.Profiles
+	if err := node.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to apply profile %s: %w", profile, err)
+	}
+	cfg.Profiles, cfg.Profile = profiles, profile
+	return nil
+}
+
+// loadUserConfig loads the configuration file from the user's config directory.
+func loadUserConfig(ctx context.Context) (*Config, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, fmt.Errorf("context error before loading config: %w", err)
 	}
@@ -144,7 +432,7 @@ func loadConfigFiles(ctx context.Context) (*Config, error) {
 
 	// Return default config early if directory doesn't exist
 	if _, err := os.Stat(configDir); os.IsNotExist(err) {
-		return newDefaultConfig(), nil
+		return newDefaultConfig()
 	}
 
 	for _, filename := range configFiles {
@@ -161,5 +449,58 @@ func loadConfigFiles(ctx context.Context) (*Config, error) {
 		}
 	}
 
-	return newDefaultConfig(), nil
+	return newDefaultConfig()
+}
+
+// ProjectPath finds the project configuration file by walking up from the working directory.
+// It returns an empty path when there is none.
+func ProjectPath() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	for {
+		path := filepath.Join(dir, projectConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// overlayProjectConfig applies the project configuration file on top of the configuration.
+// Keys set in the project file replace the user's values, and its prompts are added to the user's prompts.
+func overlayProjectConfig(cfg *Config) error {
+	path, err := ProjectPath()
+	if err != nil || path == "" {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read project config: %w", err)
+	}
+
+	// Formatters run arbitrary commands, the endpoints and profiles decide where the token is sent, the
+	// local embedding endpoint receives the code, and exec and the agent's test command decide what
+	// the commands the model runs may do, so a cloned repository must not be able to set them
+	user := *cfg
+	cfg.Formatters, cfg.Profiles, cfg.Exec, cfg.Agent.TestCommand = nil, nil, ConfigExec{}, ""
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse project config %s (run `gh copilot config validate %s` for details): %w", path, path, err)
+	}
+	if cfg.Formatters != nil || cfg.Profiles != nil || cfg.Endpoints != user.Endpoints || cfg.AuthHost != user.AuthHost ||
+		cfg.Rag.LocalEndpoint != user.Rag.LocalEndpoint || !reflect.DeepEqual(cfg.Exec, ConfigExec{}) || cfg.Agent.TestCommand != "" {
+		fmt.Fprintf(os.Stderr, "Ignoring formatters, endpoints, auth_host, rag.local_endpoint, exec, agent.test_command, and profiles from %s, set them in your user config instead\n", path)
+	}
+	cfg.Formatters, cfg.Profiles, cfg.Endpoints, cfg.AuthHost = user.Formatters, user.Profiles, user.Endpoints, user.AuthHost
+	cfg.Rag.LocalEndpoint, cfg.Exec, cfg.Agent.TestCommand = user.Rag.LocalEndpoint, user.Exec, user.Agent.TestCommand
+
+	return nil
 }
@@ -0,0 +1,622 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// template is the commented config file written by `config init`.
+const template = `# gh-copilot configuration
+# Run ` + "`gh copilot config validate`" + ` after editing this file.
+
+# Default model used for prompts.
+model: claude-3.7-sonnet
+
+# Short names for models, usable with --model and in prompts.
+# aliases:
+#   fast: gpt-4o-mini
+#   smart: claude-3.7-sonnet
+
+# Model translating answers for --translate-to.
+# translate_model: gpt-4o-mini
+
+# Default answer length: short, normal, or detailed (see --length).
+# length: normal
+
+# Named sets of settings applied over the others with --profile or
+# GH_COPILOT_PROFILE, e.g. for GitHub Enterprise.
+# profiles:
+#   enterprise:
+#     model: gpt-4o
+#     auth_host: github.example.com
+#     endpoints:
+#       api: https://copilot-api.github.example.com
+#       github_api: https://api.github.example.com
+
+# Maximum duration of a request, including streaming the answer.
+# context_timeout: 10m
+
+# Persist streamed answers for ` + "`gh copilot recover`" + `.
+# autosave: true
+
+# Log answers, copies, and feedback locally for ` + "`gh copilot stats quality`" + `.
+# track_acceptance: true
+
+# Processors applied to every answer: trim-fences, dos2unix, gofmt-on-go-blocks, prettier-on-json, format.
+# post_process: [dos2unix]
+
+# Formatter commands by code block language, used by the format post-processor.
+# formatters:
+#   python: "ruff format -"
+
+# Sequences where the model stops generating, and the start of its answer.
+# stop: ["` + "```" + `"]
+# prefill: ""
+
+render:
+  # "markdown" or "plain"
+  format: markdown
+  # glamour style name (dark, light, dracula, tokyo-night, ...), JSON style file,
+  # or "auto" to pick light_theme or dark_theme for the terminal background
+  theme: auto
+  # light_theme: light
+  # dark_theme: dark
+  wrap_lines: true
+  # Columns to wrap lines at, the terminal width (following resizes) if unset.
+  # wrap_width: 120
+  # Repaint the block that is streaming in, instead of waiting for it to complete.
+  # live: false
+  # Editor preset (vscode, cursor, zed, idea, sublime, ...) or link template for file references.
+  # editor_uri: "vscode://file/{path}:{line}"
+  # "clean" removes tracking parameters and redirects from links and turns remote
+  # images into links, "strip" only keeps the text of links and images.
+  # sanitize_links: off
+  # List the cited files and linked URLs after answers about attached files.
+  # sources: true
+  # Make the links of answers clickable (OSC 8) instead of printing their targets:
+  # "auto" for terminals known to support it, "always", or "never", which also
+  # leaves the citations of attached files unlinked.
+  # hyperlinks: auto
+  # Show a spinner and the time waited until the answer starts streaming.
+  # spinner: true
+
+# Answer identical requests (model, messages, and parameters) from a local cache.
+# cache:
+#   enabled: false
+#   ttl: 24h
+
+# rag:
+#   embedding_model: copilot-text-embedding-ada-002
+#   queries: 3
+#   question_weight: 2
+#   # Files retrieved for a question, the minimum similarity of their chunks, and
+#   # how much relevance counts against diversity (1 for relevance alone).
+#   top_k: 5
+#   min_score: 0.3
+#   mmr_lambda: 0.7
+#   # OpenAI-compatible local embedding model (e.g. Ollama), used by the index when
+#   # Copilot is unreachable. embedding_model: local:<model> always uses it.
+#   local_endpoint: http://localhost:11434/v1
+#   local_model: nomic-embed-text
+#   # Indexing embeds files in batches, several at once, retrying failed batches.
+#   batch_size: 64
+#   concurrency: 4
+#   retries: 2
+
+# Answers ` + "`gh copilot edit`" + ` asks for until a diff applies, showing the model the
+# lines of the file its previous diff got wrong.
+# edit:
+#   attempts: 3
+
+# http:
+#   http_client_timeout: 60s
+#   # Fail fast after this many consecutive failures, probing again after the cooldown.
+#   breaker_threshold: 5
+#   breaker_cooldown: 30s
+#   # Pause up to this long for an exhausted rate limit to reset, instead of failing.
+#   rate_limit_wait: 1m
+#   # Send the priority of each request (high, normal, or low) in this header,
+#   # for a proxy in front of the API that schedules by it.
+#   priority_header: X-Request-Priority
+
+# When a prompt exceeds the model's context window, drop or summarize its oldest
+# messages (history and attached files), or fail.
+# context_window:
+#   strategy: drop-oldest  # drop-oldest, summarize, or fail
+#   reserve: 4096          # tokens kept free for the answer
+#   summary_model: gpt-4o-mini
+#   sizes:
+#     my-fine-tuned-model: 32000
+
+# Summarize piped input longer than threshold tokens piece by piece before
+# asking about it (--no-summarize sends it as it is).
+# summarize:
+#   threshold: 20000  # 0 to never summarize
+#   chunk_size: 8000
+#   model: gpt-4o-mini
+
+# Steps of ` + "`gh copilot agent`" + `, and the command it runs tests with (detected
+# from the project, e.g. go test ./... for a go.mod, if empty).
+# agent:
+#   max_steps: 10
+#   test_command: make test
+
+# Sandbox of the commands run by ` + "`gh copilot shell suggest --run`" + ` and the agent.
+# They run in the working directory and may not name paths outside of it.
+# exec:
+#   allow: [go, make, git, ls, grep]  # only these programs, all but the denied if unset
+#   deny: [sudo, rm]                  # never these, privileged and destructive ones if unset
+#   env: [PATH, HOME, "LC_*"]         # environment passed on, ones without credentials if unset
+#   timeout: 5m
+
+# Warn about slow answers, naming the stage that took the longest (0 to not warn).
+# latency:
+#   first_token: 15s
+#   total: 2m
+
+# Local OpenAI-compatible endpoint of ` + "`gh copilot serve`" + `.
+# serve:
+#   addr: 127.0.0.1:8686  # or unix:[path] for a socket only you can access
+#   max_concurrent: 4
+#   queue_size: 32
+#   retry_after: 5s
+#   # Models callers may request, by name or glob pattern (all if empty).
+#   allowed_models: ["gpt-4o*", claude-3.7-sonnet]
+#   # Models OpenAI tools ask for, by name or glob pattern, mapped to Copilot models.
+#   model_map:
+#     gpt-3.5-turbo: gpt-4o-mini
+#     "gpt-4*": gpt-4o
+
+# Predefined prompts, run with ` + "`gh copilot <name>`" + `.
+prompts:
+  explain:
+    prompt: "Please explain this concept in simple terms:"
+  # port:
+  #   prompt: "Port this code to {language}."
+  #   args: [language]
+  # review:
+  #   prompt: "Review this code:"
+  #   system: You are a senior Go reviewer. Only point out bugs.
+  #   temperature: 0.2
+  #   top_p: 0.9
+  #   max_tokens: 800
+  #   format: plain  # or markdown, overrides render.format
+`
+
+// ErrConfigExists is returned by Init when the config file already exists.
+var ErrConfigExists = errors.New("config file already exists")
+
+// ErrPromptNotFound is returned by RemovePrompt when the config file doesn't have the prompt.
+var ErrPromptNotFound = errors.New("prompt not found")
+
+// Problem is a validation error found in the config file.
+type Problem struct {
+	Line    int // 1-based line of the offending value, 0 if unknown
+	Message string
+}
+
+// Error formats the problem with its line number.
+func (p Problem) Error() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+var (
+	typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+	unknownField  = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// Path returns the path of the config file in use, or where it would be created.
+func Path() (string, error) {
+	configDir, err := getConfigPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config path: %w", err)
+	}
+
+	for _, filename := range configFiles {
+		path := filepath.Join(configDir, filename)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return filepath.Join(configDir, configFiles[0]), nil
+}
+
+// Init writes a commented config file to path, unless it exists and force is false.
+func Init(path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%w: %s", ErrConfigExists, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(template), 0o644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// Get returns the effective value of a dotted key (e.g. "render.theme") as YAML.
+func Get(cfg Config, key string) (string, error) {
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return "", fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	node := lookup(&doc, splitKey(key))
+	if node == nil {
+		if !isKnownKey(reflect.TypeOf(cfg), splitKey(key)) {
+			return "", fmt.Errorf("unknown config key: %s", key)
+		}
+		return "", nil // known key with an empty value
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		return node.Value, nil
+	}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// Set sets a dotted key in the config file at path, keeping its comments and layout.
+// The value is parsed as YAML, so lists can be set with e.g. `[dos2unix, gofmt]`.
+func Set(path, key, value string) error {
+	doc, err := readDoc(path)
+	if err != nil {
+		return err
+	}
+
+	var newValue yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &newValue); err != nil || len(newValue.Content) == 0 {
+		newValue = yaml.Node{Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: value}}}
+	}
+
+	node, err := create(doc.Content[0], splitKey(key))
+	if err != nil {
+		return err
+	}
+	*node = *newValue.Content[0]
+
+	return writeDoc(path, doc, "invalid value for "+key)
+}
+
+// SetPrompts adds the prompts to the config file at path, replacing those of the same name,
+// keeping its comments and layout.
+func SetPrompts(path string, prompts Prompts) error {
+	doc, err := readDoc(path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(prompts)) {
+		node, err := create(doc.Content[0], []string{"prompts", name})
+		if err != nil {
+			return err
+		}
+		prompt := prompts[name]
+		if err := node.Encode(&prompt); err != nil {
+			return fmt.Errorf("failed to encode prompt %s: %w", name, err)
+		}
+	}
+
+	return writeDoc(path, doc, "invalid prompt")
+}
+
+// RemovePrompt removes a prompt from the config file at path, or returns ErrPromptNotFound when
+// the file doesn't have it, e.g. because it comes from a prompt file.
+func RemovePrompt(path, name string) error {
+	doc, err := readDoc(path)
+	if err != nil {
+		return err
+	}
+
+	section := lookup(doc, []string{"prompts"})
+	if section == nil || section.Kind != yaml.MappingNode {
+		return fmt.Errorf("%w: %s in %s", ErrPromptNotFound, name, path)
+	}
+	for i := 0; i+1 < len(section.Content); i += 2 {
+		if section.Content[i].Value == name {
+			section.Content = slices.Delete(section.Content, i, i+2)
+			return writeDoc(path, doc, "invalid config")
+		}
+	}
+	return fmt.Errorf("%w: %s in %s", ErrPromptNotFound, name, path)
+}
+
+// readDoc parses the config file at path into a document with a mapping, empty if the file
+// doesn't exist.
+func readDoc(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	return &doc, nil
+}
+
+// writeDoc validates the document and writes it to the config file at path. The first problem
+// is reported after the context, e.g. "invalid value for model".
+func writeDoc(path string, doc *yaml.Node, context string) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	if problems := Validate(buf.Bytes()); len(problems) > 0 {
+		return fmt.Errorf("%s: %w", context, problems[0])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// Validate checks the config file contents against the schema, returning the problems by line.
+func Validate(data []byte) []Problem {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []Problem{{Message: strings.TrimPrefix(err.Error(), "yaml: ")}}
+	}
+	if doc.Kind == 0 {
+		return nil // empty file
+	}
+
+	var problems []Problem
+	cfg, err := newDefaultConfig()
+	if err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+	if err := doc.Decode(cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return []Problem{{Message: err.Error()}}
+		}
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, parseTypeError(msg))
+		}
+	}
+	if err := decodeStrict(data); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				if strings.Contains(msg, "not found in type") {
+					problems = append(problems, parseTypeError(msg))
+				}
+			}
+		}
+	}
+
+	check := func(key string, ok bool, format string, args ...any) {
+		if ok {
+			return
+		}
+		line := 0
+		if node := lookup(&doc, splitKey(key)); node != nil {
+			line = node.Line
+		}
+		problems = append(problems, Problem{Line: line, Message: fmt.Sprintf("%s: %s", key, fmt.Sprintf(format, args...))})
+	}
+	check("context_timeout", cfg.ContextTimeout >= 0, "must not be negative")
+	check("render.format", cfg.Render.Format == "markdown" || cfg.Render.Format == "plain",
+		"must be markdown or plain, got %q", cfg.Render.Format)
+	check("render.sanitize_links", cfg.Render.SanitizeLinks == "off" ||
+		cfg.Render.SanitizeLinks == "clean" || cfg.Render.SanitizeLinks == "strip",
+		"must be off, clean, or strip, got %q", cfg.Render.SanitizeLinks)
+	check("render.hyperlinks", cfg.Render.Hyperlinks == "auto" ||
+		cfg.Render.Hyperlinks == "always" || cfg.Render.Hyperlinks == "never",
+		"must be auto, always, or never, got %q", cfg.Render.Hyperlinks)
+	check("render.wrap_width", cfg.Render.WrapWidth >= 0, "must not be negative")
+	check("length", cfg.Length == "short" || cfg.Length == "normal" || cfg.Length == "detailed",
+		"must be short, normal, or detailed, got %q", cfg.Length)
+	check("rag.queries", cfg.Rag.Queries >= 0, "must not be negative")
+	check("edit.attempts", cfg.Edit.Attempts >= 1, "must be at least 1")
+	check("rag.top_k", cfg.Rag.TopK >= 0, "must not be negative")
+	check("rag.min_score", cfg.Rag.MinScore >= -1 && cfg.Rag.MinScore <= 1, "must be between -1 and 1")
+	check("rag.mmr_lambda", cfg.Rag.MMRLambda > 0 && cfg.Rag.MMRLambda <= 1, "must be above 0 and at most 1")
+	check("rag.local_endpoint", cfg.Rag.LocalEndpoint == "" || strings.HasPrefix(cfg.Rag.LocalEndpoint, "http://") ||
+		strings.HasPrefix(cfg.Rag.LocalEndpoint, "https://"), "must be an http:// or https:// URL")
+	check("rag.batch_size", cfg.Rag.BatchSize >= 1, "must be at least 1")
+	check("rag.concurrency", cfg.Rag.Concurrency >= 1, "must be at least 1")
+	check("rag.retries", cfg.Rag.Retries >= 0, "must not be negative")
+	check("rag.local_model", cfg.Rag.LocalEndpoint == "" || cfg.Rag.LocalModel != "", "must name the model of rag.local_endpoint")
+	check("cache.ttl", cfg.Cache.TTL >= 0, "must not be negative")
+	check("http.breaker_threshold", cfg.Http.BreakerThreshold >= 0, "must not be negative")
+	check("context_window.strategy", cfg.ContextWindow.Strategy == "drop-oldest" ||
+		cfg.ContextWindow.Strategy == "summarize" || cfg.ContextWindow.Strategy == "fail",
+		"must be drop-oldest, summarize, or fail")
+	check("context_window.reserve", cfg.ContextWindow.Reserve >= 0, "must not be negative")
+	for model, size := range cfg.ContextWindow.Sizes {
+		check("context_window.sizes."+model, size > 0, "must be positive")
+	}
+	check("summarize.threshold", cfg.Summarize.Threshold >= 0, "must not be negative")
+	check("summarize.chunk_size", cfg.Summarize.ChunkSize >= 1000, "must be at least 1000")
+	check("agent.max_steps", cfg.Agent.MaxSteps >= 1, "must be at least 1")
+	check("exec.timeout", cfg.Exec.Timeout > 0, "must be positive")
+	for _, pattern := range slices.Concat(cfg.Exec.Allow, cfg.Exec.Deny, cfg.Exec.Env) {
+		_, err := path.Match(pattern, "")
+		check("exec", err == nil, "invalid pattern %q", pattern)
+	}
+	check("latency.first_token", cfg.Latency.FirstToken >= 0, "must not be negative")
+	check("latency.total", cfg.Latency.Total >= 0, "must not be negative")
+	for name, model := range cfg.Aliases {
+		check("aliases."+name, strings.TrimSpace(model) != "", "must name a model")
+	}
+	for _, pattern := range cfg.Serve.AllowedModels {
+		_, err := path.Match(pattern, "")
+		check("serve.allowed_models", err == nil, "invalid pattern %q", pattern)
+	}
+	for pattern, model := range cfg.Serve.ModelMap {
+		_, err := path.Match(pattern, "")
+		check("serve.model_map", err == nil, "invalid pattern %q", pattern)
+		check("serve.model_map."+pattern, strings.TrimSpace(model) != "", "must name a model")
+	}
+	for name, prompt := range cfg.Prompts {
+		key := "prompts." + name
+		check(key+".prompt", strings.TrimSpace(prompt.Prompt) != "", "must not be empty")
+		check(key+".temperature", prompt.Temperature == nil || *prompt.Temperature >= 0 && *prompt.Temperature <= 2, "must be between 0 and 2")
+		check(key+".top_p", prompt.TopP == nil || *prompt.TopP > 0 && *prompt.TopP <= 1, "must be above 0 and at most 1")
+		check(key+".max_tokens", prompt.MaxTokens >= 0, "must be positive")
+		check(key+".format", prompt.Format == "" || prompt.Format == "markdown" || prompt.Format == "plain", "must be markdown or plain")
+	}
+	check("auth_host", cfg.AuthHost != "" && !strings.Contains(cfg.AuthHost, "/"), "must be a host name, e.g. github.com")
+	check("endpoints.api", strings.HasPrefix(cfg.Endpoints.API, "https://"), "must be an https:// URL")
+	check("endpoints.github_api", strings.HasPrefix(cfg.Endpoints.GitHubAPI, "https://"), "must be an https:// URL")
+
+	// A profile takes the form of the config file, so it is validated like one, at the profile's line
+	for name, profile := range cfg.Profiles {
+		data, err := yaml.Marshal(&profile)
+		if err != nil {
+			check("profiles."+name, false, "%v", err)
+			continue
+		}
+		for _, problem := range Validate(data) {
+			check("profiles."+name, false, "%s", problem.Message)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+// decodeStrict decodes the config, rejecting keys that are not part of the schema.
+func decodeStrict(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(&Config{})
+}
+
+// parseTypeError splits the line number out of a yaml.TypeError message.
+func parseTypeError(msg string) Problem {
+	match := typeErrorLine.FindStringSubmatch(msg)
+	if match == nil {
+		return Problem{Message: msg}
+	}
+	line, _ := strconv.Atoi(match[1])
+	msg = match[2]
+	if field := unknownField.FindStringSubmatch(msg); field != nil {
+		msg = "unknown key: " + field[1]
+	}
+	return Problem{Line: line, Message: msg}
+}
+
+// splitKey splits a dotted config key into its path.
+func splitKey(key string) []string {
+	return strings.Split(key, ".")
+}
+
+// lookup finds the value node at path in a YAML document, or nil if it doesn't exist.
+func lookup(node *yaml.Node, path []string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+
+	for _, name := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == name {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// create finds the value node at path in a mapping, adding missing keys along the way.
+func create(node *yaml.Node, path []string) (*yaml.Node, error) {
+	for i, name := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s is not a mapping", strings.Join(path[:i], "."))
+		}
+
+		var next *yaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == name {
+				next = node.Content[j+1]
+				break
+			}
+		}
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, next)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// isKnownKey checks if a dotted key path names a field of the config schema.
+func isKnownKey(t reflect.Type, path []string) bool {
+	for _, name := range path {
+		if t == reflect.TypeOf(yaml.Node{}) {
+			t = reflect.TypeOf(Config{}) // A profile, which takes the form of the config
+		}
+		switch t.Kind() {
+		case reflect.Map:
+			t = t.Elem()
+			continue
+		case reflect.Struct:
+		default:
+			return false
+		}
+
+		found := false
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if tag == name {
+				t = field.Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
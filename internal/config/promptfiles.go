@@ -0,0 +1,163 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// promptsDirName is the directory of prompt files next to the config file, e.g. from installed prompt packs.
+const promptsDirName = "prompts.d"
+
+// PromptsPath retrieves the path of the prompt files directory.
+func PromptsPath() (string, error) {
+	configDir, err := getConfigPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config path: %w", err)
+	}
+	return filepath.Join(configDir, promptsDirName), nil
+}
+
+// IsPromptFile reports whether the file name is that of a prompt file: YAML with named prompts, like the
+// `prompts` section of the config, or markdown whose content is the prompt named after the file.
+func IsPromptFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".md":
+		return true
+	}
+	return false
+}
+
+// addPromptFiles adds the prompts of the files in the prompts directory and its subdirectories. Prompts
+// of the config file take precedence, and between files the first in path order wins.
+func addPromptFiles(cfg *Config) error {
+	dir, err := PromptsPath()
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case os.IsNotExist(err) && path == dir:
+			return filepath.SkipDir
+		case err != nil:
+			return err
+		case entry.IsDir() && path != dir && strings.HasPrefix(entry.Name(), "."):
+			return filepath.SkipDir // E.g. a pack being installed
+		case !entry.IsDir() && IsPromptFile(entry.Name()):
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read prompt files: %w", err)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		prompts, err := loadPromptFile(path)
+		if err != nil {
+			// A broken pack must not keep every other command from running
+			fmt.Fprintf(os.Stderr, "Ignoring prompt file: %v\n", err)
+			continue
+		}
+		for name, prompt := range prompts {
+			if _, ok := cfg.Prompts[name]; !ok {
+				cfg.Prompts[name] = prompt
+			}
+		}
+	}
+	return nil
+}
+
+// loadPromptFile reads the prompts of a prompt file.
+func loadPromptFile(path string) (Prompts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".md") {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		return Prompts{name: {Prompt: strings.TrimSpace(string(data))}}, nil
+	}
+
+	var prompts Prompts
+	if err := yaml.Unmarshal(data, &prompts); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt file %s: %w", path, err)
+	}
+	return prompts, nil
+}
+
+// PromptFile is a one-off prompt, read from a markdown file whose YAML frontmatter configures the
+// request and whose body is the prompt template, see --prompt-file.
+type PromptFile struct {
+	Model       string   `yaml:"model,omitempty"`
+	System      string   `yaml:"system,omitempty"` // system message sent before the prompt
+	Temperature *float64 `yaml:"temperature,omitempty"`
+	TopP        *float64 `yaml:"top_p,omitempty"`
+	MaxTokens   int      `yaml:"max_tokens,omitempty"`
+	Stop        []string `yaml:"stop,omitempty"`
+	Prefill     string   `yaml:"prefill,omitempty"`
+	PostProcess []string `yaml:"post_process,omitempty"`
+	Prompt      string   `yaml:"-"` // the body after the frontmatter
+}
+
+// LoadPromptFile reads a prompt file. The frontmatter is optional, a file without it is just the prompt.
+func LoadPromptFile(path string) (PromptFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PromptFile{}, fmt.Errorf("failed to read prompt file: %w", err)
+	}
+
+	var file PromptFile
+	frontmatter, body, ok := cutFrontmatter(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	if ok {
+		decoder := yaml.NewDecoder(strings.NewReader(frontmatter))
+		decoder.KnownFields(true) // A misspelled key would otherwise be silently ignored
+		if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return PromptFile{}, fmt.Errorf("failed to parse the frontmatter of %s: %w", path, err)
+		}
+	}
+
+	file.Prompt = strings.TrimSpace(body)
+	if file.Prompt == "" {
+		return PromptFile{}, fmt.Errorf("prompt file %s has no prompt after its frontmatter", path)
+	}
+	if file.Temperature != nil && (*file.Temperature < 0 || *file.Temperature > 2) {
+		return PromptFile{}, fmt.Errorf("invalid temperature in %s: must be between 0 and 2", path)
+	}
+	if file.TopP != nil && (*file.TopP <= 0 || *file.TopP > 1) {
+		return PromptFile{}, fmt.Errorf("invalid top_p in %s: must be above 0 and at most 1", path)
+	}
+	if file.MaxTokens < 0 {
+		return PromptFile{}, fmt.Errorf("invalid max_tokens in %s: must be positive", path)
+	}
+	return file, nil
+}
+
+// cutFrontmatter splits the YAML frontmatter, between "---" lines at the start, from the body.
+func cutFrontmatter(content string) (frontmatter, body string, ok bool) {
+	rest, ok := strings.CutPrefix(content, "---\n")
+	if !ok {
+		return "", content, false
+	}
+	if body, ok = strings.CutPrefix(rest, "---\n"); ok {
+		return "", body, true // Empty frontmatter
+	}
+	if frontmatter, body, ok = strings.Cut(rest, "\n---\n"); ok {
+		return frontmatter, body, true
+	}
+	if frontmatter, ok = strings.CutSuffix(rest, "\n---"); ok {
+		return frontmatter, "", true
+	}
+	return "", content, false
+}
@@ -0,0 +1,359 @@
+package edit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/patch"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/telemetry"
+	"golang.org/x/term"
+)
+
+// backupSuffix is appended to the path of a file to back it up before applying a change.
+const backupSuffix = ".orig"
+
+// pathPrefix marks a code block holding the full new content of a file, e.g. ```go path=main.go
+const pathPrefix = "path="
+
+// systemPrompt asks the model for a diff instead of a prose answer.
+const systemPrompt = `You edit files as instructed by the user.
+Reply only with a unified diff of the change to all files in a single ` + "```diff" + ` code block, with ---/+++ file headers naming the files as given and @@ hunk headers.
+Include at least 3 lines of unchanged context around each change, copied exactly from the files.
+To rewrite most of a file, you may instead reply with its full new content in a code block whose info string is the language followed by ` + pathPrefix + `<file>.
+Do not explain the change.`
+
+// Change is a validated change to a file proposed by the model.
+type Change struct {
+	Path     string
+	Original string
+	Content  string // The file's content with the change applied
+}
+
+// Diff returns the change as a unified diff.
+func (c Change) Diff() patch.File {
+	return patch.Diff(c.Path, c.Original, c.Content)
+}
+
+// Run asks the model to edit the files as instructed, renders the proposed changes with a
+// per-file summary, and writes the accepted ones after backing up the files. The changes are
+// accepted interactively per file, or all at once with --apply.
+func Run(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	instructions := strings.TrimSpace(strings.Join(append(args.ActionArgs, args.Prompts...), "\n\n"))
+	if instructions == "" {
+		return errors.New("edit needs instructions, as arguments or on stdin")
+	}
+
+	changes, err := Propose(ctx, cfg, args.Model, args.Edit.Files, instructions, args.Edit.Attempts)
+	if err != nil {
+		return err
+	}
+
+	staging, err := stage(changes)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+
+	for _, change := range changes {
+		if err := render.RenderMarkdown(ctx, cfg, args, "```diff\n"+change.Diff().String()+"```\n"); err != nil {
+			return err
+		}
+	}
+	for _, change := range changes {
+		added, removed := change.Diff().Stats()
+		fmt.Fprintf(os.Stderr, "%s: +%d -%d\n", change.Path, added, removed)
+	}
+
+	answerID := telemetry.NewAnswerID()
+	client.RecordEvent(cfg, telemetry.Event{
+		Answer:  answerID,
+		Kind:    telemetry.EventAnswer,
+		Command: args.Action,
+		Model:   args.Model,
+	})
+
+	accepted := changes
+	switch {
+	case args.Edit.Apply:
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		if accepted, err = review(changes); err != nil {
+			return err
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "No terminal to review the changes on, so none were written; run with --apply to write them, which asks the model again")
+		return nil
+	}
+
+	for _, change := range accepted {
+		if err := apply(change, filepath.Join(staging, change.Path)); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Applied the changes to %s (backup in %s)\n", change.Path, change.Path+backupSuffix)
+	}
+	if len(accepted) > 0 {
+		client.RecordEvent(cfg, telemetry.Event{Answer: answerID, Kind: telemetry.EventApply})
+	}
+	return nil
+}
+
+// Propose asks the model for changes to the files as instructed. Answers that don't apply are
+// sent back to the model with the reason and the actual lines of the file, up to attempts times.
+func Propose(ctx context.Context, cfg config.Config, model string, paths []string, instructions string, attempts int) ([]Change, error) {
+	originals := make(map[string]string, len(paths))
+	var prompt strings.Builder
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if filepath.IsAbs(path) || !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("%s: only files below the working directory can be edited", path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		originals[path] = string(data)
+		fmt.Fprintf(&prompt, "File %s:\n\n````\n%s````\n\n", path, withNewline(string(data)))
+	}
+	prompt.WriteString(instructions)
+
+	messages := []client.Message{
+		{Role: client.SystemRole, Content: systemPrompt},
+		{Role: client.UserRole, Content: prompt.String()},
+	}
+
+	for attempt := 1; ; attempt++ {
+		answer, err := client.Complete(ctx, cfg, model, messages)
+		if err != nil {
+			return nil, err
+		}
+
+		changes, err := validate(paths, originals, answer)
+		if err == nil {
+			return changes, nil
+		}
+		if attempt >= attempts {
+			return nil, fmt.Errorf("model did not return an applicable change after %d attempts: %w", attempts, err)
+		}
+		fmt.Fprintf(os.Stderr, "The change did not apply (%v), asking for a corrected one (attempt %d of %d)\n", err, attempt+1, attempts)
+		messages = append(messages,
+			client.Message{Role: client.AssistantRole, Content: answer},
+			client.Message{Role: client.UserRole, Content: retryPrompt(err)},
+		)
+	}
+}
+
+// retryPrompt tells the model why its change didn't apply. A hunk that doesn't match is quoted
+// with the lines actually in the file where it belongs, as models misremember context lines.
+func retryPrompt(err error) string {
+	var hunkErr *patch.HunkError
+	if !errors.As(err, &hunkErr) {
+		return fmt.Sprintf("The change could not be applied: %v. Reply with a corrected unified diff of the whole change.", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "The change could not be applied: %v. This hunk doesn't match the file:\n\n```diff\n%s```\n\n", err, hunkErr.Hunk)
+	if len(hunkErr.Region) == 0 {
+		b.WriteString("The file is empty.\n\n")
+	} else {
+		end := hunkErr.Start + len(hunkErr.Region) - 1
+		fmt.Fprintf(&b, "These are lines %d to %d of the file, where it belongs:\n\n````\n%s\n````\n\n",
+			hunkErr.Start, end, strings.Join(hunkErr.Region, "\n"))
+	}
+	b.WriteString("Copy the context and removed lines exactly from the file, and reply with a corrected unified diff of the whole change.")
+	return b.String()
+}
+
+// validate extracts the changes from the answer, a diff or full file contents, and checks that
+// they apply to the original contents.
+func validate(paths []string, originals map[string]string, answer string) ([]Change, error) {
+	contents := make(map[string]string, len(originals))
+	current := func(path string) string {
+		if content, ok := contents[path]; ok {
+			return content
+		}
+		return originals[path]
+	}
+
+	blocks := codeblock.Parse(answer)
+	var diffs []string
+	for _, block := range blocks {
+		if path, ok := blockPath(block.Info); ok {
+			target, err := resolvePath(paths, path)
+			if err != nil {
+				return nil, err
+			}
+			contents[target] = withNewline(block.Code)
+			continue
+		}
+		if block.Lang == "diff" || block.Lang == "patch" {
+			diffs = append(diffs, block.Code)
+		}
+	}
+	if len(blocks) == 0 {
+		diffs = append(diffs, answer)
+	} else if len(diffs) == 0 && len(contents) == 0 {
+		diffs = append(diffs, blocks[0].Code)
+	}
+
+	for _, diff := range diffs {
+		files, err := patch.Parse(diff)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			path := file.NewPath
+			if path == "" {
+				path = file.OldPath
+			}
+			target, err := resolvePath(paths, path)
+			if err != nil {
+				return nil, err
+			}
+			content, err := file.Apply(current(target))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", target, err)
+			}
+			contents[target] = content
+		}
+	}
+
+	var changes []Change
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if content, ok := contents[path]; ok && content != originals[path] {
+			changes = append(changes, Change{Path: path, Original: originals[path], Content: content})
+		}
+	}
+	if len(changes) == 0 {
+		return nil, errors.New("the answer does not change any file")
+	}
+	return changes, nil
+}
+
+// resolvePath matches a path named by the model to one of the files being edited, tolerating
+// the prefixes and missing directories models tend to add or drop.
+func resolvePath(paths []string, name string) (string, error) {
+	if len(paths) == 1 {
+		return filepath.Clean(paths[0]), nil // Whatever the model called it, there is only one file
+	}
+
+	name = filepath.Clean(strings.TrimPrefix(name, "./"))
+	var matches []string
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if path == name {
+			return path, nil
+		}
+		if filepath.Base(path) == filepath.Base(name) {
+			matches = append(matches, path)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%s is not one of the files being edited: %s", name, strings.Join(paths, ", "))
+}
+
+// blockPath returns the path of a code block holding a full file, from its path= info string attribute.
+func blockPath(info string) (string, bool) {
+	for field := range strings.FieldsSeq(info) {
+		if path, ok := strings.CutPrefix(field, pathPrefix); ok && path != "" {
+			return strings.Trim(path, `"'`), true
+		}
+	}
+	return "", false
+}
+
+// stage writes the changed files to a temporary worktree, where they can be inspected before they are applied.
+func stage(changes []Change) (string, error) {
+	dir, err := os.MkdirTemp("", "gh-copilot-edit-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	for _, change := range changes {
+		path := filepath.Join(dir, change.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to stage %s: %w", change.Path, err)
+		}
+		if err := os.WriteFile(path, []byte(change.Content), 0o644); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to stage %s: %w", change.Path, err)
+		}
+	}
+	return dir, nil
+}
+
+// review asks whether to apply each change, returning the accepted ones.
+func review(changes []Change) ([]Change, error) {
+	input := bufio.NewReader(os.Stdin)
+	var accepted []Change
+	for i, change := range changes {
+		added, removed := change.Diff().Stats()
+		fmt.Fprintf(os.Stderr, "Apply the changes to %s (+%d -%d)? [y]es, [n]o, [a]ll, [q]uit: ", change.Path, added, removed)
+
+		line, err := input.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return accepted, nil // EOF rejects the remaining changes
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			accepted = append(accepted, change)
+		case "a", "all":
+			return append(accepted, changes[i:]...), nil
+		case "q", "quit":
+			return accepted, nil
+		}
+	}
+	return accepted, nil
+}
+
+// apply backs up the file and replaces it with its staged version, keeping the file's permissions.
+func apply(change Change, staged string) error {
+	info, err := os.Stat(change.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", change.Path, err)
+	}
+
+	current, err := os.ReadFile(change.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", change.Path, err)
+	}
+	if string(current) != change.Original {
+		return fmt.Errorf("%s changed while the edit was proposed", change.Path)
+	}
+
+	content, err := os.ReadFile(staged)
+	if err != nil {
+		return fmt.Errorf("failed to read staged %s: %w", change.Path, err)
+	}
+
+	if err := os.WriteFile(change.Path+backupSuffix, current, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", change.Path, err)
+	}
+	if err := os.WriteFile(change.Path, content, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", change.Path, err)
+	}
+	return nil
+}
+
+// withNewline ensures the content ends with a newline, so the closing fence is on its own line.
+func withNewline(content string) string {
+	if content == "" || strings.HasSuffix(content, "\n") {
+		return content
+	}
+	return content + "\n"
+}
@@ -0,0 +1,97 @@
+package filetype
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// interpreters maps shebang interpreters to their language.
+var interpreters = map[string]string{
+	"python": "python",
+	"node":   "javascript",
+	"deno":   "typescript",
+	"bun":    "javascript",
+	"sh":     "sh",
+	"bash":   "sh",
+	"dash":   "sh",
+	"ksh":    "sh",
+	"zsh":    "sh",
+	"fish":   "fish",
+	"ruby":   "ruby",
+	"perl":   "perl",
+	"php":    "php",
+	"lua":    "lua",
+}
+
+// heuristics recognize languages by their telltale lines, tried in order. They only need to be
+// right for typical snippets, as an undetected language just goes without a fence.
+var heuristics = []struct {
+	lang    string
+	pattern *regexp.Regexp
+}{
+	{"php", regexp.MustCompile(`\A<\?php`)},
+	{"xml", regexp.MustCompile(`\A<\?xml`)},
+	{"html", regexp.MustCompile(`(?i)\A<(!doctype html|html)`)},
+	{"diff", regexp.MustCompile(`(?m)\A(diff --git |--- .*\n\+\+\+ )`)},
+	{"go", regexp.MustCompile(`(?m)^package \w+$[\s\S]*^(func|import|type) `)},
+	{"dockerfile", regexp.MustCompile(`(?m)\A(#.*\n|\s*\n)*FROM \S+`)},
+	{"rust", regexp.MustCompile(`(?m)^\s*(pub )?fn \w+(<.*>)?\(.*\)( -> .+)? \{$`)},
+	{"python", regexp.MustCompile(`(?m)^\s*(def \w+\(.*\)( -> .+)?|class \w+(\(.*\))?):\s*$`)},
+	{"cpp", regexp.MustCompile(`(?m)^#include <\w+>$[\s\S]*std::`)},
+	{"c", regexp.MustCompile(`(?m)^#include [<"][\w/.]+[>"]$`)},
+	{"sql", regexp.MustCompile(`(?i)\A(select\s[\s\S]*\sfrom\s|insert into |update \w+ set |delete from |create (table|index|view) )`)},
+}
+
+// FromContent detects the language of a snippet from its shebang or content, returning "" when unknown.
+func FromContent(content string) string {
+	content = strings.TrimSpace(content)
+	if lang := fromShebang(content); lang != "" {
+		return lang
+	}
+
+	if strings.HasPrefix(content, "{") || strings.HasPrefix(content, "[") {
+		if json.Valid([]byte(content)) {
+			return "json"
+		}
+	}
+
+	for _, h := range heuristics {
+		if h.pattern.MatchString(content) {
+			return h.lang
+		}
+	}
+	return ""
+}
+
+// fromShebang detects the language from the interpreter of a #! line, e.g. "#!/usr/bin/env python3".
+func fromShebang(content string) string {
+	line, _, _ := strings.Cut(content, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	for i, field := range fields {
+		name := path.Base(field)
+		if i == 0 && name == "env" {
+			continue
+		}
+		if strings.HasPrefix(field, "-") || strings.Contains(field, "=") {
+			continue // Options and variables of env
+		}
+		return interpreters[strings.TrimRight(name, "0123456789.")] // e.g. python3.12
+	}
+	return ""
+}
+
+// Normalize turns a language hint, a language name or a file extension like "py", into the
+// language name used in code fences. Unknown hints are kept as they are.
+func Normalize(hint string) string {
+	hint = strings.ToLower(strings.TrimPrefix(hint, "."))
+	if lang, ok := extensions["."+hint]; ok {
+		return lang
+	}
+	return hint
+}
@@ -0,0 +1,62 @@
+package filetype
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// extensions maps file extensions to the language name used in code fences.
+var extensions = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".php":   "php",
+	".lua":   "lua",
+	".sh":    "sh",
+	".bash":  "sh",
+	".zsh":   "sh",
+	".fish":  "fish",
+	".sql":   "sql",
+	".html":  "html",
+	".css":   "css",
+	".scss":  "scss",
+	".md":    "markdown",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".xml":   "xml",
+	".proto": "protobuf",
+	".tf":    "hcl",
+}
+
+// filenames maps well-known file names without a telling extension to their language.
+var filenames = map[string]string{
+	"Dockerfile":  "dockerfile",
+	"Makefile":    "makefile",
+	"Jenkinsfile": "groovy",
+}
+
+// FromPath detects the language of a file from its name, returning "" when unknown.
+func FromPath(path string) string {
+	base := filepath.Base(path)
+	if lang, ok := filenames[base]; ok {
+		return lang
+	}
+	return extensions[strings.ToLower(filepath.Ext(base))]
+}
@@ -0,0 +1,110 @@
+package formatter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/format"
+	"os/exec"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/codeblock"
+)
+
+// ErrNoFormatter is returned when no formatter is configured or installed for a language.
+var ErrNoFormatter = errors.New("no formatter available")
+
+// defaultCommands are the formatters used for a language unless overridden in config.
+// Each command reads the code from stdin and writes the formatted code to stdout.
+var defaultCommands = map[string]string{
+	"go":         "gofmt",
+	"python":     "black -q -",
+	"javascript": "prettier --parser babel",
+	"typescript": "prettier --parser typescript",
+	"json":       "prettier --parser json",
+	"yaml":       "prettier --parser yaml",
+	"css":        "prettier --parser css",
+	"rust":       "rustfmt --edition 2021",
+	"sh":         "shfmt",
+}
+
+// aliases maps common code fence language tags to their canonical name.
+var aliases = map[string]string{
+	"golang": "go",
+	"py":     "python",
+	"js":     "javascript",
+	"jsx":    "javascript",
+	"ts":     "typescript",
+	"tsx":    "typescript",
+	"yml":    "yaml",
+	"rs":     "rust",
+	"bash":   "sh",
+	"shell":  "sh",
+	"zsh":    "sh",
+}
+
+// Canonical returns the canonical language name for a code fence language tag.
+func Canonical(lang string) string {
+	lang = strings.ToLower(lang)
+	if canonical, ok := aliases[lang]; ok {
+		return canonical
+	}
+	return lang
+}
+
+// Format formats the code with the formatter configured for the language.
+// The commands override the default formatter per language; an empty command disables formatting.
+func Format(ctx context.Context, commands map[string]string, lang, code string) (string, error) {
+	lang = Canonical(lang)
+
+	command, ok := commands[lang]
+	if !ok {
+		command = defaultCommands[lang]
+	}
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", ErrNoFormatter
+	}
+
+	path, err := exec.LookPath(fields[0])
+	if err != nil {
+		// gofmt is always available in-process
+		if lang == "go" && !ok {
+			return formatGo(code)
+		}
+		return "", fmt.Errorf("%w: %s is not installed", ErrNoFormatter, fields[0])
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, fields[1:]...)
+	cmd.Stdin = strings.NewReader(code)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", fields[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// FormatBlocks formats every fenced code block in the markdown content,
+// leaving blocks untouched when no formatter is available or formatting fails.
+func FormatBlocks(ctx context.Context, commands map[string]string, content string) string {
+	return codeblock.Replace(content, func(block codeblock.Block) string {
+		formatted, err := Format(ctx, commands, block.Lang, block.Code)
+		if err != nil {
+			return content[block.Start:block.End]
+		}
+		return codeblock.Format(block, formatted)
+	})
+}
+
+// formatGo formats Go code in-process.
+func formatGo(code string) (string, error) {
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		return "", fmt.Errorf("gofmt failed: %w", err)
+	}
+	return string(formatted), nil
+}
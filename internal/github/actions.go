@@ -0,0 +1,96 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Job is a job of a GitHub Actions workflow run.
+type Job struct {
+	ID          int64
+	Name        string
+	Conclusion  string
+	URL         string
+	FailedSteps []string
+}
+
+// jobsResponse is the structure of the jobs of a workflow run from the REST API.
+type jobsResponse struct {
+	Jobs []struct {
+		ID         int64  `json:"id"`
+		Name       string `json:"name"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+		Steps      []struct {
+			Name       string `json:"name"`
+			Conclusion string `json:"conclusion"`
+		} `json:"steps"`
+	} `json:"jobs"`
+}
+
+// ParseRun parses a workflow run ID or the URL of a run or one of its jobs, e.g.
+// https://github.com/owner/repo/actions/runs/123/job/456. It returns the run's repository as
+// owner/repo, or "" for the current repository, its ID, and the job's ID or 0.
+func ParseRun(ref string) (repo string, runID, jobID int64, err error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil && id > 0 {
+		return "", id, 0, nil
+	}
+
+	u, err := url.Parse(ref)
+	if err == nil && u.Host != "" {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) >= 5 && parts[2] == "actions" && parts[3] == "runs" {
+			runID, err = strconv.ParseInt(parts[4], 10, 64)
+			if err == nil && len(parts) >= 7 && parts[5] == "job" {
+				jobID, err = strconv.ParseInt(parts[6], 10, 64)
+			}
+			if err == nil {
+				return parts[0] + "/" + parts[1], runID, jobID, nil
+			}
+		}
+	}
+	return "", 0, 0, fmt.Errorf("%s is neither a workflow run ID nor the URL of a run", ref)
+}
+
+// FetchFailedJobs fetches the jobs of the latest attempt of a workflow run that failed or timed out.
+// An empty repo is the current repository.
+func FetchFailedJobs(ctx context.Context, repo string, runID int64) ([]Job, error) {
+	if repo == "" {
+		repo = "{owner}/{repo}"
+	}
+	var response jobsResponse
+	if err := runJSON(ctx, &response, "api",
+		fmt.Sprintf("repos/%s/actions/runs/%d/jobs?filter=latest&per_page=100", repo, runID)); err != nil {
+		return nil, fmt.Errorf("failed to fetch the jobs of workflow run %d: %w", runID, err)
+	}
+
+	var jobs []Job
+	for _, j := range response.Jobs {
+		if j.Conclusion != "failure" && j.Conclusion != "timed_out" {
+			continue
+		}
+		job := Job{ID: j.ID, Name: j.Name, Conclusion: j.Conclusion, URL: j.HTMLURL}
+		for _, step := range j.Steps {
+			if step.Conclusion == "failure" || step.Conclusion == "timed_out" {
+				job.FailedSteps = append(job.FailedSteps, step.Name)
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// FetchJobLog downloads the log of a workflow job. An empty repo is the current repository.
+func FetchJobLog(ctx context.Context, repo string, jobID int64) (string, error) {
+	if repo == "" {
+		repo = "{owner}/{repo}"
+	}
+	log, err := run(ctx, "api", fmt.Sprintf("repos/%s/actions/jobs/%d/logs", repo, jobID))
+	if err != nil {
+		return "", fmt.Errorf("failed to download the log of job %d: %w", jobID, err)
+	}
+	return string(log), nil
+}
@@ -0,0 +1,48 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	gh "github.com/cli/go-gh/v2"
+)
+
+// run executes a gh command with the user's gh authentication and returns its stdout.
+func run(ctx context.Context, args ...string) ([]byte, error) {
+	stdout, stderr, err := gh.ExecContext(ctx, args...)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("gh %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("gh %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// runJSON executes a gh command and decodes its JSON output into v.
+func runJSON(ctx context.Context, v any, args ...string) error {
+	out, err := run(ctx, args...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("failed to decode gh %s output: %w", args[0], err)
+	}
+	return nil
+}
+
+// apiPaginated fetches all pages of a REST API list endpoint of the current repository into v,
+// which must be a pointer to a slice.
+func apiPaginated[T any](ctx context.Context, v *[]T, endpoint string) error {
+	var pages [][]T
+	if err := runJSON(ctx, &pages, "api", "--paginate", "--slurp", endpoint); err != nil {
+		return err
+	}
+
+	for _, page := range pages {
+		*v = append(*v, page...)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Issue holds an issue of the current repository with its comments.
+type Issue struct {
+	Number   int
+	Title    string
+	Body     string
+	Author   string
+	URL      string
+	State    string
+	Labels   []string
+	Comments []Comment
+}
+
+// Label is a label of the current repository.
+type Label struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// issueView is the structure of `gh issue view --json` output.
+type issueView struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	URL    string `json:"url"`
+	State  string `json:"state"`
+	Author struct {
+		Login string `json:"login"`
+	} `json:"author"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Comments []struct {
+		Author struct {
+			Login string `json:"login"`
+		} `json:"author"`
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"comments"`
+}
+
+// FetchIssue fetches an issue of the current repository, given by its number or URL, with its comments.
+func FetchIssue(ctx context.Context, ref string) (*Issue, error) {
+	var view issueView
+	if err := runJSON(ctx, &view, "issue", "view", ref, "--json",
+		"number,title,body,url,state,author,labels,comments"); err != nil {
+		return nil, fmt.Errorf("failed to fetch issue %s: %w", ref, err)
+	}
+
+	issue := &Issue{
+		Number: view.Number,
+		Title:  view.Title,
+		Body:   view.Body,
+		Author: view.Author.Login,
+		URL:    view.URL,
+		State:  view.State,
+	}
+	for _, l := range view.Labels {
+		issue.Labels = append(issue.Labels, l.Name)
+	}
+	for _, c := range view.Comments {
+		issue.Comments = append(issue.Comments, Comment{Author: c.Author.Login, Body: c.Body, CreatedAt: c.CreatedAt})
+	}
+	return issue, nil
+}
+
+// FetchLabels fetches the labels of the current repository.
+func FetchLabels(ctx context.Context) ([]Label, error) {
+	var labels []Label
+	if err := runJSON(ctx, &labels, "label", "list", "--limit", "500", "--json", "name,description"); err != nil {
+		return nil, fmt.Errorf("failed to fetch labels: %w", err)
+	}
+	return labels, nil
+}
+
+// CommentOnIssue posts a comment on an issue of the current repository and returns its URL.
+func CommentOnIssue(ctx context.Context, number int, body string) (string, error) {
+	out, err := run(ctx, "issue", "comment", strconv.Itoa(number), "--body", body)
+	if err != nil {
+		return "", fmt.Errorf("failed to comment on issue #%d: %w", number, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Markdown formats the issue as a markdown document for use as model context.
+func (issue *Issue) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Issue #%d: %s\n\n", issue.Number, issue.Title)
+	fmt.Fprintf(&b, "Author: @%s\nURL: %s\nState: %s\n", issue.Author, issue.URL, issue.State)
+	if len(issue.Labels) > 0 {
+		fmt.Fprintf(&b, "Labels: %s\n", strings.Join(issue.Labels, ", "))
+	}
+	b.WriteString("\n")
+
+	if body := strings.TrimSpace(issue.Body); body != "" {
+		fmt.Fprintf(&b, "## Description\n\n%s\n\n", body)
+	}
+
+	if len(issue.Comments) > 0 {
+		b.WriteString("## Comments\n\n")
+		for _, c := range issue.Comments {
+			fmt.Fprintf(&b, "### @%s (%s)\n\n%s\n\n", c.Author, c.CreatedAt.Format(time.DateOnly), strings.TrimSpace(c.Body))
+		}
+	}
+	return b.String()
+}
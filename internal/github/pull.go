@@ -0,0 +1,168 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/scope"
+)
+
+// PullRequest holds the context of a pull request used to ground a conversation.
+type PullRequest struct {
+	Number         int
+	Title          string
+	Body           string
+	Author         string
+	URL            string
+	HeadSHA        string
+	Diff           string
+	Comments       []Comment
+	ReviewComments []ReviewComment
+}
+
+// Comment is a conversation comment or review summary on a pull request.
+type Comment struct {
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// ReviewComment is an inline review comment on a line of a pull request's diff.
+type ReviewComment struct {
+	Author   string
+	Body     string
+	Path     string
+	Line     int
+	DiffHunk string
+}
+
+// pullRequestView is the structure of `gh pr view --json` output.
+type pullRequestView struct {
+	Number     int    `json:"number"`
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+	URL        string `json:"url"`
+	HeadRefOid string `json:"headRefOid"`
+	Author     struct {
+		Login string `json:"login"`
+	} `json:"author"`
+	Comments []struct {
+		Author struct {
+			Login string `json:"login"`
+		} `json:"author"`
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"comments"`
+	Reviews []struct {
+		Author struct {
+			Login string `json:"login"`
+		} `json:"author"`
+		Body        string    `json:"body"`
+		State       string    `json:"state"`
+		SubmittedAt time.Time `json:"submittedAt"`
+	} `json:"reviews"`
+}
+
+// reviewCommentResponse is the structure of a review comment from the REST API.
+type reviewCommentResponse struct {
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Body     string `json:"body"`
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	DiffHunk string `json:"diff_hunk"`
+}
+
+// FetchPullRequest fetches a pull request of the current repository with its diff, comments, and reviews.
+func FetchPullRequest(ctx context.Context, number int) (*PullRequest, error) {
+	id := strconv.Itoa(number)
+
+	var view pullRequestView
+	if err := runJSON(ctx, &view, "pr", "view", id, "--json",
+		"number,title,body,url,headRefOid,author,comments,reviews"); err != nil {
+		return nil, fmt.Errorf("failed to fetch pull request #%d: %w", number, err)
+	}
+
+	diff, err := run(ctx, "pr", "diff", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch diff of pull request #%d: %w", number, err)
+	}
+
+	var reviewComments []reviewCommentResponse
+	if err := apiPaginated(ctx, &reviewComments, fmt.Sprintf("repos/{owner}/{repo}/pulls/%d/comments", number)); err != nil {
+		return nil, fmt.Errorf("failed to fetch review comments of pull request #%d: %w", number, err)
+	}
+
+	pr := &PullRequest{
+		Number:  view.Number,
+		Title:   view.Title,
+		Body:    view.Body,
+		Author:  view.Author.Login,
+		URL:     view.URL,
+		HeadSHA: view.HeadRefOid,
+		Diff:    string(diff),
+	}
+	for _, c := range view.Comments {
+		pr.Comments = append(pr.Comments, Comment{Author: c.Author.Login, Body: c.Body, CreatedAt: c.CreatedAt})
+	}
+	for _, r := range view.Reviews {
+		if strings.TrimSpace(r.Body) == "" {
+			continue
+		}
+		body := fmt.Sprintf("[%s] %s", r.State, r.Body)
+		pr.Comments = append(pr.Comments, Comment{Author: r.Author.Login, Body: body, CreatedAt: r.SubmittedAt})
+	}
+	for _, c := range reviewComments {
+		pr.ReviewComments = append(pr.ReviewComments, ReviewComment{
+			Author:   c.User.Login,
+			Body:     c.Body,
+			Path:     c.Path,
+			Line:     c.Line,
+			DiffHunk: c.DiffHunk,
+		})
+	}
+	return pr, nil
+}
+
+// Confine keeps the changes and review comments of the pull request inside the scope.
+func (pr *PullRequest) Confine(s scope.Scope) {
+	pr.Diff = s.FilterDiff(pr.Diff)
+	pr.ReviewComments = slices.DeleteFunc(pr.ReviewComments, func(c ReviewComment) bool {
+		return !s.ContainsRepoPath(c.Path)
+	})
+}
+
+// Markdown formats the pull request as a markdown document for use as model context.
+func (pr *PullRequest) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Pull request #%d: %s\n\n", pr.Number, pr.Title)
+	fmt.Fprintf(&b, "Author: @%s\nURL: %s\nHead commit: %s\n\n", pr.Author, pr.URL, pr.HeadSHA)
+
+	if body := strings.TrimSpace(pr.Body); body != "" {
+		fmt.Fprintf(&b, "## Description\n\n%s\n\n", body)
+	}
+
+	if len(pr.Comments) > 0 {
+		b.WriteString("## Comments\n\n")
+		for _, c := range pr.Comments {
+			fmt.Fprintf(&b, "- @%s (%s): %s\n", c.Author, c.CreatedAt.Format(time.DateOnly), strings.TrimSpace(c.Body))
+		}
+		b.WriteString("\n")
+	}
+
+	if len(pr.ReviewComments) > 0 {
+		b.WriteString("## Review comments\n\n")
+		for _, c := range pr.ReviewComments {
+			fmt.Fprintf(&b, "- @%s on `%s:%d`: %s\n", c.Author, c.Path, c.Line, strings.TrimSpace(c.Body))
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "## Diff\n\n```diff\n%s\n```\n", strings.TrimSpace(pr.Diff))
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errNotFound matches the error of a gh API request for a resource that doesn't exist.
+var errNotFound = errors.New("HTTP 404")
+
+// LatestVersion resolves the version to install of a repository: the tag of its latest release,
+// or its default branch when it has no releases.
+func LatestVersion(ctx context.Context, repo string) (string, error) {
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	err := runJSON(ctx, &release, "api", "repos/"+repo+"/releases/latest")
+	if err == nil {
+		return release.TagName, nil
+	}
+	if !strings.Contains(err.Error(), errNotFound.Error()) {
+		return "", fmt.Errorf("failed to fetch the latest release of %s: %w", repo, err)
+	}
+
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := runJSON(ctx, &info, "api", "repos/"+repo); err != nil {
+		return "", fmt.Errorf("failed to fetch repository %s: %w", repo, err)
+	}
+	return info.DefaultBranch, nil
+}
+
+// ResolveCommit resolves a tag, branch, or commit of a repository to its commit SHA.
+func ResolveCommit(ctx context.Context, repo, ref string) (string, error) {
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := runJSON(ctx, &commit, "api", "repos/"+repo+"/commits/"+ref); err != nil {
+		return "", fmt.Errorf("failed to resolve %s of %s: %w", ref, repo, err)
+	}
+	return commit.SHA, nil
+}
+
+// Tarball downloads the gzipped tarball of a repository at a commit.
+func Tarball(ctx context.Context, repo, commit string) ([]byte, error) {
+	data, err := run(ctx, "api", "repos/"+repo+"/tarball/"+commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s at %.7s: %w", repo, commit, err)
+	}
+	return data, nil
+}
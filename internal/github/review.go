@@ -0,0 +1,57 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DraftComment is an inline comment of a draft review, on a line of the changed file.
+type DraftComment struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Side string `json:"side"` // RIGHT for lines of the changed file
+	Body string `json:"body"`
+}
+
+// draftReview is the request body of a pending review, which only its author sees until it is submitted.
+type draftReview struct {
+	CommitID string         `json:"commit_id"`
+	Body     string         `json:"body,omitempty"`
+	Comments []DraftComment `json:"comments"`
+}
+
+// CreateDraftReview creates a pending review of a pull request of the current repository at the
+// commit, with a body and inline comments, and returns its URL. The review is submitted on GitHub.
+func CreateDraftReview(ctx context.Context, number int, commitID, body string, comments []DraftComment) (string, error) {
+	data, err := json.Marshal(draftReview{CommitID: commitID, Body: body, Comments: comments})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal review: %w", err)
+	}
+
+	// gh reads the request body from a file, as it can't be passed on the command line
+	input, err := os.CreateTemp("", "gh-copilot-review-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create review file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(input.Name())
+	}()
+	if _, err := input.Write(data); err != nil {
+		_ = input.Close()
+		return "", fmt.Errorf("failed to write review file: %w", err)
+	}
+	if err := input.Close(); err != nil {
+		return "", fmt.Errorf("failed to write review file: %w", err)
+	}
+
+	var review struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := runJSON(ctx, &review, "api", "--method", "POST",
+		fmt.Sprintf("repos/{owner}/{repo}/pulls/%d/reviews", number), "--input", input.Name()); err != nil {
+		return "", fmt.Errorf("failed to create a draft review of pull request #%d: %w", number, err)
+	}
+	return review.HTMLURL, nil
+}
@@ -0,0 +1,30 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// codeSearchResponse is the structure of a code search result from the REST API.
+type codeSearchResponse struct {
+	Items []struct {
+		Path string `json:"path"`
+	} `json:"items"`
+}
+
+// SearchCode searches the code of the current repository's default branch with GitHub code search,
+// returning the paths of the matching files, best match first.
+func SearchCode(ctx context.Context, query string, limit int) ([]string, error) {
+	var response codeSearchResponse
+	if err := runJSON(ctx, &response, "api", "--method", "GET", "search/code",
+		"-F", "q="+query+" repo:{owner}/{repo}", "-F", "per_page="+strconv.Itoa(limit)); err != nil {
+		return nil, fmt.Errorf("failed to search code: %w", err)
+	}
+
+	paths := make([]string, 0, len(response.Items))
+	for _, item := range response.Items {
+		paths = append(paths, item.Path)
+	}
+	return paths, nil
+}
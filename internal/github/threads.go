@@ -0,0 +1,155 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// ReviewThread is a thread of inline review comments on a pull request's diff.
+type ReviewThread struct {
+	ID       string // GraphQL node ID, used to reply to the thread
+	Path     string
+	Line     int
+	DiffHunk string
+	Comments []ReviewComment
+}
+
+// Participants returns the logins of the authors of the thread's comments.
+func (t ReviewThread) Participants() []string {
+	var logins []string
+	for _, c := range t.Comments {
+		if !slices.Contains(logins, c.Author) {
+			logins = append(logins, c.Author)
+		}
+	}
+	return logins
+}
+
+// reviewThreadsQuery fetches the review threads of a pull request and the login of the viewer.
+const reviewThreadsQuery = `query($owner: String!, $repo: String!, $number: Int!) {
+  viewer { login }
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      reviewThreads(first: 100) {
+        nodes {
+          id
+          isResolved
+          isOutdated
+          path
+          line
+          originalLine
+          comments(first: 100) {
+            nodes {
+              author { login }
+              body
+              diffHunk
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// reviewThreadsResponse is the structure of the reviewThreadsQuery response.
+type reviewThreadsResponse struct {
+	Data struct {
+		Viewer struct {
+			Login string `json:"login"`
+		} `json:"viewer"`
+		Repository struct {
+			PullRequest *struct {
+				ReviewThreads struct {
+					Nodes []struct {
+						ID           string `json:"id"`
+						IsResolved   bool   `json:"isResolved"`
+						IsOutdated   bool   `json:"isOutdated"`
+						Path         string `json:"path"`
+						Line         int    `json:"line"`
+						OriginalLine int    `json:"originalLine"`
+						Comments     struct {
+							Nodes []struct {
+								Author struct {
+									Login string `json:"login"`
+								} `json:"author"`
+								Body     string `json:"body"`
+								DiffHunk string `json:"diffHunk"`
+							} `json:"nodes"`
+						} `json:"comments"`
+					} `json:"nodes"`
+				} `json:"reviewThreads"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+// FetchUnresolvedThreads fetches the unresolved review threads of a pull request of the current
+// repository that the viewer commented on, and the viewer's login.
+func FetchUnresolvedThreads(ctx context.Context, number int) ([]ReviewThread, string, error) {
+	var response reviewThreadsResponse
+	if err := runJSON(ctx, &response, "api", "graphql",
+		"-f", "query="+reviewThreadsQuery,
+		"-F", "owner={owner}", "-F", "repo={repo}", "-F", "number="+strconv.Itoa(number)); err != nil {
+		return nil, "", fmt.Errorf("failed to fetch review threads of pull request #%d: %w", number, err)
+	}
+	pr := response.Data.Repository.PullRequest
+	if pr == nil {
+		return nil, "", fmt.Errorf("pull request #%d not found", number)
+	}
+
+	viewer := response.Data.Viewer.Login
+	var threads []ReviewThread
+	for _, node := range pr.ReviewThreads.Nodes {
+		if node.IsResolved || len(node.Comments.Nodes) == 0 {
+			continue
+		}
+		thread := ReviewThread{ID: node.ID, Path: node.Path, Line: node.Line}
+		if node.IsOutdated || thread.Line == 0 {
+			thread.Line = node.OriginalLine
+		}
+		for _, c := range node.Comments.Nodes {
+			thread.Comments = append(thread.Comments, ReviewComment{
+				Author:   c.Author.Login,
+				Body:     c.Body,
+				Path:     node.Path,
+				Line:     thread.Line,
+				DiffHunk: c.DiffHunk,
+			})
+		}
+		thread.DiffHunk = thread.Comments[0].DiffHunk
+		if slices.Contains(thread.Participants(), viewer) {
+			threads = append(threads, thread)
+		}
+	}
+	return threads, viewer, nil
+}
+
+// replyMutation adds a reply to a review thread.
+const replyMutation = `mutation($thread: ID!, $body: String!) {
+  addPullRequestReviewThreadReply(input: {pullRequestReviewThreadId: $thread, body: $body}) {
+    comment { url }
+  }
+}`
+
+// replyResponse is the structure of the replyMutation response.
+type replyResponse struct {
+	Data struct {
+		AddPullRequestReviewThreadReply struct {
+			Comment struct {
+				URL string `json:"url"`
+			} `json:"comment"`
+		} `json:"addPullRequestReviewThreadReply"`
+	} `json:"data"`
+}
+
+// ReplyToThread posts a reply to the review thread and returns its URL.
+func ReplyToThread(ctx context.Context, threadID, body string) (string, error) {
+	var response replyResponse
+	if err := runJSON(ctx, &response, "api", "graphql",
+		"-f", "query="+replyMutation, "-f", "thread="+threadID, "-f", "body="+body); err != nil {
+		return "", fmt.Errorf("failed to reply to the review thread: %w", err)
+	}
+	return response.Data.AddPullRequestReviewThreadReply.Comment.URL, nil
+}
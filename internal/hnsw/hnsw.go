@@ -0,0 +1,348 @@
+// Package hnsw implements a hierarchical navigable small world graph, an approximate nearest
+// neighbor index that finds the vectors most similar to a query by cosine similarity without
+// comparing it with all of them.
+package hnsw
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Defaults of the parameters of a graph.
+const (
+	DefaultM              = 16  // Links per node on the upper layers, twice as many on the bottom one
+	DefaultEfConstruction = 100 // Candidates considered when linking a new node
+	DefaultEfSearch       = 64  // Candidates considered by a search, at least the number of results
+)
+
+// Neighbor is a vector found by a search, by its ID, the position it was added at.
+type Neighbor struct {
+	ID    int
+	Score float32 // Cosine similarity to the query
+}
+
+// Graph is an HNSW graph over vectors added in order. The vectors themselves aren't saved with
+// the graph, they are passed again when it is loaded.
+type Graph struct {
+	m              int
+	efConstruction int
+	levelMult      float64
+	rng            *rand.Rand
+
+	vectors  [][]float32
+	norms    []float32
+	links    [][][]int32 // Neighbors of each node, by layer
+	entry    int
+	maxLevel int
+}
+
+// New creates an empty graph with m links per node and efConstruction candidates considered per insertion.
+func New(m, efConstruction int) *Graph {
+	return &Graph{
+		m:              m,
+		efConstruction: efConstruction,
+		levelMult:      1 / math.Log(float64(m)),
+		rng:            rand.New(rand.NewSource(1)), // Builds of the same vectors give the same graph
+		entry:          -1,
+	}
+}
+
+// Len returns the number of vectors in the graph.
+func (g *Graph) Len() int {
+	return len(g.vectors)
+}
+
+// Add inserts the vector into the graph, with the next ID.
+func (g *Graph) Add(vector []float32) {
+	id := len(g.vectors)
+	g.vectors = append(g.vectors, vector)
+	g.norms = append(g.norms, norm(vector))
+	level := int(math.Floor(-math.Log(1-g.rng.Float64()) * g.levelMult))
+	g.links = append(g.links, make([][]int32, level+1))
+	if g.entry < 0 {
+		g.entry, g.maxLevel = id, level
+		return
+	}
+
+	q := query{vector: vector, norm: g.norms[id]}
+	ep := g.entry
+	for l := g.maxLevel; l > level; l-- {
+		ep = g.greedy(q, ep, l)
+	}
+	for l := min(level, g.maxLevel); l >= 0; l-- {
+		candidates := g.searchLayer(q, ep, g.efConstruction, l)
+		neighbors := g.selectNeighbors(candidates, g.maxLinks(l))
+		g.links[id][l] = ids(neighbors)
+		for _, n := range neighbors {
+			g.link(n.ID, id, l)
+		}
+		ep = candidates[0].ID
+	}
+	if level > g.maxLevel {
+		g.entry, g.maxLevel = id, level
+	}
+}
+
+// Search returns the k vectors most similar to the query, best first, considering ef candidates:
+// more candidates find the true nearest neighbors more often, at the cost of speed.
+func (g *Graph) Search(vector []float32, k, ef int) []Neighbor {
+	if g.entry < 0 || k <= 0 {
+		return nil
+	}
+	q := query{vector: vector, norm: norm(vector)}
+	ep := g.entry
+	for l := g.maxLevel; l > 0; l-- {
+		ep = g.greedy(q, ep, l)
+	}
+	results := g.searchLayer(q, ep, max(ef, k), 0)
+	return results[:min(k, len(results))]
+}
+
+// query is a vector searched for, with its norm computed once.
+type query struct {
+	vector []float32
+	norm   float32
+}
+
+// similarity returns the cosine similarity between the query and the node.
+func (g *Graph) similarity(q query, id int) float32 {
+	if q.norm == 0 || g.norms[id] == 0 || len(q.vector) != len(g.vectors[id]) {
+		return 0
+	}
+	return dot(q.vector, g.vectors[id]) / (q.norm * g.norms[id])
+}
+
+// greedy walks the layer from the node to the neighbor most similar to the query, until no neighbor is closer.
+func (g *Graph) greedy(q query, id, layer int) int {
+	best := g.similarity(q, id)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range g.links[id][layer] {
+			if s := g.similarity(q, int(n)); s > best {
+				best, id, changed = s, int(n), true
+			}
+		}
+	}
+	return id
+}
+
+// searchLayer returns the ef nodes of the layer most similar to the query found from the entry point, best first.
+func (g *Graph) searchLayer(q query, entry, ef, layer int) []Neighbor {
+	visited := map[int]bool{entry: true}
+	start := Neighbor{ID: entry, Score: g.similarity(q, entry)}
+	candidates := &maxHeap{start} // Nodes to expand, most similar first
+	results := &minHeap{start}    // Best nodes found, least similar first
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(Neighbor)
+		if results.Len() >= ef && c.Score < (*results)[0].Score {
+			break // No candidate left can improve the results
+		}
+		for _, n := range g.links[c.ID][layer] {
+			if visited[int(n)] {
+				continue
+			}
+			visited[int(n)] = true
+			s := g.similarity(q, int(n))
+			if results.Len() < ef || s > (*results)[0].Score {
+				heap.Push(candidates, Neighbor{ID: int(n), Score: s})
+				heap.Push(results, Neighbor{ID: int(n), Score: s})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	found := []Neighbor(*results)
+	sort.Slice(found, func(i, j int) bool { return found[i].Score > found[j].Score })
+	return found
+}
+
+// selectNeighbors picks up to m of the candidates, best first, skipping those closer to an already
+// picked one than to the query, so links lead in different directions. The skipped ones fill the
+// remaining links.
+func (g *Graph) selectNeighbors(candidates []Neighbor, m int) []Neighbor {
+	selected := make([]Neighbor, 0, m)
+	var skipped []Neighbor
+	for _, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		q := query{vector: g.vectors[c.ID], norm: g.norms[c.ID]}
+		diverse := true
+		for _, s := range selected {
+			if g.similarity(q, s.ID) > c.Score {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, c)
+		} else {
+			skipped = append(skipped, c)
+		}
+	}
+	for _, c := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+// link adds a link from the node to the new node on the layer. When the node has too many links,
+// the least similar one is dropped; running the heuristic of selectNeighbors again instead would
+// make building several times slower for little gain in recall.
+func (g *Graph) link(from, to, layer int) {
+	links := append(g.links[from][layer], int32(to))
+	if len(links) > g.maxLinks(layer) {
+		q := query{vector: g.vectors[from], norm: g.norms[from]}
+		worst, worstScore := 0, float32(math.Inf(1))
+		for i, n := range links {
+			if s := g.similarity(q, int(n)); s < worstScore {
+				worst, worstScore = i, s
+			}
+		}
+		links = append(links[:worst], links[worst+1:]...)
+	}
+	g.links[from][layer] = links
+}
+
+// maxLinks returns the number of links a node may have on the layer.
+func (g *Graph) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * g.m
+	}
+	return g.m
+}
+
+// snapshot is the saved form of a graph.
+type snapshot struct {
+	M              int
+	EfConstruction int
+	Links          [][][]int32
+	Entry          int
+	MaxLevel       int
+}
+
+// Save writes the links of the graph, without the vectors.
+func (g *Graph) Save(w io.Writer) error {
+	err := gob.NewEncoder(w).Encode(snapshot{
+		M:              g.m,
+		EfConstruction: g.efConstruction,
+		Links:          g.links,
+		Entry:          g.entry,
+		MaxLevel:       g.maxLevel,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode graph: %w", err)
+	}
+	return nil
+}
+
+// Load reads a graph written by Save, over the vectors it was built from, in the same order.
+func Load(r io.Reader, vectors [][]float32) (*Graph, error) {
+	var s snapshot
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode graph: %w", err)
+	}
+	if len(s.Links) != len(vectors) {
+		return nil, fmt.Errorf("the graph has %d nodes for %d vectors", len(s.Links), len(vectors))
+	}
+	if s.M <= 1 || (len(vectors) > 0 && (s.Entry < 0 || s.Entry >= len(vectors) || len(s.Links[s.Entry]) != s.MaxLevel+1)) {
+		return nil, errors.New("the graph is corrupt")
+	}
+	// A link on a layer must lead to a node that is on the layer too
+	for _, layers := range s.Links {
+		for layer, links := range layers {
+			for _, n := range links {
+				if int(n) < 0 || int(n) >= len(vectors) || len(s.Links[n]) <= layer {
+					return nil, errors.New("the graph is corrupt")
+				}
+			}
+		}
+	}
+
+	g := New(s.M, s.EfConstruction)
+	g.vectors = vectors
+	g.norms = make([]float32, len(vectors))
+	for i, v := range vectors {
+		g.norms[i] = norm(v)
+	}
+	g.links, g.entry, g.maxLevel = s.Links, s.Entry, s.MaxLevel
+	if len(vectors) == 0 {
+		g.entry = -1
+	}
+	return g, nil
+}
+
+// dot returns the dot product of vectors of the same length, four products at a time, which is
+// where building and searching spend their time.
+func dot(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// norm returns the Euclidean norm of the vector.
+func norm(v []float32) float32 {
+	var sum float32
+	for _, x := range v {
+		sum += x * x
+	}
+	return float32(math.Sqrt(float64(sum)))
+}
+
+// ids returns the IDs of the neighbors.
+func ids(neighbors []Neighbor) []int32 {
+	out := make([]int32, len(neighbors))
+	for i, n := range neighbors {
+		out[i] = int32(n.ID)
+	}
+	return out
+}
+
+// maxHeap orders neighbors by descending similarity.
+type maxHeap []Neighbor
+
+func (h maxHeap) Len() int           { return len(h) }
+func (h maxHeap) Less(i, j int) bool { return h[i].Score > h[j].Score }
+func (h maxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x any)        { *h = append(*h, x.(Neighbor)) }
+func (h *maxHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// minHeap orders neighbors by ascending similarity.
+type minHeap []Neighbor
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].Score < h[j].Score }
+func (h minHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x any)        { *h = append(*h, x.(Neighbor)) }
+func (h *minHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
@@ -0,0 +1,131 @@
+package index
+
+import (
+	"strings"
+)
+
+const (
+	maxChunkSize = 4000 // Bytes of a chunk; larger files are split, so their embeddings see all of them
+	chunkOverlap = 3    // Lines of the previous chunk repeated at the start of the next one, for context
+)
+
+// splitFile splits the content of a source file into chunks on the boundaries of its definitions,
+// packing small definitions together and cutting large ones at blank lines. Files in languages
+// without definitions are cut at blank lines too. Each chunk after the first starts with the last
+// lines of the one before it, and records the lines it spans, overlap included.
+func splitFile(path, lang, content string) []Chunk {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	defs := extractDefinitions(lang, content)
+	if len(content) <= maxChunkSize {
+		return []Chunk{newChunk(path, lang, lines, 1, len(lines), defs)}
+	}
+
+	// A section runs from a definition to the next one, the first one from the top of the file
+	starts := []int{1}
+	for _, def := range defs {
+		if def.Line > starts[len(starts)-1] {
+			starts = append(starts, def.Line)
+		}
+	}
+
+	var spans [][2]int // First and last line of each chunk, without the overlap
+	start, size := 0, 0
+	for i, first := range starts {
+		last := len(lines)
+		if i+1 < len(starts) {
+			last = starts[i+1] - 1
+		}
+		section := linesSize(lines, first, last)
+		if start > 0 && size+section <= maxChunkSize {
+			size += section
+			continue
+		}
+		if start > 0 {
+			spans = append(spans, [2]int{start, first - 1})
+			start = 0
+		}
+		if section <= maxChunkSize {
+			start, size = first, section
+			continue
+		}
+		spans = append(spans, cutLines(lines, first, last)...)
+	}
+	if start > 0 {
+		spans = append(spans, [2]int{start, len(lines)})
+	}
+
+	chunks := make([]Chunk, len(spans))
+	for i, span := range spans {
+		first := span[0]
+		if i > 0 {
+			first = max(1, first-chunkOverlap)
+		}
+		chunks[i] = newChunk(path, lang, lines, first, span[1], definitionsIn(defs, span[0], span[1]))
+	}
+	return chunks
+}
+
+// cutLines cuts the lines from first to last, which are too large for a chunk, into spans of at most
+// maxChunkSize bytes, after the last blank line that fits when there is one. A single line larger
+// than that is a span of its own.
+func cutLines(lines []string, first, last int) [][2]int {
+	var spans [][2]int
+	for first <= last {
+		end, blank, size := first, 0, 0
+		for line := first; line <= last; line++ {
+			size += len(lines[line-1])
+			if size > maxChunkSize && line > first {
+				break
+			}
+			end = line
+			if strings.TrimSpace(lines[line-1]) == "" {
+				blank = line
+			}
+		}
+		if end < last && blank > first {
+			end = blank
+		}
+		spans = append(spans, [2]int{first, end})
+		first = end + 1
+	}
+	return spans
+}
+
+// newChunk returns the chunk of the lines from first to last, with the names of the definitions.
+func newChunk(path, lang string, lines []string, first, last int, defs []definition) Chunk {
+	var symbols []string
+	for _, def := range defs {
+		symbols = append(symbols, def.Names...)
+	}
+	return Chunk{
+		Path:      path,
+		Filetype:  lang,
+		StartLine: first,
+		EndLine:   last,
+		Symbols:   symbols,
+		Content:   strings.Join(lines[first-1:last], ""),
+	}
+}
+
+// definitionsIn returns the definitions that start between the lines first and last.
+func definitionsIn(defs []definition, first, last int) []definition {
+	var in []definition
+	for _, def := range defs {
+		if def.Line >= first && def.Line <= last {
+			in = append(in, def)
+		}
+	}
+	return in
+}
+
+// linesSize returns the size in bytes of the lines from first to last.
+func linesSize(lines []string, first, last int) int {
+	size := 0
+	for _, line := range lines[first-1 : last] {
+		size += len(line)
+	}
+	return size
+}
@@ -0,0 +1,118 @@
+package index
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/hnsw"
+)
+
+// minGraphChunks is the number of chunks from which searches go through a graph; comparing the
+// query with fewer chunks is fast enough, and exact.
+const minGraphChunks = 2000
+
+// graphCandidates is the number of chunks a search through the graph considers, at least the number of results.
+const graphCandidates = 100
+
+// BuildGraph builds the approximate nearest neighbor graph that searches of large indexes go
+// through, over the chunks embedded with the index's model. Smaller indexes are searched exactly.
+func (idx *Index) BuildGraph() {
+	idx.graph, idx.graphChunks = nil, nil
+	positions := idx.graphPositions()
+	if len(positions) < minGraphChunks {
+		return
+	}
+
+	graph := hnsw.New(hnsw.DefaultM, hnsw.DefaultEfConstruction)
+	for _, n := range positions {
+		graph.Add(idx.Chunks[n].Embedding)
+	}
+	idx.graph, idx.graphChunks = graph, positions
+}
+
+// graphPositions returns the positions of the chunks the graph is built over, in the order of its nodes.
+func (idx *Index) graphPositions() []int {
+	var positions []int
+	for i, chunk := range idx.Chunks {
+		if idx.ChunkModel(chunk) == idx.Model {
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+// searchGraph returns the top chunks most similar to the embedding of the query, through the graph.
+func (idx *Index) searchGraph(embedding []float32, top int) []Result {
+	neighbors := idx.graph.Search(embedding, top, graphCandidates)
+	results := make([]Result, len(neighbors))
+	for i, n := range neighbors {
+		results[i] = Result{Chunk: idx.Chunks[idx.graphChunks[n.ID]], Score: n.Score}
+	}
+	return results
+}
+
+// saveGraph writes the graph next to the index, recording its checksum in the index, or removes
+// the graph of a previous build when there is none.
+func (idx *Index) saveGraph(path string) error {
+	if idx.graph == nil {
+		idx.Graph = ""
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove search graph: %w", err)
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := idx.graph.Save(&buf); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write search graph: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace search graph: %w", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	idx.Graph = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// loadGraph reads the graph recorded in the index. A graph that is missing, of another build, or
+// corrupt is left out, so searches compare all chunks, and Verify reports it.
+func (idx *Index) loadGraph(path string) {
+	if idx.Graph == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		idx.graphErr = fmt.Errorf("failed to read search graph: %w", err)
+		return
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != idx.Graph {
+		idx.graphErr = errors.New("the search graph belongs to another build of the index")
+		return
+	}
+
+	positions := idx.graphPositions()
+	vectors := make([][]float32, len(positions))
+	for i, n := range positions {
+		vectors[i] = idx.Chunks[n].Embedding
+	}
+	graph, err := hnsw.Load(bytes.NewReader(data), vectors)
+	if err != nil {
+		idx.graphErr = err
+		return
+	}
+	idx.graph, idx.graphChunks = graph, positions
+}
+
+// getGraphPath returns the path of the search graph stored next to the index.
+func getGraphPath(indexPath string) string {
+	return strings.TrimSuffix(indexPath, ".json") + ".hnsw"
+}
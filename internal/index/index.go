@@ -0,0 +1,431 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/filetype"
+	"github.com/markis/gh-copilot/internal/hnsw"
+	"github.com/markis/gh-copilot/internal/scope"
+)
+
+const (
+	indexDirName  = "index"
+	maxFileSize   = 1 << 20 // Larger files are almost always generated or vendored
+	mmrCandidates = 4       // Results considered per result picked by diversity re-ranking
+)
+
+// ErrNoIndex is returned when the repository has not been indexed yet.
+var ErrNoIndex = errors.New("no index found for this repository, run `gh copilot index build` first")
+
+// Chunk is a piece of a source file stored in the index together with its embedding and metadata.
+type Chunk struct {
+	Path      string    `json:"path"` // Slash-separated path relative to the index root
+	Filetype  string    `json:"filetype"`
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Symbols   []string  `json:"symbols,omitempty"`
+	Content   string    `json:"content"`
+	Embedding []float32 `json:"embedding"`
+	Model     string    `json:"model,omitempty"` // Embedding model, empty in indexes that only recorded the index's model
+}
+
+// Index is the stored vector index of a repository.
+type Index struct {
+	Root    string            `json:"root"`
+	Model   string            `json:"model"` // Embedding model of the queries, and of the chunks unless being migrated
+	Created time.Time         `json:"created"`
+	Files   map[string]string `json:"files"` // SHA-256 of each indexed file's content, by path
+	Chunks  []Chunk           `json:"chunks"`
+	Graph   string            `json:"graph,omitempty"` // SHA-256 of the saved search graph of large indexes
+
+	graph       *hnsw.Graph // Searched instead of comparing the query with all chunks
+	graphChunks []int       // Position of the chunk of each node of the graph
+	graphErr    error       // Why the recorded graph couldn't be loaded
+}
+
+// Filter restricts a search using the metadata stored with each chunk.
+type Filter struct {
+	Symbol string // Symbol name or glob pattern, matched case-insensitively
+	Lang   string // Filetype of the chunk
+}
+
+// Result is a chunk matched by a search with its similarity score.
+type Result struct {
+	Chunk Chunk
+	Score float32
+}
+
+// FileResult is a file matched by a search, scored by its most similar chunk.
+type FileResult struct {
+	Path   string   // Slash-separated path relative to the index root
+	Score  float32  // Score of the best chunk
+	Chunks []Result // Matched chunks of the file, best first
+}
+
+// FindRoot returns the root of the current git repository, or the working directory outside of one.
+// A scope has an index of its own, rooted at its directory.
+func FindRoot(ctx context.Context, s scope.Scope) (string, error) {
+	if s.IsSet() {
+		return s.Dir, nil
+	}
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel").Output()
+	if err == nil {
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+	return wd, nil
+}
+
+// Load reads the stored index for the root directory.
+func Load(root string) (*Index, error) {
+	path, err := getIndexPath(root)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoIndex
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	idx := &Index{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	idx.loadGraph(getGraphPath(path))
+	return idx, nil
+}
+
+// Save atomically writes the index to the cache directory.
+func (idx *Index) Save() error {
+	path, err := getIndexPath(idx.Root)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	if err := idx.saveGraph(getGraphPath(path)); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace index: %w", err)
+	}
+	return nil
+}
+
+// Build indexes the source files under the root directory, embedding their chunks, split on the
+// boundaries of definitions. Progress is called after every batch of embedded chunks.
+func Build(ctx context.Context, cfg config.Config, root string, progress func(done, total int)) (*Index, error) {
+	files, err := listFiles(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]Chunk, 0, len(files))
+	hashes := make(map[string]string, len(files))
+	for _, file := range files {
+		content, ok, err := readSource(root, file)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			chunks = append(chunks, splitFile(file, filetype.FromPath(file), content)...)
+			hashes[file] = hashContent(content)
+		}
+	}
+
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no source files found in %s", root)
+	}
+
+	model, err := embedChunks(ctx, cfg, cfg.Rag.EmbeddingModel, chunks, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{
+		Root:    root,
+		Model:   model,
+		Created: time.Now(),
+		Files:   hashes,
+		Chunks:  chunks,
+	}
+	idx.BuildGraph()
+	return idx, nil
+}
+
+// Search ranks the chunks matching the filter by similarity to the query, returning at most top results.
+// With an empty query, the matching chunks are returned in index order.
+func Search(ctx context.Context, cfg config.Config, idx *Index, query string, filter Filter, top int) ([]Result, error) {
+	// Chunks of another model, e.g. during a migration, can't be compared with the query
+	candidates := make([]Chunk, 0, len(idx.Chunks))
+	for _, chunk := range idx.Chunks {
+		if filter.Match(chunk) && (query == "" || idx.ChunkModel(chunk) == idx.Model) {
+			candidates = append(candidates, chunk)
+		}
+	}
+
+	results := make([]Result, 0, len(candidates))
+	if query == "" {
+		for _, chunk := range candidates {
+			results = append(results, Result{Chunk: chunk, Score: 1})
+		}
+		return limit(results, top), nil
+	}
+
+	// The query must be embedded by the model of the index, so there is no fallback
+	embeddings, err := client.GenerateEmbeddings(ctx, cfg, []client.EmbeddingInput{{Content: query, Filetype: "raw"}}, idx.Model)
+	if err != nil && client.Unreachable(err) && cfg.Rag.LocalEndpoint != "" {
+		return nil, fmt.Errorf("failed to embed query with %s, run `gh copilot index build` to index with the local model: %w", idx.Model, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	if len(embeddings) == 0 {
+		return nil, errors.New("received no embedding for the query")
+	}
+
+	// Large indexes are searched through their graph, unless the filter leaves few chunks. Diversity
+	// re-ranking picks from more of them.
+	opts := client.NewRankOptions(cfg)
+	if idx.graph != nil && filter == (Filter{}) && top > 0 {
+		found := top
+		if opts.Diversify() {
+			found = top * mmrCandidates
+		}
+		return rank(idx.searchGraph(embeddings[0].Embedding, found), opts, top), nil
+	}
+	for _, chunk := range candidates {
+		results = append(results, Result{
+			Chunk: chunk,
+			Score: client.CosineSimilarity(embeddings[0].Embedding, chunk.Embedding),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return rank(results, opts, top), nil
+}
+
+// rank drops the results, sorted by score, below the minimum score of the options, and returns the
+// top of them, picked by maximal marginal relevance when the options diversify.
+func rank(results []Result, opts client.RankOptions, top int) []Result {
+	kept := 0
+	for kept < len(results) && results[kept].Score >= opts.MinScore {
+		kept++
+	}
+	results = results[:kept]
+	if !opts.Diversify() {
+		return limit(results, top)
+	}
+
+	if top <= 0 {
+		return results // Picking is quadratic, all results would take too long
+	}
+	// Picking is quadratic, the candidates are the best few times as many as picked
+	results = limit(results, top*mmrCandidates)
+	scores := make([]float32, len(results))
+	vectors := make([][]float32, len(results))
+	for i, result := range results {
+		scores[i], vectors[i] = result.Score, result.Chunk.Embedding
+	}
+	picked := make([]Result, 0, top)
+	for _, i := range client.SelectMMR(scores, vectors, opts.MMRLambda, top) {
+		picked = append(picked, results[i])
+	}
+	return picked
+}
+
+// SearchFiles ranks the files of the index by the similarity of their best chunk to the query, returning at
+// most top files. These are the files retrieval attaches for the query.
+func SearchFiles(ctx context.Context, cfg config.Config, idx *Index, query string, top int) ([]FileResult, error) {
+	// All chunks are ranked, unless the graph or diversity re-ranking finds the best ones, among
+	// which files with several matching chunks mustn't crowd out the others
+	chunks := 0
+	if top > 0 && (idx.graph != nil || client.NewRankOptions(cfg).Diversify()) {
+		chunks = top * 10
+	}
+	results, err := Search(ctx, cfg, idx, query, Filter{}, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileResult
+	byPath := map[string]int{}
+	for _, result := range results {
+		i, ok := byPath[result.Chunk.Path]
+		if !ok {
+			if top > 0 && len(files) == top {
+				continue
+			}
+			i = len(files)
+			byPath[result.Chunk.Path] = i
+			files = append(files, FileResult{Path: result.Chunk.Path, Score: result.Score})
+		}
+		files[i].Chunks = append(files[i].Chunks, result)
+	}
+	return files, nil
+}
+
+// Match reports whether the chunk satisfies the filter.
+func (f Filter) Match(chunk Chunk) bool {
+	if f.Lang != "" && !strings.EqualFold(chunk.Filetype, f.Lang) {
+		return false
+	}
+	if f.Symbol == "" {
+		return true
+	}
+
+	pattern := strings.ToLower(f.Symbol)
+	for _, symbol := range chunk.Symbols {
+		if matched, _ := path.Match(pattern, strings.ToLower(symbol)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// limit truncates the results to at most top entries, when top is positive.
+func limit(results []Result, top int) []Result {
+	if top > 0 && len(results) > top {
+		return results[:top]
+	}
+	return results
+}
+
+// embedChunks generates the embeddings for the chunks in place, returning the model that embedded them,
+// the local one if Copilot's is unreachable.
+func embedChunks(ctx context.Context, cfg config.Config, model string, chunks []Chunk, progress func(done, total int)) (string, error) {
+	embeddings, model, err := client.EmbedWithFallback(ctx, cfg, chunkInputs(chunks), model, progress)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate embeddings: %w", err)
+	}
+
+	for _, embedding := range embeddings {
+		if embedding.Index >= 0 && embedding.Index < len(chunks) {
+			chunks[embedding.Index].Embedding = embedding.Embedding
+		}
+	}
+	for i := range chunks {
+		chunks[i].Model = model
+	}
+	return model, nil
+}
+
+// isIndexable checks whether a file has a known filetype and an indexable size.
+func isIndexable(root, file string) bool {
+	if filetype.FromPath(file) == "" {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(file)))
+	return err == nil && info.Mode().IsRegular() && info.Size() <= maxFileSize
+}
+
+// hashContent returns the hex SHA-256 of file content, used to detect changes since indexing.
+func hashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// readSource reads the content of a source file, skipping binary, empty, oversized, and unknown files.
+func readSource(root, file string) (string, bool, error) {
+	if !isIndexable(root, file) {
+		return "", false, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if bytes.IndexByte(data, 0) >= 0 || len(bytes.TrimSpace(data)) == 0 {
+		return "", false, nil
+	}
+	return string(data), true, nil
+}
+
+// listFiles returns the slash-separated paths of the files to index, relative to the root.
+// Inside a git repository only tracked and unignored files are listed.
+func listFiles(ctx context.Context, root string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	if out, err := cmd.Output(); err == nil {
+		var files []string
+		for file := range strings.SplitSeq(string(out), "\x00") {
+			if file != "" {
+				files = append(files, file)
+			}
+		}
+		return files, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	return files, nil
+}
+
+// getIndexPath retrieves the path of the stored index for a root directory.
+func getIndexPath(root string) (string, error) {
+	cacheDir, err := config.CachePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache path: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(cacheDir, indexDirName, hex.EncodeToString(sum[:8])+".json"), nil
+}
@@ -0,0 +1,117 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// migrateBatch is the number of chunks re-embedded between saves of the index.
+const migrateBatch = 64
+
+// ChunkModel returns the embedding model of the chunk, which is the index's model for chunks of
+// indexes that didn't record it per chunk.
+func (idx *Index) ChunkModel(chunk Chunk) string {
+	if chunk.Model == "" {
+		return idx.Model
+	}
+	return chunk.Model
+}
+
+// Mismatched counts the chunks embedded with other models than the index's, by model. Searches
+// skip them, as their vectors can't be compared with the query's.
+func (idx *Index) Mismatched() map[string]int {
+	mismatched := map[string]int{}
+	for _, chunk := range idx.Chunks {
+		if model := idx.ChunkModel(chunk); model != idx.Model {
+			mismatched[model]++
+		}
+	}
+	return mismatched
+}
+
+// Migrate re-embeds the chunks that aren't embedded with the model yet, saving the index after every
+// batch so an interrupted migration resumes where it stopped. Searches keep using the previous model
+// until all chunks are migrated, then the index switches to the model. It returns the number of
+// chunks re-embedded; progress is called after every batch.
+func Migrate(ctx context.Context, cfg config.Config, idx *Index, model string, progress func(done, total int)) (int, error) {
+	var pending []int
+	for i, chunk := range idx.Chunks {
+		if idx.ChunkModel(chunk) != model {
+			pending = append(pending, i)
+		}
+	}
+
+	// The graph is built over the vectors being replaced, and rebuilt when the index switches models
+	if len(pending) > 0 {
+		idx.graph, idx.graphChunks = nil, nil
+	}
+	for start := 0; start < len(pending); start += migrateBatch {
+		batch := pending[start:min(start+migrateBatch, len(pending))]
+		chunks := make([]Chunk, len(batch))
+		for i, n := range batch {
+			chunks[i] = idx.Chunks[n]
+			chunks[i].Embedding = nil
+		}
+
+		// The requested model is used as is, a fallback would mix vector spaces again
+		embeddings, err := client.GenerateEmbeddings(ctx, cfg, chunkInputs(chunks), model)
+		if err != nil {
+			return start, fmt.Errorf("failed to generate embeddings: %w", err)
+		}
+		for _, embedding := range embeddings {
+			if embedding.Index >= 0 && embedding.Index < len(chunks) {
+				chunks[embedding.Index].Embedding = embedding.Embedding
+			}
+		}
+		for i, n := range batch {
+			if len(chunks[i].Embedding) == 0 {
+				return start, fmt.Errorf("received no embedding for %s:%d", chunks[i].Path, chunks[i].StartLine)
+			}
+			chunks[i].Model = model
+			idx.Chunks[n] = chunks[i]
+		}
+
+		if err := idx.Save(); err != nil {
+			return start, err
+		}
+		progress(start+len(batch), len(pending))
+	}
+
+	if idx.Model != model {
+		idx.Model = model
+		idx.BuildGraph()
+		if err := idx.Save(); err != nil {
+			return len(pending), err
+		}
+	}
+	return len(pending), nil
+}
+
+// mismatchProblems describes the chunks embedded with other models than the index's, one problem per model.
+func (idx *Index) mismatchProblems() []string {
+	var problems []string
+	for model, count := range idx.Mismatched() {
+		problems = append(problems, fmt.Sprintf("%d chunks are embedded with %s instead of %s, "+
+			"run `gh copilot index migrate --model %s` to finish migrating them", count, model, idx.Model, model))
+	}
+	return problems
+}
+
+// chunkInputs converts the chunks to embedding inputs, whose indexes are the chunks' positions.
+func chunkInputs(chunks []Chunk) []client.EmbeddingInput {
+	inputs := make([]client.EmbeddingInput, len(chunks))
+	for i, chunk := range chunks {
+		inputs[i] = client.EmbeddingInput{
+			Filename:  chunk.Path,
+			Content:   chunk.Content,
+			Outline:   strings.Join(chunk.Symbols, "\n"),
+			Filetype:  chunk.Filetype,
+			StartLine: chunk.StartLine,
+		}
+	}
+	return inputs
+}
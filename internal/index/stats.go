@@ -0,0 +1,156 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Stats describes the size and freshness of a stored index.
+type Stats struct {
+	Root       string
+	Model      string
+	Created    time.Time
+	Files      int
+	Chunks     int
+	Dimensions int
+	DiskSize   int64
+	Stale      []string // Indexed files that changed or were deleted since indexing
+	Unindexed  []string // Indexable files that are missing from the index
+	Coverage   float64  // Percentage of the indexable files that are indexed and fresh
+	Graph      int      // Chunks searched through the search graph, 0 when searches compare all chunks
+}
+
+// Stats computes the statistics of the index against the current state of the repository.
+func (idx *Index) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{
+		Root:    idx.Root,
+		Model:   idx.Model,
+		Created: idx.Created,
+		Files:   len(idx.Files),
+		Chunks:  len(idx.Chunks),
+	}
+	if idx.graph != nil {
+		stats.Graph = idx.graph.Len()
+	}
+	for _, chunk := range idx.Chunks {
+		if idx.ChunkModel(chunk) == idx.Model {
+			stats.Dimensions = len(chunk.Embedding)
+			break
+		}
+	}
+
+	path, err := getIndexPath(idx.Root)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, file := range []string{path, getGraphPath(path)} {
+		if info, err := os.Stat(file); err == nil {
+			stats.DiskSize += info.Size()
+		}
+	}
+
+	files, err := listFiles(ctx, idx.Root)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	indexable := 0
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[file] = true
+		if !isIndexable(idx.Root, file) {
+			continue
+		}
+		indexable++
+		if _, ok := idx.Files[file]; !ok {
+			stats.Unindexed = append(stats.Unindexed, file)
+		}
+	}
+
+	for file, hash := range idx.Files {
+		if !present[file] {
+			stats.Stale = append(stats.Stale, file)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(idx.Root, filepath.FromSlash(file)))
+		if err != nil || hashContent(string(data)) != hash {
+			stats.Stale = append(stats.Stale, file)
+		}
+	}
+	sort.Strings(stats.Stale)
+
+	if indexable > 0 {
+		fresh := len(idx.Files) - len(stats.Stale)
+		stats.Coverage = math.Min(100, float64(fresh)*100/float64(indexable))
+	}
+	return stats, nil
+}
+
+// Verify checks the index for corruption, returning a description of every problem found.
+func (idx *Index) Verify() []string {
+	var problems []string
+
+	if idx.Model == "" {
+		problems = append(problems, "index has no embedding model recorded")
+	}
+
+	problems = append(problems, idx.mismatchProblems()...)
+	if idx.graphErr != nil {
+		problems = append(problems, fmt.Sprintf("search graph is unusable, searches compare all chunks: %v", idx.graphErr))
+	}
+
+	dimensions := map[string]int{} // By model, whose vectors may differ in size
+	chunkFiles := make(map[string]bool, len(idx.Files))
+	for i, chunk := range idx.Chunks {
+		name := fmt.Sprintf("chunk %d (%s:%d)", i, chunk.Path, chunk.StartLine)
+		chunkFiles[chunk.Path] = true
+
+		if chunk.Path == "" {
+			problems = append(problems, fmt.Sprintf("chunk %d has no path", i))
+		}
+		if _, ok := idx.Files[chunk.Path]; !ok {
+			problems = append(problems, name+" belongs to a file without a recorded hash")
+		}
+		if chunk.StartLine < 1 || chunk.EndLine < chunk.StartLine {
+			problems = append(problems, fmt.Sprintf("%s has an invalid line range %d-%d", name, chunk.StartLine, chunk.EndLine))
+		}
+
+		if len(chunk.Embedding) == 0 {
+			problems = append(problems, name+" has no embedding")
+			continue
+		}
+		model := idx.ChunkModel(chunk)
+		if dimensions[model] == 0 {
+			dimensions[model] = len(chunk.Embedding)
+		} else if len(chunk.Embedding) != dimensions[model] {
+			problems = append(problems, fmt.Sprintf("%s has %d dimensions, expected %d", name, len(chunk.Embedding), dimensions[model]))
+		}
+
+		zero := true
+		for _, v := range chunk.Embedding {
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				problems = append(problems, name+" has a non-finite embedding value")
+				break
+			}
+			if v != 0 {
+				zero = false
+			}
+		}
+		if zero {
+			problems = append(problems, name+" has an all-zero embedding")
+		}
+	}
+
+	for file := range idx.Files {
+		if !chunkFiles[file] {
+			problems = append(problems, fmt.Sprintf("file %s has no chunks", file))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
@@ -0,0 +1,117 @@
+package index
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// symbolPatterns match definitions in languages without a parser in the standard library.
+var symbolPatterns = map[string]*regexp.Regexp{
+	"python":     regexp.MustCompile(`(?m)^\s*(?:async\s+)?(?:def|class)\s+([A-Za-z_]\w*)`),
+	"javascript": regexp.MustCompile(`(?m)^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?(?:function\*?|class)\s+([A-Za-z_$][\w$]*)`),
+	"typescript": regexp.MustCompile(`(?m)^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?(?:async\s+)?(?:function\*?|class|interface|type|enum)\s+([A-Za-z_$][\w$]*)`),
+	"ruby":       regexp.MustCompile(`(?m)^\s*(?:def|class|module)\s+(?:self\.)?([A-Za-z_]\w*[?!]?)`),
+	"rust":       regexp.MustCompile(`(?m)^\s*(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?(?:fn|struct|enum|trait|type|mod)\s+([A-Za-z_]\w*)`),
+	"java":       regexp.MustCompile(`(?m)^\s*(?:(?:public|private|protected|static|final|abstract)\s+)*(?:class|interface|enum|record)\s+([A-Za-z_]\w*)`),
+	"lua":        regexp.MustCompile(`(?m)^\s*(?:local\s+)?function\s+([A-Za-z_][\w.:]*)`),
+	"sh":         regexp.MustCompile(`(?m)^\s*(?:function\s+)?([A-Za-z_][\w-]*)\s*\(\)`),
+}
+
+// definition is a definition in a source file, by the names it declares and the line it starts at,
+// including the comments above it.
+type definition struct {
+	Line  int // 1-based
+	Names []string
+}
+
+// extractDefinitions returns the definitions in the source, in order.
+func extractDefinitions(filetype, content string) []definition {
+	if filetype == "go" {
+		return extractGoDefinitions(content)
+	}
+
+	pattern, ok := symbolPatterns[filetype]
+	if !ok {
+		return nil
+	}
+
+	lines := strings.Split(content, "\n")
+	var defs []definition
+	for _, match := range pattern.FindAllStringSubmatchIndex(content, -1) {
+		// The pattern may match leading blank lines, the definition starts at its name's line
+		line := strings.Count(content[:match[2]], "\n") + 1
+		defs = append(defs, definition{
+			Line:  withComments(lines, line),
+			Names: []string{content[match[2]:match[3]]},
+		})
+	}
+	return defs
+}
+
+// extractGoDefinitions returns the functions, methods, types, and top-level values declared in Go source.
+func extractGoDefinitions(content string) []definition {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", content, parser.SkipObjectResolution|parser.ParseComments)
+	if file == nil || err != nil && len(file.Decls) == 0 {
+		return nil
+	}
+
+	var defs []definition
+	for _, decl := range file.Decls {
+		start := decl.Pos()
+		var names []string
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			names = append(names, d.Name.Name)
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if name.Name != "_" {
+							names = append(names, name.Name)
+						}
+					}
+				}
+			}
+		}
+		if len(names) > 0 {
+			defs = append(defs, definition{Line: fset.Position(start).Line, Names: names})
+		}
+	}
+	return defs
+}
+
+// commentPrefixes start the lines of comments, and of decorators and attributes, above a definition.
+var commentPrefixes = []string{"//", "#", "/*", "*", "--", "@"}
+
+// withComments returns the line of the first of the comment lines right above the 1-based line,
+// or the line itself without any.
+func withComments(lines []string, line int) int {
+	for line > 1 {
+		above := strings.TrimSpace(lines[line-2])
+		commented := false
+		for _, prefix := range commentPrefixes {
+			if strings.HasPrefix(above, prefix) {
+				commented = true
+				break
+			}
+		}
+		if !commented {
+			break
+		}
+		line--
+	}
+	return line
+}
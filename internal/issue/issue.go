@@ -0,0 +1,113 @@
+// Package issue helps triaging GitHub issues: it summarizes them, suggests labels, and drafts replies.
+package issue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/github"
+	"github.com/markis/gh-copilot/internal/render"
+	"golang.org/x/term"
+)
+
+// summarizePrompt asks for a triage summary of the issue.
+const summarizePrompt = `You help maintainers triage GitHub issues. Summarize the issue and its discussion in markdown:
+what is reported or asked, the steps to reproduce and the environment if given, what was found or decided in the
+comments, and what is still open or missing to act on it.`
+
+// labelsPrompt asks for labels of the repository that fit the issue.
+const labelsPrompt = `You help maintainers triage GitHub issues. Suggest the labels of the repository that fit the issue,
+only from the given list, each with a one-line reason, most fitting first. Mention labels the issue has that don't fit.`
+
+// replyPrompt asks for a reply to the issue on behalf of a maintainer.
+const replyPrompt = `You help maintainers triage GitHub issues. Draft the maintainer's next comment on the issue: thank
+the author where appropriate, answer questions, ask for what is missing to reproduce or act on it, and say what
+happens next. Don't promise fixes or dates. Keep it short and friendly. Reply only with the markdown of the comment.`
+
+// Run fetches the issue given as the first argument and summarizes it, suggests labels with
+// --labels, or drafts a reply with --reply. --post-comment posts the drafted reply after confirmation.
+func Run(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	if len(args.ActionArgs) == 0 {
+		return errors.New("issue needs an issue number or URL")
+	}
+	if args.Issue.PostComment && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("--post-comment asks before posting the reply, which needs a terminal")
+	}
+
+	issue, err := github.FetchIssue(ctx, args.ActionArgs[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Loaded issue #%d with %d comment(s)\n", issue.Number, len(issue.Comments))
+
+	prompt := issue.Markdown()
+	system := summarizePrompt
+	switch {
+	case args.Issue.Labels:
+		labels, err := github.FetchLabels(ctx)
+		if err != nil {
+			return err
+		}
+		if len(labels) == 0 {
+			return errors.New("the repository has no labels to suggest")
+		}
+		var list strings.Builder
+		for _, label := range labels {
+			if label.Description == "" {
+				fmt.Fprintf(&list, "- %s\n", label.Name)
+			} else {
+				fmt.Fprintf(&list, "- %s: %s\n", label.Name, label.Description)
+			}
+		}
+		prompt += "\n# Labels of the repository\n\n" + list.String()
+		system = labelsPrompt
+	case args.Issue.Reply || args.Issue.PostComment:
+		system = replyPrompt
+	}
+	if instructions := strings.TrimSpace(strings.Join(args.ActionArgs[1:], " ")); instructions != "" {
+		prompt += "\n" + instructions + "\n"
+	}
+
+	if !args.Issue.PostComment {
+		args.Prompts = []string{prompt}
+		args.System = strings.TrimSpace(args.System + "\n\n" + system)
+		args.Command = args.Action
+		args.Action = ""
+		return client.Ask(ctx, cfg, args)
+	}
+
+	reply, err := client.Complete(ctx, cfg, args.Model, []client.Message{
+		{Role: client.SystemRole, Content: strings.TrimSpace(args.System + "\n\n" + system)},
+		{Role: client.UserRole, Content: prompt},
+	})
+	if err != nil {
+		return err
+	}
+	reply = strings.TrimSpace(reply)
+	if err := render.RenderMarkdown(ctx, cfg, args, reply+"\n"); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Post this comment on issue #%d? [y/N]: ", issue.Number)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return nil
+	}
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+		return nil
+	}
+	url, err := github.CommentOnIssue(ctx, issue.Number, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Posted %s\n", url)
+	return nil
+}
@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envDebug enables debug logging: "1" or "true" logs to stderr, any other value is a log file path.
+const envDebug = "GH_COPILOT_DEBUG"
+
+// Redacted replaces secret values in logs.
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaders are the headers whose values are never logged.
+var sensitiveHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+	"X-Api-Key":     true,
+}
+
+type contextKey struct{}
+
+// discard is used when debug logging is disabled.
+var discard = slog.New(slog.DiscardHandler)
+
+// New creates the debug logger. Debug logging is enabled by the flag or the GH_COPILOT_DEBUG
+// environment variable and writes to the log file when one is given, or stderr otherwise.
+// The returned closer must be closed when the logger is no longer used.
+func New(debug bool, logFile string) (*slog.Logger, io.Closer, error) {
+	if env := os.Getenv(envDebug); env != "" {
+		if enabled, err := strconv.ParseBool(env); err == nil {
+			debug = debug || enabled
+		} else {
+			debug = true
+			if logFile == "" {
+				logFile = env
+			}
+		}
+	}
+
+	if !debug {
+		return discard, io.NopCloser(nil), nil
+	}
+
+	var (
+		w      io.Writer = os.Stderr
+		closer io.Closer = io.NopCloser(nil)
+		opts             = &slog.HandlerOptions{Level: slog.LevelDebug}
+	)
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		w, closer = file, file
+	}
+
+	return slog.New(slog.NewTextHandler(w, opts)), closer, nil
+}
+
+// WithLogger returns a context carrying the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or a logger that discards everything.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return discard
+}
+
+// Sensitive reports whether the values of the HTTP header are secrets, which are never logged.
+func Sensitive(header string) bool {
+	return sensitiveHeaders[http.CanonicalHeaderKey(header)]
+}
+
+// Headers formats HTTP headers for logging with secret values redacted.
+func Headers(headers http.Header) slog.Attr {
+	attrs := make([]any, 0, len(headers))
+	for name, values := range headers {
+		value := strings.Join(values, ", ")
+		if Sensitive(name) {
+			value = Redacted
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.Group("headers", attrs...)
+}
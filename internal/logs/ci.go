@@ -0,0 +1,85 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/github"
+	"github.com/markis/gh-copilot/internal/render"
+)
+
+// Explain downloads the logs of the failed jobs of a GitHub Actions workflow run, given by its ID
+// or URL, and renders the root cause of each job's failure.
+func Explain(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	if len(args.ActionArgs) == 0 {
+		return errors.New("ci explain needs a workflow run ID or URL")
+	}
+	repo, runID, jobID, err := github.ParseRun(args.ActionArgs[0])
+	if err != nil {
+		return err
+	}
+
+	jobs, err := github.FetchFailedJobs(ctx, repo, runID)
+	if err != nil {
+		return err
+	}
+	if jobID != 0 {
+		jobs = selectJob(jobs, jobID)
+		if len(jobs) == 0 {
+			return fmt.Errorf("job %d of workflow run %d didn't fail", jobID, runID)
+		}
+	}
+	if len(jobs) == 0 {
+		fmt.Fprintf(os.Stderr, "No failed jobs in the latest attempt of workflow run %d\n", runID)
+		return nil
+	}
+
+	question := strings.TrimSpace(strings.Join(args.ActionArgs[1:], " "))
+	if question == "" {
+		question = defaultQuestion
+	}
+	for _, job := range jobs {
+		fmt.Fprintf(os.Stderr, "Diagnosing job %q\n", job.Name)
+		log, err := github.FetchJobLog(ctx, repo, job.ID)
+		if err != nil {
+			return err
+		}
+
+		prompt := fmt.Sprintf("Job: %s\n", job.Name)
+		if len(job.FailedSteps) > 0 {
+			prompt += fmt.Sprintf("Failed steps: %s\n", strings.Join(job.FailedSteps, ", "))
+		}
+		prompt += "\nLog:\n````log\n" + Cut(fmt.Sprintf("the log of job %q", job.Name), Clean(log)) + "````\n\n" + question
+
+		answer, err := client.Complete(ctx, cfg, args.Model, []client.Message{
+			{Role: client.SystemRole, Content: strings.TrimSpace(args.System + "\n\n" + systemPrompt)},
+			{Role: client.UserRole, Content: prompt},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to diagnose job %q: %w", job.Name, err)
+		}
+		// The job's sections are nested under its heading
+		findings := strings.ReplaceAll("\n"+strings.TrimSpace(answer), "\n## ", "\n### ")
+		markdown := fmt.Sprintf("## %s\n\n%s\n%s\n\n", job.Name, job.URL, findings)
+		if err := render.RenderMarkdown(ctx, cfg, args, markdown); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectJob returns the job of the ID among the jobs, if it is one of them.
+func selectJob(jobs []github.Job, id int64) []github.Job {
+	for _, job := range jobs {
+		if job.ID == id {
+			return []github.Job{job}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Package logs prepares CI and build logs for root-cause analysis: it removes the noise of terminal
+// codes, timestamps, and repetition, and extracts the segments that look like errors.
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+const (
+	contextBefore = 3   // Lines kept before an error-looking line
+	contextAfter  = 8   // Lines kept after an error-looking line, e.g. for stack traces
+	tailLines     = 20  // Lines kept at the end of the log, which usually tell how it failed
+	maxLines      = 400 // Lines of the excerpt; the segments closest to the end are kept
+)
+
+// defaultQuestion is asked when the command has no question of its own.
+const defaultQuestion = "Why did this fail?"
+
+// systemPrompt asks for a root-cause analysis in a fixed structure.
+const systemPrompt = `You analyze CI and build logs to find the root cause of a failure.
+The log was cleaned up: timestamps and terminal codes are removed, repeated lines are collapsed, and only the
+segments that look like errors are kept, with the end of the log. Tell the first error that caused the others
+apart from its consequences. Reply in markdown with exactly these sections:
+
+## Cause
+The root cause in one or two sentences.
+
+## Evidence
+The log lines that show it, quoted verbatim in a code block.
+
+## Fix
+Concrete steps or a code change that fixes it. Say so if the log doesn't show enough to be sure.`
+
+// ansiCode matches terminal escape sequences, e.g. colors and cursor movement.
+var ansiCode = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// timestamp matches the timestamp at the start of a log line, e.g. of GitHub Actions, syslog, or a logger.
+var timestamp = regexp.MustCompile(`^(?:\[?\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?\]?` +
+	`|\[?\d{2}:\d{2}:\d{2}(?:[.,]\d+)?\]?)\s+`)
+
+// jobPrefix matches the job and step names `gh run view --log` puts before each line.
+var jobPrefix = regexp.MustCompile(`^[^\t]*\t[^\t]*\t(\d{4}-\d{2}-\d{2}T)`)
+
+// errorLine matches lines that look like an error, a failure, or the start of a stack trace.
+var errorLine = regexp.MustCompile(`(?i)\b(?:error|errors|err|fail|failed|failure|fatal|panic|exception|traceback|` +
+	`denied|refused|not found|undefined|unresolved|cannot|can't|unable to|timed out|timeout|segmentation fault|` +
+	`killed|oom|aborted)\b|##\[error\]|exit (?:code|status) [1-9]|^\s*(?:E|F)\s|^FAIL`)
+
+// Clean strips terminal codes, timestamps, and CI markup from the lines of the log, and collapses
+// runs of repeated lines.
+func Clean(log string) []string {
+	var lines []string
+	previous, repeats := "", 0
+	flush := func() {
+		if repeats > 0 {
+			lines = append(lines, fmt.Sprintf("(previous line repeated %d more times)", repeats))
+			repeats = 0
+		}
+	}
+
+	for line := range strings.Lines(strings.ReplaceAll(log, "\r\n", "\n")) {
+		line = strings.TrimRight(line, "\n")
+		if i := strings.LastIndexByte(line, '\r'); i >= 0 {
+			line = line[i+1:] // Progress bars overwrite the line, only the last state is visible
+		}
+		line = ansiCode.ReplaceAllString(line, "")
+		line = jobPrefix.ReplaceAllString(line, "$1")
+		line = timestamp.ReplaceAllString(line, "")
+		line = strings.TrimRight(line, " \t")
+		if strings.HasPrefix(line, "##[group]") || strings.HasPrefix(line, "##[endgroup]") {
+			continue
+		}
+
+		if line == previous && len(lines) > 0 {
+			repeats++
+			continue
+		}
+		flush()
+		lines = append(lines, line)
+		previous = line
+	}
+	flush()
+	return lines
+}
+
+// Excerpt returns the segments of the lines that look like errors, with some context, and the end
+// of the log, marking the lines left out, and the number of lines it kept. Logs without such
+// segments are kept whole, up to the maximum number of lines counted from their end.
+func Excerpt(lines []string) (string, int) {
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if errorLine.MatchString(line) {
+			for j := max(0, i-contextBefore); j <= min(len(lines)-1, i+contextAfter); j++ {
+				keep[j] = true
+			}
+		}
+	}
+	for i := max(0, len(lines)-tailLines); i < len(lines); i++ {
+		keep[i] = true
+	}
+
+	// The segments closest to the end are the most likely to show how it failed
+	kept := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		if keep[i] {
+			if kept == maxLines {
+				keep[i] = false
+				continue
+			}
+			kept++
+		}
+	}
+
+	var excerpt strings.Builder
+	omitted := 0
+	for i, line := range lines {
+		if !keep[i] {
+			omitted++
+			continue
+		}
+		if omitted > 0 {
+			fmt.Fprintf(&excerpt, "... (%d lines omitted)\n", omitted)
+			omitted = 0
+		}
+		excerpt.WriteString(line + "\n")
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&excerpt, "... (%d lines omitted)\n", omitted)
+	}
+	return excerpt.String(), kept
+}
+
+// Cut returns the excerpt of the lines of the log, noting on stderr when lines were left out.
+func Cut(name string, lines []string) string {
+	excerpt, kept := Excerpt(lines)
+	if kept < len(lines) {
+		client.NoteTruncated(name, kept, len(lines), "lines", "kept the segments that look like errors and the end")
+	}
+	return excerpt
+}
+
+// Run asks the model for the root cause of the failure in the piped log, with the question given
+// as arguments or a default one.
+func Run(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	if args.Stdin == "" {
+		return errors.New("logs reads the log from stdin, e.g. `gh run view --log-failed | gh copilot logs`")
+	}
+
+	question := strings.TrimSpace(strings.Join(args.ActionArgs, " "))
+	if question == "" {
+		question = defaultQuestion
+	}
+
+	excerpt := Cut("the piped input", Clean(args.Stdin))
+	args.Stdin = excerpt // Summarized instead when it is still too long
+	args.Prompts = []string{"Log:\n````log\n" + excerpt + "````", question}
+	args.System = strings.TrimSpace(args.System + "\n\n" + systemPrompt)
+	args.Command = args.Action
+	args.Action = ""
+	return client.Ask(ctx, cfg, args)
+}
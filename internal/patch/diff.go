@@ -0,0 +1,191 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	contextLines = 3       // Unchanged lines around each change
+	maxDiffCells = 4 << 20 // Largest table compared line by line, beyond it the changed region is replaced as a whole
+)
+
+// Diff compares two versions of a file, returning the changes as hunks with context.
+func Diff(path, old, new string) File {
+	oldLines, newLines := splitLines(old), splitLines(new)
+	ops := diffLines(oldLines, newLines)
+
+	file := File{OldPath: path, NewPath: path}
+	if old == "" {
+		file.OldPath = ""
+	}
+
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].Op == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// Start the hunk with the context before the change
+		start := max(0, i-contextLines)
+		hunk := Hunk{OldStart: oldLine - (i - start), NewStart: newLine - (i - start)}
+		hunk.Lines = append(hunk.Lines, ops[start:i]...)
+
+		// Extend it while the next change is close enough to share the context
+		end := i
+		for end < len(ops) {
+			if ops[end].Op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].Op == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*contextLines {
+				break
+			}
+			end = next
+		}
+
+		hunk.Lines = append(hunk.Lines, ops[i:end]...)
+		for _, line := range ops[i:end] {
+			if line.Op != '+' {
+				oldLine++
+			}
+			if line.Op != '-' {
+				newLine++
+			}
+		}
+		after := min(len(ops), end+contextLines)
+		hunk.Lines = append(hunk.Lines, ops[end:after]...)
+		oldLine += after - end
+		newLine += after - end
+		i = after
+
+		file.Hunks = append(file.Hunks, hunk)
+	}
+	return file
+}
+
+// String formats the file's changes as a unified diff.
+func (f File) String() string {
+	var buf strings.Builder
+	oldPath, newPath := "/dev/null", "/dev/null"
+	if f.OldPath != "" {
+		oldPath = "a/" + f.OldPath
+	}
+	if f.NewPath != "" {
+		newPath = "b/" + f.NewPath
+	}
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldPath, newPath)
+
+	for _, hunk := range f.Hunks {
+		oldCount, newCount := 0, 0
+		for _, line := range hunk.Lines {
+			if line.Op != '+' {
+				oldCount++
+			}
+			if line.Op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(hunk.OldStart, oldCount), hunkRange(hunk.NewStart, newCount))
+		for _, line := range hunk.Lines {
+			buf.WriteByte(line.Op)
+			buf.WriteString(line.Text)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String()
+}
+
+// hunkRange formats the start and length of a hunk's range, where an empty range starts before its line.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", max(0, start-1))
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// diffLines returns the edit script turning the old lines into the new ones, using the longest common subsequence.
+func diffLines(old, new []string) []Line {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]Line, 0, len(old)+len(new))
+	for _, line := range old[:prefix] {
+		ops = append(ops, Line{Op: ' ', Text: line})
+	}
+	ops = append(ops, diffMiddle(old[prefix:len(old)-suffix], new[prefix:len(new)-suffix])...)
+	for _, line := range old[len(old)-suffix:] {
+		ops = append(ops, Line{Op: ' ', Text: line})
+	}
+	return ops
+}
+
+// diffMiddle diffs the region between the common prefix and suffix.
+func diffMiddle(old, new []string) []Line {
+	var ops []Line
+	if len(old)*len(new) > maxDiffCells {
+		for _, line := range old {
+			ops = append(ops, Line{Op: '-', Text: line})
+		}
+		for _, line := range new {
+			ops = append(ops, Line{Op: '+', Text: line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of old[i:] and new[j:]
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			ops = append(ops, Line{Op: ' ', Text: old[i]})
+			i++
+			j++
+		case j < len(new) && (i == len(old) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, Line{Op: '+', Text: new[j]})
+			j++
+		default:
+			ops = append(ops, Line{Op: '-', Text: old[i]})
+			i++
+		}
+	}
+	return ops
+}
+
+// splitLines splits content into lines, ignoring the final newline.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
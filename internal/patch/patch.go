@@ -0,0 +1,274 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxFuzz is how far from its stated position a hunk is searched for, as models often get line numbers wrong.
+const maxFuzz = 1000
+
+// regionMargin is how many lines before and after a hunk that doesn't match are quoted from the file.
+const regionMargin = 10
+
+// ErrNoHunks is returned when the diff contains no hunks.
+var ErrNoHunks = errors.New("diff contains no hunks")
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// File is the diff of a single file.
+type File struct {
+	OldPath string // Path before the change, empty when the diff has no file headers
+	NewPath string
+	Hunks   []Hunk
+}
+
+// Hunk is a contiguous change of a file.
+type Hunk struct {
+	Header   string // The @@ line
+	OldStart int    // 1-based line the hunk starts at in the original file, 0 if unknown
+	NewStart int    // 1-based line the hunk starts at in the changed file, 0 if unknown
+	Lines    []Line
+}
+
+// HunkError is returned when a hunk doesn't match the file, with the lines of the file where it
+// belongs, so the model can be shown what is actually there.
+type HunkError struct {
+	Hunk   Hunk
+	Start  int      // 1-based line of the file the region starts at
+	Region []string // Lines of the file around where the hunk was expected
+}
+
+func (e *HunkError) Error() string {
+	return fmt.Sprintf("hunk %q does not match the file", e.Hunk.Header)
+}
+
+// Line is a line of a hunk.
+type Line struct {
+	Op   byte // ' ' for context, '-' for removed, '+' for added
+	Text string
+}
+
+// Parse parses a unified diff. It is lenient with the mistakes models make: hunk line counts
+// are ignored, blank lines are taken as blank context, and file headers are optional.
+func Parse(diff string) ([]File, error) {
+	var files []File
+	var file *File
+	var hunk *Hunk
+
+	flushHunk := func() {
+		if hunk != nil && file != nil {
+			file.Hunks = append(file.Hunks, *hunk)
+		}
+		hunk = nil
+	}
+
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "--- ") && (hunk == nil || isFileHeader(lines, i)):
+			flushHunk()
+			files = append(files, File{OldPath: headerPath(line[4:])})
+			file = &files[len(files)-1]
+		case strings.HasPrefix(line, "+++ ") && file != nil && hunk == nil && file.NewPath == "":
+			file.NewPath = headerPath(line[4:])
+		case strings.HasPrefix(line, "@@"):
+			flushHunk()
+			if file == nil {
+				files = append(files, File{})
+				file = &files[len(files)-1]
+			}
+			hunk = &Hunk{Header: line}
+			if match := hunkHeader.FindStringSubmatch(line); match != nil {
+				hunk.OldStart, _ = strconv.Atoi(match[1])
+				hunk.NewStart, _ = strconv.Atoi(match[3])
+			}
+		case hunk == nil:
+			continue // Text before the first hunk, e.g. "diff --git" lines
+		case line == "":
+			hunk.Lines = append(hunk.Lines, Line{Op: ' '})
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			hunk.Lines = append(hunk.Lines, Line{Op: line[0], Text: line[1:]})
+		case line[0] == '\\':
+			continue // "\ No newline at end of file"
+		default:
+			return nil, fmt.Errorf("invalid line in hunk %q: %q", hunk.Header, line)
+		}
+	}
+	flushHunk()
+
+	// Trailing blank lines are the end of the diff, not context
+	for i := range files {
+		for j := range files[i].Hunks {
+			h := &files[i].Hunks[j]
+			for len(h.Lines) > 0 && h.Lines[len(h.Lines)-1] == (Line{Op: ' '}) {
+				h.Lines = h.Lines[:len(h.Lines)-1]
+			}
+		}
+	}
+
+	count := 0
+	for _, f := range files {
+		count += len(f.Hunks)
+	}
+	if count == 0 {
+		return nil, ErrNoHunks
+	}
+	return files, nil
+}
+
+// Apply applies the file's hunks to its original content, returning the changed content.
+// Each hunk is located by its context, starting from its stated position.
+func (f File) Apply(content string) (string, error) {
+	trailingNewline := strings.HasSuffix(content, "\n")
+	lines := splitLines(content)
+
+	offset := 0 // Shift of the line numbers caused by the previous hunks
+	searchFrom := 0
+	for _, hunk := range f.Hunks {
+		old, replacement := hunk.split()
+
+		expected := max(hunk.OldStart-1+offset, searchFrom)
+		if hunk.OldStart == 0 {
+			expected = searchFrom
+		}
+		at := find(lines, old, expected, searchFrom)
+		if at < 0 {
+			return "", hunkError(hunk, lines, old, expected)
+		}
+
+		lines = append(lines[:at:at], append(replacement, lines[at+len(old):]...)...)
+		offset += len(replacement) - len(old)
+		searchFrom = at + len(replacement)
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+	result := strings.Join(lines, "\n")
+	if trailingNewline || content == "" {
+		result += "\n"
+	}
+	return result, nil
+}
+
+// Stats counts the added and removed lines of the file's hunks.
+func (f File) Stats() (added, removed int) {
+	for _, hunk := range f.Hunks {
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case '+':
+				added++
+			case '-':
+				removed++
+			}
+		}
+	}
+	return added, removed
+}
+
+// String returns the hunk as it appears in a unified diff.
+func (h Hunk) String() string {
+	var b strings.Builder
+	b.WriteString(h.Header + "\n")
+	for _, line := range h.Lines {
+		b.WriteString(string(line.Op) + line.Text + "\n")
+	}
+	return b.String()
+}
+
+// split returns the lines the hunk expects in the original file and the lines replacing them.
+func (h Hunk) split() (old, replacement []string) {
+	for _, line := range h.Lines {
+		if line.Op != '+' {
+			old = append(old, line.Text)
+		}
+		if line.Op != '-' {
+			replacement = append(replacement, line.Text)
+		}
+	}
+	return old, replacement
+}
+
+// find locates the lines in the content, searching outwards from the expected position without
+// going before min. Exact matches win over matches ignoring trailing whitespace.
+func find(lines, want []string, expected, min int) int {
+	for _, equal := range []func(a, b string) bool{
+		func(a, b string) bool { return a == b },
+		func(a, b string) bool { return strings.TrimRight(a, " \t") == strings.TrimRight(b, " \t") },
+	} {
+		for delta := 0; delta <= maxFuzz; delta++ {
+			for _, at := range []int{expected - delta, expected + delta} {
+				if at >= min && at+len(want) <= len(lines) && matches(lines[at:], want, equal) {
+					return at
+				}
+				if delta == 0 {
+					break
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// hunkError quotes the region of the lines where the hunk belongs: around the line nearest to its
+// expected position that matches one of its original lines, as models tend to get a few of them
+// wrong, or else around the expected position.
+func hunkError(hunk Hunk, lines, old []string, expected int) *HunkError {
+	distance := func(at int) int { return max(at-expected, expected-at) }
+	anchor := -1
+	for i, want := range old {
+		want = strings.TrimSpace(want)
+		if want == "" {
+			continue
+		}
+		for at, line := range lines {
+			if strings.TrimSpace(line) == want && (anchor < 0 || distance(at-i) < distance(anchor)) {
+				anchor = at - i
+			}
+		}
+	}
+	if anchor < 0 {
+		anchor = expected
+	}
+	anchor = min(max(anchor, 0), len(lines))
+
+	start := max(anchor-regionMargin, 0)
+	end := min(anchor+len(old)+regionMargin, len(lines))
+	return &HunkError{Hunk: hunk, Start: start + 1, Region: lines[start:end]}
+}
+
+// matches checks if the lines start with the wanted lines.
+func matches(lines, want []string, equal func(a, b string) bool) bool {
+	for i, line := range want {
+		if !equal(lines[i], line) {
+			return false
+		}
+	}
+	return true
+}
+
+// isFileHeader distinguishes a "--- path" file header in the middle of a diff from a removed
+// line starting with "-- ", by the "+++ path" header that must follow it.
+func isFileHeader(lines []string, i int) bool {
+	return i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+}
+
+// headerPath extracts the path from a file header, dropping the a/ or b/ prefix and any timestamp.
+func headerPath(header string) string {
+	path, _, _ := strings.Cut(header, "\t")
+	path = strings.TrimSpace(path)
+	if path == "/dev/null" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(path, "a/"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(path, "b/"); ok {
+		return rest
+	}
+	return path
+}
@@ -0,0 +1,52 @@
+package patch
+
+import (
+	"regexp"
+	"strings"
+)
+
+// word matches a word with the whitespace following it.
+var word = regexp.MustCompile(`\S+\s*`)
+
+// DiffWords returns the edit script turning the old text into the new one word by word. Words are
+// compared without their whitespace; each Line is a word with the whitespace following it.
+func DiffWords(old, new string) []Line {
+	oldWords, newWords := splitWords(old), splitWords(new)
+	oldKeys, newKeys := trimWords(oldWords), trimWords(newWords)
+
+	ops := diffLines(oldKeys, newKeys)
+	i, j := 0, 0
+	for k := range ops {
+		switch ops[k].Op {
+		case ' ':
+			ops[k].Text = newWords[j] // The new text's layout wins
+			i++
+			j++
+		case '+':
+			ops[k].Text = newWords[j]
+			j++
+		case '-':
+			ops[k].Text = oldWords[i]
+			i++
+		}
+	}
+	return ops
+}
+
+// splitWords splits text into its words, keeping the whitespace after each and before the first.
+func splitWords(text string) []string {
+	words := word.FindAllString(text, -1)
+	if lead := len(text) - len(strings.TrimLeft(text, " \t\r\n")); lead > 0 && len(words) > 0 {
+		words[0] = text[:lead] + words[0]
+	}
+	return words
+}
+
+// trimWords returns the words without their whitespace.
+func trimWords(words []string) []string {
+	trimmed := make([]string, len(words))
+	for i, w := range words {
+		trimmed[i] = strings.TrimSpace(w)
+	}
+	return trimmed
+}
@@ -0,0 +1,144 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/formatter"
+)
+
+// Format is the name of the post-processor that runs the configured code formatters.
+const Format = "format"
+
+// Options configures the post-processors.
+type Options struct {
+	Formatters map[string]string // Formatter commands by code block language
+}
+
+// Processor transforms the final answer before it is rendered or extracted.
+type Processor func(ctx context.Context, answer string, opts Options) (string, error)
+
+// builtins maps the names usable in the `post_process` config to their processors.
+var builtins = map[string]Processor{
+	"trim-fences":        trimFences,
+	"dos2unix":           dos2unix,
+	"gofmt-on-go-blocks": gofmtBlocks,
+	"prettier-on-json":   prettierJSONBlocks,
+	Format:               formatBlocks,
+
+	// Short names of the above
+	"gofmt":         gofmtBlocks,
+	"prettier-json": prettierJSONBlocks,
+}
+
+// jsonParsers are the prettier parsers of the languages of JSON code blocks.
+var jsonParsers = map[string]string{
+	"json":  "json",
+	"jsonc": "json", // The json parser keeps comments
+	"json5": "json5",
+}
+
+// Validate checks that all the named processors exist.
+func Validate(names []string) error {
+	for _, name := range names {
+		if _, ok := builtins[name]; !ok {
+			return fmt.Errorf("unknown post-processor: %s", name)
+		}
+	}
+	return nil
+}
+
+// Apply runs the named processors over the answer, in order.
+func Apply(ctx context.Context, names []string, answer string, opts Options) (string, error) {
+	if err := Validate(names); err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		result, err := builtins[name](ctx, answer, opts)
+		if err != nil {
+			return "", fmt.Errorf("post-processor %s failed: %w", name, err)
+		}
+		answer = result
+	}
+	return answer, nil
+}
+
+// trimFences strips the fence markers when the whole answer is a single code block,
+// so the output can be used as-is.
+func trimFences(_ context.Context, answer string, _ Options) (string, error) {
+	blocks := codeblock.Parse(answer)
+	if len(blocks) != 1 {
+		return answer, nil
+	}
+
+	block := blocks[0]
+	if strings.TrimSpace(answer[:block.Start]) != "" || strings.TrimSpace(answer[block.End:]) != "" {
+		return answer, nil
+	}
+	return block.Code + "\n", nil
+}
+
+// dos2unix converts CRLF line endings to LF.
+func dos2unix(_ context.Context, answer string, _ Options) (string, error) {
+	return strings.ReplaceAll(answer, "\r\n", "\n"), nil
+}
+
+// gofmtBlocks formats Go code blocks with gofmt, leaving blocks that don't parse untouched.
+// The configured formatters don't apply, gofmt is what was asked for.
+func gofmtBlocks(ctx context.Context, answer string, _ Options) (string, error) {
+	return codeblock.Replace(answer, func(block codeblock.Block) string {
+		original := answer[block.Start:block.End]
+		if formatter.Canonical(block.Lang) != "go" {
+			return original
+		}
+
+		formatted, err := formatter.Format(ctx, nil, block.Lang, block.Code)
+		if err != nil {
+			return original
+		}
+		return codeblock.Format(block, formatted)
+	}), nil
+}
+
+// prettierJSONBlocks formats JSON, JSONC, and JSON5 code blocks with prettier when it is
+// installed, falling back to the standard library indenter, which only takes plain JSON.
+func prettierJSONBlocks(ctx context.Context, answer string, _ Options) (string, error) {
+	prettier, _ := exec.LookPath("prettier")
+
+	return codeblock.Replace(answer, func(block codeblock.Block) string {
+		original := answer[block.Start:block.End]
+		parser, ok := jsonParsers[strings.ToLower(block.Lang)]
+		if !ok {
+			return original
+		}
+
+		if prettier != "" {
+			cmd := exec.CommandContext(ctx, prettier, "--parser", parser)
+			cmd.Stdin = strings.NewReader(block.Code)
+			if out, err := cmd.Output(); err == nil {
+				return codeblock.Format(block, string(out))
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(block.Code), "", "  "); err != nil {
+			return original
+		}
+		return codeblock.Format(block, buf.String())
+	}), nil
+}
+
+// formatBlocks formats code blocks with the formatter configured for their language.
+func formatBlocks(ctx context.Context, answer string, opts Options) (string, error) {
+	return formatter.FormatBlocks(ctx, opts.Formatters, answer), nil
+}
@@ -0,0 +1,122 @@
+// Package pr drafts answers to the review of a pull request.
+package pr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/github"
+	"github.com/markis/gh-copilot/internal/render"
+	"golang.org/x/term"
+)
+
+// replyPrompt asks for a reply to a review thread on behalf of the user.
+const replyPrompt = `You draft replies to code review threads on a pull request on behalf of @%s.
+You are given the diff hunk the thread is about and the thread's comments, oldest first. Draft the next reply of
+@%s: answer the open questions, agree with valid points and say how they will be addressed, or explain
+politely with reasons why the code is right as it is. Suggest code in a ` + "```suggestion" + ` block when it helps.
+Don't claim that a change was already made. Keep it short and in the tone of the thread. Reply only with the
+markdown of the reply.`
+
+// Replies drafts a reply to each unresolved review thread of the pull request the user takes part
+// in, unless the user wrote its last comment. The drafts are printed for copying, or posted one by
+// one after confirmation with --post.
+func Replies(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	number := args.PR.Number
+	if number <= 0 {
+		return errors.New("pr replies needs a pull request, e.g. --pr 123")
+	}
+	post := args.PR.Post
+	if post && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("--post asks before posting each reply, which needs a terminal")
+	}
+
+	unresolved, viewer, err := github.FetchUnresolvedThreads(ctx, number)
+	if err != nil {
+		return err
+	}
+	// Threads the user replied to last wait for the reviewer, and those outside the scope are left out
+	var threads []github.ReviewThread
+	for _, thread := range unresolved {
+		if thread.Comments[len(thread.Comments)-1].Author != viewer && args.Scope.ContainsRepoPath(thread.Path) {
+			threads = append(threads, thread)
+		}
+	}
+	if len(threads) == 0 {
+		fmt.Fprintf(os.Stderr, "No unresolved review threads waiting for your reply on pull request #%d\n", number)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Drafting replies to %d unresolved review thread(s) of pull request #%d\n", len(threads), number)
+
+	input := bufio.NewReader(os.Stdin)
+	for _, thread := range threads {
+		reply, err := Draft(ctx, cfg, args.Model, viewer, thread)
+		if err != nil {
+			return err
+		}
+		if err := render.RenderMarkdown(ctx, cfg, args, threadMarkdown(thread, reply)); err != nil {
+			return err
+		}
+		if !post {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "Post this reply to the thread on %s:%d? [y]es, [n]o, [q]uit: ", thread.Path, thread.Line)
+		line, err := input.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return nil // EOF posts none of the remaining replies
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			url, err := github.ReplyToThread(ctx, thread.ID, reply)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Posted %s\n", url)
+		case "q", "quit":
+			return nil
+		}
+	}
+	return nil
+}
+
+// Draft asks the model for the next reply of the viewer to the review thread, with its diff hunk as context.
+func Draft(ctx context.Context, cfg config.Config, model, viewer string, thread github.ReviewThread) (string, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "File %s, line %d:\n\n```diff\n%s\n```\n\nThread:\n\n", thread.Path, thread.Line,
+		strings.TrimSpace(thread.DiffHunk))
+	for _, c := range thread.Comments {
+		fmt.Fprintf(&prompt, "@%s:\n%s\n\n", c.Author, strings.TrimSpace(c.Body))
+	}
+
+	reply, err := client.Complete(ctx, cfg, model, []client.Message{
+		{Role: client.SystemRole, Content: fmt.Sprintf(replyPrompt, viewer, viewer)},
+		{Role: client.UserRole, Content: prompt.String()},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to draft a reply to the thread on %s:%d: %w", thread.Path, thread.Line, err)
+	}
+	return strings.TrimSpace(reply), nil
+}
+
+// threadMarkdown formats the thread's last comment and the drafted reply for review.
+func threadMarkdown(thread github.ReviewThread, reply string) string {
+	last := thread.Comments[len(thread.Comments)-1]
+	var b strings.Builder
+	fmt.Fprintf(&b, "## `%s:%d`\n\n", thread.Path, thread.Line)
+	fmt.Fprintf(&b, "%d comment(s) by %s, the last by @%s:\n\n", len(thread.Comments),
+		"@"+strings.Join(thread.Participants(), ", @"), last.Author)
+	for line := range strings.Lines(strings.TrimSpace(last.Body)) {
+		b.WriteString("> " + line)
+	}
+	fmt.Fprintf(&b, "\n\n### Suggested reply\n\n%s\n\n", reply)
+	return b.String()
+}
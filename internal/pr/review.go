@@ -0,0 +1,178 @@
+package pr
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/github"
+	"github.com/markis/gh-copilot/internal/render"
+	"golang.org/x/term"
+)
+
+// reviewPrompt asks for the findings of a review as JSON, so they can be posted as inline comments.
+const reviewPrompt = `You review pull requests. Find bugs, security issues, missing error handling, and unclear code in
+the changes; leave out praise, nitpicks about style, and what linters catch. The diff shows the line number of each
+line of the changed file before it. Reply only with a JSON array in a ` + "```json" + ` code block, with one object per
+finding: {"path": "<file as in the diff>", "line": <line number of the changed file>, "message": "<the finding and
+how to fix it, in markdown>"}. Reply with [] when there is nothing to report.`
+
+// hunkStart matches a hunk header, capturing the first line of the changed file.
+var hunkStart = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// Finding is an issue the model found in a pull request, on a line of a changed file.
+type Finding struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Review asks the model to review the changes of the pull request and renders its findings. With
+// --post, they are posted after confirmation as a draft review, with an inline comment for each
+// finding on a line of the diff; the others go to the review's body.
+func Review(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	number := args.PR.Number
+	if number <= 0 {
+		return errors.New("pr review needs a pull request, e.g. --pr 123")
+	}
+	if args.PR.Post && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("--post asks before posting the review, which needs a terminal")
+	}
+
+	pr, err := github.FetchPullRequest(ctx, number)
+	if err != nil {
+		return err
+	}
+	pr.Confine(args.Scope)
+	diff, commentable := annotateDiff(pr.Diff)
+	if strings.TrimSpace(pr.Diff) == "" {
+		fmt.Fprintf(os.Stderr, "Pull request #%d has no changes to review\n", number)
+		return nil
+	}
+
+	prompt := fmt.Sprintf("# Pull request #%d: %s\n\n%s\n\n## Diff\n\n````diff\n%s````\n",
+		pr.Number, pr.Title, strings.TrimSpace(pr.Body), diff)
+	if instructions := strings.TrimSpace(strings.Join(append(args.ActionArgs, args.Prompts...), "\n\n")); instructions != "" {
+		prompt += "\n" + instructions + "\n"
+	}
+	answer, err := client.Complete(ctx, cfg, args.Model, []client.Message{
+		{Role: client.SystemRole, Content: reviewPrompt},
+		{Role: client.UserRole, Content: prompt},
+	})
+	if err != nil {
+		return err
+	}
+	findings, err := parseFindings(answer)
+	if err != nil {
+		return err
+	}
+
+	var inline []github.DraftComment
+	var other []Finding
+	var report strings.Builder
+	fmt.Fprintf(&report, "## Review of #%d at %.7s\n\n", pr.Number, pr.HeadSHA)
+	for _, f := range findings {
+		location := fmt.Sprintf("%s:%d", f.Path, f.Line)
+		if commentable[f.Path][f.Line] {
+			inline = append(inline, github.DraftComment{Path: f.Path, Line: f.Line, Side: "RIGHT", Body: f.Message})
+		} else {
+			other = append(other, f)
+			location += ", not a line of the diff"
+		}
+		fmt.Fprintf(&report, "- `%s`: %s\n", location, strings.TrimSpace(f.Message))
+	}
+	if len(findings) == 0 {
+		report.WriteString("No findings.\n")
+	}
+	if err := render.RenderMarkdown(ctx, cfg, args, report.String()); err != nil {
+		return err
+	}
+	if !args.PR.Post || len(findings) == 0 {
+		return nil
+	}
+
+	if !confirm(fmt.Sprintf("Create a draft review of #%d with %d inline comment(s)? [y/N]: ", number, len(inline))) {
+		return nil
+	}
+	var body strings.Builder
+	for _, f := range other {
+		fmt.Fprintf(&body, "- `%s:%d`: %s\n", f.Path, f.Line, strings.TrimSpace(f.Message))
+	}
+	url, err := github.CreateDraftReview(ctx, number, pr.HeadSHA, body.String(), inline)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created the draft review, submit it at %s\n", url)
+	return nil
+}
+
+// annotateDiff prefixes the lines of a git diff with their line number in the changed file, and
+// returns the lines that can be commented on, by path: those of the changed file in the hunks.
+func annotateDiff(diff string) (string, map[string]map[int]bool) {
+	commentable := map[string]map[int]bool{}
+	var b strings.Builder
+	path, line := "", 0
+	inHunk := false
+	for text := range strings.Lines(diff) {
+		text = strings.TrimSuffix(text, "\n")
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			inHunk = false
+		case !inHunk && strings.HasPrefix(text, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@"):
+			if match := hunkStart.FindStringSubmatch(text); match != nil {
+				line, _ = strconv.Atoi(match[1])
+				inHunk = true
+			}
+		case inHunk && path != "/dev/null" && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "+") || text == ""):
+			if commentable[path] == nil {
+				commentable[path] = map[int]bool{}
+			}
+			commentable[path][line] = true
+			fmt.Fprintf(&b, "%5d %s\n", line, text)
+			line++
+			continue
+		}
+		fmt.Fprintf(&b, "      %s\n", text)
+	}
+	return b.String(), commentable
+}
+
+// parseFindings extracts the findings from the JSON array of the answer, fenced or not.
+func parseFindings(answer string) ([]Finding, error) {
+	data := answer
+	for _, block := range codeblock.Parse(answer) {
+		if block.Lang == "json" || block.Lang == "" {
+			data = block.Code
+			break
+		}
+	}
+	var findings []Finding
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &findings); err != nil {
+		return nil, fmt.Errorf("failed to parse the findings of the review: %w", err)
+	}
+	return findings, nil
+}
+
+// confirm asks the question on stderr and reports whether it was answered with yes.
+func confirm(question string) bool {
+	fmt.Fprint(os.Stderr, question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
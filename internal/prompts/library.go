@@ -0,0 +1,95 @@
+package prompts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// validName matches the names prompts may have, which are those of their commands.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// ValidName reports whether the name can be that of a prompt: letters, digits, dashes, and
+// underscores, starting with a letter or digit.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Fetch downloads a shared prompt file, YAML with named prompts like those of prompts.d, over
+// HTTPS, and parses it.
+func Fetch(ctx context.Context, cfg config.Config, url string) (config.Prompts, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("%s is not an https:// URL", url)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpClient := &http.Client{
+		Timeout: cfg.Http.HttpClientTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to %s, which is not https", req.URL)
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status code %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPackFile+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	if len(data) > maxPackFile {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxPackFile)
+	}
+
+	prompts, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt file %s: %w", url, err)
+	}
+	return prompts, nil
+}
+
+// Parse parses YAML with named prompts, rejecting unknown keys, which would otherwise be silently
+// ignored, invalid names, and empty prompts.
+func Parse(data []byte) (config.Prompts, error) {
+	var prompts config.Prompts
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&prompts); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, errors.New("no prompts")
+	}
+
+	for name, prompt := range prompts {
+		if !ValidName(name) {
+			return nil, fmt.Errorf("invalid prompt name %q", name)
+		}
+		if strings.TrimSpace(prompt.Prompt) == "" {
+			return nil, fmt.Errorf("prompt %s is empty", name)
+		}
+	}
+	return prompts, nil
+}
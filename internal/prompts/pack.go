@@ -0,0 +1,367 @@
+package prompts
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/github"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	lockFile      = "packs.lock"
+	packPromptDir = "prompts" // Prompt files of a pack, the repository root when it has none
+	maxPackFile   = 1 << 20
+	maxPackFiles  = 256
+)
+
+// ErrChecksumMismatch is returned when the prompts fetched for a locked pack differ from the ones locked.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrNotInstalled is returned when updating a pack that isn't installed.
+var ErrNotInstalled = errors.New("prompt pack not installed")
+
+// skippedFiles are markdown files of a pack repository that aren't prompts.
+var skippedFiles = map[string]bool{
+	"readme.md":       true,
+	"changelog.md":    true,
+	"license.md":      true,
+	"contributing.md": true,
+	"security.md":     true,
+}
+
+// Pack is an installed prompt pack, as pinned in the lock file.
+type Pack struct {
+	Source    string    `yaml:"source"`           // Repository, e.g. github.com/org/prompt-pack
+	Pinned    string    `yaml:"pinned,omitempty"` // Version asked for, empty to follow the latest release
+	Version   string    `yaml:"version"`          // Tag or branch installed
+	Commit    string    `yaml:"commit"`           // Commit installed
+	Checksum  string    `yaml:"checksum"`         // sha256 of the installed prompt files
+	Files     []string  `yaml:"files"`            // Installed prompt files
+	Installed time.Time `yaml:"installed"`
+}
+
+// lock is the structure of the lock file, which pins the installed packs.
+type lock struct {
+	Packs []Pack `yaml:"packs"`
+}
+
+// Install fetches a prompt pack, "github.com/org/repo" optionally followed by "@version", into the
+// prompts directory. A pack that is locked at the version is installed from the locked commit, and
+// must match the locked checksum.
+func Install(ctx context.Context, source string) (Pack, error) {
+	repo, version := parseSource(source)
+	if strings.Count(repo, "/") != 1 {
+		return Pack{}, fmt.Errorf("invalid prompt pack %q: use github.com/owner/repo[@version]", source)
+	}
+
+	l, err := loadLock()
+	if err != nil {
+		return Pack{}, err
+	}
+	if locked, ok := l.find(repo); ok && locked.Pinned == version {
+		return install(ctx, l, repo, locked.Pinned, locked.Version, locked.Commit, locked.Checksum)
+	}
+	return resolveAndInstall(ctx, l, repo, version)
+}
+
+// Update installs the latest version of the installed packs, or of the named one, returning the packs
+// before and after. Packs pinned to a version are updated when the version's tag or branch moved.
+func Update(ctx context.Context, name string) (before, after []Pack, err error) {
+	l, err := loadLock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	packs := l.Packs
+	if name != "" {
+		repo, _ := parseSource(name)
+		pack, ok := l.find(repo)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNotInstalled, name)
+		}
+		packs = []Pack{pack}
+	}
+
+	for _, pack := range packs {
+		updated, err := resolveAndInstall(ctx, l, repoOf(pack.Source), pack.Pinned)
+		if err != nil {
+			return before, after, err
+		}
+		before, after = append(before, pack), append(after, updated)
+	}
+	return before, after, nil
+}
+
+// resolveAndInstall resolves the version to its current commit and installs it, locking its checksum.
+func resolveAndInstall(ctx context.Context, l *lock, repo, pinned string) (Pack, error) {
+	version := pinned
+	if version == "" {
+		latest, err := github.LatestVersion(ctx, repo)
+		if err != nil {
+			return Pack{}, err
+		}
+		version = latest
+	}
+
+	commit, err := github.ResolveCommit(ctx, repo, version)
+	if err != nil {
+		return Pack{}, err
+	}
+	return install(ctx, l, repo, pinned, version, commit, "")
+}
+
+// install downloads the pack at the commit into its directory, replacing an earlier version, and locks it.
+// When a checksum is given, the prompt files must match it.
+func install(ctx context.Context, l *lock, repo, pinned, version, commit, checksum string) (Pack, error) {
+	tarball, err := github.Tarball(ctx, repo, commit)
+	if err != nil {
+		return Pack{}, err
+	}
+	files, err := extractPrompts(tarball)
+	if err != nil {
+		return Pack{}, fmt.Errorf("failed to extract %s: %w", repo, err)
+	}
+	if len(files) == 0 {
+		return Pack{}, fmt.Errorf("%s at %s contains no prompt files", repo, version)
+	}
+	for name, data := range files {
+		var prompts config.Prompts
+		if !strings.EqualFold(path.Ext(name), ".md") && yaml.Unmarshal(data, &prompts) != nil {
+			return Pack{}, fmt.Errorf("%s at %s has an invalid prompt file: %s", repo, version, name)
+		}
+	}
+
+	sum := checksumOf(files)
+	if checksum != "" && sum != checksum {
+		return Pack{}, fmt.Errorf("%w: %s at %.7s is %s, locked %s", ErrChecksumMismatch, repo, commit, sum, checksum)
+	}
+
+	if err := writePack(repo, files); err != nil {
+		return Pack{}, err
+	}
+
+	pack := Pack{
+		Source:    "github.com/" + repo,
+		Pinned:    pinned,
+		Version:   version,
+		Commit:    commit,
+		Checksum:  sum,
+		Files:     sortedNames(files),
+		Installed: time.Now().UTC().Truncate(time.Second),
+	}
+	l.put(pack)
+	return pack, l.save()
+}
+
+// extractPrompts reads the prompt files of a pack from its tarball, by file name. Files in the
+// pack's prompts directory are used when it has one, otherwise those at its root.
+func extractPrompts(tarball []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return nil, err
+	}
+	reader := tar.NewReader(gz)
+
+	root, nested := map[string][]byte{}, map[string][]byte{}
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Entries are prefixed with a directory named after the repository and commit
+		_, name, _ := strings.Cut(header.Name, "/")
+		dir, base := path.Split(name)
+		if !config.IsPromptFile(base) || skippedFiles[strings.ToLower(base)] {
+			continue
+		}
+
+		var files map[string][]byte
+		switch dir {
+		case "":
+			files = root
+		case packPromptDir + "/":
+			files = nested
+		default:
+			continue
+		}
+		if header.Size > maxPackFile {
+			return nil, fmt.Errorf("%s is larger than %d bytes", name, maxPackFile)
+		}
+		if len(files) >= maxPackFiles {
+			return nil, fmt.Errorf("more than %d prompt files", maxPackFiles)
+		}
+		if files[base], err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(nested) > 0 {
+		return nested, nil
+	}
+	return root, nil
+}
+
+// writePack replaces the pack's directory with the files, so no files of an earlier version remain.
+func writePack(repo string, files map[string][]byte) error {
+	dir, err := packDir(repo)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return fmt.Errorf("failed to create prompts directory: %w", err)
+	}
+
+	// Hidden while it is written, so a half-installed pack is never loaded
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".install-*")
+	if err != nil {
+		return fmt.Errorf("failed to create pack directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmp, name), data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove the installed version: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf("failed to install pack: %w", err)
+	}
+	return nil
+}
+
+// checksumOf hashes the files by name and content, independent of the order they were read in.
+func checksumOf(files map[string][]byte) string {
+	h := sha256.New()
+	for _, name := range sortedNames(files) {
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(files[name]))
+		h.Write(files[name])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// sortedNames returns the file names in order.
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseSource splits a pack source into its repository, without the github.com/ prefix, and version.
+func parseSource(source string) (repo, version string) {
+	repo, version, _ = strings.Cut(source, "@")
+	return repoOf(repo), version
+}
+
+// repoOf strips the host and a trailing slash or .git of a repository.
+func repoOf(source string) string {
+	source = strings.TrimPrefix(strings.TrimPrefix(source, "https://"), "github.com/")
+	return strings.TrimSuffix(strings.TrimSuffix(source, "/"), ".git")
+}
+
+// packDir retrieves the directory of the installed pack, e.g. prompts.d/org-prompt-pack.
+func packDir(repo string) (string, error) {
+	dir, err := config.PromptsPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strings.ReplaceAll(repo, "/", "-")), nil
+}
+
+// loadLock reads the lock file, empty when no pack was installed yet.
+func loadLock() (*lock, error) {
+	path, err := lockPath()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &lock{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// save writes the lock file.
+func (l *lock) save() error {
+	path, err := lockPath()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock file: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return nil
+}
+
+// find returns the locked pack of the repository.
+func (l *lock) find(repo string) (Pack, bool) {
+	for _, pack := range l.Packs {
+		if repoOf(pack.Source) == repo {
+			return pack, true
+		}
+	}
+	return Pack{}, false
+}
+
+// put adds the pack to the lock, replacing an earlier version of it.
+func (l *lock) put(pack Pack) {
+	for i := range l.Packs {
+		if l.Packs[i].Source == pack.Source {
+			l.Packs[i] = pack
+			return
+		}
+	}
+	l.Packs = append(l.Packs, pack)
+}
+
+// lockPath retrieves the path of the lock file.
+func lockPath() (string, error) {
+	dir, err := config.PromptsPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, lockFile), nil
+}
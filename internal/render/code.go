@@ -0,0 +1,24 @@
+package render
+
+import (
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/formatter"
+)
+
+// ExtractCode returns the code blocks of the answer in the language, or all of them for AnyLanguage.
+// Language aliases match, e.g. "golang" selects blocks tagged "go".
+func ExtractCode(answer, lang string) []codeblock.Block {
+	blocks := codeblock.Parse(answer)
+	if lang == AnyLanguage {
+		return blocks
+	}
+
+	want := formatter.Canonical(lang)
+	matching := blocks[:0]
+	for _, block := range blocks {
+		if formatter.Canonical(block.Lang) == want {
+			matching = append(matching, block)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,82 @@
+package render
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+	"golang.org/x/term"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+const (
+	columnSeparator = " │ "
+	minColumnWidth  = 20 // Narrower terminals get the columns one after the other
+)
+
+// RenderColumns renders two answers next to each other, each in half of the terminal width.
+func RenderColumns(cfg config.Config, args args.Arguments, left, right string) error {
+	width := cmp.Or(cfg.Render.WrapWidth, defaultWrapWidth)
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		width = consoleWrap(w)
+	}
+	if args.Deterministic {
+		width = deterministicWidth
+	}
+	column := (width - len(columnSeparator)) / 2
+
+	if column < minColumnWidth {
+		fmt.Println(left)
+		fmt.Println()
+		fmt.Println(right)
+		return nil
+	}
+
+	leftLines, err := renderColumn(cfg, args, left, column)
+	if err != nil {
+		return err
+	}
+	rightLines, err := renderColumn(cfg, args, right, column)
+	if err != nil {
+		return err
+	}
+
+	for i := range max(len(leftLines), len(rightLines)) {
+		var l, r string
+		if i < len(leftLines) {
+			l = leftLines[i]
+		}
+		if i < len(rightLines) {
+			r = rightLines[i]
+		}
+		padding := strings.Repeat(" ", max(0, column-ansi.StringWidth(l)))
+		fmt.Println(strings.TrimRight(l+padding+columnSeparator+r, " "))
+	}
+	return nil
+}
+
+// renderColumn renders the content as lines no wider than the column.
+func renderColumn(cfg config.Config, args args.Arguments, content string, column int) ([]string, error) {
+	content = SanitizeLinks(content, cfg.Render.SanitizeLinks)
+	rendered := ansi.Wrap(content, column, "")
+	if !args.UsePlainText {
+		md, err := newMarkdown(cfg, args, column)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create renderer: %w", err)
+		}
+		if rendered, err = md.Render(strings.TrimSpace(content)); err != nil {
+			return nil, fmt.Errorf("failed to render markdown: %w", err)
+		}
+	}
+
+	lines := strings.Split(strings.Trim(rendered, "\n"), "\n")
+	for i, line := range lines {
+		// Code blocks and tables aren't wrapped by the markdown renderer
+		lines[i] = ansi.Truncate(line, column, "…")
+	}
+	return lines, nil
+}
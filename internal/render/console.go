@@ -0,0 +1,67 @@
+package render
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/muesli/termenv"
+	"golang.org/x/term"
+)
+
+// noANSI is set by PrepareConsole when stdout is a console that doesn't process ANSI escape
+// sequences, such as the legacy Windows console, which would print them as garbage.
+var noANSI bool
+
+// PrepareConsole enables the processing of ANSI escape sequences by the Windows console of stdout
+// and stderr, and notes when it can't, so answers are rendered without them. It returns a
+// function restoring the consoles, and does nothing on other platforms.
+func PrepareConsole() (restore func()) {
+	restoreStdout, err := termenv.EnableVirtualTerminalProcessing(termenv.NewOutput(os.Stdout))
+	if err != nil {
+		noANSI = true
+		restoreStdout = func() error { return nil }
+	}
+	restoreStderr, err := termenv.EnableVirtualTerminalProcessing(termenv.NewOutput(os.Stderr))
+	if err != nil {
+		restoreStderr = func() error { return nil }
+	}
+	return func() {
+		_ = restoreStdout()
+		_ = restoreStderr()
+	}
+}
+
+// legacyStyle is the glamour style of consoles without ANSI support: no colors, and ASCII only, as
+// their fonts often lack the box drawing characters.
+const legacyStyle = styles.AsciiStyle
+
+// defaultWrapWidth is the wrap width when none is configured and stdout isn't a terminal.
+const defaultWrapWidth = 120
+
+// wrapWidth returns the width lines are wrapped at: the configured one, or else the width of the
+// terminal, or defaultWrapWidth when stdout isn't one.
+func wrapWidth(configured int) int {
+	if configured > 0 {
+		return consoleWrap(configured)
+	}
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		return defaultWrapWidth
+	}
+	return consoleWrap(width)
+}
+
+// consoleWrap returns the wrap width for the console of stdout: the Windows console moves to the
+// next line when the last column is written, which would leave an empty line after every full
+// one, so lines are kept a column short of its width.
+func consoleWrap(wrap int) int {
+	if runtime.GOOS != "windows" || wrap <= 0 {
+		return wrap
+	}
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 1 {
+		return wrap
+	}
+	return min(wrap, width-1)
+}
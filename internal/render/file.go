@@ -0,0 +1,144 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Output formats supported by OutputFile.
+const (
+	OutputMarkdown = "md"
+	OutputText     = "txt"
+	OutputJSON     = "json"
+)
+
+// OutputFile tees the raw, un-rendered answer into a file while it streams to the terminal.
+type OutputFile struct {
+	file    *os.File
+	format  string
+	model   string
+	prompts []string
+	created time.Time       // Creation time for the JSON format, zero to omit it
+	answer  strings.Builder // Buffered answer for the JSON format
+	pending string          // Incomplete trailing line for the text format
+}
+
+// outputDocument is the structure written by the JSON output format.
+type outputDocument struct {
+	Model   string    `json:"model"`
+	Prompts []string  `json:"prompts"`
+	Answer  string    `json:"answer"`
+	Created time.Time `json:"created,omitzero"`
+}
+
+// OutputFormatFromPath infers the output format from the file extension, defaulting to markdown.
+func OutputFormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return OutputJSON
+	case ".txt":
+		return OutputText
+	default:
+		return OutputMarkdown
+	}
+}
+
+// NewOutputFile creates the output file, inferring the format from the path when it is empty.
+// Deterministic files leave out the creation time.
+func NewOutputFile(path, format, model string, prompts []string, deterministic bool) (*OutputFile, error) {
+	if format == "" {
+		format = OutputFormatFromPath(path)
+	}
+	switch format {
+	case OutputMarkdown, OutputText, OutputJSON:
+	default:
+		return nil, fmt.Errorf("unknown output format %q: must be md, txt, or json", format)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	var created time.Time
+	if !deterministic {
+		created = time.Now()
+	}
+
+	return &OutputFile{
+		file:    file,
+		format:  format,
+		model:   model,
+		prompts: prompts,
+		created: created,
+	}, nil
+}
+
+// Write receives streamed content and writes it to the file in the chosen format.
+func (o *OutputFile) Write(p []byte) (int, error) {
+	switch o.format {
+	case OutputJSON:
+		o.answer.Write(p)
+	case OutputText:
+		if err := o.writeText(string(p)); err != nil {
+			return 0, err
+		}
+	default:
+		if _, err := o.file.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes any buffered content and closes the file.
+func (o *OutputFile) Close() error {
+	var err error
+	switch o.format {
+	case OutputJSON:
+		encoder := json.NewEncoder(o.file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(outputDocument{
+			Model:   o.model,
+			Prompts: o.prompts,
+			Answer:  o.answer.String(),
+			Created: o.created,
+		})
+	case OutputText:
+		err = o.writeText("\n")
+	}
+
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// writeText writes complete lines, dropping the markdown code fence lines.
+func (o *OutputFile) writeText(content string) error {
+	content = o.pending + content
+	lastNewline := strings.LastIndexByte(content, '\n')
+	if lastNewline < 0 {
+		o.pending = content
+		return nil
+	}
+	o.pending = content[lastNewline+1:]
+
+	var buf strings.Builder
+	for line := range strings.SplitAfterSeq(content[:lastNewline+1], "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			continue
+		}
+		buf.WriteString(line)
+	}
+	_, err := o.file.WriteString(buf.String())
+	return err
+}
@@ -0,0 +1,118 @@
+package render
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// TestDeterministicGolden renders an answer with --deterministic-output, streamed in chunks of
+// several sizes and in a terminal environment that would otherwise get colors and hyperlinks,
+// and compares the output and the --out file with the golden files in testdata.
+func TestDeterministicGolden(t *testing.T) {
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("COLORTERM", "truecolor")
+	t.Setenv("TERM_PROGRAM", "vscode")
+	t.Setenv("CLICOLOR_FORCE", "1")
+
+	answer, err := os.ReadFile(filepath.Join("testdata", "answer.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunkSize := range []int{1, 7, len(answer)} {
+		out := filepath.Join(t.TempDir(), "answer.json")
+		rendered := renderDeterministic(t, string(answer), chunkSize, out)
+		written, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compareGolden(t, "answer.golden", rendered)
+		compareGolden(t, "answer.json.golden", string(written))
+		if t.Failed() {
+			t.Fatalf("output differs from the golden files with chunks of %d bytes", chunkSize)
+		}
+	}
+}
+
+// renderDeterministic streams the answer to the renderer in chunks of the size, as
+// --deterministic-output with --out does, and returns what it printed.
+func renderDeterministic(t *testing.T, answer string, chunkSize int, out string) string {
+	cfg, err := config.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := args.Arguments{Deterministic: true, Model: "gpt-4o", Prompts: []string{"How do I read a file in Go?"}}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	printed := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- string(data)
+	}()
+
+	renderer, err := NewTerminalRenderer(context.Background(), cfg, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewOutputFile(out, "", a.Model, a.Prompts, a.Deterministic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer.Tee(file)
+
+	chunks := make(chan stream.Chunk)
+	go func() {
+		defer close(chunks)
+		for rest := answer; rest != ""; {
+			n := min(chunkSize, len(rest))
+			chunks <- stream.Chunk{Content: rest[:n]}
+			rest = rest[n:]
+		}
+	}()
+	if err := renderer.Render(chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = w.Close()
+	return <-printed
+}
+
+// compareGolden compares the output with the golden file in testdata, or rewrites it with -update.
+func compareGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run go test -update to create it: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s (run go test -update to accept it):\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package render
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Modes of `render.hyperlinks`.
+const (
+	HyperlinksAuto   = "auto"   // Detect whether the terminal supports hyperlinks
+	HyperlinksAlways = "always" // Assume it does, e.g. behind tmux or ssh
+	HyperlinksNever  = "never"  // Print link targets after their text, and don't link citations
+)
+
+// The labels of markdown links are marked with sequences of zero width characters, which don't
+// change how they are wrapped and aligned, so they can be found in the rendered markdown and turned
+// into hyperlinks.
+const (
+	linkOpen  = "\u2060\u200b\u2060"
+	linkClose = "\u200b\u2060\u200b"
+)
+
+// hyperlinkTerminals are the TERM_PROGRAM values of terminals that support hyperlinks.
+var hyperlinkTerminals = map[string]bool{
+	"iTerm.app": true,
+	"WezTerm":   true,
+	"vscode":    true,
+	"ghostty":   true,
+	"Hyper":     true,
+	"Tabby":     true,
+	"rio":       true,
+}
+
+// hyperlinkTerms are substrings of the TERM values of terminals that support hyperlinks.
+var hyperlinkTerms = []string{"kitty", "alacritty", "foot", "ghostty", "wezterm", "contour"}
+
+// useHyperlinks reports whether the markdown links should be rendered as hyperlinks, in the mode
+// of `render.hyperlinks`.
+func useHyperlinks(mode string) bool {
+	switch mode {
+	case HyperlinksAlways:
+		return true
+	case HyperlinksNever:
+		return false
+	default:
+		return supportsHyperlinks()
+	}
+}
+
+// supportsHyperlinks detects a terminal that supports OSC 8 hyperlinks by its environment. Others
+// may print the escape sequences, or drop the link targets that are no longer written out.
+func supportsHyperlinks() bool {
+	term := os.Getenv("TERM")
+	switch {
+	case term == "dumb" || strings.HasPrefix(term, "screen") || strings.HasPrefix(term, "tmux"):
+		return false // Multiplexers only pass hyperlinks on when configured to
+	case hyperlinkTerminals[os.Getenv("TERM_PROGRAM")]:
+		return true
+	case os.Getenv("WT_SESSION") != "" || os.Getenv("KONSOLE_VERSION") != "" || os.Getenv("DOMTERM") != "":
+		return true
+	}
+	if version, err := strconv.Atoi(os.Getenv("VTE_VERSION")); err == nil && version >= 5000 {
+		return true // GNOME Terminal, Tilix, and other VTE terminals since 0.50
+	}
+	for _, name := range hyperlinkTerms {
+		if strings.Contains(term, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// markLinks replaces the targets of the markdown links to URLs outside of code with an anchor,
+// which isn't printed, and puts the links between markers. It returns the targets in order.
+func markLinks(markdown string) (string, []string) {
+	var urls []string
+	marked := replaceOutsideCode(markdown, func(text string) string {
+		return markdownLink.ReplaceAllStringFunc(text, func(link string) string {
+			match := markdownLink.FindStringSubmatch(link)
+			image, label, target := match[1] == "!", match[2], match[3]
+			if image || label == "" || !strings.Contains(target, "://") && !strings.HasPrefix(target, "mailto:") {
+				return link
+			}
+			urls = append(urls, target)
+			return linkOpen + "[" + label + "](#)" + linkClose // Styled as a link, without a target to print
+		})
+	})
+	return marked, urls
+}
+
+// hyperlinkMarked turns the marked labels of the rendered markdown into hyperlinks to the URLs,
+// in order. When the markers don't match the URLs, e.g. as a label was wrapped over several lines
+// of a table, the markers are removed and the labels stay unlinked.
+func hyperlinkMarked(rendered string, urls []string) string {
+	if len(urls) == 0 {
+		return rendered
+	}
+	if strings.Count(rendered, linkOpen) != len(urls) || strings.Count(rendered, linkClose) != len(urls) {
+		return strings.NewReplacer(linkOpen, "", linkClose, "").Replace(rendered)
+	}
+
+	var b strings.Builder
+	for _, url := range urls {
+		before, rest, _ := strings.Cut(rendered, linkOpen)
+		label, after, _ := strings.Cut(rest, linkClose)
+		b.WriteString(before)
+		b.WriteString(Hyperlink(url, label))
+		rendered = after
+	}
+	b.WriteString(rendered)
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package render
+
+import (
+	"context"
+	"testing"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// TestCitationHyperlinks checks that citations of attached files are only linked, and the
+// sources only listed with links, when the terminal gets hyperlinks.
+func TestCitationHyperlinks(t *testing.T) {
+	// A terminal that isn't detected as supporting hyperlinks
+	t.Setenv("TERM", "xterm-256color")
+	for _, name := range []string{"TERM_PROGRAM", "WT_SESSION", "KONSOLE_VERSION", "DOMTERM", "VTE_VERSION"} {
+		t.Setenv(name, "")
+	}
+
+	tests := []struct {
+		mode   string
+		linked bool
+	}{
+		{HyperlinksAuto, false},
+		{HyperlinksNever, false},
+		{HyperlinksAlways, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg, err := config.Default()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Render.Hyperlinks = tt.mode
+			a := args.Arguments{Model: "gpt-4o", Files: []string{"main.go"}}
+
+			renderer, err := NewTerminalRenderer(context.Background(), cfg, a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked := renderer.linker != nil; linked != tt.linked {
+				t.Errorf("citations linked: %t, want %t", linked, tt.linked)
+			}
+			if renderer.hyperlinks != tt.linked {
+				t.Errorf("hyperlinks: %t, want %t", renderer.hyperlinks, tt.linked)
+			}
+			if renderer.sources == nil {
+				t.Error("sources aren't listed")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+// Output modes of --output.
+const (
+	OutputModeText  = "text"  // The rendered answer
+	OutputModeJSONL = "jsonl" // A JSON object per streamed chunk
+)
+
+// StreamRenderer renders a streamed answer, passing its raw content to the tee writers.
+type StreamRenderer interface {
+	Tee(w io.Writer)
+	Render(chunks <-chan stream.Chunk) error
+	Answer() string
+}
+
+// jsonlEvent is a line of the JSON Lines output.
+type jsonlEvent struct {
+	Content      string    `json:"content"`
+	Index        int       `json:"index"`
+	FinishReason string    `json:"finish_reason,omitempty"`
+	Filtered     []string  `json:"filtered,omitempty"` // Categories of the content filter that blocked content
+	Notice       string    `json:"notice,omitempty"`   // Why the answer is incomplete, on the chunk that ends it
+	Error        string    `json:"error,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// JSONLRenderer writes every chunk of the answer as a line of JSON as soon as it arrives, for
+// programs that consume the stream.
+type JSONLRenderer struct {
+	ctx     context.Context
+	encoder *json.Encoder
+	answer  strings.Builder
+	sinks   []io.Writer
+}
+
+// NewJSONLRenderer creates a renderer writing JSON Lines to w.
+func NewJSONLRenderer(ctx context.Context, w io.Writer) *JSONLRenderer {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &JSONLRenderer{ctx: ctx, encoder: encoder}
+}
+
+// Tee registers a writer that receives the raw content as it streams in.
+func (j *JSONLRenderer) Tee(w io.Writer) {
+	j.sinks = append(j.sinks, w)
+}
+
+// Answer returns the raw answer of the first choice received so far.
+func (j *JSONLRenderer) Answer() string {
+	return j.answer.String()
+}
+
+// Render writes the chunks until the stream ends. A stream error is written as an event of its own
+// before it is returned.
+func (j *JSONLRenderer) Render(chunks <-chan stream.Chunk) error {
+	for {
+		select {
+		case <-j.ctx.Done():
+			return j.ctx.Err()
+		case chunk, ok := <-chunks:
+			if !ok {
+				return nil
+			}
+			event := jsonlEvent{
+				Content:      chunk.Content,
+				Index:        chunk.Index,
+				FinishReason: chunk.FinishReason,
+				Filtered:     chunk.Filtered,
+				Notice:       stream.Notice(chunk.FinishReason, chunk.Filtered),
+				Time:         time.Now().UTC(),
+			}
+			if chunk.Error != nil {
+				event.Error = chunk.Error.Error()
+			}
+			if err := j.encoder.Encode(event); err != nil {
+				return fmt.Errorf("failed to write chunk: %w", err)
+			}
+			if chunk.Error != nil {
+				return fmt.Errorf("stream error: %w", chunk.Error)
+			}
+
+			// The answer is the first choice's, the others are only in the events
+			if chunk.Index != 0 {
+				continue
+			}
+			for _, w := range j.sinks {
+				if _, err := io.WriteString(w, chunk.Content); err != nil {
+					return fmt.Errorf("failed to write chunk: %w", err)
+				}
+			}
+			j.answer.WriteString(chunk.Content)
+		}
+	}
+}
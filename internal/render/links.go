@@ -0,0 +1,98 @@
+package render
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultEditorURI opens the file with the system handler; it can't jump to a line.
+const defaultEditorURI = "file://{path}"
+
+// editorPresets maps editor names usable as `render.editor_uri` to their URI templates.
+var editorPresets = map[string]string{
+	"file":            defaultEditorURI,
+	"vscode":          "vscode://file/{path}:{line}",
+	"vscode-insiders": "vscode-insiders://file/{path}:{line}",
+	"cursor":          "cursor://file/{path}:{line}",
+	"zed":             "zed://file/{path}:{line}",
+	"idea":            "idea://open?file={path}&line={line}",
+	"sublime":         "subl://open?url=file://{path}&line={line}",
+	"textmate":        "txmt://open?url=file://{path}&line={line}",
+}
+
+// Linker turns `path:line` citations of known files into clickable OSC 8 hyperlinks.
+type Linker struct {
+	pattern *regexp.Regexp
+	uri     string // Editor preset or URI template with {path} and {line} placeholders
+}
+
+// NewLinker creates a linker for citations of the given paths, or nil when there are none.
+func NewLinker(paths []string, uriTemplate string) *Linker {
+	if len(paths) == 0 {
+		return nil
+	}
+	// Match longer paths first, so "a/b.go" wins over "b.go"
+	sorted := append([]string(nil), paths...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	quoted := make([]string, 0, len(sorted))
+	for _, path := range sorted {
+		quoted = append(quoted, regexp.QuoteMeta(path))
+	}
+
+	return &Linker{
+		pattern: regexp.MustCompile(`(` + strings.Join(quoted, "|") + `):(\d+)(?:-(\d+))?`),
+		uri:     uriTemplate,
+	}
+}
+
+// Link wraps every citation in the text in an OSC 8 hyperlink.
+func (l *Linker) Link(text string) string {
+	if l == nil {
+		return text
+	}
+
+	return l.pattern.ReplaceAllStringFunc(text, func(citation string) string {
+		match := l.pattern.FindStringSubmatch(citation)
+		line, _ := strconv.Atoi(match[2])
+		return Hyperlink(EditorURI(l.uri, match[1], line), citation)
+	})
+}
+
+// EditorURI builds the URI that opens a line of a file, from an editor preset or a URI template.
+// Templates without a {path} placeholder get the path appended, e.g. "idea://open?file=".
+func EditorURI(template, path string, line int) string {
+	if template == "" {
+		template = defaultEditorURI
+	}
+	if preset, ok := editorPresets[template]; ok {
+		template = preset
+	}
+	if !strings.Contains(template, "{path}") {
+		template += "{path}"
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return strings.NewReplacer(
+		"{path}", filepath.ToSlash(path),
+		"{line}", strconv.Itoa(max(line, 1)),
+	).Replace(template)
+}
+
+// FileLink wraps the text in a hyperlink to a line of a file, or returns it as is in plain text mode.
+func FileLink(template, path string, line int, text string, plainText bool) string {
+	if plainText {
+		return text
+	}
+	return Hyperlink(EditorURI(template, path, line), text)
+}
+
+// Hyperlink wraps the text in an OSC 8 hyperlink escape sequence.
+func Hyperlink(uri, text string) string {
+	return "\x1b]8;;" + uri + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
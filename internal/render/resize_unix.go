@@ -0,0 +1,17 @@
+//go:build !windows
+
+package render
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize returns a channel receiving a value when the terminal is resized, and a function
+// stopping the notifications.
+func notifyResize() (<-chan os.Signal, func()) {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	return resized, func() { signal.Stop(resized) }
+}
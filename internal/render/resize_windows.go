@@ -0,0 +1,9 @@
+package render
+
+import "os"
+
+// notifyResize returns nil, as Windows has no signal for resizes: the width is only detected when
+// rendering starts.
+func notifyResize() (<-chan os.Signal, func()) {
+	return nil, func() {}
+}
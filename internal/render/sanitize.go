@@ -0,0 +1,123 @@
+package render
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Modes of `render.sanitize_links`.
+const (
+	SanitizeOff   = "off"   // Render links and images as they are
+	SanitizeClean = "clean" // Turn remote images into links, and remove tracking parameters and redirects
+	SanitizeStrip = "strip" // Only keep the text of links and the description of images
+)
+
+// markdownLink matches inline links and images, with an optional title: [text](url "title").
+var markdownLink = regexp.MustCompile(`(!?)\[([^\]\n]*)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"\n]*")?\s*\)`)
+
+// linkOrURL matches inline links and images, or URLs written out in the text.
+var linkOrURL = regexp.MustCompile(markdownLink.String() + `|https?://[^\s<>()\[\]"'` + "`" + `]+`)
+
+// trackingParams are query parameters that only identify where a click came from.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true, "igshid": true,
+	"mc_cid": true, "mc_eid": true, "_hsenc": true, "_hsmi": true, "mkt_tok": true, "ref_src": true,
+}
+
+// redirects maps link redirectors to the query parameter holding the destination.
+var redirects = map[string]string{
+	"www.google.com/url":    "q",
+	"google.com/url":        "q",
+	"l.facebook.com/l.php":  "u",
+	"lm.facebook.com/l.php": "u",
+}
+
+// SanitizeLinks rewrites the links and images of the markdown as the mode asks. Code is left alone.
+func SanitizeLinks(markdown, mode string) string {
+	if mode == "" || mode == SanitizeOff {
+		return markdown
+	}
+	return replaceOutsideCode(markdown, func(text string) string { return sanitizeText(text, mode) })
+}
+
+// replaceOutsideCode replaces the text of the markdown that isn't in code blocks or code spans.
+func replaceOutsideCode(markdown string, replace func(text string) string) string {
+	lines := strings.SplitAfter(markdown, "\n")
+	fence := ""
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		parts := strings.Split(line, "`")
+		for i := 0; i < len(parts); i += 2 { // Odd parts are inside code spans
+			parts[i] = replace(parts[i])
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+	return strings.Join(lines, "")
+}
+
+// sanitizeText rewrites the links, images, and URLs of text without code.
+func sanitizeText(text, mode string) string {
+	return linkOrURL.ReplaceAllStringFunc(text, func(found string) string {
+		match := markdownLink.FindStringSubmatch(found)
+		if match == nil || match[0] != found {
+			return cleanURL(found)
+		}
+
+		image, label, target := match[1] == "!", match[2], match[3]
+		switch {
+		case mode == SanitizeStrip && image && label == "":
+			return ""
+		case mode == SanitizeStrip && image:
+			return "(image: " + label + ")"
+		case mode == SanitizeStrip:
+			return label
+		case !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://"):
+			return found
+		case image && label == "":
+			label = "Image"
+		case image:
+			label = "Image: " + label
+		}
+		return "[" + label + "](" + cleanURL(target) + ")"
+	})
+}
+
+// cleanURL unwraps a redirect and removes the tracking parameters of the URL.
+func cleanURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+
+	query := u.Query()
+	if param, ok := redirects[u.Host+u.Path]; ok {
+		if target := query.Get(param); strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+			return cleanURL(target)
+		}
+	}
+
+	changed := false
+	for name := range query {
+		if trackingParams[strings.ToLower(name)] || strings.HasPrefix(strings.ToLower(name), "utm_") {
+			query.Del(name)
+			changed = true
+		}
+	}
+	if !changed {
+		return raw
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
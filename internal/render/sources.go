@@ -0,0 +1,92 @@
+package render
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/codeblock"
+)
+
+// Source is a source the answer cites: a location of an attached file, or a linked URL.
+type Source struct {
+	Path  string // Attached file, empty for a URL
+	Start int    // First cited line of the file
+	End   int    // Last cited line of the file, 0 for a single line
+	URL   string
+}
+
+// String returns the source as it is cited: path:line, path:start-end, or the URL.
+func (s Source) String() string {
+	switch {
+	case s.Path == "":
+		return s.URL
+	case s.End > s.Start:
+		return fmt.Sprintf("%s:%d-%d", s.Path, s.Start, s.End)
+	default:
+		return fmt.Sprintf("%s:%d", s.Path, s.Start)
+	}
+}
+
+// Sources returns the citations of the attached files and the URLs linked in the answer, in the
+// order they first appear, without duplicates. Code blocks are left out, their URLs are examples.
+func (l *Linker) Sources(answer string) []Source {
+	if l == nil {
+		return nil
+	}
+	text := codeblock.Replace(answer, func(codeblock.Block) string { return "" })
+
+	type found struct {
+		at     int
+		source Source
+	}
+	var all []found
+	for _, match := range l.pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, _ := strconv.Atoi(text[match[4]:match[5]])
+		end := 0
+		if match[6] >= 0 {
+			end, _ = strconv.Atoi(text[match[6]:match[7]])
+		}
+		all = append(all, found{match[0], Source{Path: text[match[2]:match[3]], Start: start, End: end}})
+	}
+	for _, match := range linkOrURL.FindAllStringSubmatchIndex(text, -1) {
+		uri := text[match[0]:match[1]]
+		if match[6] >= 0 {
+			uri = text[match[6]:match[7]] // The target of a markdown link
+		}
+		uri = strings.TrimRight(uri, ".,;:!?")
+		if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+			all = append(all, found{match[0], Source{URL: uri}})
+		}
+	}
+	slices.SortStableFunc(all, func(a, b found) int { return a.at - b.at })
+
+	var sources []Source
+	for _, f := range all {
+		if !slices.Contains(sources, f.source) {
+			sources = append(sources, f.source)
+		}
+	}
+	return sources
+}
+
+// FormatSources formats the numbered list of sources printed after an answer, with the files
+// and URLs as hyperlinks unless they are plain.
+func (l *Linker) FormatSources(sources []Source, plain bool) string {
+	var b strings.Builder
+	b.WriteString("  Sources:\n")
+	width := len(strconv.Itoa(len(sources)))
+	for i, source := range sources {
+		text := source.String()
+		switch {
+		case plain:
+		case source.Path != "":
+			text = Hyperlink(EditorURI(l.uri, source.Path, source.Start), text)
+		default:
+			text = Hyperlink(source.URL, text)
+		}
+		fmt.Fprintf(&b, "    [%*d] %s\n", width, i+1, text)
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+	"golang.org/x/term"
+)
+
+// statusInterval is the delay between the frames of the status line, and before the first one,
+// so quick answers don't flicker.
+const statusInterval = 100 * time.Millisecond
+
+// spinnerFrames are the frames of the spinner of the status line.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Status is the line showing a spinner and the time waited until the first chunk of an answer.
+type Status struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// StartStatus shows the status line on stderr until it is stopped, when stderr is a terminal, so
+// stdout only ever carries the answer. Otherwise it returns nil, which can be stopped all the same.
+func StartStatus(cfg config.Config, args args.Arguments) *Status {
+	if !cfg.Render.Spinner || args.Deterministic || args.Output == OutputModeJSONL || noANSI ||
+		!term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	s := &Status{stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run(time.Now())
+	return s
+}
+
+// run repaints the status line in place until it is stopped, then erases it.
+func (s *Status) run(start time.Time) {
+	defer close(s.done)
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	shown := false
+	for frame := 0; ; frame++ {
+		select {
+		case <-s.stop:
+			if shown {
+				fmt.Fprint(os.Stderr, "\r\x1b[K")
+			}
+			return
+		case <-ticker.C:
+			elapsed := time.Since(start).Seconds()
+			fmt.Fprintf(os.Stderr, "\r%s waiting for model… %.1fs\x1b[K", spinnerFrames[frame%len(spinnerFrames)], elapsed)
+			shown = true
+		}
+	}
+}
+
+// Stop erases the status line, and returns once it is, so the answer is printed in its place.
+func (s *Status) Stop() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
@@ -2,78 +2,364 @@ package render
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/x/ansi"
 	"github.com/cli/go-gh/v2/pkg/markdown"
 	"github.com/markis/gh-copilot/internal/args"
 	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logging"
+	"github.com/markis/gh-copilot/internal/postprocess"
 	"github.com/markis/gh-copilot/internal/stream"
+	"golang.org/x/term"
+)
+
+// AnyLanguage selects the code blocks of every language for --code.
+const AnyLanguage = "*"
+
+const (
+	deterministicWidth = 80                    // Wrap width of --deterministic-output
+	repaintInterval    = 50 * time.Millisecond // Minimum delay between live repaints
 )
 
 // TerminalRenderer is responsible for rendering markdown content to the terminal.
 type TerminalRenderer struct {
-	ctx       context.Context
-	markdown  *glamour.TermRenderer
-	plainText bool
-	buffer    strings.Builder
-	inBlock   bool // Track if we are currently in a block element (e.g., code block, table, etc.)
+	ctx         context.Context
+	markdown    *glamour.TermRenderer
+	plainText   bool
+	buffer      strings.Builder
+	answer      strings.Builder // The full raw answer received so far
+	inBlock     bool            // Track if we are currently in a block element (e.g., code block, table, etc.)
+	sinks       []io.Writer     // Receive the raw, un-rendered content as it streams in
+	postProcess []string        // Post-processors to apply to the final answer before rendering
+	code        string          // Only print the code blocks of this language ("*" for all), "" to render the answer
+	whole       bool            // Render the answer once complete, so the blocks don't depend on how it was streamed
+	formatters  config.Formatters
+	linker      *Linker // Links citations of the attached files, nil when there are none
+	sources     *Linker // Collects the sources listed after the answer, nil when there are no attached files
+	hyperlinks  bool    // Render markdown links as hyperlinks instead of printing their targets
+	sanitize    string  // How links and images are sanitized before rendering, see SanitizeLinks
+	notice      string  // Why the answer is incomplete, printed after it
+	logger      *slog.Logger
+
+	// Live repaint of the block that is still streaming in
+	live      bool
+	width     int       // Terminal width, to count wrapped lines
+	height    int       // Terminal height, previews taller than it can't be repainted
+	painted   int       // Terminal lines taken by the current preview
+	lastPaint time.Time // Previews are throttled to repaintInterval
+
+	// rewrap creates the markdown renderer for a new terminal width, nil when the width is fixed
+	rewrap func(wrap int) (*glamour.TermRenderer, error)
 }
 
 // NewTerminalRenderer creates a new TerminalRenderer instance.
 func NewTerminalRenderer(ctx context.Context, cfg config.Config, args args.Arguments) (*TerminalRenderer, error) {
 	var md *glamour.TermRenderer
+	var linker, sources *Linker
+	var hyperlinks bool
 	var err error
+	plainText := args.UsePlainText
 
 	// use plain text rendering if specified in arguments
-	if !args.UsePlainText {
-		options := make([]glamour.TermRendererOption, 0, 2)
-		if cfg.Render.WrapLines && cfg.Render.WrapWidth >= 0 {
-			options = append(options, markdown.WithWrap(cfg.Render.WrapWidth))
+	if !plainText {
+		wrap := -1
+		switch {
+		case args.Deterministic:
+			wrap = deterministicWidth
+		case cfg.Render.WrapLines:
+			wrap = wrapWidth(cfg.Render.WrapWidth)
 		}
-		if cfg.Render.Theme != "" {
-			options = append(options, glamour.WithStandardStyle(cfg.Render.Theme))
+		md, err = newMarkdown(cfg, args, wrap)
+		if err != nil {
+			// Don't fail the request over its looks, e.g. for an unknown theme
+			warnPlainText(err)
+			plainText = true
+		} else {
+			citations := NewLinker(args.Files, cfg.Render.EditorURI)
+			// Terminals without hyperlinks would print the escape sequences of linked citations
+			if !args.Deterministic && !noANSI && useHyperlinks(cfg.Render.Hyperlinks) {
+				linker = citations
+				hyperlinks = true
+			}
+			if cfg.Render.Sources {
+				sources = citations
+			}
 		}
+	}
 
-		md, err = glamour.NewTermRenderer(options...)
-		if err != nil {
-			return nil, fmt.Errorf("creating markdown renderer: %w", err)
+	t := &TerminalRenderer{
+		ctx:         ctx,
+		markdown:    md,
+		plainText:   plainText,
+		postProcess: args.PostProcess,
+		code:        args.Code,
+		whole:       args.Deterministic,
+		formatters:  cfg.Formatters,
+		linker:      linker,
+		sources:     sources,
+		hyperlinks:  hyperlinks,
+		sanitize:    cfg.Render.SanitizeLinks,
+		logger:      logging.FromContext(ctx),
+	}
+
+	// Without a configured width, blocks are wrapped at the terminal's, which can change while streaming
+	if md != nil && cfg.Render.WrapLines && cfg.Render.WrapWidth == 0 && !args.Deterministic {
+		t.rewrap = func(wrap int) (*glamour.TermRenderer, error) { return newMarkdown(cfg, args, wrap) }
+	}
+
+	// Post-processed answers are only rendered once complete, so there is nothing to repaint
+	if cfg.Render.Live && !plainText && !args.Deterministic && !noANSI && len(args.PostProcess) == 0 && args.Code == "" {
+		if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			t.live, t.width, t.height = true, width, height
 		}
 	}
+	return t, nil
+}
+
+// newMarkdown creates the markdown renderer for the configured theme, wrapping lines at the
+// width unless it is negative.
+func newMarkdown(cfg config.Config, args args.Arguments, wrap int) (*glamour.TermRenderer, error) {
+	if args.Deterministic {
+		// Independent of the terminal and the user's config
+		return glamour.NewTermRenderer(markdown.WithWrap(wrap), glamour.WithStandardStyle(styles.NoTTYStyle))
+	}
+
+	options := make([]glamour.TermRendererOption, 0, 2)
+	if wrap >= 0 {
+		options = append(options, markdown.WithWrap(wrap))
+	}
+	if noANSI {
+		return glamour.NewTermRenderer(append(options, glamour.WithStandardStyle(legacyStyle))...)
+	}
+	theme := cfg.Render.Theme
+	if args.Theme != "" {
+		theme = args.Theme
+	}
+	if theme != "" {
+		options = append(options, themeOption(theme, cfg.Render.LightTheme, cfg.Render.DarkTheme))
+	}
+	return glamour.NewTermRenderer(options...)
+}
 
-	return &TerminalRenderer{
-		ctx:       ctx,
-		markdown:  md,
-		plainText: args.UsePlainText,
-	}, nil
+// Tee registers a writer that receives the raw, un-rendered content as it streams in.
+func (t *TerminalRenderer) Tee(w io.Writer) {
+	t.sinks = append(t.sinks, w)
 }
 
 // Render processes the stream of chunks and renders them to the terminal.
 func (t *TerminalRenderer) Render(chunks <-chan stream.Chunk) error {
+	start := time.Now()
+	defer func() {
+		t.logger.Debug("render finished", "bytes", t.answer.Len(), "duration", time.Since(start))
+	}()
+
+	var resized <-chan os.Signal
+	if t.rewrap != nil || t.live {
+		var stop func()
+		resized, stop = notifyResize()
+		defer stop()
+	}
+
 	done := t.ctx.Done()
 	for {
 		select {
 		case <-done:
-			return t.ctx.Err()
+			return t.interrupted()
+
+		case <-resized:
+			t.resize()
 
 		case chunk, ok := <-chunks:
 			if !ok {
+				// A canceled stream may end without the chunk saying so
+				if t.ctx.Err() != nil {
+					return t.interrupted()
+				}
+				defer t.printNotice()
 				// Channel closed, render remaining content
-				return t.renderRemaining()
+				if t.code != "" {
+					return t.renderCode()
+				}
+				if len(t.postProcess) > 0 || t.whole {
+					if err := t.renderPostProcessed(); err != nil {
+						return err
+					}
+				} else if err := t.renderRemaining(); err != nil {
+					return err
+				}
+				t.printSources()
+				return nil
 			}
 
 			if chunk.Error != nil {
+				if chunk.Kind == stream.KindCanceled {
+					return t.interrupted()
+				}
 				return fmt.Errorf("stream error: %w", chunk.Error)
 			}
 
+			if notice := stream.Notice(chunk.FinishReason, chunk.Filtered); notice != "" {
+				t.notice = notice
+			}
+
+			if err := t.writeSinks(chunk.Content); err != nil {
+				return fmt.Errorf("failed to write chunk: %w", err)
+			}
+			t.answer.WriteString(chunk.Content)
+
+			// Post-processors and code extraction need the whole answer, so rendering waits for the stream to finish.
+			// So does --deterministic-output: streamed blocks end where the chunks happen to, which varies.
+			if len(t.postProcess) > 0 || t.code != "" || t.whole {
+				continue
+			}
+
 			if err := t.processChunk(chunk.Content); err != nil {
 				return fmt.Errorf("failed to process chunk: %w", err)
 			}
+			if t.live {
+				t.repaint()
+			}
 		}
 	}
 }
 
+// resize adapts to a new size of the terminal: the blocks that follow are wrapped at its width,
+// unless one is configured, and the preview is measured by it.
+func (t *TerminalRenderer) resize() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		return
+	}
+	t.width, t.height = width, height
+	if t.rewrap == nil {
+		return
+	}
+	md, err := t.rewrap(consoleWrap(width))
+	if err != nil {
+		t.logger.Debug("failed to rewrap for the new terminal width", "width", width, "error", err)
+		return
+	}
+	t.markdown = md
+	t.logger.Debug("terminal resized", "width", width, "height", height)
+}
+
+// printSources lists the files and URLs that an answer about attached files cites, numbered.
+func (t *TerminalRenderer) printSources() {
+	sources := t.sources.Sources(SanitizeLinks(t.answer.String(), t.sanitize))
+	if len(sources) == 0 || t.plainText {
+		return
+	}
+	fmt.Print(t.sources.FormatSources(sources, t.linker == nil))
+}
+
+// printNotice warns on stderr when the answer is incomplete, after it was rendered.
+func (t *TerminalRenderer) printNotice() {
+	if t.notice != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", t.notice)
+	}
+}
+
+// interrupted shows what was received before the answer was interrupted, and returns why.
+func (t *TerminalRenderer) interrupted() error {
+	if t.code == "" && len(t.postProcess) == 0 {
+		_ = t.renderRemaining()
+	}
+	return t.ctx.Err()
+}
+
+// RenderMarkdown renders a complete markdown document, e.g. a diff that was not streamed.
+func RenderMarkdown(ctx context.Context, cfg config.Config, args args.Arguments, content string) error {
+	args.Code, args.PostProcess = "", nil
+	t, err := NewTerminalRenderer(ctx, cfg, args)
+	if err != nil {
+		return err
+	}
+	t.sources = nil // The document isn't an answer
+
+	chunks := make(chan stream.Chunk, 1)
+	chunks <- stream.Chunk{Content: content}
+	close(chunks)
+	return t.Render(chunks)
+}
+
+// Answer returns the raw answer received so far, post-processed once the stream has finished.
+func (t *TerminalRenderer) Answer() string {
+	return t.answer.String()
+}
+
+// renderPostProcessed applies the post-processors to the full answer and renders the result.
+func (t *TerminalRenderer) renderPostProcessed() error {
+	answer, err := t.applyPostProcess()
+	if err != nil {
+		return err
+	}
+
+	if err := t.processChunk(answer); err != nil {
+		return fmt.Errorf("failed to process answer: %w", err)
+	}
+	return t.renderRemaining()
+}
+
+// applyPostProcess applies the post-processors to the full answer, replacing it.
+func (t *TerminalRenderer) applyPostProcess() (string, error) {
+	if len(t.postProcess) == 0 {
+		return t.answer.String(), nil
+	}
+
+	answer, err := postprocess.Apply(t.ctx, t.postProcess, t.answer.String(), postprocess.Options{
+		Formatters: t.formatters,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to post-process answer: %w", err)
+	}
+	t.answer.Reset()
+	t.answer.WriteString(answer)
+	return answer, nil
+}
+
+// renderCode prints only the code of the answer's code blocks, unrendered, so it can be redirected to a file.
+func (t *TerminalRenderer) renderCode() error {
+	answer, err := t.applyPostProcess()
+	if err != nil {
+		return err
+	}
+
+	blocks := ExtractCode(answer, t.code)
+	if len(blocks) == 0 {
+		if t.code == AnyLanguage {
+			return errors.New("the answer has no code blocks")
+		}
+		return fmt.Errorf("the answer has no %s code blocks", t.code)
+	}
+
+	for i, block := range blocks {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(block.Code)
+	}
+	return nil
+}
+
+// writeSinks writes the raw content to all registered tee writers.
+func (t *TerminalRenderer) writeSinks(content string) error {
+	for _, w := range t.sinks {
+		if _, err := io.WriteString(w, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // processChunk processes the incoming content chunk, checking for markdown break points
 func (t *TerminalRenderer) processChunk(content string) error {
 	t.buffer.WriteString(content)
@@ -91,6 +377,54 @@ func (t *TerminalRenderer) processChunk(content string) error {
 	return nil
 }
 
+// repaint replaces the preview of the block that is still streaming in with its latest content.
+func (t *TerminalRenderer) repaint() {
+	if time.Since(t.lastPaint) < repaintInterval {
+		return
+	}
+	t.lastPaint = time.Now()
+
+	content := strings.TrimSpace(t.buffer.String())
+	if content == "" {
+		t.clearPreview()
+		return
+	}
+
+	rendered, err := t.renderMarkdown(SanitizeLinks(content, t.sanitize))
+	if err != nil {
+		return // The block is rendered again once complete, which reports the error
+	}
+
+	// The cursor can't move above the top of the screen, so tall blocks wait until they are complete
+	lines := t.countLines(rendered)
+	t.clearPreview()
+	if lines >= t.height {
+		return
+	}
+
+	fmt.Println(rendered)
+	t.painted = lines
+}
+
+// clearPreview erases the preview of the streaming block from the terminal.
+func (t *TerminalRenderer) clearPreview() {
+	if t.painted == 0 {
+		return
+	}
+	// Move to the start of the preview's first line, then erase to the end of the screen
+	fmt.Printf("\x1b[%dF\x1b[J", t.painted)
+	t.painted = 0
+}
+
+// countLines counts the terminal lines taken by the rendered text, including wrapped lines.
+func (t *TerminalRenderer) countLines(rendered string) int {
+	count := 0
+	for line := range strings.SplitSeq(rendered, "\n") {
+		count += max(1, (ansi.StringWidth(line)+t.width-1)/t.width)
+	}
+	return count
+}
+
 // renderRemaining checks if there's any content left in the buffer and renders it.
 func (t *TerminalRenderer) renderRemaining() error {
 	if remaining := t.buffer.String(); remaining != "" {
@@ -104,23 +438,46 @@ func (t *TerminalRenderer) renderRemaining() error {
 
 // renderContent processes and prints the content, handling both plain text and markdown rendering.
 func (t *TerminalRenderer) renderContent(content string) error {
+	t.clearPreview()
+	content = SanitizeLinks(content, t.sanitize)
 	if t.plainText {
 		fmt.Print(content)
 		return nil
 	}
 
-	content = strings.TrimSpace(content)
-	if strings.HasPrefix(content, "#") {
+	mdContent, err := t.renderMarkdown(strings.TrimSpace(content))
+	if err != nil {
+		// The answer was already paid for, so show the rest of it as it is
+		warnPlainText(err)
+		t.plainText = true
+		fmt.Print(content)
+		return nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(content), "#") {
 		fmt.Println()
 	}
 
-	mdContent, err := t.markdown.Render(content)
+	fmt.Println(mdContent)
+	return nil
+}
+
+// renderMarkdown renders the markdown with its links and citations as hyperlinks, trimmed.
+func (t *TerminalRenderer) renderMarkdown(content string) (string, error) {
+	var urls []string
+	if t.hyperlinks {
+		content, urls = markLinks(content)
+	}
+	rendered, err := t.markdown.Render(content)
 	if err != nil {
-		return fmt.Errorf("failed to render markdown: %w", err)
+		return "", err
 	}
+	return t.linker.Link(hyperlinkMarked(strings.TrimSpace(rendered), urls)), nil
+}
 
-	fmt.Println(strings.TrimSpace(mdContent))
-	return nil
+// warnPlainText tells the user that markdown rendering failed and the answer is shown as plain text.
+func warnPlainText(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: markdown rendering failed, falling back to plain text: %v\n", err)
 }
 
 // findMarkdownBreakPoint finds the last occurrence of a markdown break point in the content,
@@ -0,0 +1,45 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/muesli/termenv"
+	"golang.org/x/term"
+)
+
+// themeOption returns the glamour option for a theme, which is a standard style name,
+// the path of a JSON style file, or "auto" to pick the light or dark theme for the terminal.
+func themeOption(theme, lightTheme, darkTheme string) glamour.TermRendererOption {
+	if theme == styles.AutoStyle {
+		theme = detectTheme(lightTheme, darkTheme)
+	}
+	return glamour.WithStylePath(expandHome(theme))
+}
+
+// detectTheme picks the light or dark theme by querying the terminal's background color.
+func detectTheme(lightTheme, darkTheme string) string {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return styles.NoTTYStyle
+	}
+	if termenv.NewOutput(os.Stdout).HasDarkBackground() {
+		return darkTheme
+	}
+	return lightTheme
+}
+
+// expandHome expands a leading ~ in a style file path to the user's home directory.
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
@@ -0,0 +1,66 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/patch"
+)
+
+// Styles of the word diff in the terminal.
+const (
+	removedStyle = "\x1b[9;31m" // Red, struck through
+	addedStyle   = "\x1b[32m"   // Green
+	resetStyle   = "\x1b[0m"
+)
+
+// WordDiff writes the word-level changes from the old answer to the new one under a header naming
+// both. In plain text mode, removals are marked as [-word-] and additions as {+word+}, like git does.
+func WordDiff(w io.Writer, oldName, newName string, ops []patch.Line, plainText bool) error {
+	var b strings.Builder
+	if plainText {
+		fmt.Fprintf(&b, "--- %s\n+++ %s\n\n", oldName, newName)
+	} else {
+		fmt.Fprintf(&b, "%s--- %s%s\n%s+++ %s%s\n\n", removedStyle, oldName, resetStyle, addedStyle, newName, resetStyle)
+	}
+
+	for k := 0; k < len(ops); {
+		op := ops[k].Op
+		var run strings.Builder
+		for ; k < len(ops) && ops[k].Op == op; k++ {
+			run.WriteString(ops[k].Text)
+		}
+
+		// The whitespace after a change is written unmarked, so markers hug the words
+		text := run.String()
+		changed := strings.TrimRight(text, " \t\r\n")
+		space := text[len(changed):]
+		switch {
+		case op == ' ':
+			b.WriteString(text)
+		case plainText && op == '-':
+			b.WriteString("[-" + changed + "-]" + space)
+		case plainText:
+			b.WriteString("{+" + changed + "+}" + space)
+		case op == '-':
+			b.WriteString(styleLines(changed, removedStyle) + space)
+		default:
+			b.WriteString(styleLines(changed, addedStyle) + space)
+		}
+	}
+
+	_, err := io.WriteString(w, strings.TrimRight(b.String(), "\n")+"\n")
+	return err
+}
+
+// styleLines applies the style to every line of the text, so it survives terminals resetting it at line breaks.
+func styleLines(text, style string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = style + line + resetStyle
+		}
+	}
+	return strings.Join(lines, "\n")
+}
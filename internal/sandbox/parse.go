@@ -0,0 +1,221 @@
+package sandbox
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// wrappers run the command given as their arguments, which is checked too.
+var wrappers = map[string]bool{
+	"builtin": true,
+	"command": true,
+	"env":     true,
+	"exec":    true,
+	"nice":    true,
+	"nohup":   true,
+	"stdbuf":  true,
+	"time":    true,
+	"timeout": true,
+	"xargs":   true,
+}
+
+// shells run the command line of their -c option, which is checked too.
+var shells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"dash": true,
+	"zsh":  true,
+	"ksh":  true,
+	"fish": true,
+}
+
+// command is a simple command of a command line.
+type command struct {
+	words    []string
+	expanded []bool // Whether the word of the same index has a parameter expansion or command substitution
+}
+
+// parse splits a command line into its simple commands, each a list of words with the quotes
+// removed, e.g. `a "b c" | d` into [[a, b c], [d]]. Command substitutions, subshells, and the
+// commands of pipelines and lists are commands of their own. It is an approximation of the shell's
+// grammar that errs on the side of finding more commands.
+func parse(line string) ([]command, error) {
+	var commands []command
+	var current command
+	var word strings.Builder
+	inWord, expands := false, false
+	endWord := func() {
+		if inWord {
+			current.words = append(current.words, word.String())
+			current.expanded = append(current.expanded, expands)
+			word.Reset()
+			inWord, expands = false, false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(current.words) > 0 {
+			commands = append(commands, current)
+			current = command{}
+		}
+	}
+
+	// Command substitutions run commands even inside double quotes, and the word and command they
+	// are in resume after them
+	type substitution struct {
+		end     rune // ) or `
+		quote   rune // Quote the substitution is in
+		command command
+		word    string
+	}
+	var substitutions []substitution
+
+	runes := []rune(line)
+	var quote rune
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote == 0 && len(substitutions) > 0 && r == substitutions[len(substitutions)-1].end:
+			endCommand()
+			outer := substitutions[len(substitutions)-1]
+			substitutions = substitutions[:len(substitutions)-1]
+			// The output of the substitution is part of the word
+			quote, current, inWord, expands = outer.quote, outer.command, true, true
+			word.WriteString(outer.word)
+		case r == '`' || r == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			end := '`'
+			if r == '$' {
+				end = ')'
+				i++
+			}
+			substitutions = append(substitutions, substitution{end: end, quote: quote, command: current, word: word.String()})
+			quote, current, inWord, expands = 0, command{}, false, false
+			word.Reset()
+		case r == '$' && (quote == 0 || quote == '"'):
+			word.WriteRune(r)
+			inWord, expands = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '|' || r == '&' || r == ';' || r == '(' || r == ')' || r == '\n':
+			endCommand()
+		case r == '<' || r == '>':
+			endWord()
+			// The &1 of 2>&1 duplicates a descriptor, it doesn't end the command
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				i++
+				for i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '-') {
+					i++
+				}
+			}
+		case unicode.IsSpace(r):
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || len(substitutions) > 0 {
+		return nil, errors.New("unterminated quote or command substitution")
+	}
+	endCommand()
+	return commands, nil
+}
+
+// programs returns the programs the command runs: its first word after variable assignments, the
+// command of a wrapper such as env or xargs, and the programs of a shell's -c command line.
+func programs(words []string) ([]string, error) {
+	for len(words) > 0 && isAssignment(words[0]) {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	program := words[0]
+	found := []string{program}
+	name := baseName(program)
+	switch {
+	case wrappers[name]:
+		rest := words[1:]
+		for len(rest) > 0 && (strings.HasPrefix(rest[0], "-") || isAssignment(rest[0])) {
+			rest = rest[1:]
+		}
+		if name == "timeout" && len(rest) > 0 {
+			rest = rest[1:] // The duration
+		}
+		if len(rest) > 0 {
+			inner, err := programs(rest)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, inner...)
+		}
+	case shells[name]:
+		if line, ok := shellLine(words); ok {
+			commands, err := parse(line)
+			if err != nil {
+				return nil, err
+			}
+			for _, command := range commands {
+				inner, err := programs(command.words)
+				if err != nil {
+					return nil, err
+				}
+				found = append(found, inner...)
+			}
+		}
+	}
+	return found, nil
+}
+
+// shellLine returns the command line of the -c option of a shell, given by the first word. The
+// option may be combined with others, e.g. -lc.
+func shellLine(words []string) (string, bool) {
+	for i, word := range words[1:] {
+		isOption := strings.HasPrefix(word, "-") && !strings.HasPrefix(word, "--")
+		if isOption && strings.Contains(word, "c") && i+2 < len(words) {
+			return words[i+2], true
+		}
+	}
+	return "", false
+}
+
+// isAssignment reports whether the word assigns a variable, e.g. GOOS=linux.
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// baseName returns the name of a program given by its path, e.g. rm for /bin/rm.
+func baseName(program string) string {
+	if i := strings.LastIndexAny(program, `/\`); i >= 0 {
+		return program[i+1:]
+	}
+	return program
+}
@@ -0,0 +1,383 @@
+// Package sandbox runs the command lines suggested by the model: only allowed programs, in a
+// working directory they may not name paths outside of, with an environment scrubbed of
+// credentials and a timeout. Every execution is logged for audit.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// auditFile is the log of the executions, in the state directory.
+const auditFile = "exec.jsonl"
+
+// waitDelay is how long the output of a killed command's children is waited for.
+const waitDelay = 2 * time.Second
+
+// defaultDeny are the programs that may not run without a deny list in the config: those that
+// gain privileges, shut the machine down, or overwrite disks, and eval, which can't be checked.
+var defaultDeny = []string{
+	"sudo", "su", "doas", "pkexec", "runas",
+	"shutdown", "reboot", "halt", "poweroff", "init",
+	"dd", "mkfs", "mkfs.*", "fdisk", "parted", "format",
+	"eval",
+}
+
+// defaultEnv are the environment variables passed on without an env list in the config: what
+// tools need to find themselves and their caches, but no tokens.
+var defaultEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TMPDIR", "TZ", "LANG", "LC_*",
+	"GOPATH", "GOROOT", "GOCACHE", "GOMODCACHE", "GOFLAGS", "GOPROXY", "GOPRIVATE",
+	"CARGO_HOME", "RUSTUP_HOME", "NVM_DIR", "VIRTUAL_ENV",
+	"SYSTEMROOT", "WINDIR", "COMSPEC", "PATHEXT", "USERPROFILE", "APPDATA", "LOCALAPPDATA", "TEMP", "TMP",
+}
+
+// devices may be named by commands, e.g. to discard output, although they are outside of the directory.
+var devices = []string{"/dev/null", "/dev/stdin", "/dev/stdout", "/dev/stderr", "/dev/tty"}
+
+// ErrDenied is returned for command lines the policy doesn't let run.
+var ErrDenied = errors.New("denied by the exec policy")
+
+// Policy decides which command lines may run, and how.
+type Policy struct {
+	Allow   []string      // Programs that may run, by name or glob pattern, all that aren't denied if empty
+	Deny    []string      // Programs that may never run
+	Env     []string      // Environment variables passed on, by name or glob pattern
+	Dir     string        // Absolute path of the directory the commands run in
+	Shell   string        // Shell that runs the command lines, sh (PowerShell on Windows) if empty
+	Timeout time.Duration // Time until a command is killed, none if 0
+}
+
+// NewPolicy creates the policy of the config for commands run in the directory, the working
+// directory if empty.
+func NewPolicy(cfg config.Config, dir string) (Policy, error) {
+	if dir == "" {
+		dir = "."
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+
+	p := Policy{
+		Allow:   cfg.Exec.Allow,
+		Deny:    cfg.Exec.Deny,
+		Env:     cfg.Exec.Env,
+		Dir:     abs,
+		Timeout: cfg.Exec.Timeout,
+	}
+	if p.Deny == nil {
+		p.Deny = defaultDeny
+	}
+	if p.Env == nil {
+		p.Env = defaultEnv
+	}
+	return p, nil
+}
+
+// Check reports why the command line may not run, wrapping ErrDenied, or nil if it may.
+func (p Policy) Check(line string) error {
+	commands, err := parse(line)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDenied, err)
+	}
+	if len(commands) == 0 {
+		return fmt.Errorf("%w: no command", ErrDenied)
+	}
+
+	for _, command := range commands {
+		found, err := programs(command.words)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrDenied, err)
+		}
+		for _, program := range found {
+			if strings.ContainsAny(program, "$*?[{~") {
+				return fmt.Errorf("%w: can't tell which program %s runs", ErrDenied, program)
+			}
+			name := baseName(program)
+			if matchAny(p.Deny, name) {
+				return fmt.Errorf("%w: %s is denied", ErrDenied, name)
+			}
+			if len(p.Allow) > 0 && !matchAny(p.Allow, name) {
+				return fmt.Errorf("%w: %s is not allowed", ErrDenied, name)
+			}
+		}
+		if err := p.checkPaths(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPaths reports why the command may name a path outside of the directory, or nil if it
+// can't. The command lines of shells' -c options are checked too.
+func (p Policy) checkPaths(command command) error {
+	if dir, ok := cdOutside(command.words); ok {
+		return fmt.Errorf("%w: cd to %s is outside of %s", ErrDenied, dir, p.Dir)
+	}
+	for i, word := range command.words {
+		if command.expanded[i] {
+			return fmt.Errorf("%w: can't tell which path %s names", ErrDenied, word)
+		}
+		if outside, ok := p.outside(word); ok {
+			return fmt.Errorf("%w: %s is outside of %s", ErrDenied, outside, p.Dir)
+		}
+		if !shells[baseName(word)] {
+			continue
+		}
+		if line, ok := shellLine(command.words[i:]); ok {
+			commands, err := parse(line)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrDenied, err)
+			}
+			for _, command := range commands {
+				if err := p.checkPaths(command); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cdOutside returns the directory a cd command without a directory, or with -, changes to.
+func cdOutside(words []string) (string, bool) {
+	for len(words) > 0 && (isAssignment(words[0]) || words[0] == "builtin" || words[0] == "command") {
+		words = words[1:]
+	}
+	if len(words) == 0 || words[0] != "cd" {
+		return "", false
+	}
+	args := words[1:]
+	for len(args) > 0 && len(args[0]) > 1 && strings.HasPrefix(args[0], "-") {
+		args = args[1:] // Options such as -P, and --
+	}
+	switch {
+	case len(args) == 0:
+		return "the home directory", true
+	case args[0] == "-":
+		return "the previous directory", true
+	}
+	return "", false
+}
+
+// outside returns the path a word names, or the value of an option or assignment in it, when
+// it may be outside of the directory. Absolute paths only count if they or their parent exist, so
+// e.g. the /pattern/ of awk doesn't, and glob patterns if they match such a path.
+func (p Policy) outside(word string) (string, bool) {
+	if _, value, ok := strings.Cut(word, "="); ok {
+		word = value
+	}
+	if word == "" || strings.Contains(word, "://") {
+		return "", false
+	}
+
+	target := word
+	switch {
+	case word == "~" || strings.HasPrefix(word, "~/"):
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return word, true
+		}
+		target = filepath.Join(home, word[1:])
+	case strings.HasPrefix(word, "~"):
+		return word, true // The home directory of another user, or ~+ and ~-
+	case strings.ContainsAny(word, "*?[{"):
+		if !filepath.IsAbs(word) {
+			target = filepath.Join(p.Dir, word)
+		}
+		return p.outsideGlob(word, target)
+	case filepath.IsAbs(word):
+		for _, device := range devices {
+			if word == device {
+				return "", false
+			}
+		}
+		if !exists(word) && !exists(filepath.Dir(word)) {
+			return "", false
+		}
+	case !strings.Contains(word, ".."):
+		return "", false
+	default:
+		target = filepath.Join(p.Dir, word)
+	}
+
+	if !p.inside(target) {
+		return word, true
+	}
+	return "", false
+}
+
+// outsideGlob returns the path outside of the directory that a glob pattern, the absolute form
+// of the word, may match. Parts such as .* that match .. count, as shells match them with it
+// (only a literal dot matches a leading one), and so do brace expansions, which Glob doesn't do,
+// with a directory outside of it.
+func (p Policy) outsideGlob(word, pattern string) (string, bool) {
+	parts := strings.FieldsFunc(word, func(r rune) bool { return r == '/' || r == filepath.Separator })
+	for _, part := range parts {
+		if ok, _ := path.Match(part, ".."); ok && strings.HasPrefix(part, ".") || strings.Contains(part, "{") && strings.Contains(part, "..") {
+			return word, true
+		}
+	}
+	if i := strings.Index(pattern, "{"); i >= 0 && !p.inside(filepath.Dir(pattern[:i])) {
+		return word, true
+	}
+
+	matches, _ := filepath.Glob(pattern)
+	for _, match := range matches {
+		if !p.inside(match) {
+			return match, true
+		}
+	}
+	return "", false
+}
+
+// inside reports whether the path is the directory or in it.
+func (p Policy) inside(target string) bool {
+	rel, err := filepath.Rel(p.Dir, filepath.Clean(target))
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+// Run checks the command line, and runs it in the directory with the scrubbed environment,
+// writing its output to stdout and stderr. It returns the exit code of the command, and an error
+// when it was denied, couldn't run, or timed out. The caller, e.g. "agent", is logged with it.
+func (p Policy) Run(ctx context.Context, caller, line string, stdout, stderr io.Writer) (int, error) {
+	entry := auditEntry{Time: time.Now(), Caller: caller, Command: line, Dir: p.Dir, ExitCode: -1}
+	defer func() {
+		entry.Duration = time.Since(entry.Time).Round(time.Millisecond).String()
+		if err := appendAudit(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to log execution: %v\n", err)
+		}
+	}()
+
+	if err := p.Check(line); err != nil {
+		entry.Error = err.Error()
+		return -1, err
+	}
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	shell, flag := p.shell()
+	cmd := exec.CommandContext(ctx, shell, flag, line)
+	cmd.Dir = p.Dir
+	cmd.Env = p.environ()
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.WaitDelay = waitDelay
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("%s timed out after %s", line, p.Timeout)
+	case errors.As(err, &exitErr):
+		entry.ExitCode = exitErr.ExitCode()
+		return entry.ExitCode, nil
+	case err != nil:
+		err = fmt.Errorf("failed to run %s: %w", line, err)
+	default:
+		entry.ExitCode = 0
+		return 0, nil
+	}
+	entry.Error = err.Error()
+	return -1, err
+}
+
+// shell returns the shell that runs the command lines, and its option taking a command line.
+func (p Policy) shell() (string, string) {
+	shell := p.Shell
+	if shell == "" {
+		shell = "sh"
+		if runtime.GOOS == "windows" {
+			shell = "powershell"
+		}
+	}
+	switch baseName(shell) {
+	case "powershell", "pwsh":
+		return shell, "-Command"
+	case "cmd":
+		return shell, "/C"
+	default:
+		return shell, "-c"
+	}
+}
+
+// environ returns the variables of the environment the policy passes on.
+func (p Policy) environ() []string {
+	var env []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if matchAny(p.Env, name) {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
+
+// matchAny reports whether the name matches one of the names or glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exists reports whether the path exists.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// auditEntry is an entry of the audit log.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Caller   string    `json:"caller"`
+	Command  string    `json:"command"`
+	Dir      string    `json:"dir"`
+	ExitCode int       `json:"exit_code"` // -1 when the command didn't run or didn't exit
+	Duration string    `json:"duration"`
+	Error    string    `json:"error,omitempty"` // Why the command was denied, or failed to run
+}
+
+// appendAudit appends an entry to the audit log.
+func appendAudit(entry auditEntry) error {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return fmt.Errorf("failed to get state path: %w", err)
+	}
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(stateDir, auditFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCheckPaths checks that command lines naming paths outside of the directory, including
+// through expansions the shell does, are denied.
+func TestCheckPaths(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "work")
+	for _, d := range []string{filepath.Join(dir, "sub"), filepath.Join(parent, "secret")} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret", "key"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", parent)
+	p := Policy{Dir: dir}
+
+	tests := []struct {
+		line   string
+		denied bool
+	}{
+		{"cat $HOME/.ssh/id_rsa", true},
+		{`cat "${HOME}/.ssh/id_rsa"`, true},
+		{"cat `echo ~`/.ssh/id_rsa", true},
+		{`cat "$(printf ~)"/.ssh/id_rsa`, true},
+		{"cd && cat .ssh/id_rsa", true},
+		{"cd -- && cat .ssh/id_rsa", true},
+		{"cd - && cat .ssh/id_rsa", true},
+		{"builtin cd; cat .ssh/id_rsa", true},
+		{"cat " + filepath.Join(parent, "*", "key"), true},
+		{"cat ../*/key", true},
+		{"cat .*/secret/key", true},
+		{"cat {..,x}/secret/key", true},
+		{"cat " + parent + "/{secret,x}/key", true},
+		{"cat ~root/.ssh/id_rsa", true},
+		{"cat ~-/secret/key", true},
+		{"cat ~/secret/key", true},
+		{"sh -c 'cat $HOME/.ssh/id_rsa'", true},
+		{"bash -lc 'cd; cat .ssh/id_rsa'", true},
+
+		{"cat '$HOME/.ssh/id_rsa'", false},
+		{`echo \$HOME`, false},
+		{"awk '{print $1}' go.mod", false},
+		{"awk '/^[0-9]+/' go.mod", false},
+		{"ls *.go sub/*", false},
+		{"cat {a,b}.go", false},
+		{"cd sub && ls", false},
+		{"ls > /dev/null", false},
+		{"sh -c 'ls *.go'", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			err := p.Check(tt.line)
+			if tt.denied && !errors.Is(err, ErrDenied) {
+				t.Errorf("got %v, want it denied", err)
+			}
+			if !tt.denied && err != nil {
+				t.Errorf("got %v, want it allowed", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+// Package schema validates JSON values against a JSON Schema, so structured answers can be checked
+// before they are used. It covers the keywords that describe the shape of a value, those that
+// structured outputs support: types, enums, properties, items, bounds, patterns, combinators, and
+// local references. Formats and other annotations are ignored.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDepth bounds the references followed while validating a value, against recursive schemas.
+const maxDepth = 64
+
+// Schema is a parsed JSON Schema.
+type Schema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// Parse parses a JSON Schema document.
+func Parse(data []byte) (*Schema, error) {
+	var root any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	switch root.(type) {
+	case map[string]any, bool:
+	default:
+		return nil, fmt.Errorf("a schema must be an object or a boolean, not %s", typeOf(root))
+	}
+
+	s := &Schema{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compilePatterns compiles the pattern keywords of the schema and its subschemas, so invalid ones
+// are reported up front.
+func (s *Schema) compilePatterns(node any) error {
+	switch node := node.(type) {
+	case map[string]any:
+		if pattern, ok := node["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			s.patterns[pattern] = re
+		}
+		for _, child := range node {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, child := range node {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateJSON parses the JSON document and validates it, returning why it doesn't match the
+// schema, or nothing when it does.
+func (s *Schema) ValidateJSON(data []byte) []string {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []string{fmt.Sprintf("not valid JSON: %v", err)}
+	}
+	return s.Validate(value)
+}
+
+// Validate returns why the value, as decoded by encoding/json into an any, doesn't match the
+// schema, one problem per entry prefixed with the path of the value, e.g. $.items[2].name.
+func (s *Schema) Validate(value any) []string {
+	return s.validate(s.root, value, "$", 0)
+}
+
+func (s *Schema) validate(node, value any, path string, depth int) []string {
+	if depth > maxDepth {
+		return []string{path + ": the schema's references are nested too deeply"}
+	}
+	var schema map[string]any
+	switch node := node.(type) {
+	case bool:
+		if !node {
+			return []string{path + ": no value is allowed here"}
+		}
+		return nil
+	case map[string]any:
+		schema = node
+	default:
+		return nil
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			return []string{fmt.Sprintf("%s: %v", path, err)}
+		}
+		if errs := s.validate(target, value, path, depth+1); len(errs) > 0 {
+			return errs
+		}
+	}
+
+	if errs := checkType(schema, value, path); len(errs) > 0 {
+		return errs // The other keywords would only repeat the mismatch
+	}
+
+	var errs []string
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(v any) bool { return reflect.DeepEqual(v, value) }) {
+		errs = append(errs, fmt.Sprintf("%s: must be one of %s", path, compact(enum)))
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		errs = append(errs, fmt.Sprintf("%s: must be %s", path, compact(constant)))
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		errs = append(errs, s.validateObject(schema, value, path, depth)...)
+	case []any:
+		errs = append(errs, s.validateArray(schema, value, path, depth)...)
+	case string:
+		errs = append(errs, s.validateString(schema, value, path)...)
+	case float64:
+		errs = append(errs, validateNumber(schema, value, path)...)
+	}
+	return append(errs, s.validateCombinators(schema, value, path, depth)...)
+}
+
+// resolve returns the subschema of a local reference, e.g. #/$defs/item.
+func (s *Schema) resolve(ref string) (any, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("only local references are supported, not %s", ref)
+	}
+	node := s.root
+	if pointer == "" {
+		return node, nil
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch n := node.(type) {
+		case map[string]any:
+			node, ok = n[token]
+		case []any:
+			i, err := strconv.Atoi(token)
+			ok = err == nil && i >= 0 && i < len(n)
+			if ok {
+				node = n[i]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("unresolved reference %s", ref)
+		}
+	}
+	return node, nil
+}
+
+// checkType checks the type keyword, a type or a list of them.
+func checkType(schema map[string]any, value any, path string) []string {
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				types = append(types, name)
+			}
+		}
+	default:
+		return nil
+	}
+
+	actual := typeOf(value)
+	for _, name := range types {
+		if name == actual || name == "number" && actual == "integer" {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("%s: must be of type %s, not %s", path, strings.Join(types, " or "), actual)}
+}
+
+// typeOf returns the JSON Schema type of a decoded value.
+func typeOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) && !math.IsInf(value, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func (s *Schema) validateObject(schema, object map[string]any, path string, depth int) []string {
+	var errs []string
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := object[name]; !ok {
+					errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	slices.Sort(names) // Problems in a stable order
+	for _, name := range names {
+		childPath := path + "." + name
+		if property, ok := properties[name]; ok {
+			errs = append(errs, s.validate(property, object[name], childPath, depth+1)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, fmt.Sprintf("%s: unexpected property %q", path, name))
+			}
+		case map[string]any:
+			errs = append(errs, s.validate(additional, object[name], childPath, depth+1)...)
+		}
+	}
+
+	if n, ok := number(schema, "minProperties"); ok && float64(len(object)) < n {
+		errs = append(errs, fmt.Sprintf("%s: must have at least %v properties", path, n))
+	}
+	if n, ok := number(schema, "maxProperties"); ok && float64(len(object)) > n {
+		errs = append(errs, fmt.Sprintf("%s: must have at most %v properties", path, n))
+	}
+	return errs
+}
+
+func (s *Schema) validateArray(schema map[string]any, array []any, path string, depth int) []string {
+	var errs []string
+	if items, ok := schema["items"]; ok {
+		for i, item := range array {
+			errs = append(errs, s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), depth+1)...)
+		}
+	}
+	if n, ok := number(schema, "minItems"); ok && float64(len(array)) < n {
+		errs = append(errs, fmt.Sprintf("%s: must have at least %v items", path, n))
+	}
+	if n, ok := number(schema, "maxItems"); ok && float64(len(array)) > n {
+		errs = append(errs, fmt.Sprintf("%s: must have at most %v items", path, n))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range array {
+			for j := range i {
+				if reflect.DeepEqual(array[i], array[j]) {
+					errs = append(errs, fmt.Sprintf("%s: items %d and %d must be unique", path, j, i))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func (s *Schema) validateString(schema map[string]any, value, path string) []string {
+	var errs []string
+	length := float64(utf8.RuneCountInString(value))
+	if n, ok := number(schema, "minLength"); ok && length < n {
+		errs = append(errs, fmt.Sprintf("%s: must be at least %v characters long", path, n))
+	}
+	if n, ok := number(schema, "maxLength"); ok && length > n {
+		errs = append(errs, fmt.Sprintf("%s: must be at most %v characters long", path, n))
+	}
+	if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(value) {
+		errs = append(errs, fmt.Sprintf("%s: must match the pattern %q", path, pattern))
+	}
+	return errs
+}
+
+func validateNumber(schema map[string]any, value float64, path string) []string {
+	var errs []string
+	if n, ok := number(schema, "minimum"); ok && value < n {
+		errs = append(errs, fmt.Sprintf("%s: must be at least %v", path, n))
+	}
+	if n, ok := number(schema, "maximum"); ok && value > n {
+		errs = append(errs, fmt.Sprintf("%s: must be at most %v", path, n))
+	}
+	if n, ok := number(schema, "exclusiveMinimum"); ok && value <= n {
+		errs = append(errs, fmt.Sprintf("%s: must be greater than %v", path, n))
+	}
+	if n, ok := number(schema, "exclusiveMaximum"); ok && value >= n {
+		errs = append(errs, fmt.Sprintf("%s: must be less than %v", path, n))
+	}
+	if n, ok := number(schema, "multipleOf"); ok && n > 0 && math.Abs(math.Remainder(value, n)) > 1e-9 {
+		errs = append(errs, fmt.Sprintf("%s: must be a multiple of %v", path, n))
+	}
+	return errs
+}
+
+// validateCombinators checks allOf, anyOf, oneOf, and not.
+func (s *Schema) validateCombinators(schema map[string]any, value any, path string, depth int) []string {
+	var errs []string
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			errs = append(errs, s.validate(sub, value, path, depth+1)...)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok && s.matching(anyOf, value, path, depth) == 0 {
+		errs = append(errs, fmt.Sprintf("%s: must match at least one of the anyOf schemas", path))
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		if n := s.matching(oneOf, value, path, depth); n != 1 {
+			errs = append(errs, fmt.Sprintf("%s: must match exactly one of the oneOf schemas, matches %d", path, n))
+		}
+	}
+	if not, ok := schema["not"]; ok && len(s.validate(not, value, path, depth+1)) == 0 {
+		errs = append(errs, fmt.Sprintf("%s: must not match the not schema", path))
+	}
+	return errs
+}
+
+// matching returns how many of the schemas the value matches.
+func (s *Schema) matching(schemas []any, value any, path string, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(s.validate(sub, value, path, depth+1)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// number returns a numeric keyword of the schema.
+func number(schema map[string]any, keyword string) (float64, bool) {
+	n, ok := schema[keyword].(float64)
+	return n, ok
+}
+
+// compact returns the value as compact JSON, for messages.
+func compact(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,93 @@
+// Package scope confines retrieval and git operations to a subdirectory of a repository, e.g. one
+// project of a monorepo.
+package scope
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Scope is a directory that confines the files a command reads. The zero Scope contains everything.
+type Scope struct {
+	Dir    string // Absolute path of the directory
+	Prefix string // Slash-separated path of the directory relative to the repository root, with a trailing slash
+}
+
+// Resolve resolves the directory to a scope. Outside of a git repository, paths relative to the
+// repository can't be scoped, and only the files below the directory are.
+func Resolve(ctx context.Context, dir string) (Scope, error) {
+	if dir == "" {
+		return Scope{}, nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return Scope{}, fmt.Errorf("failed to resolve scope %s: %w", dir, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil || !info.IsDir() {
+		return Scope{}, fmt.Errorf("scope %s is not a directory", dir)
+	}
+
+	s := Scope{Dir: abs}
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--show-prefix")
+	cmd.Dir = abs
+	if out, err := cmd.Output(); err == nil {
+		s.Prefix = strings.TrimSpace(string(out))
+	}
+	return s, nil
+}
+
+// IsSet reports whether the scope confines anything.
+func (s Scope) IsSet() bool {
+	return s.Dir != ""
+}
+
+// Contains reports whether the file, a path of the file system, is inside the scope.
+func (s Scope) Contains(file string) bool {
+	if !s.IsSet() {
+		return true
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(s.Dir, abs)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// ContainsRepoPath reports whether the slash-separated path relative to the repository root is inside the scope.
+func (s Scope) ContainsRepoPath(file string) bool {
+	return s.Prefix == "" || strings.HasPrefix(path.Clean(file)+"/", s.Prefix)
+}
+
+// Pathspec returns the arguments that limit a git command to the scope, to append after its other arguments.
+func (s Scope) Pathspec() []string {
+	if !s.IsSet() {
+		return nil
+	}
+	return []string{"--", s.Dir}
+}
+
+// FilterDiff keeps the files of a unified diff of the repository that are inside the scope.
+func (s Scope) FilterDiff(diff string) string {
+	if s.Prefix == "" {
+		return diff
+	}
+	var b strings.Builder
+	keep := true
+	for line := range strings.Lines(diff) {
+		if rest, ok := strings.CutPrefix(line, "diff --git a/"); ok {
+			file, _, _ := strings.Cut(rest, " b/")
+			keep = s.ContainsRepoPath(file)
+		}
+		if keep {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package serve
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// keyName is the file in the state directory holding the API key of the TCP server.
+const keyName = "serve.key"
+
+// LoadAPIKey returns the API key callers of the TCP server must send as a bearer token, creating
+// it on first use. It is kept in the state directory, so it survives restarts of the server.
+func LoadAPIKey() (key, path string, err error) {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", "", err
+	}
+	path = filepath.Join(stateDir, keyName)
+
+	data, err := os.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data)), path, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", fmt.Errorf("failed to read API key: %w", err)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key = "ghc-" + hex.EncodeToString(secret)
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return "", "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+		return "", "", fmt.Errorf("failed to write API key: %w", err)
+	}
+	return key, path, nil
+}
+
+// probes are the routes served without the API key, for supervisors that can't send it.
+var probes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// guard rejects the requests a web page could make the browser send, so browsing can't spend the
+// user's Copilot access: pages can post text/plain bodies without a CORS preflight, and reach the
+// server through a name of theirs that resolves to 127.0.0.1 (DNS rebinding). Browsers always
+// send the Host, and an Origin with cross-origin requests, which pages can't forge. Requests of
+// other processes need the API key, except for the probes.
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loopbackHost(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("host %q is not a loopback name", r.Host))
+			return
+		}
+		if r.Header.Get("Origin") != "" {
+			writeError(w, http.StatusForbidden, "requests from web pages are not allowed")
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "the content type must be application/json")
+				return
+			}
+		}
+		if s.apiKey != "" && !probes[r.URL.Path] {
+			key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(key), []byte(s.apiKey)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "invalid API key, see "+keyName+" in the state directory")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loopbackHost reports whether the Host header names the local machine: localhost, a name
+// below it, or a loopback address, with or without a port.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,144 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned when a request can't be queued because too many are already waiting.
+var ErrQueueFull = errors.New("request queue is full")
+
+// Queue bounds the number of concurrent upstream requests. Waiting requests are granted a slot
+// one client at a time, so a client sending a burst of requests can't starve the others.
+type Queue struct {
+	mu      sync.Mutex
+	limit   int                        // Maximum number of concurrent requests
+	size    int                        // Maximum number of waiting requests
+	active  int                        // Requests holding a slot
+	waiting int                        // Requests waiting for a slot
+	clients []string                   // Clients with waiting requests, in round-robin order
+	waiters map[string][]chan struct{} // Waiting requests of each client, in arrival order
+}
+
+// NewQueue creates a queue allowing limit concurrent requests and size waiting requests.
+func NewQueue(limit, size int) *Queue {
+	return &Queue{
+		limit:   max(limit, 1),
+		size:    max(size, 0),
+		waiters: make(map[string][]chan struct{}),
+	}
+}
+
+// Acquire waits for a slot for the client's request, returning the function that releases it.
+// It fails with ErrQueueFull when the queue is saturated, or the context's error when it is canceled.
+func (q *Queue) Acquire(ctx context.Context, client string) (release func(), err error) {
+	q.mu.Lock()
+	if q.active < q.limit && q.waiting == 0 {
+		q.active++
+		q.mu.Unlock()
+		return q.releaser(), nil
+	}
+	if q.waiting >= q.size {
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	ready := make(chan struct{})
+	if len(q.waiters[client]) == 0 {
+		q.clients = append(q.clients, client)
+	}
+	q.waiters[client] = append(q.waiters[client], ready)
+	q.waiting++
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return q.releaser(), nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		removed := q.remove(client, ready)
+		q.mu.Unlock()
+		if !removed {
+			// The slot was granted while the context was canceled, so hand it on
+			q.releaser()()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Resize changes the number of concurrent and waiting requests. Requests already waiting stay
+// queued even if they exceed the new size.
+func (q *Queue) Resize(limit, size int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = max(limit, 1)
+	q.size = max(size, 0)
+	q.grant()
+}
+
+// Stats returns the number of active and waiting requests.
+func (q *Queue) Stats() (active, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.active, q.waiting
+}
+
+// releaser returns a function that releases a slot once, however often it is called.
+func (q *Queue) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.active--
+			q.grant()
+		})
+	}
+}
+
+// grant hands the free slots to the waiting requests, taking turns between the clients.
+func (q *Queue) grant() {
+	for q.active < q.limit && len(q.clients) > 0 {
+		client := q.clients[0]
+		q.clients = q.clients[1:]
+
+		waiters := q.waiters[client]
+		if len(waiters) > 1 {
+			q.waiters[client] = waiters[1:]
+			q.clients = append(q.clients, client) // Back of the line for the client's next request
+		} else {
+			delete(q.waiters, client)
+		}
+
+		q.waiting--
+		q.active++
+		close(waiters[0])
+	}
+}
+
+// remove takes a waiting request out of the queue, reporting false if it was already granted a slot.
+func (q *Queue) remove(client string, ready chan struct{}) bool {
+	waiters := q.waiters[client]
+	for i, waiter := range waiters {
+		if waiter != ready {
+			continue
+		}
+
+		q.waiting--
+		if len(waiters) > 1 {
+			q.waiters[client] = append(waiters[:i:i], waiters[i+1:]...)
+			return true
+		}
+
+		delete(q.waiters, client)
+		for j, c := range q.clients {
+			if c == client {
+				q.clients = append(q.clients[:j:j], q.clients[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
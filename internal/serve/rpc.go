@@ -0,0 +1,645 @@
+package serve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/stream"
+	"github.com/markis/gh-copilot/internal/tokens"
+)
+
+const (
+	rpcVersion    = "2.0"
+	contentLength = "Content-Length"
+	cancelMethod  = "$/cancelRequest" // Notification canceling a request in flight, as in LSP
+	authMethod    = "auth"            // Request with the API key, which must come first over TCP
+	deltaMethod   = "chat/delta"      // Notification carrying a part of a streamed answer
+
+	// Error codes of the JSON-RPC specification, one of its server range for failed upstream requests
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	codeUpstreamError  = -32000
+	codeUnauthorized   = -32001 // Of the server range, for requests before a successful auth
+	codeCanceled       = -32800 // Of LSP, for requests canceled with $/cancelRequest
+)
+
+// rpcRequest is a JSON-RPC request, or a notification when it has no ID.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is the response to a request, with either a result or an error.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcNotification is a message sent to the caller without expecting a response.
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// rpcError is the error of a failed request.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// Error returns the message of the error.
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcMethod handles the request with the ID and params, returning its result.
+type rpcMethod func(c *rpcConn, ctx context.Context, id, params json.RawMessage) (any, error)
+
+// rpcMethods are the methods served over JSON-RPC.
+var rpcMethods = map[string]rpcMethod{
+	"chat":       (*rpcConn).chat,
+	"embeddings": (*rpcConn).embeddings,
+	"models":     (*rpcConn).models,
+	"ready":      (*rpcConn).ready,
+}
+
+// ServeRPC serves JSON-RPC 2.0 for editor plugins, which keep a single process with a cached
+// token instead of spawning one per request. It serves stdin and stdout for an empty address,
+// until stdin is closed, or else every connection to the address, like ListenAndServe. Messages
+// are separated by newlines, or framed by Content-Length headers as in LSP; responses follow
+// the framing of the caller's first message. Callers of a TCP address send the API key of
+// LoadAPIKey in an auth request first, as any local process can connect to it.
+func (s *Server) ServeRPC(ctx context.Context, addr string) error {
+	go s.reloadOnHangup(ctx)
+
+	if addr == "" {
+		fmt.Fprintln(os.Stderr, "Serving JSON-RPC on stdin and stdout")
+		done := make(chan struct{})
+		go func() {
+			s.serveConn(ctx, os.Stdin, os.Stdout, "stdio")
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done(): // Reading stdin can't be interrupted
+		}
+		return nil
+	}
+
+	listener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	if listener.Addr().Network() == "unix" {
+		fmt.Fprintf(os.Stderr, "Serving JSON-RPC on %s%s\n", unixPrefix, listener.Addr())
+	} else {
+		var keyPath string
+		if s.apiKey, keyPath, err = LoadAPIKey(); err != nil {
+			_ = listener.Close()
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Serving JSON-RPC on %s\n", listener.Addr())
+		fmt.Fprintf(os.Stderr, "Send the API key in %s with an %s request first\n", keyPath, authMethod)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = listener.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for seq := 1; ; seq++ {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = conn.Close() }()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			// Each connection is a caller of its own, for fair queueing and usage accounting
+			s.serveConn(ctx, conn, conn, "rpc:"+strconv.Itoa(seq))
+		}()
+	}
+}
+
+// reloadOnHangup reloads the config on SIGHUP, until the context is canceled.
+func (s *Server) reloadOnHangup(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-hangups:
+			s.reload(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rpcConn is a JSON-RPC session with a caller, whose requests are handled concurrently.
+type rpcConn struct {
+	server *Server
+	caller string
+	reader *bufio.Reader
+
+	writeMu sync.Mutex
+	writer  io.Writer
+	framed  atomic.Bool // Messages are framed by Content-Length headers instead of newlines
+	authed  bool        // The caller sent the API key, or none is needed
+
+	cancelMu sync.Mutex
+	cancels  map[string]context.CancelFunc // Requests in flight, by ID
+}
+
+// serveConn reads the caller's messages until the reader is closed, then waits for the requests in flight.
+func (s *Server) serveConn(ctx context.Context, r io.Reader, w io.Writer, caller string) {
+	c := &rpcConn{
+		server:  s,
+		caller:  caller,
+		reader:  bufio.NewReader(r),
+		writer:  w,
+		authed:  s.apiKey == "",
+		cancels: make(map[string]context.CancelFunc),
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for first := true; ; first = false {
+		data, framed, err := c.read()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				s.logger.Debug("failed to read message", "client", caller, "error", err)
+			}
+			return
+		}
+		if first {
+			c.framed.Store(framed)
+		}
+
+		if data[0] == '[' {
+			c.reply(nil, nil, &rpcError{Code: codeInvalidRequest, Message: "batches are not supported"})
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.reply(nil, nil, &rpcError{Code: codeParseError, Message: "invalid JSON: " + err.Error()})
+			continue
+		}
+		if req.JSONRPC != rpcVersion || req.Method == "" {
+			c.reply(req.ID, nil, &rpcError{Code: codeInvalidRequest, Message: "expected a JSON-RPC 2.0 request"})
+			continue
+		}
+		if req.Method == authMethod {
+			c.authenticate(req)
+			continue
+		}
+		if !c.authed {
+			c.reply(req.ID, nil, &rpcError{Code: codeUnauthorized, Message: "send the API key in an " + authMethod + " request first"})
+			continue
+		}
+		if req.Method == cancelMethod {
+			c.cancel(req.Params)
+			continue
+		}
+		if req.ID == nil {
+			continue // The methods are all requests, a notification of them has no one to answer to
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.handle(ctx, req)
+		}()
+	}
+}
+
+// authenticate checks the API key of an auth request, which unlocks the other methods.
+func (c *rpcConn) authenticate(req rpcRequest) {
+	var p struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(req.Params, &p); err != nil {
+		c.reply(req.ID, nil, &rpcError{Code: codeInvalidParams, Message: `params must be {"key": "<API key>"}`})
+		return
+	}
+	if c.server.apiKey != "" && subtle.ConstantTimeCompare([]byte(p.Key), []byte(c.server.apiKey)) != 1 {
+		c.reply(req.ID, nil, &rpcError{Code: codeUnauthorized, Message: "invalid API key, see " + keyName + " in the state directory"})
+		return
+	}
+	c.authed = true
+	if req.ID != nil {
+		c.reply(req.ID, map[string]any{"authenticated": true}, nil)
+	}
+}
+
+// read reads the next message, and whether it was framed by headers rather than a newline. An
+// HTTP request, e.g. one a web page made the browser send, ends the session instead of having
+// its body read as a message.
+func (c *rpcConn) read() ([]byte, bool, error) {
+	for {
+		line, err := c.reader.ReadBytes('\n')
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			if err != nil {
+				return nil, false, err
+			}
+			continue // Blank lines between messages
+		}
+		if trimmed[0] == '{' || trimmed[0] == '[' {
+			return trimmed, false, nil // A partial last line fails to decode, and the next read ends the session
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if fields := strings.Fields(string(trimmed)); len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/") {
+			return nil, false, fmt.Errorf("HTTP request %s %s is not JSON-RPC", fields[0], fields[1])
+		}
+
+		// Headers, up to a blank line, then a body of the announced length
+		size := -1
+		for len(trimmed) > 0 {
+			name, value, ok := strings.Cut(string(trimmed), ":")
+			if !ok {
+				return nil, true, fmt.Errorf("invalid header line: %.100q", trimmed)
+			}
+			if strings.EqualFold(strings.TrimSpace(name), contentLength) {
+				if size, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || size < 0 {
+					return nil, true, fmt.Errorf("invalid %s header: %q", contentLength, value)
+				}
+			}
+			if line, err = c.reader.ReadBytes('\n'); err != nil {
+				return nil, true, err
+			}
+			trimmed = bytes.TrimSpace(line)
+		}
+		if size < 0 {
+			return nil, true, fmt.Errorf("message without a %s header", contentLength)
+		}
+		if size > maxBodySize {
+			return nil, true, fmt.Errorf("message of %d bytes is too large", size)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, body); err != nil {
+			return nil, true, err
+		}
+		return body, true, nil
+	}
+}
+
+// write writes a message in the framing of the session.
+func (c *rpcConn) write(message any) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		c.server.logger.Debug("failed to encode message", "error", err)
+		return
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.framed.Load() {
+		_, err = fmt.Fprintf(c.writer, "%s: %d\r\n\r\n%s", contentLength, len(data), data)
+	} else {
+		_, err = fmt.Fprintf(c.writer, "%s\n", data)
+	}
+	if err != nil {
+		c.server.logger.Debug("failed to write message", "client", c.caller, "error", err)
+	}
+}
+
+// reply responds to the request with the ID, with its result or error.
+func (c *rpcConn) reply(id json.RawMessage, result any, err error) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	response := rpcResponse{JSONRPC: rpcVersion, ID: id, Result: result}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		response.Result, response.Error = nil, rpcErr
+	}
+	c.write(response)
+}
+
+// notify sends a notification to the caller.
+func (c *rpcConn) notify(method string, params any) {
+	c.write(rpcNotification{JSONRPC: rpcVersion, Method: method, Params: params})
+}
+
+// handle calls the method of the request and replies with its result, unless the request was canceled.
+func (c *rpcConn) handle(ctx context.Context, req rpcRequest) {
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		c.reply(req.ID, nil, &rpcError{Code: codeMethodNotFound, Message: "unknown method " + req.Method})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	key := string(req.ID)
+	c.cancelMu.Lock()
+	c.cancels[key] = cancel
+	c.cancelMu.Unlock()
+	defer func() {
+		c.cancelMu.Lock()
+		delete(c.cancels, key)
+		c.cancelMu.Unlock()
+		cancel()
+	}()
+
+	result, err := method(c, ctx, req.ID, req.Params)
+	if err != nil && ctx.Err() != nil {
+		err = &rpcError{Code: codeCanceled, Message: "request canceled"}
+	}
+	c.reply(req.ID, result, err)
+}
+
+// cancel cancels the request in flight with the ID of the params.
+func (c *rpcConn) cancel(params json.RawMessage) {
+	var p struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.ID == nil {
+		return
+	}
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	if cancel, ok := c.cancels[string(p.ID)]; ok {
+		cancel()
+	}
+}
+
+// chatResult is the result of a chat request.
+type chatResult struct {
+	Model        string   `json:"model"`
+	Content      string   `json:"content"`
+	FinishReason string   `json:"finish_reason,omitempty"`
+	Filtered     []string `json:"filtered,omitempty"` // Categories of the content filter that blocked content
+	Usage        Usage    `json:"usage"`
+}
+
+// chat answers a chat completion request, whose params are those of the OpenAI API. With stream
+// set, the parts of the answer are sent as chat/delta notifications with the ID of the request
+// before the response, which holds the whole answer either way.
+func (c *rpcConn) chat(ctx context.Context, id, params json.RawMessage) (any, error) {
+	cfg := c.server.config() // The same config for the whole request, even if it is reloaded
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(params, &request); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "params must be a chat completion request"}
+	}
+	var notify bool
+	if raw, ok := request["stream"]; ok {
+		if err := json.Unmarshal(raw, &notify); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "stream must be a boolean"}
+		}
+	}
+	// Upstream always streams, the whole answer is collected from its parts
+	request["stream"] = json.RawMessage("true")
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	body, model, status, err := resolveModel(cfg, body)
+	if err != nil {
+		return nil, statusError(status, err)
+	}
+	release, err := c.acquire(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ContextTimeout)
+	defer cancel()
+	resp, err := client.Post(ctx, cfg, "/chat/completions", body, "text/event-stream")
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.server.logger.Debug("failed to close upstream response", "error", err)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusResponseError(resp)
+	}
+
+	// Models that don't stream answer with a whole JSON response anyway
+	contentType := resp.Header.Get("Content-Type")
+	meter := &usageMeter{stream: strings.HasPrefix(contentType, "text/event-stream")}
+	parser := stream.NewParser(ctx)
+	// The parser closes the body itself when the request is canceled, to abort a stalled read
+	go parser.ProcessResponse(contentType, struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, meter), resp.Body})
+
+	result := chatResult{Model: model}
+	var content strings.Builder
+	var streamErr error
+	for chunk := range parser.Chunks() { // Drained to the end, so the parser isn't left blocked
+		if chunk.Error != nil {
+			if streamErr == nil {
+				streamErr = chunk.Error
+			}
+			continue
+		}
+		if chunk.Index != 0 {
+			continue
+		}
+		content.WriteString(chunk.Content)
+		if chunk.FinishReason != "" {
+			result.FinishReason = chunk.FinishReason
+		}
+		if len(chunk.Filtered) > 0 {
+			result.Filtered = chunk.Filtered
+		}
+		if notify && chunk.Content != "" {
+			c.notify(deltaMethod, map[string]any{"id": id, "content": chunk.Content})
+		}
+	}
+	// A canceled stream may end without the chunk saying so, its answer is cut off all the same
+	if streamErr == nil {
+		streamErr = ctx.Err()
+	}
+	if streamErr != nil {
+		return nil, upstreamError(streamErr)
+	}
+
+	result.Content = content.String()
+	result.Usage = meter.Usage(body)
+	c.record(model, result.Usage)
+	return result, nil
+}
+
+// embeddings embeds the input, a string or list of strings, with the model of the params or the
+// configured embedding model.
+func (c *rpcConn) embeddings(ctx context.Context, _, params json.RawMessage) (any, error) {
+	cfg := c.server.config()
+	var request struct {
+		Input json.RawMessage `json:"input"`
+		Model string          `json:"model"`
+	}
+	if err := json.Unmarshal(params, &request); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "params must be an embeddings request"}
+	}
+	var texts []string
+	if err := json.Unmarshal(request.Input, &texts); err != nil {
+		var text string
+		if err := json.Unmarshal(request.Input, &text); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "input must be a string or a list of strings"}
+		}
+		texts = []string{text}
+	}
+	if len(texts) == 0 {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "input is empty"}
+	}
+
+	model := request.Model
+	if model == "" {
+		model = cfg.Rag.EmbeddingModel
+	}
+	model = cfg.ResolveModel(cfg.Serve.ModelMap.Map(model))
+	if !modelAllowed(cfg.Serve.AllowedModels, model) {
+		return nil, statusError(http.StatusForbidden, fmt.Errorf("model %s is not allowed by this server", model))
+	}
+
+	release, err := c.acquire(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ContextTimeout)
+	defer cancel()
+	inputs := make([]client.EmbeddingInput, len(texts))
+	usage := Usage{Requests: 1, Estimated: true}
+	for i, text := range texts {
+		inputs[i] = client.EmbeddingInput{Content: text, Filetype: "raw"}
+		usage.PromptTokens += tokens.Estimate(text)
+	}
+	embeddings, err := client.GenerateEmbeddings(ctx, cfg, inputs, model)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	c.record(model, usage)
+	return map[string]any{"model": model, "data": embeddings}, nil
+}
+
+// models lists the models of the Copilot API that this server allows.
+func (c *rpcConn) models(ctx context.Context, _, _ json.RawMessage) (any, error) {
+	cfg := c.server.config()
+	ctx, cancel := context.WithTimeout(ctx, cfg.ContextTimeout)
+	defer cancel()
+	models, err := client.ListModels(ctx, cfg)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	allowed := make([]client.Model, 0, len(models))
+	for _, model := range models {
+		if modelAllowed(cfg.Serve.AllowedModels, model.ID) {
+			allowed = append(allowed, model)
+		}
+	}
+	return map[string]any{"models": allowed}, nil
+}
+
+// ready checks that the token exchange succeeds and the Copilot API is reachable.
+func (c *rpcConn) ready(ctx context.Context, _, _ json.RawMessage) (any, error) {
+	if err := c.server.ready(ctx); err != nil {
+		return nil, upstreamError(err)
+	}
+	return map[string]any{"ready": true}, nil
+}
+
+// acquire waits for a slot in the queue, failing with the delay to retry after when it is full.
+func (c *rpcConn) acquire(ctx context.Context, cfg config.Config) (func(), error) {
+	release, err := c.server.queue.Acquire(ctx, c.caller)
+	if errors.Is(err, ErrQueueFull) {
+		return nil, &rpcError{
+			Code:    codeUpstreamError,
+			Message: "too many requests queued, retry later",
+			Data:    map[string]any{"retry_after": retryAfter(cfg)},
+		}
+	}
+	return release, err
+}
+
+// record accounts the usage of a request to the caller.
+func (c *rpcConn) record(model string, usage Usage) {
+	c.server.logger.Debug("request served", "client", c.caller, "model", model,
+		"prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens)
+	if err := c.server.usage.Record(c.caller, model, usage); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to record usage: %v\n", err)
+	}
+}
+
+// statusError turns an error with the HTTP status of the equivalent endpoint into a JSON-RPC error.
+func statusError(status int, err error) *rpcError {
+	switch status {
+	case http.StatusBadRequest, http.StatusForbidden:
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	default:
+		return &rpcError{Code: codeInternalError, Message: err.Error()}
+	}
+}
+
+// upstreamError describes a failed request to the Copilot API, with the delay to retry after when it is known.
+func upstreamError(err error) *rpcError {
+	rpcErr := &rpcError{Code: codeUpstreamError, Message: err.Error()}
+	var circuitErr *client.CircuitOpenError
+	var rateErr *client.RateLimitError
+	switch {
+	case errors.As(err, &circuitErr):
+		rpcErr.Data = map[string]any{"retry_after": max(1, int(circuitErr.RetryIn.Seconds()))}
+	case errors.As(err, &rateErr):
+		rpcErr.Data = map[string]any{"retry_after": max(1, int(time.Until(rateErr.Reset).Seconds()))}
+	}
+	return rpcErr
+}
+
+// statusResponseError describes an upstream response with an error status, with its status and body.
+func statusResponseError(resp *http.Response) *rpcError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	return &rpcError{
+		Code:    codeUpstreamError,
+		Message: fmt.Sprintf("API request failed with status %d", resp.StatusCode),
+		Data:    map[string]any{"status": resp.StatusCode, "body": string(body)},
+	}
+}
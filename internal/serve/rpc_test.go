@@ -0,0 +1,106 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// testServer returns a server requiring the API key, if any, whose API fails the test when it
+// is called, as none of the requests of the tests may reach it.
+func testServer(t *testing.T, apiKey string) *Server {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("the API was called: %s %s", r.Method, r.URL.Path)
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	t.Cleanup(api.Close)
+
+	cfg, err := config.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Endpoints.API, cfg.Endpoints.GitHubAPI = api.URL, api.URL
+	s := New(context.Background(), cfg)
+	s.apiKey = apiKey
+	return s
+}
+
+// serveRPC serves the input as a session of a caller and returns what was written to it.
+func serveRPC(s *Server, input string) string {
+	var out bytes.Buffer
+	s.serveConn(context.Background(), strings.NewReader(input), &out, "test")
+	return out.String()
+}
+
+// decodeResponses decodes the newline separated responses of a session.
+func decodeResponses(out string) []rpcResponse {
+	var responses []rpcResponse
+	decoder := json.NewDecoder(strings.NewReader(out))
+	for decoder.More() {
+		var response rpcResponse
+		if err := decoder.Decode(&response); err != nil {
+			break
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// TestRPCRefusesHTTPRequest sends what a web page can make the browser send to a TCP address:
+// a POST whose body is a request, with the Content-Length of the body. It must not be handled.
+func TestRPCRefusesHTTPRequest(t *testing.T) {
+	body := `{"jsonrpc": "2.0", "id": 1, "method": "chat", "params": {"messages": [{"role": "user", "content": "hi"}]}}`
+	request := fmt.Sprintf("POST / HTTP/1.1\r\nHost: 127.0.0.1:8686\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+
+	for _, apiKey := range []string{"ghc-test", ""} {
+		if out := serveRPC(testServer(t, apiKey), request); out != "" {
+			t.Errorf("with API key %q, the HTTP request got a response:\n%s", apiKey, out)
+		}
+	}
+}
+
+// TestRPCAuth checks that methods are only dispatched after an auth request with the API key.
+func TestRPCAuth(t *testing.T) {
+	const apiKey = "ghc-test"
+	request := func(id int, method, params string) string {
+		return fmt.Sprintf(`{"jsonrpc": "2.0", "id": %d, "method": %q, "params": %s}`+"\n", id, method, params)
+	}
+
+	tests := []struct {
+		name   string
+		apiKey string
+		input  string
+		codes  []int // Error codes of the responses, 0 for a result
+	}{
+		{"no auth", apiKey, request(1, "models", "{}"), []int{codeUnauthorized}},
+		{"wrong key", apiKey, request(1, authMethod, `{"key": "ghc-wrong"}`) + request(2, "nope", "{}"),
+			[]int{codeUnauthorized, codeUnauthorized}},
+		{"right key", apiKey, request(1, authMethod, `{"key": "ghc-test"}`) + request(2, "nope", "{}"),
+			[]int{0, codeMethodNotFound}},
+		{"no key needed", "", request(1, "nope", "{}"), []int{codeMethodNotFound}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := decodeResponses(serveRPC(testServer(t, tt.apiKey), tt.input))
+			if len(responses) != len(tt.codes) {
+				t.Fatalf("got %d responses, want %d: %+v", len(responses), len(tt.codes), responses)
+			}
+			for i, response := range responses {
+				code := 0
+				if response.Error != nil {
+					code = response.Error.Code
+				}
+				if code != tt.codes[i] {
+					t.Errorf("response %d has code %d, want %d: %+v", i+1, code, tt.codes[i], response)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package serve
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logging"
+)
+
+const (
+	maxBodySize       = 10 << 20 // Largest accepted request body
+	shutdownTimeout   = 10 * time.Second
+	readHeaderTimeout = 10 * time.Second
+	clientHeader      = "X-Client-Id"    // Identifies callers sharing an address, for fair queueing
+	readyTTL          = 30 * time.Second // Readiness checks are cached, so frequent probes don't hammer the API
+	readyTimeout      = 10 * time.Second
+	unixPrefix        = "unix:"
+	socketName        = "serve.sock"
+)
+
+// Server exposes the Copilot API to local tools as an OpenAI-compatible endpoint,
+// taking care of the authentication and bounding the concurrent upstream requests.
+type Server struct {
+	cfgMu  sync.RWMutex
+	cfg    config.Config
+	queue  *Queue
+	usage  *UsageTracker
+	logger *slog.Logger
+	apiKey string // Bearer token callers must send, empty on a Unix domain socket only the user can access
+
+	readyMu      sync.Mutex
+	readyChecked time.Time
+	readyErr     error
+}
+
+// New creates a server for the configuration.
+func New(ctx context.Context, cfg config.Config) *Server {
+	return &Server{
+		cfg:    cfg,
+		queue:  NewQueue(cfg.Serve.MaxConcurrent, cfg.Serve.QueueSize),
+		usage:  NewUsageTracker(),
+		logger: logging.FromContext(ctx),
+	}
+}
+
+// config returns the current configuration, which changes when it is reloaded.
+func (s *Server) config() config.Config {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
+}
+
+// Reload replaces the configuration, e.g. the aliases, allowed models, and queue limits.
+// The listen address and HTTP client settings only change on restart.
+func (s *Server) Reload(cfg config.Config) {
+	s.cfgMu.Lock()
+	s.cfg = cfg
+	s.cfgMu.Unlock()
+	s.queue.Resize(cfg.Serve.MaxConcurrent, cfg.Serve.QueueSize)
+}
+
+// Handler returns the HTTP handler serving the API routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("POST /chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("GET /v1/models", s.handleModels)
+	mux.HandleFunc("GET /models", s.handleModels)
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /usage", s.handleUsage)
+	return s.guard(mux)
+}
+
+// ListenAndServe serves the API on the address until the context is canceled,
+// then waits for the requests in flight to finish. An address of the form unix:path
+// listens on a Unix domain socket, by default in the user's runtime directory.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	// Any local process, and web pages through the browser, can reach a TCP port
+	var keyPath string
+	if listener.Addr().Network() != "unix" {
+		if s.apiKey, keyPath, err = LoadAPIKey(); err != nil {
+			_ = listener.Close()
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		// Requests in flight outlive the shutdown signal, and keep the logger
+		BaseContext: func(net.Listener) context.Context { return context.WithoutCancel(ctx) },
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(listener)
+	}()
+	if listener.Addr().Network() == "unix" {
+		fmt.Fprintf(os.Stderr, "Serving the Copilot API on %s%s\n", unixPrefix, listener.Addr())
+	} else {
+		fmt.Fprintf(os.Stderr, "Serving the Copilot API on http://%s\n", listener.Addr())
+		fmt.Fprintf(os.Stderr, "Send the API key in %s as a bearer token\n", keyPath)
+	}
+
+	// SIGHUP reloads the config without dropping the requests in flight
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for done := false; !done; {
+		select {
+		case err := <-errs:
+			return err
+		case <-hangups:
+			s.reload(ctx)
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down: %w", err)
+	}
+	return nil
+}
+
+// reload reloads the config file, keeping the current config when it fails.
+func (s *Server) reload(ctx context.Context) {
+	cfg, err := config.LoadConfig(ctx, s.config().Profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Keeping the current config, reloading failed: %v\n", err)
+		return
+	}
+	s.Reload(cfg)
+	fmt.Fprintln(os.Stderr, "Reloaded the config")
+}
+
+// listen listens on a TCP address, or on a Unix domain socket for a unix:path address.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return listener, nil
+	}
+
+	if path == "" {
+		path = SocketPath()
+	}
+	// The proxy hands out the user's Copilot access, so only the user may reach it
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// SocketPath returns the default path of the server's Unix domain socket.
+func SocketPath() string {
+	return filepath.Join(config.RuntimePath(), socketName)
+}
+
+// removeStaleSocket removes a socket left behind by a server that didn't shut down cleanly,
+// refusing to replace one that is still served or a file that isn't a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat socket: %w", err)
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is already in use by another server", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// handleHealthz reports that the process is up.
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeStatus(w, http.StatusOK, "ok")
+}
+
+// handleReadyz reports whether the token exchange succeeds and the upstream API is reachable.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.ready(r.Context()); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeStatus(w, http.StatusOK, "ok")
+}
+
+// ready checks the upstream API, reusing the result of a recent check.
+func (s *Server) ready(ctx context.Context) error {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+
+	if time.Since(s.readyChecked) < readyTTL {
+		return s.readyErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	s.readyErr = client.Ping(ctx, s.config())
+	s.readyChecked = time.Now()
+	if s.readyErr != nil {
+		s.logger.Debug("readiness check failed", "error", s.readyErr)
+	}
+	return s.readyErr
+}
+
+// handleChatCompletions forwards a chat completion request upstream, streaming back the response.
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	cfg := s.config() // The same config for the whole request, even if it is reloaded
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+
+	body, model, status, err := resolveModel(cfg, body)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	caller := clientID(r, s.apiKey)
+	release, err := s.queue.Acquire(r.Context(), caller)
+	if errors.Is(err, ErrQueueFull) {
+		active, waiting := s.queue.Stats()
+		s.logger.Debug("request rejected", "client", caller, "active", active, "waiting", waiting)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(cfg)))
+		writeError(w, http.StatusTooManyRequests, "too many requests queued, retry later")
+		return
+	}
+	if err != nil {
+		return // The caller went away while queued
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.ContextTimeout)
+	defer cancel()
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		accept = "application/json"
+	}
+	resp, err := client.Post(ctx, cfg, "/chat/completions", body, accept)
+	var circuitErr *client.CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(circuitErr.RetryIn.Seconds()))))
+		writeError(w, http.StatusServiceUnavailable, circuitErr.Error())
+		return
+	}
+	var rateErr *client.RateLimitError
+	if errors.As(err, &rateErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(time.Until(rateErr.Reset).Seconds()))))
+		writeError(w, http.StatusTooManyRequests, rateErr.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			s.logger.Debug("failed to close upstream response", "error", err)
+		}
+	}()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	meter := &usageMeter{stream: strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")}
+	if err := copyFlushing(w, io.TeeReader(resp.Body, meter)); err != nil {
+		s.logger.Debug("failed to forward response", "error", err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		usage := meter.Usage(body)
+		s.logger.Debug("request served", "client", caller, "model", model,
+			"prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens)
+		if err := s.usage.Record(caller, model, usage); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to record usage: %v\n", err)
+		}
+	}
+}
+
+// openAIModel is a model in the format of the OpenAI API.
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// handleModels lists the models callers may request, in the OpenAI format: the allowed Copilot
+// models, and the names of the model map, so tools that check a model exists accept them.
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	cfg := s.config()
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.ContextTimeout)
+	defer cancel()
+	models, err := client.ListModels(ctx, cfg)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	data := make([]openAIModel, 0, len(models)+len(cfg.Serve.ModelMap))
+	for _, model := range models {
+		if modelAllowed(cfg.Serve.AllowedModels, model.ID) {
+			data = append(data, openAIModel{ID: model.ID, Object: "model", OwnedBy: cmp.Or(model.Vendor, "github-copilot")})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Serve.ModelMap)) {
+		// Patterns aren't models a caller could pick
+		if !strings.ContainsAny(name, "*?[") && modelAllowed(cfg.Serve.AllowedModels, cfg.ResolveModel(cfg.Serve.ModelMap[name])) {
+			data = append(data, openAIModel{ID: name, Object: "model", OwnedBy: "gh-copilot"})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+}
+
+// handleUsage reports the token usage per client since the server started.
+func (s *Server) handleUsage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"clients": s.usage.Snapshot()})
+}
+
+// resolveModel sets the model of the request body, defaulting to the configured model, mapping
+// it with the model map, and resolving aliases, and rejects models that aren't allowed. It returns the rewritten body,
+// the model, and the HTTP status for errors.
+func resolveModel(cfg config.Config, body []byte) ([]byte, string, int, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, "", http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	var model string
+	if raw, ok := request["model"]; ok {
+		if err := json.Unmarshal(raw, &model); err != nil {
+			return nil, "", http.StatusBadRequest, errors.New("model must be a string")
+		}
+	}
+	if model == "" {
+		model = cfg.Model
+	}
+	model = cfg.ResolveModel(cfg.Serve.ModelMap.Map(model))
+
+	if !modelAllowed(cfg.Serve.AllowedModels, model) {
+		return nil, "", http.StatusForbidden, fmt.Errorf("model %s is not allowed by this server", model)
+	}
+
+	raw, err := json.Marshal(model)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+	request["model"] = raw
+	body, err = json.Marshal(request)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, fmt.Errorf("failed to encode request: %w", err)
+	}
+	return body, model, http.StatusOK, nil
+}
+
+// modelAllowed checks the model against the allowlist of names and glob patterns, where an empty list allows all.
+func modelAllowed(allowed []string, model string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyFlushing copies the upstream response, flushing after every read so streamed events aren't delayed.
+func copyFlushing(w http.ResponseWriter, body io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// clientID identifies the caller for fair queueing and usage accounting: by its X-Client-Id
+// header, the API key it sends unless it is the server's, or its address.
+func clientID(r *http.Request, serverKey string) string {
+	if id := r.Header.Get(clientHeader); id != "" {
+		return id
+	}
+	// On a socket, callers only send dummy keys, but tools can be told apart by them
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && key != "" && key != serverKey {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:4])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+			return "unix" // Unix domain socket peers have no address
+		}
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// retryAfter returns the number of seconds rejected callers should wait before retrying.
+func retryAfter(cfg config.Config) int {
+	return max(1, int(cfg.Serve.RetryAfter.Round(time.Second)/time.Second))
+}
+
+// writeStatus writes a plain text status response for probes.
+func writeStatus(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintln(w, message)
+}
+
+// writeError writes an error response in the OpenAI format.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    http.StatusText(status),
+		},
+	})
+}
@@ -0,0 +1,260 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+const (
+	serviceName = "gh-copilot"
+	launchLabel = "com.github.markis.gh-copilot"
+)
+
+// ErrUnsupportedPlatform is returned when there is no service manager to install the server with.
+var ErrUnsupportedPlatform = fmt.Errorf("installing the server as a service is not supported on %s", runtime.GOOS)
+
+// systemdUnit is the user-level systemd unit running the server.
+var systemdUnit = template.Must(template.New("unit").Parse(`[Unit]
+Description=GitHub Copilot API server (gh copilot serve)
+After=network-online.target
+
+[Service]
+ExecStart={{ .Command }}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=default.target
+`))
+
+// launchdPlist is the launchd agent running the server.
+var launchdPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{ .Label | xml }}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args }}
+		<string>{{ . | xml }}</string>
+{{- end }}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardErrorPath</key>
+	<string>{{ .LogPath | xml }}</string>
+</dict>
+</plist>
+`))
+
+// serviceData fills the service templates.
+type serviceData struct {
+	Label   string
+	Command string   // Command line for systemd
+	Args    []string // Command line for launchd
+	LogPath string
+}
+
+// Install writes a user-level service running the server with the arguments and starts it,
+// returning the path of the service file.
+func Install(ctx context.Context, serveArgs []string) (string, error) {
+	gh, err := exec.LookPath("gh")
+	if err != nil {
+		return "", fmt.Errorf("failed to find gh: %w", err)
+	}
+	command := append([]string{gh, "copilot", "serve"}, serveArgs...)
+
+	switch runtime.GOOS {
+	case "linux":
+		return installSystemd(ctx, command)
+	case "darwin":
+		return installLaunchd(ctx, command)
+	default:
+		return "", ErrUnsupportedPlatform
+	}
+}
+
+// Uninstall stops the server's service and removes it, returning the path of the removed service file.
+func Uninstall(ctx context.Context) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return uninstallSystemd(ctx)
+	case "darwin":
+		return uninstallLaunchd(ctx)
+	default:
+		return "", ErrUnsupportedPlatform
+	}
+}
+
+// installSystemd writes and starts a systemd user unit.
+func installSystemd(ctx context.Context, command []string) (string, error) {
+	path, err := systemdUnitPath()
+	if err != nil {
+		return "", err
+	}
+
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = systemdQuote(arg)
+	}
+	if err := writeService(path, systemdUnit, serviceData{Command: strings.Join(quoted, " ")}); err != nil {
+		return "", err
+	}
+
+	if err := run(ctx, "systemctl", "--user", "daemon-reload"); err != nil {
+		return path, err
+	}
+	return path, run(ctx, "systemctl", "--user", "enable", "--now", serviceName+".service")
+}
+
+// uninstallSystemd stops and removes the systemd user unit.
+func uninstallSystemd(ctx context.Context) (string, error) {
+	path, err := systemdUnitPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("service is not installed: %w", err)
+	}
+
+	if err := run(ctx, "systemctl", "--user", "disable", "--now", serviceName+".service"); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove service: %w", err)
+	}
+	return path, run(ctx, "systemctl", "--user", "daemon-reload")
+}
+
+// installLaunchd writes and loads a launchd agent.
+func installLaunchd(ctx context.Context, command []string) (string, error) {
+	path, err := launchdPlistPath()
+	if err != nil {
+		return "", err
+	}
+
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data := serviceData{
+		Label:   launchLabel,
+		Args:    command,
+		LogPath: filepath.Join(stateDir, "serve.log"),
+	}
+	if err := writeService(path, launchdPlist, data); err != nil {
+		return "", err
+	}
+
+	// Reload an already installed agent so it picks up the new arguments
+	_ = run(ctx, "launchctl", "unload", path)
+	return path, run(ctx, "launchctl", "load", "-w", path)
+}
+
+// uninstallLaunchd unloads and removes the launchd agent.
+func uninstallLaunchd(ctx context.Context) (string, error) {
+	path, err := launchdPlistPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("service is not installed: %w", err)
+	}
+
+	if err := run(ctx, "launchctl", "unload", "-w", path); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove service: %w", err)
+	}
+	return path, nil
+}
+
+// systemdUnitPath returns the path of the systemd user unit.
+func systemdUnitPath() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "systemd", "user", serviceName+".service"), nil
+}
+
+// launchdPlistPath returns the path of the launchd agent.
+func launchdPlistPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchLabel+".plist"), nil
+}
+
+// writeService renders the service template to the path.
+func writeService(path string, tmpl *template.Template, data serviceData) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render service: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create service directory: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write service: %w", err)
+	}
+	return nil
+}
+
+// systemdQuote quotes an argument of a systemd ExecStart line when needed.
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%;") {
+		return arg
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%")
+	return `"` + replacer.Replace(arg) + `"`
+}
+
+// xmlEscape escapes text for the plist.
+func xmlEscape(text string) string {
+	var buf strings.Builder
+	_ = xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
+
+// run runs a service manager command, including its output in the error.
+func run(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%s %s failed: %s", name, strings.Join(args, " "), strings.TrimSpace(string(out)))
+		}
+		return fmt.Errorf("failed to run %s: %w", name, err)
+	}
+	return nil
+}
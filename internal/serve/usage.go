@@ -0,0 +1,282 @@
+package serve
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/tokens"
+)
+
+// usageFile is the log of the token usage of served requests, in the state directory.
+const usageFile = "usage.jsonl"
+
+// Usage is the token usage of one or more requests.
+type Usage struct {
+	Requests         int  `json:"requests"`
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	Estimated        bool `json:"estimated,omitempty"` // Some counts are estimates, as upstream didn't report them
+}
+
+// Add adds the usage of other requests.
+func (u *Usage) Add(other Usage) {
+	u.Requests += other.Requests
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.Estimated = u.Estimated || other.Estimated
+}
+
+// UsageRecord is an entry of the usage log.
+type UsageRecord struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Model  string    `json:"model,omitempty"`
+	Usage
+}
+
+// UsageSummary is the usage of a client with a model.
+type UsageSummary struct {
+	Client string
+	Model  string
+	Usage
+}
+
+// UsageTracker aggregates the token usage per client since the server started, and logs
+// each request for `stats usage`.
+type UsageTracker struct {
+	mu      sync.Mutex
+	clients map[string]*Usage
+}
+
+// NewUsageTracker creates an empty usage tracker.
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{clients: make(map[string]*Usage)}
+}
+
+// Record adds the usage of a request by the client, and appends it to the usage log.
+func (t *UsageTracker) Record(client, model string, usage Usage) error {
+	t.mu.Lock()
+	if t.clients[client] == nil {
+		t.clients[client] = &Usage{}
+	}
+	t.clients[client].Add(usage)
+	t.mu.Unlock()
+
+	return appendUsage(UsageRecord{Time: time.Now(), Client: client, Model: model, Usage: usage})
+}
+
+// Snapshot returns the usage per client since the server started.
+func (t *UsageTracker) Snapshot() map[string]Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := make(map[string]Usage, len(t.clients))
+	for client, usage := range t.clients {
+		snapshot[client] = *usage
+	}
+	return snapshot
+}
+
+// LoadUsage reads all records of the usage log.
+func LoadUsage() ([]UsageRecord, error) {
+	path, err := usagePath()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage log: %w", err)
+	}
+	defer file.Close()
+
+	var records []UsageRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // Skip corrupt lines, e.g. from an interrupted write
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage log: %w", err)
+	}
+	return records, nil
+}
+
+// SummarizeUsage aggregates the usage records per client and model, heaviest clients first.
+func SummarizeUsage(records []UsageRecord) []UsageSummary {
+	type key struct{ client, model string }
+	summaries := make(map[key]*UsageSummary)
+	totals := make(map[string]int)
+	for _, record := range records {
+		k := key{record.Client, record.Model}
+		if summaries[k] == nil {
+			summaries[k] = &UsageSummary{Client: record.Client, Model: record.Model}
+		}
+		summaries[k].Add(record.Usage)
+		totals[record.Client] += record.PromptTokens + record.CompletionTokens
+	}
+
+	result := make([]UsageSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Client != result[j].Client {
+			if totals[result[i].Client] != totals[result[j].Client] {
+				return totals[result[i].Client] > totals[result[j].Client]
+			}
+			return result[i].Client < result[j].Client
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result
+}
+
+// appendUsage appends a record to the usage log.
+func appendUsage(record UsageRecord) error {
+	path, err := usagePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open usage log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	return nil
+}
+
+// usagePath retrieves the path of the usage log.
+func usagePath() (string, error) {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	return filepath.Join(stateDir, usageFile), nil
+}
+
+// usageEvent holds the parts of a completion response, or one of its stream events, that tell the usage.
+type usageEvent struct {
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// usageMeter reads the token usage from an upstream response while it is forwarded. When upstream
+// doesn't report the usage, the completion tokens are estimated from the content.
+type usageMeter struct {
+	stream   bool
+	pending  bytes.Buffer // Incomplete stream line, or the whole response body
+	reported *Usage
+	content  strings.Builder
+}
+
+// Write receives the forwarded response.
+func (m *usageMeter) Write(p []byte) (int, error) {
+	if !m.stream {
+		if m.pending.Len() < maxBodySize {
+			m.pending.Write(p)
+		}
+		return len(p), nil
+	}
+
+	m.pending.Write(p)
+	for {
+		line, err := m.pending.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			m.pending.Reset()
+			m.pending.WriteString(line)
+			return len(p), nil
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok {
+			m.parse([]byte(strings.TrimSpace(data)))
+		}
+	}
+}
+
+// parse takes the usage and content of a response or stream event.
+func (m *usageMeter) parse(data []byte) {
+	var event usageEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return // e.g. the [DONE] marker
+	}
+	if event.Usage != nil {
+		m.reported = &Usage{PromptTokens: event.Usage.PromptTokens, CompletionTokens: event.Usage.CompletionTokens}
+	}
+	for _, choice := range event.Choices {
+		m.content.WriteString(choice.Delta.Content)
+		m.content.WriteString(choice.Message.Content)
+	}
+}
+
+// Usage returns the usage of the request, estimating the prompt tokens from the request body if needed.
+func (m *usageMeter) Usage(request []byte) Usage {
+	if !m.stream {
+		m.parse(m.pending.Bytes())
+	}
+	if m.reported != nil {
+		usage := *m.reported
+		usage.Requests = 1
+		return usage
+	}
+
+	var body struct {
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	_ = json.Unmarshal(request, &body)
+	prompt := 0
+	for _, message := range body.Messages {
+		var text string
+		if err := json.Unmarshal(message.Content, &text); err != nil {
+			text = string(message.Content) // Content parts
+		}
+		prompt += tokens.Estimate(text)
+	}
+
+	return Usage{
+		Requests:         1,
+		PromptTokens:     prompt,
+		CompletionTokens: tokens.Estimate(m.content.String()),
+		Estimated:        true,
+	}
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Export formats.
+const (
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+	FormatJSON     = "json"
+)
+
+// timeLayout is how the turns' timestamps are shown.
+const timeLayout = "2006-01-02 15:04:05"
+
+// htmlPage is the standalone document of the HTML export. The turns' markdown is converted
+// without its raw HTML, so a conversation can't inject markup into the page.
+var htmlPage = template.Must(template.New("session").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { max-width: 50rem; margin: 2rem auto; padding: 0 1rem; font-family: system-ui, sans-serif; line-height: 1.5; }
+section { border-top: 1px solid #ddd; padding: 0.5rem 0; }
+section.user { background: #f6f8fa; padding: 0.5rem 1rem; }
+header { color: #57606a; font-size: 0.9rem; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+code { font-family: ui-monospace, monospace; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 0.25rem 0.5rem; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>Started {{.Created}}{{with .Models}}, models: {{.}}{{end}}</p>
+{{range .Turns}}<section class="{{.Role}}">
+<header>{{.Author}} · {{.Time}}</header>
+{{.Body}}</section>
+{{end}}</body>
+</html>
+`))
+
+// Export writes the session as a shareable document in the format.
+func Export(w io.Writer, s *Session, format string) error {
+	switch format {
+	case FormatMarkdown:
+		return exportMarkdown(w, s)
+	case FormatHTML:
+		return exportHTML(w, s)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s)
+	default:
+		return fmt.Errorf("invalid format %q: must be md, html, or json", format)
+	}
+}
+
+// exportMarkdown writes the session as a markdown document with a heading per turn.
+func exportMarkdown(w io.Writer, s *Session) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\nStarted %s", s.Name, s.Created.Format(timeLayout))
+	if models := s.Models(); len(models) > 0 {
+		fmt.Fprintf(&b, ", models: %s", strings.Join(models, ", "))
+	}
+	b.WriteString("\n")
+
+	for _, turn := range s.Turns {
+		fmt.Fprintf(&b, "\n## %s · %s\n\n%s\n", author(turn), turn.Time.Format(timeLayout), strings.TrimSpace(turn.Content))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// exportHTML writes the session as a standalone HTML document.
+func exportHTML(w io.Writer, s *Session) error {
+	markdown := goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	type turnView struct {
+		Role, Author, Time string
+		Body               template.HTML
+	}
+	turns := make([]turnView, 0, len(s.Turns))
+	for _, turn := range s.Turns {
+		var body bytes.Buffer
+		if err := markdown.Convert([]byte(turn.Content), &body); err != nil {
+			return fmt.Errorf("failed to convert markdown: %w", err)
+		}
+		turns = append(turns, turnView{
+			Role:   turn.Role,
+			Author: author(turn),
+			Time:   turn.Time.Format(timeLayout),
+			Body:   template.HTML(body.String()), // Raw HTML in the markdown is omitted by goldmark
+		})
+	}
+
+	return htmlPage.Execute(w, map[string]any{
+		"Name":    s.Name,
+		"Created": s.Created.Format(timeLayout),
+		"Models":  strings.Join(s.Models(), ", "),
+		"Turns":   turns,
+	})
+}
+
+// author names who wrote the turn: the user, or the model that answered.
+func author(turn Turn) string {
+	switch {
+	case turn.Role == "user":
+		return "You"
+	case turn.Model != "":
+		return turn.Model
+	default:
+		return turn.Role
+	}
+}
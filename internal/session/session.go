@@ -0,0 +1,183 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+const (
+	sessionDir = "sessions"
+	fileExt    = ".json"
+)
+
+// ErrNotFound is returned when there is no stored session of the name.
+var ErrNotFound = errors.New("session not found")
+
+// validName restricts session names to ones that are safe as file names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Turn is a message of the conversation.
+type Turn struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Model   string    `json:"model,omitempty"` // Model that wrote an assistant turn
+	Time    time.Time `json:"time"`
+}
+
+// Session is a stored chat conversation.
+type Session struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Turns   []Turn    `json:"turns"`
+}
+
+// New creates an empty session, named after the current time when the name is empty.
+func New(name string) (*Session, error) {
+	now := time.Now()
+	if name == "" {
+		name = now.Format("2006-01-02-150405")
+	}
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	return &Session{Name: name, Created: now, Updated: now}, nil
+}
+
+// ValidateName checks that the name can be used for a session.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid session name %q: use letters, digits, '.', '_', and '-'", name)
+	}
+	return nil
+}
+
+// Models returns the models that wrote the assistant turns, in the order they were first used.
+func (s *Session) Models() []string {
+	var models []string
+	for _, turn := range s.Turns {
+		if turn.Model != "" && !slices.Contains(models, turn.Model) {
+			models = append(models, turn.Model)
+		}
+	}
+	return models
+}
+
+// Load reads the stored session of the name.
+func Load(name string) (*Session, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	path, err := sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", name, err)
+	}
+	return &s, nil
+}
+
+// Save stores the session, replacing an earlier version of it.
+func (s *Session) Save() error {
+	path, err := sessionPath(s.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	s.Updated = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	// A crash while writing must not lose the conversation saved before
+	tmp, err := os.CreateTemp(filepath.Dir(path), s.Name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create session file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// List returns the stored sessions, most recently updated first.
+func List() ([]*Session, error) {
+	dir, err := getSessionPath()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := make([]*Session, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), fileExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		s, err := Load(name)
+		if err != nil {
+			continue // Skip unreadable sessions instead of failing the listing
+		}
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+	return sessions, nil
+}
+
+// sessionPath retrieves the path of the session's file.
+func sessionPath(name string) (string, error) {
+	dir, err := getSessionPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+fileExt), nil
+}
+
+// getSessionPath retrieves the directory of the stored sessions.
+func getSessionPath() (string, error) {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	return filepath.Join(stateDir, sessionDir), nil
+}
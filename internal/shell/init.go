@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scripts define the widgets of each shell: Ctrl-G replaces the command line with a suggestion
+// for it, and Alt-G explains it.
+var scripts = map[string]string{
+	"zsh": `# gh copilot: Ctrl-G replaces the command line with a suggestion, Alt-G explains it
+_gh_copilot_suggest() {
+  [[ -z $BUFFER ]] && return
+  zle -R "Asking Copilot..."
+  local suggestion
+  suggestion=$(gh copilot shell suggest --shell zsh -- "$BUFFER" </dev/null 2>/dev/null)
+  if [[ $? -eq 0 && -n $suggestion ]]; then
+    BUFFER=$suggestion
+    CURSOR=${#BUFFER}
+    zle -R
+  else
+    zle -M "gh copilot: no suggestion, run gh copilot shell suggest to see why"
+  fi
+}
+_gh_copilot_explain() {
+  [[ -z $BUFFER ]] && return
+  zle -I
+  gh copilot shell explain --shell zsh -- "$BUFFER" </dev/null
+}
+zle -N _gh_copilot_suggest
+zle -N _gh_copilot_explain
+bindkey '^G' _gh_copilot_suggest
+bindkey '^[g' _gh_copilot_explain
+`,
+	"bash": `# gh copilot: Ctrl-G replaces the command line with a suggestion, Alt-G explains it
+__gh_copilot_suggest() {
+  [[ -z $READLINE_LINE ]] && return
+  local suggestion
+  suggestion=$(gh copilot shell suggest --shell bash -- "$READLINE_LINE" </dev/null 2>/dev/null)
+  if [[ $? -eq 0 && -n $suggestion ]]; then
+    READLINE_LINE=$suggestion
+    READLINE_POINT=${#READLINE_LINE}
+  else
+    echo "gh copilot: no suggestion, run gh copilot shell suggest to see why" >&2
+  fi
+}
+__gh_copilot_explain() {
+  [[ -z $READLINE_LINE ]] && return
+  gh copilot shell explain --shell bash -- "$READLINE_LINE" </dev/null
+}
+bind -x '"\C-g": __gh_copilot_suggest'
+bind -x '"\eg": __gh_copilot_explain'
+`,
+	"fish": `# gh copilot: Ctrl-G replaces the command line with a suggestion, Alt-G explains it
+function __gh_copilot_suggest
+    set -l line (commandline | string collect)
+    test -n "$line"; or return
+    if set -l suggestion (gh copilot shell suggest --shell fish -- $line </dev/null 2>/dev/null); and test -n "$suggestion"
+        commandline -r -- (string join \n -- $suggestion)
+        commandline -f end-of-buffer
+    else
+        echo "gh copilot: no suggestion, run gh copilot shell suggest to see why" >&2
+        commandline -f repaint
+    end
+end
+function __gh_copilot_explain
+    set -l line (commandline | string collect)
+    test -n "$line"; or return
+    echo
+    gh copilot shell explain --shell fish -- $line </dev/null
+    commandline -f repaint
+end
+bind \cg __gh_copilot_suggest
+bind \eg __gh_copilot_explain
+`,
+}
+
+// Init returns the script that defines the widgets of the shell and binds them to keys.
+func Init(shell string) (string, error) {
+	script, ok := scripts[shell]
+	if !ok {
+		return "", fmt.Errorf("unsupported shell %q: must be %s", shell, strings.Join(Supported(), ", "))
+	}
+	return script, nil
+}
+
+// Supported returns the shells Init has a script for.
+func Supported() []string {
+	return []string{"bash", "fish", "zsh"}
+}
@@ -0,0 +1,125 @@
+// Package shell suggests and explains command lines for the widgets that `gh copilot init` binds
+// to keys of the shell's line editor.
+package shell
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/codeblock"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/sandbox"
+	"golang.org/x/term"
+)
+
+// suggestPrompt asks for nothing but the command line, which the widget puts in place of the buffer.
+const suggestPrompt = `You write command lines for %s on %s. You are given a description of a task, or a command line
+to complete or fix. Reply only with a single command line that does it, without explanation and without a code block.`
+
+// explainPrompt asks for an explanation of each part of the command line.
+const explainPrompt = `You explain command lines for %s on %s. Say what the command line does in one sentence, then
+explain each command, option, and argument in a short list, and warn about anything destructive.`
+
+// Suggest prints a command line for the task or the command line given as arguments, and nothing
+// else, so a widget can replace the line editor's buffer with it. With --run, it runs the command
+// line in the exec sandbox once the user confirms it.
+func Suggest(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	line := strings.TrimSpace(strings.Join(append(args.ActionArgs, args.Prompts...), " "))
+	if line == "" {
+		return errors.New("shell suggest needs a task or a command line")
+	}
+
+	completeCtx, cancel := context.WithTimeout(ctx, cfg.ContextTimeout)
+	defer cancel()
+	answer, err := client.Complete(completeCtx, cfg, args.Model, []client.Message{
+		{Role: client.SystemRole, Content: fmt.Sprintf(suggestPrompt, Name(args.Shell.Name), runtime.GOOS)},
+		{Role: client.UserRole, Content: line},
+	})
+	if err != nil {
+		return err
+	}
+	suggestion := answer
+	if blocks := codeblock.Parse(answer); len(blocks) > 0 {
+		suggestion = blocks[0].Code // Some models fence it anyway
+	}
+	suggestion = strings.TrimPrefix(strings.TrimSpace(suggestion), "$ ")
+	if suggestion == "" {
+		return errors.New("the model suggested no command line")
+	}
+	fmt.Println(suggestion)
+	if args.Shell.Run {
+		return run(ctx, cfg, args, suggestion)
+	}
+	return nil
+}
+
+// run runs the suggested command line in the exec sandbox, once the user confirms it.
+func run(ctx context.Context, cfg config.Config, args args.Arguments, line string) error {
+	policy, err := sandbox.NewPolicy(cfg, args.Scope.Dir)
+	if err != nil {
+		return err
+	}
+	policy.Shell = Name(args.Shell.Name)
+	if err := policy.Check(line); err != nil {
+		return fmt.Errorf("refusing to run the command line: %w", err)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("--run needs a terminal to confirm the command line on")
+	}
+
+	fmt.Fprint(os.Stderr, "Run it? [y/N]: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return nil
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return nil
+	}
+
+	code, err := policy.Run(ctx, "shell suggest", line, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("the command line failed with exit status %d", code)
+	}
+	return nil
+}
+
+// Explain asks the model to explain the command line given as arguments.
+func Explain(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	line := strings.TrimSpace(strings.Join(append(args.ActionArgs, args.Prompts...), " "))
+	if line == "" {
+		return errors.New("shell explain needs a command line")
+	}
+
+	shell := Name(args.Shell.Name)
+	args.Prompts = []string{fmt.Sprintf("Explain this %s command line:\n\n```%s\n%s\n```", shell, shell, line)}
+	args.System = strings.TrimSpace(args.System + "\n\n" + fmt.Sprintf(explainPrompt, shell, runtime.GOOS))
+	args.Command = args.Action
+	args.Action = ""
+	return client.Ask(ctx, cfg, args)
+}
+
+// Name returns the name of the shell, the one of $SHELL when none is given.
+func Name(shell string) string {
+	if shell != "" {
+		return shell
+	}
+	if path := os.Getenv("SHELL"); path != "" {
+		return filepath.Base(path)
+	}
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	return "sh"
+}
@@ -2,9 +2,14 @@ package stream
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"mime"
+	"slices"
 	"strings"
+	"time"
 )
 
 // ChatResponse represents the structure of the response from the chat API.
@@ -16,65 +21,239 @@ type ChatResponse struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
+		Index                int                     `json:"index"`
+		FinishReason         string                  `json:"finish_reason"`
+		ContentFilterResults map[string]filterResult `json:"content_filter_results"`
 	} `json:"choices"`
+	Error *APIError `json:"error"` // Sent instead of the choices when the answer fails mid-stream
 }
 
+// filterResult is the verdict of a category of the content filter on a choice, e.g. of "hate".
+type filterResult struct {
+	Filtered bool `json:"filtered"`
+}
+
+// event is a server-sent event, read field by field until the blank line that ends it.
+type event struct {
+	kind string   // Of the event: field, empty for the default "message"
+	data []string // Of the data: fields, one per line
+}
+
+// Process parses the events of the body into chunks, closing the channel at the end of the
+// stream. The body is closed as soon as the context is done, so a read blocked on a stalled stream
+// returns at once, and the last chunk carries the context's error instead of the read's, if the
+// consumer still takes it, see send.
 func (p *Parser) Process(body io.ReadCloser) {
 	defer close(p.chunks)
 
+	p.start = time.Now()
+	defer func() {
+		p.logger.Debug("stream finished", "chunks", p.count, "duration", time.Since(p.start))
+	}()
+
+	stop := context.AfterFunc(p.ctx, func() {
+		if err := body.Close(); err != nil {
+			p.logger.Debug("failed to close stream on cancellation", "error", err)
+		}
+	})
+	defer stop()
+
 	reader := bufio.NewReaderSize(body, 4096)
 	scanner := bufio.NewScanner(reader)
 	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	scanner.Split(bufio.ScanLines)
 
-	var (
-		chunk ChatResponse
-		done  = p.ctx.Done()
-	)
-
+	var ev event
 	for {
-		select {
-		case <-done:
-			p.chunks <- Chunk{Error: p.ctx.Err()}
+		if err := p.ctx.Err(); err != nil {
+			p.send(Chunk{Kind: KindCanceled, Error: err})
 			return
-		default:
-			if ok := p.processChunk(scanner, chunk); !ok {
-				return
-			}
 		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		if line == "" {
+			p.dispatch(ev)
+			ev = event{}
+			continue
+		}
+		p.readField(&ev, line)
+	}
+
+	// A read that failed because the body was closed on cancellation reports the cancellation
+	switch {
+	case p.ctx.Err() != nil:
+		p.send(Chunk{Kind: KindCanceled, Error: p.ctx.Err()})
+	case scanner.Err() != nil:
+		p.send(Chunk{Kind: KindInvalid, Error: scanner.Err()})
+	default:
+		p.dispatch(ev) // The last event, if the stream ended without the blank line after it
 	}
 }
 
-func (p *Parser) processChunk(scanner *bufio.Scanner, chunk ChatResponse) bool {
-	if !scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			p.chunks <- Chunk{Error: err}
+// ProcessJSON parses a whole chat response, of models that don't stream or of requests that
+// didn't ask to, into a chunk per choice, closing the channel after them. Like Process, it closes
+// the body as soon as the context is done.
+func (p *Parser) ProcessJSON(body io.ReadCloser) {
+	defer close(p.chunks)
+
+	p.start = time.Now()
+	defer func() {
+		p.logger.Debug("response parsed", "chunks", p.count, "duration", time.Since(p.start))
+	}()
+
+	stop := context.AfterFunc(p.ctx, func() {
+		if err := body.Close(); err != nil {
+			p.logger.Debug("failed to close response on cancellation", "error", err)
+		}
+	})
+	defer stop()
+
+	var response ChatResponse
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		if p.ctx.Err() != nil {
+			p.send(Chunk{Kind: KindCanceled, Error: p.ctx.Err()})
+		} else {
+			p.send(Chunk{Kind: KindInvalid, Error: fmt.Errorf("failed to decode response: %w", err)})
 		}
-		return false
+		return
+	}
+	p.emit(response)
+}
+
+// ProcessResponse parses the body by its content type: as server-sent events, or as a whole JSON
+// response, which some models send even when asked to stream.
+func (p *Parser) ProcessResponse(contentType string, body io.ReadCloser) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		p.ProcessJSON(body)
+		return
+	}
+	p.Process(body)
+}
+
+// readField adds the field of an SSE line to the event. Comments, which servers send as
+// heartbeats, and the id and retry fields are ignored.
+func (p *Parser) readField(ev *event, line string) {
+	if strings.HasPrefix(line, ":") {
+		p.logger.Debug("stream heartbeat", "comment", truncate(line[1:], 200))
+		return
 	}
+	if strings.HasPrefix(line, "{") {
+		// A bare JSON line, as some proxies send, is an event of its own
+		p.dispatch(event{data: []string{line}})
+		return
+	}
+
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch field {
+	case "event":
+		ev.kind = value
+	case "data":
+		ev.data = append(ev.data, value)
+	case "id", "retry":
+	default:
+		p.logger.Debug("unknown stream field", "line", truncate(line, 200))
+	}
+}
 
-	line := scanner.Text()
+// dispatch sends the chunk of a complete event: the content of an answer, or an error.
+func (p *Parser) dispatch(ev event) {
+	if len(ev.data) == 0 {
+		return
+	}
+	data := strings.Join(ev.data, "\n")
+	if data == "[DONE]" {
+		return
+	}
 
-	// Fast path for empty lines and done marker
-	switch line {
-	case "", "data: [DONE]":
-		return true
+	switch ev.kind {
+	case "", "message":
+	case "error":
+		apiErr := parseAPIError(data)
+		p.logger.Debug("stream error event", "error", apiErr)
+		p.send(Chunk{Kind: KindError, Error: apiErr})
+		return
+	default:
+		p.logger.Debug("skipping stream event", "event", ev.kind, "data", truncate(data, 200))
+		return
 	}
 
-	data := strings.TrimPrefix(line, "data: ")
+	// A fresh value per event, decoding into a reused one would keep fields the event omits
+	var chunk ChatResponse
 	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-		p.chunks <- Chunk{Error: err}
-		return true
+		p.logger.Debug("failed to parse stream event", "error", err, "data", truncate(data, 200))
+		p.send(Chunk{Kind: KindInvalid, Error: err})
+		return
+	}
+	p.emit(chunk)
+}
+
+// emit sends the chunks of a response or stream event, one per choice with content, a finish
+// reason, or blocked content, or its error.
+func (p *Parser) emit(chunk ChatResponse) {
+	if chunk.Error != nil {
+		p.logger.Debug("stream error event", "error", chunk.Error)
+		p.send(Chunk{Kind: KindError, Error: chunk.Error})
+		return
 	}
 
-	if len(chunk.Choices) > 0 {
-		content := chunk.Choices[0].Delta.Content
+	// With n > 1, the choices are told apart by their index
+	for _, choice := range chunk.Choices {
+		content := choice.Delta.Content
 		if content == "" {
-			content = chunk.Choices[0].Message.Content
+			content = choice.Message.Content
 		}
 		if content != "" {
-			p.chunks <- Chunk{Content: content}
+			if p.count == 0 {
+				p.logger.Debug("first chunk received", "latency", time.Since(p.start))
+			}
+			p.count++
+		}
+		var filtered []string
+		for category, result := range choice.ContentFilterResults {
+			if result.Filtered {
+				filtered = append(filtered, category)
+			}
 		}
+		slices.Sort(filtered)
+		if content != "" || choice.FinishReason != "" || len(filtered) > 0 {
+			p.send(Chunk{Content: content, Index: choice.Index, FinishReason: choice.FinishReason, Filtered: filtered})
+		}
+	}
+}
+
+// send passes the chunk to the consumer, unless the context is done first: a consumer that stopped
+// reading, e.g. on cancellation, would leave the parser blocked for good. A canceled stream can so
+// end without its KindCanceled chunk, consumers that need to know check their context.
+func (p *Parser) send(chunk Chunk) {
+	select {
+	case p.chunks <- chunk:
+	case <-p.ctx.Done():
+	}
+}
+
+// parseAPIError parses the data of an error event: an error object, wrapped in {"error": ...} or
+// not, or a plain message.
+func parseAPIError(data string) *APIError {
+	var wrapped struct {
+		Error *APIError `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(data), &wrapped); err == nil && wrapped.Error != nil {
+		return wrapped.Error
+	}
+	var apiErr APIError
+	if err := json.Unmarshal([]byte(data), &apiErr); err == nil && apiErr.Message != "" {
+		return &apiErr
+	}
+	return &APIError{Message: data}
+}
+
+// truncate shortens a string for logging.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
 	}
-	return true
+	return s[:n] + "..."
 }
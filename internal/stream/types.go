@@ -1,18 +1,44 @@
 package stream
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/logging"
+)
+
+// Kind is the type of a chunk.
+type Kind int
+
+const (
+	KindContent  Kind = iota // Content of the answer, or the finish reason of a choice
+	KindError                // Error event the API sent mid-stream, Error is an *APIError
+	KindInvalid              // Event that couldn't be parsed, or a failed read
+	KindCanceled             // Last chunk of a stream whose context is done
+)
 
 // Chunk represents a processed piece of content from the stream
 type Chunk struct {
-	Content string
-	Done    bool
-	Error   error
+	Kind         Kind
+	Content      string
+	Index        int      // Choice the content belongs to
+	FinishReason string   // Why the model stopped, e.g. "stop" or "length", on the choice's last chunk
+	Filtered     []string // Categories of the content filter that blocked content, e.g. "violence"
+	Done         bool
+	Error        error
 }
 
-// Parser handles the processing of raw stream data into chunks
+// Parser handles the processing of raw stream data into chunks. A Parser processes a single
+// response; concurrent streams each use their own.
 type Parser struct {
 	ctx    context.Context
 	chunks chan Chunk
+	logger *slog.Logger
+	start  time.Time
+	count  int // Number of content chunks emitted
 }
 
 // NewParser creates a new Parser instance with a context and a channel for chunks
@@ -20,6 +46,7 @@ func NewParser(ctx context.Context) *Parser {
 	return &Parser{
 		ctx:    ctx,
 		chunks: make(chan Chunk),
+		logger: logging.FromContext(ctx),
 	}
 }
 
@@ -27,3 +54,45 @@ func NewParser(ctx context.Context) *Parser {
 func (p *Parser) Chunks() <-chan Chunk {
 	return p.chunks
 }
+
+// APIError is an error the API sends in the stream after the response started, e.g. when the
+// content filter or a rate limit stops the answer.
+type APIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    any    `json:"code"` // A string or a number, depending on the API
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = "unknown error"
+	}
+	if e.Code != nil && e.Code != "" {
+		return fmt.Sprintf("the API sent an error: %s (%v)", message, e.Code)
+	}
+	return "the API sent an error: " + message
+}
+
+// Finish reasons of an incomplete answer.
+const (
+	FinishLength        = "length"         // The answer reached the token limit
+	FinishContentFilter = "content_filter" // The content filter blocked the rest of the answer
+)
+
+// Notice explains why the answer of the choice is incomplete, from the finish reason and the
+// categories of the content filter, or returns "" when it is complete.
+func Notice(finishReason string, filtered []string) string {
+	switch {
+	case finishReason == FinishContentFilter || len(filtered) > 0:
+		notice := "the answer was blocked by the content filter"
+		if len(filtered) > 0 {
+			notice += " (" + strings.Join(filtered, ", ") + ")"
+		}
+		return notice
+	case finishReason == FinishLength:
+		return "the answer was cut off at the token limit, --length short asks for a shorter answer"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,201 @@
+package telemetry
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// eventsFile is the name of the local event log in the state directory. Nothing is sent anywhere.
+const eventsFile = "events.jsonl"
+
+// ErrNoAnswer is returned when there is no recorded answer to attach feedback to.
+var ErrNoAnswer = errors.New("no previous answer recorded")
+
+// EventKind identifies what happened to an answer.
+type EventKind string
+
+const (
+	EventAnswer   EventKind = "answer"   // An answer was received
+	EventCopy     EventKind = "copy"     // A code block was copied to the clipboard
+	EventExtract  EventKind = "extract"  // Code was extracted from the answer
+	EventApply    EventKind = "apply"    // A change proposed by the answer was applied
+	EventFeedback EventKind = "feedback" // The user rated the answer
+)
+
+// Feedback values accepted by `--feedback`.
+const (
+	FeedbackGood = "good"
+	FeedbackBad  = "bad"
+)
+
+// Event is a single entry in the local acceptance log.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Answer  string    `json:"answer"` // ID of the answer the event refers to
+	Kind    EventKind `json:"kind"`
+	Command string    `json:"command,omitempty"`
+	Model   string    `json:"model,omitempty"`
+	Value   string    `json:"value,omitempty"`
+}
+
+// QualityStats summarizes how often answers for a command and model were accepted.
+type QualityStats struct {
+	Command   string
+	Model     string
+	Answers   int
+	Accepted  int // Answers that were copied, extracted, or applied
+	Good, Bad int
+}
+
+// NewAnswerID creates a unique ID for a new answer.
+func NewAnswerID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// Record appends an event to the local event log.
+func Record(event Event) error {
+	path, err := getEventsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+// Load reads all events from the local event log.
+func Load() ([]Event, error) {
+	path, err := getEventsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue // Skip corrupt lines, e.g. from an interrupted write
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read event log: %w", err)
+	}
+	return events, nil
+}
+
+// LastAnswer returns the most recently recorded answer event.
+func LastAnswer() (Event, error) {
+	events, err := Load()
+	if err != nil {
+		return Event{}, err
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Kind == EventAnswer {
+			return events[i], nil
+		}
+	}
+	return Event{}, ErrNoAnswer
+}
+
+// Quality aggregates the events into acceptance statistics per command and model.
+func Quality(events []Event) []QualityStats {
+	type key struct{ command, model string }
+
+	answers := make(map[string]key)
+	stats := make(map[key]*QualityStats)
+	accepted := make(map[string]bool)
+
+	for _, event := range events {
+		if event.Kind == EventAnswer {
+			k := key{event.Command, event.Model}
+			answers[event.Answer] = k
+			if stats[k] == nil {
+				stats[k] = &QualityStats{Command: event.Command, Model: event.Model}
+			}
+			stats[k].Answers++
+			continue
+		}
+
+		k, ok := answers[event.Answer]
+		if !ok {
+			continue
+		}
+
+		switch event.Kind {
+		case EventCopy, EventExtract, EventApply:
+			if !accepted[event.Answer] {
+				accepted[event.Answer] = true
+				stats[k].Accepted++
+			}
+		case EventFeedback:
+			switch event.Value {
+			case FeedbackGood:
+				stats[k].Good++
+			case FeedbackBad:
+				stats[k].Bad++
+			}
+		}
+	}
+
+	result := make([]QualityStats, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Command != result[j].Command {
+			return result[i].Command < result[j].Command
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result
+}
+
+// getEventsPath retrieves the path of the local event log.
+func getEventsPath() (string, error) {
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	return filepath.Join(stateDir, eventsFile), nil
+}
@@ -0,0 +1,93 @@
+package tokens
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// charsPerToken is the average number of characters per token for English text and code
+// with the BPE tokenizers used by the Copilot models.
+const charsPerToken = 4
+
+// Estimate approximates the number of tokens in the text without a tokenizer.
+// Whitespace runs are cheaper than other characters, and non-ASCII characters
+// usually take a token each.
+func Estimate(text string) int {
+	var ascii, other, spaces int
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			spaces++
+		case r < utf8.RuneSelf:
+			ascii++
+		default:
+			other++
+		}
+	}
+
+	return (ascii+spaces/2+charsPerToken-1)/charsPerToken + other
+}
+
+// messageOverhead is the number of tokens the chat format adds to each message, for its role and delimiters.
+const messageOverhead = 4
+
+// defaultContextWindow is the context window of models that aren't known.
+const defaultContextWindow = 128000
+
+// contextWindows are the context windows of the Copilot models in tokens, by model name prefix. Copilot
+// limits some models to less than their providers do.
+var contextWindows = map[string]int{
+	"gpt-3.5-turbo":     16384,
+	"gpt-4":             32768,
+	"gpt-4o":            128000,
+	"gpt-4.1":           128000,
+	"o1":                200000,
+	"o3":                200000,
+	"o4-mini":           200000,
+	"claude-3.5-sonnet": 90000,
+	"claude-3.7-sonnet": 200000,
+	"claude-sonnet-4":   200000,
+	"gemini-2.0-flash":  1000000,
+	"gemini-2.5-pro":    1000000,
+}
+
+// tokenizerRatios scale the estimate for model families whose tokenizers split text into more tokens
+// than the OpenAI tokenizers the estimate is tuned for.
+var tokenizerRatios = map[string]float64{
+	"claude": 1.15,
+	"gemini": 1.05,
+}
+
+// Count estimates the number of tokens of the text with the tokenizer of the model.
+func Count(model, text string) int {
+	estimate := Estimate(text)
+	for family, ratio := range tokenizerRatios {
+		if strings.HasPrefix(model, family) {
+			return int(math.Ceil(float64(estimate) * ratio))
+		}
+	}
+	return estimate
+}
+
+// CountMessage estimates the number of tokens of a chat message with the tokenizer of the model.
+func CountMessage(model, content string) int {
+	return Count(model, content) + messageOverhead
+}
+
+// ContextWindow returns the context window of the model in tokens, from the overrides or the known
+// models, matching the longest name prefix.
+func ContextWindow(model string, overrides map[string]int) int {
+	if size, ok := overrides[model]; ok {
+		return size
+	}
+
+	window, matched := defaultContextWindow, ""
+	for prefix, size := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			window, matched = size, prefix
+		}
+	}
+	return window
+}
@@ -0,0 +1,210 @@
+// Package trace dumps the HTTP requests sent to the APIs and their responses to files, for debugging.
+// Tracing is opt-in, with GH_COPILOT_TRACE=requests, since the dumps hold prompts and attached files.
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logging"
+)
+
+const (
+	envTrace    = "GH_COPILOT_TRACE"     // Comma-separated categories to trace
+	envTraceDir = "GH_COPILOT_TRACE_DIR" // Directory of the dumps, instead of the trace directory of the state directory
+	dirName     = "trace"
+
+	// Requests dumps each request and its response.
+	Requests = "requests"
+
+	maxBody = 1 << 20 // Bytes of a body dumped, the rest is left out
+)
+
+// secretFields match the values of JSON fields that hold credentials, e.g. in the token exchange.
+var secretFields = regexp.MustCompile(`("(?:token|access_token|refresh_token|device_code|client_secret)"\s*:\s*)"[^"]*"`)
+
+// secretParams match the values of the same fields in form-encoded bodies, e.g. of the device flow.
+var secretParams = regexp.MustCompile(`((?:^|[&?\s])(?:token|access_token|refresh_token|device_code|client_secret)=)[^&\s]*`)
+
+// sequence numbers the dumps of the invocation, in the order of their requests.
+var sequence atomic.Int64
+
+// Enabled reports whether GH_COPILOT_TRACE lists the category.
+func Enabled(category string) bool {
+	categories := strings.Split(os.Getenv(envTrace), ",")
+	for i := range categories {
+		categories[i] = strings.TrimSpace(categories[i])
+	}
+	return slices.Contains(categories, category)
+}
+
+// Dir returns the directory the dumps are written to.
+func Dir() (string, error) {
+	if dir := os.Getenv(envTraceDir); dir != "" {
+		return dir, nil
+	}
+	stateDir, err := config.StatePath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state path: %w", err)
+	}
+	return filepath.Join(stateDir, dirName), nil
+}
+
+// Transport returns a transport that dumps every request sent with the base transport and its
+// response, once the response body is closed, when requests are traced, or the base transport otherwise.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if !Enabled(Requests) {
+		return base
+	}
+	return &transport{base: base}
+}
+
+// transport dumps the requests it sends.
+type transport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends the request, and dumps it with its response once the response body is closed.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d := &dump{
+		name:  fmt.Sprintf("%04d-%s-%s", sequence.Add(1), strings.ToLower(req.Method), slug(req.URL.Path)),
+		start: time.Now(),
+	}
+	d.request(req)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(&d.buf, "\n--- error after %s\n\n%v\n", time.Since(d.start).Round(time.Millisecond), err)
+		d.write()
+		return nil, err
+	}
+	fmt.Fprintf(&d.buf, "\n--- response after %s\n\n%s %s\n", time.Since(d.start).Round(time.Millisecond), resp.Proto, resp.Status)
+	writeHeaders(&d.buf, resp.Header)
+	resp.Body = &tracedBody{ReadCloser: resp.Body, dump: d}
+	return resp, nil
+}
+
+// dump is the dump of a request and its response, written to a file of its own.
+type dump struct {
+	name  string
+	start time.Time
+	buf   bytes.Buffer
+	once  sync.Once
+}
+
+// request dumps the request line, headers, and body, leaving the body of the request to send intact.
+func (d *dump) request(req *http.Request) {
+	fmt.Fprintf(&d.buf, "%s %s\n", req.Method, req.URL)
+	writeHeaders(&d.buf, req.Header)
+
+	var body []byte
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case req.GetBody != nil:
+		if copied, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(copied)
+			_ = copied.Close()
+		}
+	default:
+		body, _ = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	d.buf.WriteString("\n")
+	writeBody(&d.buf, body)
+}
+
+// write writes the dump to its file, once.
+func (d *dump) write() {
+	d.once.Do(func() {
+		dir, err := Dir()
+		if err == nil {
+			err = os.MkdirAll(dir, 0o700)
+		}
+		if err == nil {
+			name := fmt.Sprintf("%s-%d-%s.txt", d.start.Format("20060102T150405.000"), os.Getpid(), d.name)
+			err = os.WriteFile(filepath.Join(dir, name), d.buf.Bytes(), 0o600)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write trace: %v\n", err)
+		}
+	})
+}
+
+// tracedBody records a response body as it is read, and writes the dump when it is closed.
+type tracedBody struct {
+	io.ReadCloser
+	dump *dump
+	body bytes.Buffer
+}
+
+// Read reads from the response body, recording what was read.
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxBody + 1 - b.body.Len(); room > 0 {
+		b.body.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+// Close closes the response body and writes the dump, with the part of the body that was read.
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.dump.buf.WriteString("\n")
+	writeBody(&b.dump.buf, b.body.Bytes())
+	b.dump.write()
+	return err
+}
+
+// writeHeaders writes the headers, sorted, with secret values redacted.
+func writeHeaders(w *bytes.Buffer, headers http.Header) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		value := strings.Join(headers[name], ", ")
+		if logging.Sensitive(name) {
+			value = logging.Redacted
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, value)
+	}
+}
+
+// writeBody writes the body, with credentials redacted, cut at maxBody bytes.
+func writeBody(w *bytes.Buffer, body []byte) {
+	cut := len(body) > maxBody
+	body = body[:min(len(body), maxBody)]
+	body = secretFields.ReplaceAll(body, []byte(`$1"`+logging.Redacted+`"`))
+	w.Write(secretParams.ReplaceAll(body, []byte(`${1}`+logging.Redacted)))
+	if cut {
+		w.WriteString("\n... (cut)")
+	}
+	w.WriteString("\n")
+}
+
+// slug turns a URL path into a part of a file name, e.g. /chat/completions into chat-completions.
+func slug(path string) string {
+	s := strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, path), "-")
+	if s == "" {
+		return "root"
+	}
+	return s
+}
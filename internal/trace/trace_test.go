@@ -0,0 +1,47 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestWriteBodyRedacts checks that the credentials of JSON and form-encoded bodies are redacted,
+// and that the other fields are kept.
+func TestWriteBodyRedacts(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"json",
+			`{"access_token": "gho_secret", "token_type": "bearer"}`,
+			`{"access_token": "[REDACTED]", "token_type": "bearer"}`,
+		},
+		{
+			"form",
+			"access_token=gho_secret&token_type=bearer&scope=",
+			"access_token=[REDACTED]&token_type=bearer&scope=",
+		},
+		{
+			"form request",
+			"client_id=Iv1.abc&device_code=3584d83530557fdd&grant_type=urn%3Aietf%3Aparams%3Aoauth%3Agrant-type%3Adevice_code",
+			"client_id=Iv1.abc&device_code=[REDACTED]&grant_type=urn%3Aietf%3Aparams%3Aoauth%3Agrant-type%3Adevice_code",
+		},
+		{
+			"query",
+			"https://example.com/callback?refresh_token=ghr_secret&state=1",
+			"https://example.com/callback?refresh_token=[REDACTED]&state=1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bytes.Buffer
+			writeBody(&w, []byte(tt.body))
+			if got := strings.TrimSuffix(w.String(), "\n"); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package watch
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// isGlob reports whether the pattern has wildcards, rather than being the path of a file.
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// expand returns the files the patterns match, sorted and once each. Paths are kept as they are,
+// whether the file exists or not; globs match the files that exist, outside of hidden directories.
+func expand(patterns []string) []string {
+	var files []string
+	for _, pattern := range patterns {
+		if !isGlob(pattern) {
+			files = append(files, pattern)
+			continue
+		}
+		files = append(files, glob(pattern)...)
+	}
+	slices.Sort(files)
+	return slices.Compact(files)
+}
+
+// glob returns the files matching the pattern, walking the directory before its first wildcard.
+func glob(pattern string) []string {
+	segments := strings.Split(path.Clean(filepath.ToSlash(pattern)), "/")
+	static := 0
+	for static < len(segments)-1 && !isGlob(segments[static]) {
+		static++
+	}
+	root := strings.Join(segments[:static], "/")
+	switch {
+	case static == 0:
+		root = "."
+	case root == "":
+		root = "/" // An absolute pattern with a wildcard right below the root
+	}
+
+	var files []string
+	_ = filepath.WalkDir(filepath.FromSlash(root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Unreadable directories are skipped, they may be readable on the next poll
+		}
+		if d.IsDir() {
+			if p != filepath.FromSlash(root) && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if match(segments, strings.Split(filepath.ToSlash(p), "/")) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files
+}
+
+// match reports whether the segments of the path match those of the pattern, where ** matches
+// any number of segments and others match as in path.Match.
+func match(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := range len(name) + 1 {
+			if match(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && match(pattern[1:], name[1:])
+}
@@ -0,0 +1,223 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/patch"
+)
+
+const (
+	// pollInterval is how often the matched files are stat'ed, which is cheap. Polling sees files
+	// that editors replace on save, and needs no watch descriptors or platform specific APIs.
+	pollInterval = 500 * time.Millisecond
+
+	// expandInterval is how often the globs are expanded again to find files that start or stop
+	// matching them, which walks the directories of ** and costs more than the stats.
+	expandInterval = 2 * time.Second
+
+	// DefaultDebounce is how long changes must settle before a run, e.g. while a test writes its output.
+	DefaultDebounce = 500 * time.Millisecond
+)
+
+// Options configures a watch.
+type Options struct {
+	Debounce time.Duration // How long changes must settle before a run
+	Diff     bool          // Describe the changes since the previous run as a diff
+}
+
+// Change describes the files of a run.
+type Change struct {
+	Files   []string // Files matching the watched patterns, sorted
+	Changed []string // Files that changed, appeared, or disappeared since the previous run, none on the first
+	Diff    string   // Unified diff of the changed files since the previous run, with Options.Diff
+}
+
+// RunFunc is called with a context that is canceled when the watch stops, and the files of the run.
+type RunFunc func(ctx context.Context, change Change) error
+
+// fileState identifies a version of a watched file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// Run calls fn once, then again whenever one of the files matching the patterns changes, or a file
+// starts or stops matching them, until the context is canceled. A pattern is a path, or a glob
+// whose ** matches any number of directories. Files are polled rather than subscribed to, so
+// editors that replace files on save are handled.
+func Run(ctx context.Context, patterns []string, opts Options, fn RunFunc) error {
+	for _, pattern := range patterns {
+		if !isGlob(pattern) {
+			if _, err := os.Stat(pattern); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", pattern, err)
+			}
+		} else if len(expand([]string{pattern})) == 0 {
+			return fmt.Errorf("failed to watch %s: no files match it", pattern)
+		}
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = DefaultDebounce
+	}
+
+	last := snapshot(patterns)
+	change := Change{Files: paths(last)}
+	contents := readAll(change.Files, opts.Diff)
+	for {
+		if err := fn(ctx, change); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// A failed run shouldn't end the watch, the next change may fix it
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "\nWatching %d file(s) for changes, press Ctrl-C to stop...\n", len(change.Files))
+
+		next, err := waitForChange(ctx, patterns, last, opts.Debounce)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		change = Change{Files: paths(next), Changed: changed(last, next)}
+		last = next
+		if opts.Diff {
+			change.Diff = diff(contents, change.Changed)
+		}
+		fmt.Fprintf(os.Stderr, "\n--- Changed at %s: %s ---\n\n", time.Now().Format(time.TimeOnly), strings.Join(change.Changed, ", "))
+	}
+}
+
+// waitForChange polls the files until they differ from last and have settled for the debounce
+// period. The known files are stat'ed on every poll, the globs only expanded every expandInterval,
+// or when a file changed.
+func waitForChange(ctx context.Context, patterns []string, last map[string]fileState, debounce time.Duration) (map[string]fileState, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	current := last
+	files, expanded := slices.Sorted(maps.Keys(last)), time.Now()
+	var changedAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		next := stat(files)
+		// A changed file may have been removed or renamed, so the globs are expanded right away
+		if !equal(next, current) || time.Since(expanded) >= expandInterval {
+			files, expanded = expand(patterns), time.Now()
+			next = stat(files)
+		}
+		if !equal(next, current) {
+			current = next
+			changedAt = time.Now()
+			continue
+		}
+		if !changedAt.IsZero() && time.Since(changedAt) >= debounce && !equal(current, last) {
+			return current, nil
+		}
+	}
+}
+
+// snapshot records the current state of the files matching the patterns.
+func snapshot(patterns []string) map[string]fileState {
+	return stat(expand(patterns))
+}
+
+// stat records the current state of the files, missing ones included.
+func stat(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			states[path] = fileState{}
+			continue
+		}
+		states[path] = fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+	}
+	return states
+}
+
+// equal checks if two snapshots are identical, with the same files in the same states.
+func equal(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, state := range a {
+		if other, ok := b[path]; !ok || other != state {
+			return false
+		}
+	}
+	return true
+}
+
+// changed returns the files whose state differs between the snapshots, sorted.
+func changed(a, b map[string]fileState) []string {
+	var files []string
+	for path, state := range a {
+		if other, ok := b[path]; !ok || other != state {
+			files = append(files, path)
+		}
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			files = append(files, path)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// paths returns the existing files of the snapshot, sorted.
+func paths(states map[string]fileState) []string {
+	var files []string
+	for path, state := range states {
+		if state.exists {
+			files = append(files, path)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// readAll reads the content of the files, to diff them with later versions, if enabled.
+func readAll(files []string, enabled bool) map[string]string {
+	contents := make(map[string]string, len(files))
+	if !enabled {
+		return contents
+	}
+	for _, file := range files {
+		if data, err := os.ReadFile(file); err == nil {
+			contents[file] = string(data)
+		}
+	}
+	return contents
+}
+
+// diff returns the unified diff of the changed files against their previous contents, which are
+// updated to the current ones. All lines of a removed file show as removed.
+func diff(previous map[string]string, files []string) string {
+	var b strings.Builder
+	for _, file := range files {
+		current := ""
+		if data, err := os.ReadFile(file); err == nil {
+			current = string(data)
+		}
+		if d := patch.Diff(file, previous[file], current); len(d.Hunks) > 0 {
+			b.WriteString(d.String())
+		}
+		previous[file] = current
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+// Package wrap runs a command and, when it fails, asks the model to explain its output.
+package wrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logs"
+)
+
+// systemPrompt asks for the explanation of a failed command in a fixed structure.
+const systemPrompt = `You explain why a shell command failed, from its output. The output was cleaned up: terminal codes
+are removed, repeated lines are collapsed, and long output is cut down to the segments that look like errors and
+its end. Reply in markdown with exactly these sections:
+
+## Cause
+Why the command failed, in one or two sentences.
+
+## Fix
+Concrete steps, a corrected command, or a code change that fixes it.`
+
+// ExitError reports the exit status of the wrapped command, which the process exits with.
+type ExitError struct {
+	Code int
+}
+
+// Error names the exit status.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("the command exited with status %d", e.Code)
+}
+
+// Run runs the command given as arguments with its output passed through. When it exits with a
+// non-zero status, the model is asked to explain its output and suggest a fix, and an ExitError
+// with the status is returned.
+func Run(ctx context.Context, cfg config.Config, args args.Arguments) error {
+	if len(args.ActionArgs) == 0 {
+		return errors.New("wrap needs a command, e.g. `gh copilot wrap -- make test`")
+	}
+
+	var output lockedBuffer
+	cmd := exec.CommandContext(ctx, args.ActionArgs[0], args.ActionArgs[1:]...)
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+	cmd.Stdin = os.Stdin
+	if args.Stdin != "" {
+		cmd.Stdin = strings.NewReader(args.Stdin) // Piped input was read before the command started
+	}
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		if err != nil {
+			return fmt.Errorf("failed to run %s: %w", args.ActionArgs[0], err)
+		}
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err() // Interrupted, not failed
+	}
+	code := exitErr.ExitCode()
+	fmt.Fprintf(os.Stderr, "\n%s exited with status %d, asking for an explanation\n", args.ActionArgs[0], code)
+
+	// The command ran without the timeout, which only applies to the answer
+	ctx, cancel := context.WithTimeout(ctx, cfg.ContextTimeout)
+	defer cancel()
+
+	excerpt := logs.Cut("the output of "+args.ActionArgs[0], logs.Clean(output.String()))
+	args.Stdin = excerpt // Summarized instead when it is still too long
+	args.Prompts = []string{
+		fmt.Sprintf("Command: `%s`\nExit status: %d\n\nOutput:\n````\n%s````", strings.Join(args.ActionArgs, " "), code, excerpt),
+		"Explain why the command failed and how to fix it.",
+	}
+	args.System = strings.TrimSpace(args.System + "\n\n" + systemPrompt)
+	args.Command = args.Action
+	args.Action = ""
+	if err := client.Ask(ctx, cfg, args); err != nil {
+		return err
+	}
+	return &ExitError{Code: code}
+}
+
+// lockedBuffer is a buffer that the command's stdout and stderr can write to concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write appends output of the command.
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns the output captured so far.
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
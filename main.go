@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 
 	"github.com/markis/gh-copilot/internal/args"
 	"github.com/markis/gh-copilot/internal/client"
 	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/logging"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/watch"
+	"github.com/markis/gh-copilot/internal/wrap"
 )
 
 // main is the entry point of the application. It sets up signal handling for graceful shutdown and runs the main logic.
@@ -18,6 +24,11 @@ func main() {
 	defer shutdown()
 
 	if err := run(ctx); err != nil {
+		// A wrapped command's failure was explained, its exit status is passed on
+		var exit *wrap.ExitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.Code)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -25,19 +36,75 @@ func main() {
 
 // run executes the main logic of the application, loading configuration, parsing arguments, and making API calls.
 func run(ctx context.Context) error {
-	cfg, err := config.LoadConfig(ctx)
-	if err != nil {
-		return fmt.Errorf("loading config: %w", err)
-	}
+	defer render.PrepareConsole()()
 
-	// Add timeout to the context from config
-	ctx, cancel := context.WithTimeout(ctx, cfg.ContextTimeout)
-	defer cancel()
+	cfg, cfgErr := config.LoadConfig(ctx, args.Profile(os.Args[1:]))
 
 	args, err := args.ParseArgs(ctx, cfg)
+	// The config commands must work with a broken config file, to be able to fix it
+	if cfgErr != nil && (err != nil || !configActions[args.Action]) {
+		return fmt.Errorf("loading config: %w", cfgErr)
+	}
 	if err != nil {
 		return fmt.Errorf("parsing args: %w", err)
 	}
 
+	logger, logCloser, err := logging.New(args.Debug, args.LogFile)
+	if err != nil {
+		return fmt.Errorf("creating logger: %w", err)
+	}
+	defer func() {
+		if err := logCloser.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close log file: %v\n", err)
+		}
+	}()
+	ctx = logging.WithLogger(ctx, logger)
+
+	priority := actionPriorities[args.Action]
+	if args.Priority != "" {
+		if priority, err = client.ParsePriority(args.Priority); err != nil {
+			return fmt.Errorf("parsing args: %w", err)
+		}
+	}
+	ctx = client.WithPriority(ctx, priority)
+
+	// Watch mode runs until interrupted, and applies the timeout to each answer
+	if len(args.Watch) > 0 && args.Action == "" {
+		opts := watch.Options{Debounce: args.WatchDebounce, Diff: args.WatchDiff}
+		return watch.Run(ctx, args.Watch, opts, func(ctx context.Context, change watch.Change) error {
+			ctx, cancel := context.WithTimeout(ctx, cfg.ContextTimeout)
+			defer cancel()
+			return client.Ask(ctx, cfg, watchArgs(args, change))
+		})
+	}
+
+	// Add timeout to the context from config, except for interactive sessions which apply it per request,
+	// and suggested command lines that run, which have the exec timeout
+	if !interactiveActions[args.Action] && !args.Shell.Run {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ContextTimeout)
+		defer cancel()
+	}
+
+	if args.Action != "" {
+		return runAction(ctx, cfg, args)
+	}
+
 	return client.Ask(ctx, cfg, args)
 }
+
+// watchArgs returns the arguments of a run of watch mode: the watched files are attached, so every
+// run sees their latest content, or, with --watch-diff, the diff of their changes since the last run.
+func watchArgs(a args.Arguments, change watch.Change) args.Arguments {
+	if change.Diff != "" {
+		a.Prompts = append([]string{"Changes since the previous run:\n\n```diff\n" + change.Diff + "```"}, a.Prompts...)
+		return a
+	}
+	a.Files = slices.Clone(a.Files)
+	for _, file := range change.Files {
+		if !slices.Contains(a.Files, file) {
+			a.Files = append(a.Files, file)
+		}
+	}
+	return a
+}
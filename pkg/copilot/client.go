@@ -0,0 +1,162 @@
+// Package copilot is a Go client for the GitHub Copilot chat API, to embed Copilot chat in other
+// tools without running the gh-copilot binary. It authenticates like the binary does, with the
+// token of the Copilot editor plugins or the gh CLI.
+//
+//	client, err := copilot.New(copilot.WithModel("gpt-4o"))
+//	if err != nil { ... }
+//	s, err := client.Chat(ctx, copilot.Request{Messages: []copilot.Message{copilot.UserMessage("Hi")}})
+//	if err != nil { ... }
+//	defer s.Close()
+//	for s.Next() {
+//		fmt.Print(s.Chunk().Content)
+//	}
+//	if err := s.Err(); err != nil { ... }
+//
+// A Client is safe for concurrent use.
+package copilot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/markis/gh-copilot/internal/client"
+	"github.com/markis/gh-copilot/internal/config"
+)
+
+// Role is the author of a message.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleSystem    Role = "system"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a message of the conversation sent to the model.
+type Message struct {
+	Role    Role
+	Content string
+}
+
+// UserMessage creates a message of the user.
+func UserMessage(content string) Message {
+	return Message{Role: RoleUser, Content: content}
+}
+
+// SystemMessage creates a system message, instructing the model.
+func SystemMessage(content string) Message {
+	return Message{Role: RoleSystem, Content: content}
+}
+
+// Request is a chat request. Unset parameters are left to the model.
+type Request struct {
+	Model       string // Overrides the model of the client
+	Messages    []Message
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int
+	Stop        []string // Sequences where the model stops generating
+}
+
+// Client sends chat requests to the Copilot API.
+type Client struct {
+	cfg     config.Config
+	loadErr error // Failure of WithUserConfig, returned by New
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithModel sets the model of requests that don't name one.
+func WithModel(model string) Option {
+	return func(c *Client) { c.cfg.Model = model }
+}
+
+// WithAuthHost sets the GitHub host whose token authenticates the requests, e.g. for GitHub Enterprise.
+func WithAuthHost(host string) Option {
+	return func(c *Client) { c.cfg.AuthHost = host }
+}
+
+// WithEndpoints sets the base URLs of the Copilot API and of the GitHub API, which exchanges the token.
+func WithEndpoints(api, githubAPI string) Option {
+	return func(c *Client) {
+		c.cfg.Endpoints.API, c.cfg.Endpoints.GitHubAPI = api, githubAPI
+	}
+}
+
+// WithTimeout sets the timeout of each HTTP request, including the time to read a streamed answer.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.cfg.Http.HttpClientTimeout = timeout }
+}
+
+// WithUserConfig starts from the gh-copilot config of the user, e.g. its model, aliases, endpoints,
+// and HTTP settings, applying the named profile unless it is empty. Options after it override it.
+func WithUserConfig(ctx context.Context, profile string) Option {
+	return func(c *Client) {
+		c.cfg, c.loadErr = config.LoadConfig(ctx, profile)
+	}
+}
+
+// New creates a client with the default settings, changed by the options.
+func New(options ...Option) (*Client, error) {
+	cfg, err := config.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{cfg: cfg}
+	for _, option := range options {
+		option(c)
+	}
+	if c.loadErr != nil {
+		return nil, c.loadErr
+	}
+	return c, nil
+}
+
+// Chat sends the request and returns the stream of its answer. Close the stream when done with it.
+func (c *Client) Chat(ctx context.Context, req Request) (*Stream, error) {
+	if len(req.Messages) == 0 {
+		return nil, errors.New("the request has no messages")
+	}
+
+	model := req.Model
+	if model == "" {
+		model = c.cfg.Model
+	}
+	messages := make([]client.Message, 0, len(req.Messages))
+	for _, message := range req.Messages {
+		messages = append(messages, client.Message{Role: client.Role(message.Role), Content: message.Content})
+	}
+
+	payload := client.ApiPayload{
+		Model:          c.cfg.ResolveModel(model),
+		Messages:       messages,
+		NumOfResponses: 1,
+		TopP:           1.0,
+		Stream:         true,
+		Stop:           req.Stop,
+		Temperature:    req.Temperature,
+		MaxTokens:      req.MaxTokens,
+	}
+	if req.TopP != nil {
+		payload.TopP = *req.TopP
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &Stream{ctx: ctx, chunks: client.Stream(ctx, c.cfg, payload), cancel: cancel}, nil
+}
+
+// Complete sends the request and returns the whole answer once it has finished streaming.
+func (c *Client) Complete(ctx context.Context, req Request) (string, error) {
+	s, err := c.Chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	for s.Next() {
+	}
+	return s.Answer(), s.Err()
+}
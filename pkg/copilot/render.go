@@ -0,0 +1,95 @@
+package copilot
+
+import (
+	"context"
+	"io"
+
+	"github.com/markis/gh-copilot/internal/args"
+	"github.com/markis/gh-copilot/internal/config"
+	"github.com/markis/gh-copilot/internal/render"
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+// Renderer displays a streamed answer as it arrives.
+type Renderer interface {
+	// Render reads the rest of the stream, returning the complete answer.
+	Render(ctx context.Context, s *Stream) (string, error)
+}
+
+// RenderOptions configure the terminal renderer.
+type RenderOptions struct {
+	PlainText bool   // Print the markdown as it is
+	Theme     string // glamour theme name or JSON style file, empty or "auto" to detect the background
+	WrapWidth int    // Width to wrap lines at, 0 to not wrap them
+}
+
+// terminalRenderer renders markdown to the terminal like the gh-copilot binary.
+type terminalRenderer struct {
+	cfg  config.Config
+	args args.Arguments
+}
+
+// NewTerminalRenderer creates a renderer printing the answer to stdout, rendering its markdown block by block.
+func NewTerminalRenderer(options RenderOptions) (Renderer, error) {
+	cfg, err := config.Default()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Render.WrapLines, cfg.Render.WrapWidth = options.WrapWidth > 0, options.WrapWidth
+	return &terminalRenderer{cfg: cfg, args: args.Arguments{UsePlainText: options.PlainText, Theme: options.Theme}}, nil
+}
+
+// Render prints the answer as it arrives.
+func (t *terminalRenderer) Render(ctx context.Context, s *Stream) (string, error) {
+	renderer, err := render.NewTerminalRenderer(ctx, t.cfg, t.args)
+	if err != nil {
+		return "", err
+	}
+	return renderStream(ctx, renderer, s)
+}
+
+// jsonlRenderer writes the chunks as JSON Lines.
+type jsonlRenderer struct {
+	w io.Writer
+}
+
+// NewJSONLRenderer creates a renderer writing every chunk as a line of JSON, with its content, choice
+// index, finish reason, and time, like `--output jsonl`.
+func NewJSONLRenderer(w io.Writer) Renderer {
+	return &jsonlRenderer{w: w}
+}
+
+// Render writes the chunks as they arrive.
+func (j *jsonlRenderer) Render(ctx context.Context, s *Stream) (string, error) {
+	return renderStream(ctx, render.NewJSONLRenderer(ctx, j.w), s)
+}
+
+// renderStream feeds the rest of the stream to the renderer.
+func renderStream(ctx context.Context, renderer render.StreamRenderer, s *Stream) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan stream.Chunk)
+	go func() {
+		defer close(chunks)
+		for s.Next() {
+			chunk := s.Chunk()
+			select {
+			case chunks <- stream.Chunk{Content: chunk.Content, Index: chunk.Index, FinishReason: chunk.FinishReason, Filtered: chunk.Filtered}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			select {
+			case chunks <- stream.Chunk{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	if err := renderer.Render(chunks); err != nil {
+		return "", err
+	}
+	return renderer.Answer(), nil
+}
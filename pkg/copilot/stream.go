@@ -0,0 +1,72 @@
+package copilot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/markis/gh-copilot/internal/stream"
+)
+
+// Chunk is a piece of the streamed answer.
+type Chunk struct {
+	Content      string
+	Index        int      // Choice the content belongs to
+	FinishReason string   // Why the model stopped, e.g. "stop" or "length", on the choice's last chunk
+	Filtered     []string // Categories of the content filter that blocked content, e.g. "violence"
+}
+
+// APIError is an error the API sent after the answer started, e.g. when the content filter stopped
+// it, returned by Stream.Err.
+type APIError = stream.APIError
+
+// Stream iterates over the chunks of an answer as they arrive. A Stream is not safe for concurrent use.
+type Stream struct {
+	ctx    context.Context
+	chunks <-chan stream.Chunk
+	cancel context.CancelFunc
+	chunk  Chunk
+	answer strings.Builder
+	err    error
+}
+
+// Next waits for the next chunk, reporting whether there is one. It returns false once the answer
+// is complete or the stream failed, see Err.
+func (s *Stream) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	chunk, ok := <-s.chunks
+	if !ok {
+		// A canceled stream may end without a chunk saying so
+		s.err = s.ctx.Err()
+		return false
+	}
+	if chunk.Error != nil {
+		s.err = chunk.Error
+		return false
+	}
+
+	s.chunk = Chunk{Content: chunk.Content, Index: chunk.Index, FinishReason: chunk.FinishReason, Filtered: chunk.Filtered}
+	s.answer.WriteString(chunk.Content)
+	return true
+}
+
+// Chunk returns the chunk read by the last call to Next.
+func (s *Stream) Chunk() Chunk {
+	return s.chunk
+}
+
+// Answer returns the content of the chunks read so far.
+func (s *Stream) Answer() string {
+	return s.answer.String()
+}
+
+// Err returns the error that ended the stream, nil when the answer is complete.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close stops the stream, e.g. when the rest of the answer isn't needed.
+func (s *Stream) Close() {
+	s.cancel()
+}